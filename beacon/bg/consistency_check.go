@@ -0,0 +1,68 @@
+package bg
+
+import "sync"
+import "time"
+
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/device"
+import "github.com/dadleyy/beacon.api/beacon/logging"
+
+// NewConsistencyCheckProcessor returns a processor that periodically checks the store for inconsistent keys, repairing
+// them if requested.
+func NewConsistencyCheckProcessor(
+	checker device.ConsistencyChecker, interval time.Duration, repair bool,
+) *ConsistencyCheckProcessor {
+	logger := logging.New(defs.ConsistencyCheckLogPrefix, logging.Magenta)
+	return &ConsistencyCheckProcessor{logger, checker, interval, repair}
+}
+
+// ConsistencyCheckProcessor periodically asks the checker for keys left inconsistent by writes that were interrupted
+// part way, logging every issue found. Issues are only repaired when the processor was created w/ repairs enabled so
+// the checker can be run in a report-only mode first.
+type ConsistencyCheckProcessor struct {
+	*logging.Logger
+	checker  device.ConsistencyChecker
+	interval time.Duration
+	repair   bool
+}
+
+// Start is the Processor#Start implementation
+func (processor *ConsistencyCheckProcessor) Start(wg *sync.WaitGroup, stop KillSwitch) {
+	defer wg.Done()
+
+	processor.Infof("consistency check processor starting (repair: %v)", processor.repair)
+
+	timer := time.NewTicker(processor.interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			processor.run()
+		case <-stop:
+			processor.Infof("received kill signal, breaking")
+			return
+		}
+	}
+}
+
+// run performs a single consistency check, logging each issue along w/ whether it was repaired.
+func (processor *ConsistencyCheckProcessor) run() {
+	issues, e := processor.checker.CheckConsistency(processor.repair)
+
+	for _, issue := range issues {
+		if issue.Repaired {
+			processor.Infof("repaired %s: %s", issue.Key, issue.Description)
+			continue
+		}
+
+		processor.Warnf("found %s: %s", issue.Key, issue.Description)
+	}
+
+	if e != nil {
+		processor.Errorf("unable to complete consistency check: %s", e.Error())
+		return
+	}
+
+	processor.Debugf("consistency check complete (issues: %d)", len(issues))
+}
@@ -0,0 +1,81 @@
+package bg
+
+import "fmt"
+import "sync"
+import "time"
+import "bytes"
+import "strings"
+import "testing"
+import "github.com/franela/goblin"
+import "github.com/dadleyy/beacon.api/beacon/device"
+
+type testConsistencyChecker struct {
+	lastErrorLister
+	issues  []device.ConsistencyIssue
+	errors  []error
+	repairs []bool
+}
+
+func (c *testConsistencyChecker) CheckConsistency(repair bool) ([]device.ConsistencyIssue, error) {
+	c.repairs = append(c.repairs, repair)
+	return c.issues, c.lastError(c.errors)
+}
+
+type consistencyCheckScaffold struct {
+	checker   *testConsistencyChecker
+	processor *ConsistencyCheckProcessor
+	log       *bytes.Buffer
+}
+
+func (s *consistencyCheckScaffold) Reset() {
+	s.checker = &testConsistencyChecker{}
+	s.log = bytes.NewBuffer([]byte{})
+	s.processor = &ConsistencyCheckProcessor{
+		Logger:   newTestLogger(s.log),
+		checker:  s.checker,
+		interval: time.Millisecond,
+	}
+}
+
+func Test_ConsistencyCheck(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("ConsistencyCheckProcessor", func() {
+		s := &consistencyCheckScaffold{}
+
+		g.BeforeEach(s.Reset)
+
+		g.It("successfully terminates when kill signal is given", func() {
+			wg, kill := &sync.WaitGroup{}, make(KillSwitch)
+			wg.Add(1)
+			go s.processor.Start(wg, kill)
+			kill <- struct{}{}
+			wg.Wait()
+		})
+
+		g.It("only asks for repairs when created w/ repairs enabled", func() {
+			s.processor.run()
+			s.processor.repair = true
+			s.processor.run()
+			g.Assert(s.checker.repairs).Equal([]bool{false, true})
+		})
+
+		g.It("logs each issue found along w/ whether it was repaired", func() {
+			s.checker.issues = []device.ConsistencyIssue{
+				{Key: "first-key", Description: "first-issue"},
+				{Key: "second-key", Description: "second-issue", Repaired: true},
+			}
+			s.processor.run()
+			g.Assert(strings.Contains(s.log.String(), "found first-key: first-issue")).Equal(true)
+			g.Assert(strings.Contains(s.log.String(), "repaired second-key: second-issue")).Equal(true)
+		})
+
+		g.It("logs the error if unable to complete the check", func() {
+			s.checker.issues = []device.ConsistencyIssue{{Key: "first-key", Description: "first-issue"}}
+			s.checker.errors = []error{fmt.Errorf("bad-check")}
+			s.processor.run()
+			g.Assert(strings.Contains(s.log.String(), "first-issue")).Equal(true)
+			g.Assert(strings.Contains(s.log.String(), "bad-check")).Equal(true)
+		})
+	})
+}
@@ -19,6 +19,13 @@ type WriteStream chan<- io.Reader
 // ReadStream defines a receive-only channel for io.Reader types
 type ReadStream <-chan io.Reader
 
+// connectionReader associates data received from a device connection w/ the id of the connection it was read from so
+// that the feedback processor is able to verify the identity of the device that sent it.
+type connectionReader struct {
+	io.Reader
+	deviceID string
+}
+
 // DeviceChannels is a convenience structure containing a ReadStream, WriteStream and RegistrationStream
 type DeviceChannels struct {
 	Commands      ReadStream
@@ -27,10 +34,20 @@ type DeviceChannels struct {
 }
 
 // NewDeviceControlProcessor returns a new DeviceControlProcessor
-func NewDeviceControlProcessor(c *DeviceChannels, s device.Index, k *security.ServerKey) *DeviceControlProcessor {
+func NewDeviceControlProcessor(
+	c *DeviceChannels,
+	s device.Index,
+	m device.CommandStore,
+	q device.PendingMessageStore,
+	t device.StateStore,
+	v EventPublisher,
+	o DeviceClaimer,
+	k *security.ServerKey,
+) *DeviceControlProcessor {
 	logger := logging.New(defs.DeviceControlLogPrefix, logging.Yellow)
 	var pool []device.Connection
-	return &DeviceControlProcessor{logger, k, c, s, pool}
+	names := make(map[string]string)
+	return &DeviceControlProcessor{logger, k, c, s, m, q, t, v, o, pool, names, sync.Mutex{}}
 }
 
 // The DeviceControlProcessor is used by the server to maintain the pool of websocket connections, register new device
@@ -40,7 +57,14 @@ type DeviceControlProcessor struct {
 	key      *security.ServerKey
 	channels *DeviceChannels
 	index    device.Index
+	commands device.CommandStore
+	pending  device.PendingMessageStore
+	states   device.StateStore
+	events   EventPublisher
+	owners   DeviceClaimer
 	pool     []device.Connection
+	names    map[string]string
+	nameLock sync.Mutex
 }
 
 // Start will continuously loop over registration & command channels delegating to private methods as necessary.
@@ -69,6 +93,14 @@ func (processor *DeviceControlProcessor) Start(wg *sync.WaitGroup, stop KillSwit
 				break
 			}
 
+			processor.track(connection.GetID())
+
+			// Record that this node holds the connection so messages sent to other nodes are relayed here.
+			if e := processor.owners.ClaimDevice(connection.GetID()); e != nil {
+				processor.Warnf("unable to claim device[%s]: %s", connection.GetID(), e.Error())
+			}
+
+			processor.publishEvent(defs.DeviceEventRegistered, connection.GetID(), "")
 			wait.Add(2)
 
 			// If we've received a welcome message, send our shared secret to the device and start polling for feedback msgs.
@@ -94,6 +126,7 @@ func (processor *DeviceControlProcessor) Start(wg *sync.WaitGroup, stop KillSwit
 // handle receives a reader interface that contains a serialized device message and attempts
 func (processor *DeviceControlProcessor) handle(message io.Reader, wg *sync.WaitGroup) {
 	defer wg.Done()
+	defer acknowledge(message)
 
 	messageData, e := ioutil.ReadAll(message)
 
@@ -110,7 +143,7 @@ func (processor *DeviceControlProcessor) handle(message io.Reader, wg *sync.Wait
 	}
 
 	var device device.Connection
-	targetID := controlMessage.GetAuthentication().GetDeviceID()
+	targetID, commandID := controlMessage.GetAuthentication().GetDeviceID(), controlMessage.GetCommandID()
 
 	// Attempt to find a device in our pool associated with the message we've received.
 	for _, d := range processor.pool {
@@ -126,23 +159,219 @@ func (processor *DeviceControlProcessor) handle(message io.Reader, wg *sync.Wait
 
 	if device == nil {
 		processor.Warnf("unable to locate device for command, command device id: %s", targetID)
+		processor.enqueue(controlMessage, defs.CommandStatusDeviceOffline)
 		return
 	}
 
 	// At this point we've found a device to send to, write our message into it.
 	if e := device.Send(controlMessage); e != nil {
 		processor.Warnf("unable to write command to device (closing device): %s", e.Error())
+		processor.enqueue(controlMessage, defs.CommandStatusFailed)
 		processor.unsubscribe(device)
 		return
 	}
 
+	processor.updateCommand(commandID, defs.CommandStatusSent)
 	processor.Infof("relayed command to device[%s]", device.GetID())
+
+	if details, e := processor.index.FindDevice(targetID); e == nil {
+		processor.remember(details.Name, controlMessage)
+		processor.events.PublishEvent(DeviceEvent{
+			Type:       defs.DeviceEventCommandRelayed,
+			DeviceID:   targetID,
+			DeviceName: details.Name,
+			CommandID:  commandID,
+		})
+	}
+}
+
+// enqueue stores a message that could not be delivered so that it is sent once the device reconnects. The name of the
+// device is resolved from the connections tracked by the processor, which outlive the registration of a device that
+// has disconnected. If the name is unknown the message is dropped and its command is given the status provided.
+func (processor *DeviceControlProcessor) enqueue(message interchange.DeviceMessage, status string) {
+	targetID, commandID := message.GetAuthentication().GetDeviceID(), message.GetCommandID()
+	name, ok := processor.lookup(targetID)
+
+	if ok != true {
+		processor.Warnf("dropping message for unknown device[%s]", targetID)
+		processor.updateCommand(commandID, status)
+		return
+	}
+
+	if e := processor.pending.QueueMessage(name, message); e != nil {
+		processor.Errorf("unable to queue message for device[%s]: %s", name, e.Error())
+		processor.updateCommand(commandID, status)
+		return
+	}
+
+	processor.Infof("queued message for device[%s] until it reconnects", name)
+}
+
+// track records the name of the device registered w/ the connection id. Entries are kept after the connection closes
+// so messages that arrive once the device has been removed from the index can still be queued for its name; they are
+// only replaced when a new connection is registered w/ the same name.
+func (processor *DeviceControlProcessor) track(deviceID string) {
+	details, e := processor.index.FindDevice(deviceID)
+
+	if e != nil {
+		processor.Warnf("unable to find registration for device[%s]: %s", deviceID, e.Error())
+		return
+	}
+
+	processor.nameLock.Lock()
+	defer processor.nameLock.Unlock()
+
+	for id, name := range processor.names {
+		if name == details.Name {
+			delete(processor.names, id)
+		}
+	}
+
+	processor.names[deviceID] = details.Name
+}
+
+// lookup returns the name of the device for the connection id, falling back to the index for connections that were
+// not registered w/ this processor.
+func (processor *DeviceControlProcessor) lookup(deviceID string) (string, bool) {
+	processor.nameLock.Lock()
+	name, ok := processor.names[deviceID]
+	processor.nameLock.Unlock()
+
+	if ok == true {
+		return name, true
+	}
+
+	details, e := processor.index.FindDevice(deviceID)
+
+	if e != nil {
+		return "", false
+	}
+
+	return details.Name, true
+}
+
+// restore brings a device that has just connected back to where it was before it disconnected. Messages queued while
+// the device was not connected are sent to its new id, otherwise the device is sent its last known state.
+func (processor *DeviceControlProcessor) restore(connection device.Connection) {
+	details, e := processor.index.FindDevice(connection.GetID())
+
+	if e != nil {
+		processor.Warnf("unable to find registration for device[%s]: %s", connection.GetID(), e.Error())
+		return
+	}
+
+	messages, e := processor.pending.DequeueMessages(details.Name)
+
+	if e != nil {
+		processor.Errorf("unable to load pending messages for device[%s]: %s", details.Name, e.Error())
+	}
+
+	if len(messages) == 0 {
+		processor.resume(connection, details.Name)
+		return
+	}
+
+	for _, message := range messages {
+		message.Authentication = &interchange.DeviceMessageAuthentication{DeviceID: connection.GetID()}
+
+		if e := connection.Send(message); e != nil {
+			processor.Warnf("unable to replay message to device[%s]: %s", details.Name, e.Error())
+			processor.updateCommand(message.GetCommandID(), defs.CommandStatusFailed)
+			continue
+		}
+
+		processor.updateCommand(message.GetCommandID(), defs.CommandStatusSent)
+		processor.remember(details.Name, message)
+	}
+
+	processor.Infof("replayed %d pending messages to device[%s]", len(messages), details.Name)
+}
+
+// resume sends the last known state of the device name to the connection, if there is one.
+func (processor *DeviceControlProcessor) resume(connection device.Connection, name string) {
+	state, e := processor.states.FindState(name)
+
+	if e != nil {
+		processor.Debugf("no state to restore for device[%s]: %s", name, e.Error())
+		return
+	}
+
+	payload, e := proto.Marshal(state)
+
+	if e != nil {
+		processor.Errorf("unable to marshal state for device[%s]: %s", name, e.Error())
+		return
+	}
+
+	message := interchange.DeviceMessage{
+		Type: interchange.DeviceMessageType_CONTROL,
+		Authentication: &interchange.DeviceMessageAuthentication{
+			DeviceID: connection.GetID(),
+		},
+		Payload: payload,
+	}
+
+	if e := connection.Send(message); e != nil {
+		processor.Warnf("unable to restore state of device[%s]: %s", name, e.Error())
+		return
+	}
+
+	processor.Infof("restored last known state of device[%s]", name)
+}
+
+// remember saves the control message that was sent to the device name as its last known state.
+func (processor *DeviceControlProcessor) remember(name string, message interchange.DeviceMessage) {
+	if message.Type != interchange.DeviceMessageType_CONTROL {
+		return
+	}
+
+	control := interchange.ControlMessage{}
+
+	if e := proto.Unmarshal(message.GetPayload(), &control); e != nil {
+		processor.Warnf("unable to unmarshal control message for device[%s]: %s", name, e.Error())
+		return
+	}
+
+	if e := processor.states.SaveState(name, control); e != nil {
+		processor.Errorf("unable to save state of device[%s]: %s", name, e.Error())
+	}
+}
+
+// publishEvent broadcasts an event for the device id, provided the device can still be found in the index.
+func (processor *DeviceControlProcessor) publishEvent(eventType, deviceID, commandID string) {
+	details, e := processor.index.FindDevice(deviceID)
+
+	if e != nil {
+		processor.Debugf("skipping %s event for unknown device[%s]", eventType, deviceID)
+		return
+	}
+
+	processor.events.PublishEvent(DeviceEvent{
+		Type:       eventType,
+		DeviceID:   deviceID,
+		DeviceName: details.Name,
+		CommandID:  commandID,
+	})
+}
+
+// updateCommand records the status of the command handled by the processor. Messages published w/o a command id (e.g.
+// scheduled messages) are not tracked.
+func (processor *DeviceControlProcessor) updateCommand(commandID, status string) {
+	if commandID == "" {
+		return
+	}
+
+	if e := processor.commands.UpdateCommandStatus(commandID, status); e != nil {
+		processor.Warnf("unable to update command[%s] status to %s: %s", commandID, status, e.Error())
+	}
 }
 
 func (processor *DeviceControlProcessor) unsubscribe(connection device.Connection) error {
 	defer connection.Close()
 	pool, targetID := make([]device.Connection, 0, len(processor.pool)-1), connection.GetID()
 
+	processor.publishEvent(defs.DeviceEventDisconnected, targetID, "")
+
 	if e := processor.index.RemoveDevice(targetID); e != nil {
 		processor.Errorf("unable to remove target from device index: %s", e.Error())
 		return e
@@ -194,6 +423,8 @@ func (processor *DeviceControlProcessor) welcome(connection device.Connection, w
 	}
 
 	processor.Infof("welcomed device[%s]", connection.GetID())
+	processor.publishEvent(defs.DeviceEventWelcomed, connection.GetID(), "")
+	processor.restore(connection)
 }
 
 func (processor *DeviceControlProcessor) subscribe(connection device.Connection, wg *sync.WaitGroup) error {
@@ -212,6 +443,6 @@ func (processor *DeviceControlProcessor) subscribe(connection device.Connection,
 			return e
 		}
 
-		processor.channels.Feedback <- reader
+		processor.channels.Feedback <- &connectionReader{reader, connection.GetID()}
 	}
 }
@@ -6,11 +6,13 @@ import "log"
 import "sync"
 import "bytes"
 import "strings"
+import "io/ioutil"
 import "testing"
 import "crypto/rsa"
 import "crypto/rand"
 import "github.com/franela/goblin"
 import "github.com/golang/protobuf/proto"
+import "github.com/dadleyy/beacon.api/beacon/defs"
 import "github.com/dadleyy/beacon.api/beacon/device"
 import "github.com/dadleyy/beacon.api/beacon/logging"
 import "github.com/dadleyy/beacon.api/beacon/security"
@@ -27,6 +29,11 @@ type deviceControlScaffold struct {
 	log           *bytes.Buffer
 	connections   []device.Connection
 	index         *testDeviceIndex
+	commands      *testCommandStore
+	pending       *testPendingStore
+	states        *testStateStore
+	events        *testEventPublisher
+	owners        *testDeviceClaimer
 	channels      []chan io.Reader
 	registrations device.RegistrationStream
 	processor     *DeviceControlProcessor
@@ -41,6 +48,16 @@ func (s *deviceControlScaffold) Reset() {
 
 	s.index = &testDeviceIndex{}
 
+	s.commands = &testCommandStore{statuses: make(map[string]string)}
+
+	s.pending = &testPendingStore{queued: make(map[string][]interchange.DeviceMessage)}
+
+	s.states = &testStateStore{states: make(map[string]interchange.ControlMessage)}
+
+	s.events = &testEventPublisher{}
+
+	s.owners = &testDeviceClaimer{}
+
 	s.channels = []chan io.Reader{
 		make(chan io.Reader, 1),
 		make(chan io.Reader, 1),
@@ -66,8 +83,14 @@ func (s *deviceControlScaffold) Reset() {
 			Feedback:      s.channels[1],
 			Registrations: s.registrations,
 		},
-		index: s.index,
-		pool:  s.connections,
+		index:    s.index,
+		commands: s.commands,
+		pending:  s.pending,
+		states:   s.states,
+		events:   s.events,
+		owners:   s.owners,
+		pool:     s.connections,
+		names:    make(map[string]string),
 	}
 
 	s.wg = &sync.WaitGroup{}
@@ -117,6 +140,108 @@ func (i testDeviceIndex) FindDevice(string) (device.RegistrationDetails, error)
 	return device.RegistrationDetails{}, i.lastErrorOrNotFound(i.errors)
 }
 
+type testCommandStore struct {
+	lastErrorLister
+	commands []device.CommandDetails
+	statuses map[string]string
+	logged   []device.CommandLogEntry
+	errors   []error
+}
+
+func (c *testCommandStore) CreateCommand(string, string) (device.CommandDetails, error) {
+	return device.CommandDetails{}, nil
+}
+
+func (c *testCommandStore) FindCommand(id string) (device.CommandDetails, error) {
+	for _, command := range c.commands {
+		if command.CommandID == id {
+			return command, nil
+		}
+	}
+
+	return device.CommandDetails{}, fmt.Errorf("not-found")
+}
+
+func (c *testCommandStore) AuthorizeCommand(string, string) bool {
+	return false
+}
+
+func (c *testCommandStore) UpdateCommandStatus(id, status string) error {
+	c.statuses[id] = status
+	return c.lastError(c.errors)
+}
+
+func (c *testCommandStore) LogCommand(_ string, entry device.CommandLogEntry) error {
+	c.logged = append(c.logged, entry)
+	return c.lastError(c.errors)
+}
+
+func (c *testCommandStore) ListCommandLog(string, int, int) ([]device.CommandLogEntry, error) {
+	return nil, nil
+}
+
+type testPendingStore struct {
+	lastErrorLister
+	queued map[string][]interchange.DeviceMessage
+	errors []error
+}
+
+func (p *testPendingStore) QueueMessage(name string, message interchange.DeviceMessage) error {
+	if e := p.lastError(p.errors); e != nil {
+		return e
+	}
+
+	p.queued[name] = append(p.queued[name], message)
+	return nil
+}
+
+func (p *testPendingStore) DequeueMessages(name string) ([]interchange.DeviceMessage, error) {
+	messages := p.queued[name]
+	delete(p.queued, name)
+	return messages, nil
+}
+
+type testStateStore struct {
+	lastErrorLister
+	states map[string]interchange.ControlMessage
+	errors []error
+}
+
+func (s *testStateStore) SaveState(name string, message interchange.ControlMessage) error {
+	s.states[name] = message
+	return s.lastError(s.errors)
+}
+
+func (s *testStateStore) FindState(name string) (*interchange.ControlMessage, error) {
+	if state, ok := s.states[name]; ok {
+		return &state, nil
+	}
+
+	return nil, fmt.Errorf("not-found")
+}
+
+type testEventPublisher struct {
+	sync.Mutex
+	published []DeviceEvent
+}
+
+func (p *testEventPublisher) PublishEvent(event DeviceEvent) {
+	p.Lock()
+	defer p.Unlock()
+	p.published = append(p.published, event)
+}
+
+type testDeviceClaimer struct {
+	lastErrorLister
+	claimed []string
+	errors  []error
+}
+
+func (c *testDeviceClaimer) ClaimDevice(deviceID string) error {
+	c.claimed = append(c.claimed, deviceID)
+	return c.lastError(c.errors)
+}
+
 type testConnection struct {
 	lastErrorLister
 	closed       bool
@@ -192,13 +317,186 @@ func Test_DeviceControl(t *testing.T) {
 
 			g.It("sends the feedback message on a successful receive to the feedback channel", func() {
 				wg.Add(1)
+				connection.id = "some-device"
 				connection.readers = append(connection.readers, bytes.NewBuffer([]byte("hello world")))
 				scaffold.processor.subscribe(connection, wg)
 				wg.Wait()
 				feedback := <-scaffold.channels[1]
-				reader, ok := feedback.(*bytes.Buffer)
+				reader, ok := feedback.(*connectionReader)
+				g.Assert(ok).Equal(true)
+				g.Assert(reader.deviceID).Equal("some-device")
+				data, _ := ioutil.ReadAll(reader)
+				g.Assert(string(data)).Equal("hello world")
+			})
+		})
+
+		g.Describe("#handle", func() {
+			var message *bytes.Buffer
+			var wg *sync.WaitGroup
+
+			g.BeforeEach(func() {
+				b, _ := proto.Marshal(&interchange.DeviceMessage{
+					Type:      interchange.DeviceMessageType_CONTROL,
+					CommandID: "command-id",
+					Authentication: &interchange.DeviceMessageAuthentication{
+						DeviceID: "some-device",
+					},
+				})
+				message, wg = bytes.NewBuffer(b), &sync.WaitGroup{}
+				wg.Add(1)
+			})
+
+			g.It("marks the command as device-offline if the device is not in the pool", func() {
+				scaffold.processor.handle(message, wg)
+				g.Assert(scaffold.commands.statuses["command-id"]).Equal(defs.CommandStatusDeviceOffline)
+			})
+
+			g.It("marks the command as failed if unable to send to the device", func() {
+				connection := &testConnection{id: "some-device", errors: []error{fmt.Errorf("bad-send")}}
+				scaffold.processor.pool = []device.Connection{connection}
+				scaffold.processor.handle(message, wg)
+				g.Assert(scaffold.commands.statuses["command-id"]).Equal(defs.CommandStatusFailed)
+			})
+
+			g.It("marks the command as sent after writing to the device", func() {
+				connection := &testConnection{id: "some-device"}
+				scaffold.processor.pool = []device.Connection{connection}
+				scaffold.processor.handle(message, wg)
+				g.Assert(scaffold.commands.statuses["command-id"]).Equal(defs.CommandStatusSent)
+				g.Assert(connection.sentMessages[0].GetCommandID()).Equal("command-id")
+			})
+
+			g.Describe("for a device that is registered but not in the pool", func() {
+				g.BeforeEach(func() {
+					scaffold.index.devices = []device.RegistrationDetails{{DeviceID: "some-device", Name: "desk-lamp"}}
+				})
+
+				g.It("queues the message until the device reconnects", func() {
+					scaffold.processor.handle(message, wg)
+					g.Assert(len(scaffold.pending.queued["desk-lamp"])).Equal(1)
+					g.Assert(len(scaffold.commands.statuses)).Equal(0)
+				})
+
+				g.It("marks the command as device-offline if unable to queue the message", func() {
+					scaffold.pending.errors = []error{fmt.Errorf("bad-queue")}
+					scaffold.processor.handle(message, wg)
+					g.Assert(scaffold.commands.statuses["command-id"]).Equal(defs.CommandStatusDeviceOffline)
+				})
+
+				g.It("queues the message for the device name after the device has been removed from the index", func() {
+					scaffold.processor.track("some-device")
+					scaffold.index.devices = nil
+					scaffold.processor.handle(message, wg)
+					g.Assert(len(scaffold.pending.queued["desk-lamp"])).Equal(1)
+					g.Assert(len(scaffold.commands.statuses)).Equal(0)
+				})
+
+				g.It("drops the message of a connection replaced by a newer one w/ the same name", func() {
+					scaffold.processor.track("some-device")
+					scaffold.index.devices = []device.RegistrationDetails{{DeviceID: "other-device", Name: "desk-lamp"}}
+					scaffold.processor.track("other-device")
+					scaffold.index.devices = nil
+					scaffold.processor.handle(message, wg)
+					g.Assert(len(scaffold.pending.queued["desk-lamp"])).Equal(0)
+					g.Assert(scaffold.commands.statuses["command-id"]).Equal(defs.CommandStatusDeviceOffline)
+				})
+
+				g.It("queues the message if unable to send to the device", func() {
+					connection := &testConnection{id: "some-device", errors: []error{fmt.Errorf("bad-send")}}
+					scaffold.processor.pool = []device.Connection{connection}
+					scaffold.processor.handle(message, wg)
+					g.Assert(len(scaffold.pending.queued["desk-lamp"])).Equal(1)
+					g.Assert(len(scaffold.commands.statuses)).Equal(0)
+				})
+			})
+
+			g.It("remembers the control message as the device's last known state", func() {
+				scaffold.index.devices = []device.RegistrationDetails{{DeviceID: "some-device", Name: "desk-lamp"}}
+				scaffold.processor.pool = []device.Connection{&testConnection{id: "some-device"}}
+				scaffold.processor.handle(message, wg)
+				_, ok := scaffold.states.states["desk-lamp"]
 				g.Assert(ok).Equal(true)
-				g.Assert(reader.String()).Equal("hello world")
+			})
+
+			g.It("publishes a command-relayed event after writing to the device", func() {
+				scaffold.index.devices = []device.RegistrationDetails{{DeviceID: "some-device", Name: "desk-lamp"}}
+				scaffold.processor.pool = []device.Connection{&testConnection{id: "some-device"}}
+				scaffold.processor.handle(message, wg)
+				g.Assert(len(scaffold.events.published)).Equal(1)
+				g.Assert(scaffold.events.published[0].Type).Equal(defs.DeviceEventCommandRelayed)
+				g.Assert(scaffold.events.published[0].DeviceName).Equal("desk-lamp")
+				g.Assert(scaffold.events.published[0].CommandID).Equal("command-id")
+			})
+
+			g.It("acknowledges messages delivered from a stream once handled", func() {
+				acknowledged := false
+				scaffold.processor.handle(&streamReader{message, func() { acknowledged = true }}, wg)
+				g.Assert(acknowledged).Equal(true)
+			})
+
+			g.It("logs the error if unable to update the command status", func() {
+				scaffold.commands.errors = []error{fmt.Errorf("bad-status")}
+				scaffold.processor.handle(message, wg)
+				g.Assert(strings.Contains(scaffold.log.String(), "bad-status")).Equal(true)
+			})
+		})
+
+		g.Describe("#welcome", func() {
+			var connection *testConnection
+			var wg *sync.WaitGroup
+
+			g.BeforeEach(func() {
+				connection, wg = &testConnection{id: "new-device"}, &sync.WaitGroup{}
+				wg.Add(1)
+				scaffold.index.devices = []device.RegistrationDetails{{DeviceID: "new-device", Name: "desk-lamp"}}
+				scaffold.pending.queued["desk-lamp"] = []interchange.DeviceMessage{{
+					Type:           interchange.DeviceMessageType_CONTROL,
+					CommandID:      "command-id",
+					Authentication: &interchange.DeviceMessageAuthentication{DeviceID: "old-device"},
+				}}
+			})
+
+			g.It("replays pending messages after the welcome message, addressed to the new device id", func() {
+				scaffold.processor.welcome(connection, wg)
+				g.Assert(len(connection.sentMessages)).Equal(2)
+				g.Assert(connection.sentMessages[0].Type).Equal(interchange.DeviceMessageType_WELCOME)
+				g.Assert(connection.sentMessages[1].GetAuthentication().GetDeviceID()).Equal("new-device")
+				g.Assert(scaffold.commands.statuses["command-id"]).Equal(defs.CommandStatusSent)
+				g.Assert(len(scaffold.pending.queued)).Equal(0)
+			})
+
+			g.It("publishes a welcomed event for the device", func() {
+				scaffold.processor.welcome(connection, wg)
+				g.Assert(len(scaffold.events.published)).Equal(1)
+				g.Assert(scaffold.events.published[0].Type).Equal(defs.DeviceEventWelcomed)
+				g.Assert(scaffold.events.published[0].DeviceID).Equal("new-device")
+			})
+
+			g.It("does not restore the last known state while replaying pending messages", func() {
+				scaffold.states.states["desk-lamp"] = interchange.ControlMessage{}
+				scaffold.processor.welcome(connection, wg)
+				g.Assert(len(connection.sentMessages)).Equal(2)
+				g.Assert(connection.sentMessages[1].CommandID).Equal("command-id")
+			})
+
+			g.It("restores the last known state when there are no pending messages", func() {
+				delete(scaffold.pending.queued, "desk-lamp")
+				scaffold.states.states["desk-lamp"] = interchange.ControlMessage{
+					Frames: []*interchange.ControlFrame{{Red: 255}},
+				}
+				scaffold.processor.welcome(connection, wg)
+				g.Assert(len(connection.sentMessages)).Equal(2)
+				restored := interchange.ControlMessage{}
+				g.Assert(proto.Unmarshal(connection.sentMessages[1].GetPayload(), &restored)).Equal(nil)
+				g.Assert(restored.Frames[0].Red).Equal(uint32(255))
+				g.Assert(connection.sentMessages[1].GetAuthentication().GetDeviceID()).Equal("new-device")
+			})
+
+			g.It("does not replay pending messages if the welcome message fails", func() {
+				connection.errors = []error{fmt.Errorf("bad-welcome")}
+				scaffold.processor.welcome(connection, wg)
+				g.Assert(len(connection.sentMessages)).Equal(1)
+				g.Assert(len(scaffold.pending.queued["desk-lamp"])).Equal(1)
 			})
 		})
 
@@ -231,6 +529,18 @@ func Test_DeviceControl(t *testing.T) {
 				g.Assert(len(scaffold.processor.pool)).Equal(2)
 			})
 
+			g.It("publishes a disconnected event for registered devices", func() {
+				scaffold.index.devices = []device.RegistrationDetails{{DeviceID: "patriots", Name: "desk-lamp"}}
+				scaffold.processor.unsubscribe(connection)
+				g.Assert(len(scaffold.events.published)).Equal(1)
+				g.Assert(scaffold.events.published[0].Type).Equal(defs.DeviceEventDisconnected)
+			})
+
+			g.It("does not publish events for devices missing from the index", func() {
+				scaffold.processor.unsubscribe(connection)
+				g.Assert(len(scaffold.events.published)).Equal(0)
+			})
+
 		})
 
 		g.Describe("#Start", func() {
@@ -273,6 +583,22 @@ func Test_DeviceControl(t *testing.T) {
 					g.Assert(strings.Contains(scaffold.log.String(), "bad-welcome-send")).Equal(true)
 				})
 
+				g.It("claims the device connection for the running node", func() {
+					scaffold.registrations <- &testConnection{id: "some-device"}
+					go scaffold.processor.Start(scaffold.wg, scaffold.kill)
+					close(scaffold.registrations)
+					scaffold.wg.Wait()
+					g.Assert(scaffold.owners.claimed).Equal([]string{"some-device"})
+				})
+
+				g.It("logs any error from claiming the device connection", func() {
+					scaffold.owners.errors = []error{fmt.Errorf("bad-claim")}
+					scaffold.registrations <- &testConnection{id: "some-device"}
+					go scaffold.processor.Start(scaffold.wg, scaffold.kill)
+					close(scaffold.registrations)
+					scaffold.wg.Wait()
+					g.Assert(strings.Contains(scaffold.log.String(), "bad-claim")).Equal(true)
+				})
 			})
 
 			g.Describe("receieving commands", func() {
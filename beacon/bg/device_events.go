@@ -0,0 +1,121 @@
+package bg
+
+import "sync"
+import "time"
+
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/logging"
+
+// DeviceEvent describes a change in the lifecycle of a device connection.
+type DeviceEvent struct {
+	Type       string    `json:"type"`
+	DeviceID   string    `json:"device_id"`
+	DeviceName string    `json:"device_name"`
+	CommandID  string    `json:"command_id,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// EventPublisher defines an interface for broadcasting device events to any interested subscribers.
+type EventPublisher interface {
+	PublishEvent(DeviceEvent)
+}
+
+// EventSubscriber defines an interface for receiving the events of a single device, by name.
+type EventSubscriber interface {
+	Subscribe(string) *EventSubscription
+	Unsubscribe(*EventSubscription)
+}
+
+// EventSubscription receives the events published for a device name. The events channel is closed once the
+// subscription has been removed or the broker has been stopped.
+type EventSubscription struct {
+	Events <-chan DeviceEvent
+	name   string
+	events chan DeviceEvent
+}
+
+// NewDeviceEventBroker returns a broker w/o any subscriptions.
+func NewDeviceEventBroker() *DeviceEventBroker {
+	logger := logging.New(defs.DeviceEventsLogPrefix, logging.Cyan)
+	return &DeviceEventBroker{Logger: logger, subscriptions: make(map[*EventSubscription]struct{})}
+}
+
+// DeviceEventBroker fans device events published by the background processors out to the subscriptions for the device
+// the event belongs to. Subscribers that are not keeping up have new events dropped rather than blocking the publisher.
+type DeviceEventBroker struct {
+	*logging.Logger
+	sync.Mutex
+	subscriptions map[*EventSubscription]struct{}
+	stopped       bool
+}
+
+// Start is the Processor#Start implementation; it waits for the kill signal and closes any remaining subscriptions.
+func (broker *DeviceEventBroker) Start(wg *sync.WaitGroup, stop KillSwitch) {
+	defer wg.Done()
+
+	broker.Infof("device event broker starting")
+	<-stop
+	broker.Infof("received kill signal, closing subscriptions")
+
+	broker.Lock()
+	defer broker.Unlock()
+
+	for subscription := range broker.subscriptions {
+		close(subscription.events)
+		delete(broker.subscriptions, subscription)
+	}
+
+	broker.stopped = true
+}
+
+// PublishEvent sends the event to every subscription for the event's device name.
+func (broker *DeviceEventBroker) PublishEvent(event DeviceEvent) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	broker.Lock()
+	defer broker.Unlock()
+
+	for subscription := range broker.subscriptions {
+		if subscription.name != event.DeviceName {
+			continue
+		}
+
+		select {
+		case subscription.events <- event:
+		default:
+			broker.Warnf("dropping %s event for slow subscriber to device[%s]", event.Type, event.DeviceName)
+		}
+	}
+}
+
+// Subscribe returns a new subscription for the events of the device name.
+func (broker *DeviceEventBroker) Subscribe(name string) *EventSubscription {
+	events := make(chan DeviceEvent, defs.DefaultEventBufferSize)
+	subscription := &EventSubscription{Events: events, name: name, events: events}
+
+	broker.Lock()
+	defer broker.Unlock()
+
+	if broker.stopped {
+		close(events)
+		return subscription
+	}
+
+	broker.subscriptions[subscription] = struct{}{}
+	return subscription
+}
+
+// Unsubscribe removes the subscription from the broker, closing its events channel.
+func (broker *DeviceEventBroker) Unsubscribe(subscription *EventSubscription) {
+	broker.Lock()
+	defer broker.Unlock()
+
+	if _, ok := broker.subscriptions[subscription]; ok != true {
+		return
+	}
+
+	close(subscription.events)
+	delete(broker.subscriptions, subscription)
+}
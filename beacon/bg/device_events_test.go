@@ -0,0 +1,87 @@
+package bg
+
+import "sync"
+import "bytes"
+import "testing"
+import "github.com/franela/goblin"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+
+type deviceEventsScaffold struct {
+	broker *DeviceEventBroker
+	log    *bytes.Buffer
+	wg     *sync.WaitGroup
+	kill   KillSwitch
+}
+
+func (s *deviceEventsScaffold) Reset() {
+	s.log = bytes.NewBuffer([]byte{})
+	s.wg = &sync.WaitGroup{}
+	s.kill = make(KillSwitch)
+	s.broker = &DeviceEventBroker{
+		Logger:        newTestLogger(s.log),
+		subscriptions: make(map[*EventSubscription]struct{}),
+	}
+}
+
+func Test_DeviceEvents(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("DeviceEventBroker", func() {
+		s := &deviceEventsScaffold{}
+
+		g.BeforeEach(s.Reset)
+
+		g.It("sends published events to subscriptions for the device name", func() {
+			subscription := s.broker.Subscribe("desk-lamp")
+			s.broker.PublishEvent(DeviceEvent{Type: defs.DeviceEventWelcomed, DeviceName: "desk-lamp"})
+			event := <-subscription.Events
+			g.Assert(event.Type).Equal(defs.DeviceEventWelcomed)
+			g.Assert(event.Timestamp.IsZero()).Equal(false)
+		})
+
+		g.It("does not send events for other devices", func() {
+			subscription := s.broker.Subscribe("desk-lamp")
+			s.broker.PublishEvent(DeviceEvent{Type: defs.DeviceEventWelcomed, DeviceName: "porch-light"})
+			g.Assert(len(subscription.Events)).Equal(0)
+		})
+
+		g.It("drops events for subscriptions that are not keeping up", func() {
+			subscription := s.broker.Subscribe("desk-lamp")
+
+			for i := 0; i <= defs.DefaultEventBufferSize; i++ {
+				s.broker.PublishEvent(DeviceEvent{Type: defs.DeviceEventWelcomed, DeviceName: "desk-lamp"})
+			}
+
+			g.Assert(len(subscription.Events)).Equal(defs.DefaultEventBufferSize)
+			g.Assert(bytes.Contains(s.log.Bytes(), []byte("dropping"))).Equal(true)
+		})
+
+		g.It("closes the events channel on unsubscribe", func() {
+			subscription := s.broker.Subscribe("desk-lamp")
+			s.broker.Unsubscribe(subscription)
+			s.broker.Unsubscribe(subscription)
+			_, ok := <-subscription.Events
+			g.Assert(ok).Equal(false)
+			g.Assert(len(s.broker.subscriptions)).Equal(0)
+		})
+
+		g.It("closes all subscriptions when the kill signal is sent", func() {
+			subscription := s.broker.Subscribe("desk-lamp")
+			s.wg.Add(1)
+			go s.broker.Start(s.wg, s.kill)
+			s.kill <- struct{}{}
+			s.wg.Wait()
+			_, ok := <-subscription.Events
+			g.Assert(ok).Equal(false)
+		})
+
+		g.It("returns closed subscriptions once stopped", func() {
+			s.wg.Add(1)
+			go s.broker.Start(s.wg, s.kill)
+			s.kill <- struct{}{}
+			s.wg.Wait()
+			_, ok := <-s.broker.Subscribe("desk-lamp").Events
+			g.Assert(ok).Equal(false)
+		})
+	})
+}
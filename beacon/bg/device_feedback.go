@@ -2,20 +2,36 @@ package bg
 
 import "io"
 import "sync"
+import "io/ioutil"
+
+import "github.com/golang/protobuf/proto"
 
 import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/device"
 import "github.com/dadleyy/beacon.api/beacon/logging"
+import "github.com/dadleyy/beacon.api/beacon/interchange"
 
 // NewDeviceFeedbackProcessor is responsible for receiving from the device feedback stream
-func NewDeviceFeedbackProcessor(feedback ReadStream) *DeviceFeedbackProcessor {
+func NewDeviceFeedbackProcessor(
+	feedback ReadStream,
+	index device.Index,
+	store device.FeedbackStore,
+	commands device.CommandStore,
+	events EventPublisher,
+) *DeviceFeedbackProcessor {
 	logger := logging.New(defs.DeviceFeedbackLogPrefix, logging.Cyan)
-	return &DeviceFeedbackProcessor{logger, feedback}
+	return &DeviceFeedbackProcessor{logger, feedback, index, store, commands, events}
 }
 
-// DeviceFeedbackProcessor is responsible for receiving from the device feedback stream
+// DeviceFeedbackProcessor is responsible for receiving from the device feedback stream, logging the feedback messages
+// sent by devices over their websocket connection into the feedback store.
 type DeviceFeedbackProcessor struct {
 	*logging.Logger
 	feedback <-chan io.Reader
+	index    device.Index
+	store    device.FeedbackStore
+	commands device.CommandStore
+	events   EventPublisher
 }
 
 // Start is the Processor#Start implementation
@@ -27,12 +43,13 @@ func (processor *DeviceFeedbackProcessor) Start(wg *sync.WaitGroup, stop KillSwi
 
 	for running {
 		select {
-		case _, ok := <-processor.feedback:
+		case reader, ok := <-processor.feedback:
 			if ok != true {
 				return
 			}
 
 			processor.Debugf("receieved message from device")
+			processor.handle(reader)
 		case <-stop:
 			processor.Warnf("received kill signal, breaking")
 			running = false
@@ -40,3 +57,56 @@ func (processor *DeviceFeedbackProcessor) Start(wg *sync.WaitGroup, stop KillSwi
 		}
 	}
 }
+
+// handle decodes the feedback message, verifying that it was sent by the device it claims to be from before logging it.
+func (processor *DeviceFeedbackProcessor) handle(reader io.Reader) {
+	defer acknowledge(reader)
+
+	source, ok := reader.(*connectionReader)
+
+	if ok != true {
+		processor.Warnf("received feedback from an unknown connection, skipping")
+		return
+	}
+
+	data, e := ioutil.ReadAll(source)
+
+	if e != nil {
+		processor.Warnf("unable to read feedback from device[%s]: %s", source.deviceID, e.Error())
+		return
+	}
+
+	message := interchange.FeedbackMessage{}
+
+	if e := proto.Unmarshal(data, &message); e != nil {
+		processor.Warnf("unable to unmarshal feedback from device[%s]: %s", source.deviceID, e.Error())
+		return
+	}
+
+	if deviceID := message.GetAuthentication().GetDeviceID(); deviceID != source.deviceID {
+		processor.Warnf("device[%s] sent feedback for device[%s], skipping", source.deviceID, deviceID)
+		return
+	}
+
+	if e := processor.store.LogFeedback(message); e != nil {
+		processor.Errorf("unable to log feedback from device[%s]: %s", source.deviceID, e.Error())
+		return
+	}
+
+	if message.CommandID != "" {
+		if e := device.AcknowledgeCommand(processor.commands, source.deviceID, message); e != nil {
+			processor.Warnf("unable to acknowledge command[%s] of device[%s]: %s", message.CommandID, source.deviceID, e.Error())
+		}
+	}
+
+	processor.Debugf("logged feedback from device[%s]", source.deviceID)
+
+	if details, e := processor.index.FindDevice(source.deviceID); e == nil {
+		processor.events.PublishEvent(DeviceEvent{
+			Type:       defs.DeviceEventFeedbackReceived,
+			DeviceID:   source.deviceID,
+			DeviceName: details.Name,
+			CommandID:  message.CommandID,
+		})
+	}
+}
@@ -1,16 +1,44 @@
 package bg
 
 import "io"
+import "fmt"
 import "sync"
 import "bytes"
 import "strings"
 import "testing"
 import "github.com/franela/goblin"
+import "github.com/golang/protobuf/proto"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/device"
+import "github.com/dadleyy/beacon.api/beacon/interchange"
+
+type testFeedbackStore struct {
+	lastErrorLister
+	logged []interchange.FeedbackMessage
+	errors []error
+}
+
+func (s *testFeedbackStore) LogFeedback(message interchange.FeedbackMessage) error {
+	if e := s.lastError(s.errors); e != nil {
+		return e
+	}
+
+	s.logged = append(s.logged, message)
+	return nil
+}
+
+func (s *testFeedbackStore) ListFeedback(string, int) ([]interchange.FeedbackMessage, error) {
+	return s.logged, nil
+}
 
 type deviceFeedbackScaffold struct {
 	receiver  chan io.Reader
 	wg        *sync.WaitGroup
 	kill      KillSwitch
+	index     *testDeviceIndex
+	store     *testFeedbackStore
+	commands  *testCommandStore
+	events    *testEventPublisher
 	processor *DeviceFeedbackProcessor
 	log       *bytes.Buffer
 }
@@ -20,12 +48,25 @@ func (s *deviceFeedbackScaffold) Reset() {
 	s.kill = make(KillSwitch, 1)
 	s.wg = &sync.WaitGroup{}
 	s.log = bytes.NewBuffer([]byte{})
+	s.index = &testDeviceIndex{}
+	s.store = &testFeedbackStore{}
+	s.commands = &testCommandStore{statuses: make(map[string]string)}
+	s.events = &testEventPublisher{}
 	s.processor = &DeviceFeedbackProcessor{
 		Logger:   newTestLogger(s.log),
 		feedback: s.receiver,
+		index:    s.index,
+		store:    s.store,
+		commands: s.commands,
+		events:   s.events,
 	}
 }
 
+func (s *deviceFeedbackScaffold) reader(deviceID string, message interchange.FeedbackMessage) io.Reader {
+	data, _ := proto.Marshal(&message)
+	return &connectionReader{bytes.NewBuffer(data), deviceID}
+}
+
 func Test_DeviceFeedback(t *testing.T) {
 	g := goblin.Goblin(t)
 
@@ -51,5 +92,68 @@ func Test_DeviceFeedback(t *testing.T) {
 			g.Assert(strings.Contains(s.log.String(), "kill signal")).Equal(true)
 		})
 
+		g.Describe("#handle", func() {
+			report := interchange.FeedbackMessage{
+				Type:           interchange.FeedbackMessageType_REPORT,
+				CommandID:      "command-id",
+				Authentication: &interchange.DeviceMessageAuthentication{DeviceID: "some-device"},
+			}
+
+			g.It("skips readers that did not come from a device connection", func() {
+				s.processor.handle(bytes.NewBuffer([]byte{}))
+				g.Assert(len(s.store.logged)).Equal(0)
+			})
+
+			g.It("skips data that is not a feedback message", func() {
+				s.processor.handle(&connectionReader{bytes.NewBuffer([]byte("{}{}{}")), "some-device"})
+				g.Assert(len(s.store.logged)).Equal(0)
+				g.Assert(strings.Contains(s.log.String(), "unable to unmarshal")).Equal(true)
+			})
+
+			g.It("skips feedback sent on behalf of another device", func() {
+				s.processor.handle(s.reader("other-device", report))
+				g.Assert(len(s.store.logged)).Equal(0)
+			})
+
+			g.It("logs the error if unable to store the feedback", func() {
+				s.store.errors = []error{fmt.Errorf("bad-log")}
+				s.processor.handle(s.reader("some-device", report))
+				g.Assert(strings.Contains(s.log.String(), "bad-log")).Equal(true)
+			})
+
+			g.It("stores feedback sent by the device", func() {
+				s.processor.handle(s.reader("some-device", report))
+				g.Assert(len(s.store.logged)).Equal(1)
+				g.Assert(s.store.logged[0].GetAuthentication().GetDeviceID()).Equal("some-device")
+			})
+
+			g.It("acknowledges the command the feedback was sent for", func() {
+				s.commands.commands = []device.CommandDetails{{CommandID: "command-id", DeviceID: "some-device"}}
+				s.processor.handle(s.reader("some-device", report))
+				g.Assert(s.commands.statuses["command-id"]).Equal(defs.CommandStatusAcknowledged)
+			})
+
+			g.It("does not acknowledge commands sent to other devices", func() {
+				s.commands.commands = []device.CommandDetails{{CommandID: "command-id", DeviceID: "other-device"}}
+				s.processor.handle(s.reader("some-device", report))
+				g.Assert(len(s.commands.statuses)).Equal(0)
+			})
+
+			g.It("publishes a feedback-received event once the feedback is stored", func() {
+				s.index.devices = []device.RegistrationDetails{{DeviceID: "some-device", Name: "desk-lamp"}}
+				s.processor.handle(s.reader("some-device", report))
+				g.Assert(len(s.events.published)).Equal(1)
+				g.Assert(s.events.published[0].Type).Equal(defs.DeviceEventFeedbackReceived)
+				g.Assert(s.events.published[0].DeviceName).Equal("desk-lamp")
+				g.Assert(s.events.published[0].CommandID).Equal("command-id")
+			})
+
+			g.It("does not publish an event if unable to store the feedback", func() {
+				s.index.devices = []device.RegistrationDetails{{DeviceID: "some-device", Name: "desk-lamp"}}
+				s.store.errors = []error{fmt.Errorf("bad-log")}
+				s.processor.handle(s.reader("some-device", report))
+				g.Assert(len(s.events.published)).Equal(0)
+			})
+		})
 	})
 }
@@ -0,0 +1,171 @@
+package bg
+
+import "sync"
+import "time"
+import "bytes"
+
+import "github.com/golang/protobuf/proto"
+
+import "github.com/dadleyy/beacon.api/beacon/cron"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/device"
+import "github.com/dadleyy/beacon.api/beacon/logging"
+import "github.com/dadleyy/beacon.api/beacon/interchange"
+
+// NewDeviceScheduleProcessor returns a processor that publishes scheduled control messages once they are due.
+func NewDeviceScheduleProcessor(
+	s device.ScheduleStore, i device.Index, q device.PendingMessageStore, c device.CommandStore, p ChannelPublisher,
+) *DeviceScheduleProcessor {
+	logger := logging.New(defs.DeviceScheduleLogPrefix, logging.Magenta)
+	return &DeviceScheduleProcessor{logger, s, i, q, c, p, defs.DefaultScheduleInterval}
+}
+
+// DeviceScheduleProcessor periodically checks the schedule store for due schedules, publishing their control messages
+// onto the device control channel. Since schedules are persisted in the store, any schedules that became due while the
+// server was not running are sent on the first check after starting. Messages for devices that are not connected are
+// held in the pending message store until the device reconnects. Every message sent is recorded in the command log of
+// the device name w/ the schedule as its credential.
+type DeviceScheduleProcessor struct {
+	*logging.Logger
+	store     device.ScheduleStore
+	index     device.Index
+	pending   device.PendingMessageStore
+	commands  device.CommandStore
+	publisher ChannelPublisher
+	interval  time.Duration
+}
+
+// Start is the Processor#Start implementation
+func (processor *DeviceScheduleProcessor) Start(wg *sync.WaitGroup, stop KillSwitch) {
+	defer wg.Done()
+
+	processor.Infof("device schedule processor starting")
+
+	timer := time.NewTicker(processor.interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case now := <-timer.C:
+			processor.run(now)
+		case <-stop:
+			processor.Infof("received kill signal, breaking")
+			return
+		}
+	}
+}
+
+// run claims every due schedule - advancing recurring schedules to their next run and removing the others - and only
+// publishes the schedules it was able to claim. Every api node runs this processor, so a schedule that was claimed by
+// another node first is skipped.
+func (processor *DeviceScheduleProcessor) run(now time.Time) {
+	due, e := processor.store.DueSchedules(now)
+
+	if e != nil {
+		processor.Errorf("unable to load due schedules: %s", e.Error())
+		return
+	}
+
+	for _, schedule := range due {
+		claimed, e := processor.store.ClaimSchedule(schedule, processor.next(schedule, now))
+
+		if e != nil {
+			processor.Errorf("unable to claim schedule[%s]: %s", schedule.ScheduleID, e.Error())
+			continue
+		}
+
+		if claimed != true {
+			processor.Debugf("schedule[%s] was already claimed by another node", schedule.ScheduleID)
+			continue
+		}
+
+		processor.publish(schedule)
+	}
+}
+
+// publish sends the scheduled control message to the device if it is currently connected, otherwise the message is
+// queued until the device reconnects.
+func (processor *DeviceScheduleProcessor) publish(schedule device.ScheduleDetails) {
+	commandData, e := proto.Marshal(schedule.Message)
+
+	if e != nil {
+		processor.Errorf("unable to marshal schedule[%s] message: %s", schedule.ScheduleID, e.Error())
+		return
+	}
+
+	message := interchange.DeviceMessage{
+		Type:    interchange.DeviceMessageType_CONTROL,
+		Payload: commandData,
+	}
+
+	details, e := processor.index.FindDevice(schedule.DeviceName)
+
+	if e != nil {
+		processor.Warnf("device[%s] not connected, queueing schedule[%s]", schedule.DeviceName, schedule.ScheduleID)
+
+		if processor.queue(schedule, message) {
+			processor.log(schedule, "")
+		}
+
+		return
+	}
+
+	message.Authentication = &interchange.DeviceMessageAuthentication{DeviceID: details.DeviceID}
+
+	data, e := proto.Marshal(&message)
+
+	if e != nil {
+		processor.Errorf("unable to marshal schedule[%s] device message: %s", schedule.ScheduleID, e.Error())
+		return
+	}
+
+	if e := processor.publisher.PublishReader(defs.DeviceControlChannelName, bytes.NewBuffer(data)); e != nil {
+		processor.Errorf("unable to publish schedule[%s]: %s", schedule.ScheduleID, e.Error())
+		return
+	}
+
+	processor.log(schedule, details.DeviceID)
+	processor.Infof("published schedule[%s] to device[%s]", schedule.ScheduleID, details.DeviceID)
+}
+
+func (processor *DeviceScheduleProcessor) queue(schedule device.ScheduleDetails, message interchange.DeviceMessage) bool {
+	if e := processor.pending.QueueMessage(schedule.DeviceName, message); e != nil {
+		processor.Errorf("unable to queue schedule[%s]: %s", schedule.ScheduleID, e.Error())
+		return false
+	}
+
+	return true
+}
+
+// log records the message of the schedule in the command log of its device name. Messages that were queued for a device
+// that is not connected are logged w/o a device id.
+func (processor *DeviceScheduleProcessor) log(schedule device.ScheduleDetails, deviceID string) {
+	entry := device.CommandLogEntry{
+		DeviceID:     deviceID,
+		DeviceName:   schedule.DeviceName,
+		Credential:   defs.SecurityCredentialSchedule,
+		CredentialID: schedule.ScheduleID,
+		Message:      schedule.Message,
+	}
+
+	if e := processor.commands.LogCommand("", entry); e != nil {
+		processor.Errorf("unable to log schedule[%s]: %s", schedule.ScheduleID, e.Error())
+	}
+}
+
+// next returns the run that follows the current one for recurring schedules, or the zero time for schedules that should
+// be removed once they have run.
+func (processor *DeviceScheduleProcessor) next(schedule device.ScheduleDetails, now time.Time) time.Time {
+	if schedule.Cron == "" {
+		return time.Time{}
+	}
+
+	expression, e := cron.Parse(schedule.Cron)
+
+	if e != nil {
+		processor.Warnf("removing schedule[%s] w/ invalid cron expression: %s", schedule.ScheduleID, schedule.Cron)
+		return time.Time{}
+	}
+
+	return expression.Next(now.In(time.Local))
+}
@@ -0,0 +1,202 @@
+package bg
+
+import "io"
+import "fmt"
+import "sync"
+import "time"
+import "bytes"
+import "strings"
+import "testing"
+import "github.com/franela/goblin"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/device"
+import "github.com/dadleyy/beacon.api/beacon/interchange"
+
+type testScheduleStore struct {
+	lastErrorLister
+	due         []device.ScheduleDetails
+	dueErrors   []error
+	removed     []string
+	advanced    map[string]time.Time
+	unclaimed   bool
+	claimErrors []error
+}
+
+func (s *testScheduleStore) CreateSchedule(d device.ScheduleDetails) (device.ScheduleDetails, error) {
+	return d, nil
+}
+
+func (s *testScheduleStore) ListSchedules(string) ([]device.ScheduleDetails, error) {
+	return nil, nil
+}
+
+func (s *testScheduleStore) RemoveSchedule(string, string) error {
+	return nil
+}
+
+func (s *testScheduleStore) DueSchedules(time.Time) ([]device.ScheduleDetails, error) {
+	return s.due, s.lastError(s.dueErrors)
+}
+
+func (s *testScheduleStore) ClaimSchedule(schedule device.ScheduleDetails, next time.Time) (bool, error) {
+	if e := s.lastError(s.claimErrors); e != nil || s.unclaimed {
+		return false, e
+	}
+
+	if next.IsZero() {
+		s.removed = append(s.removed, schedule.ScheduleID)
+		return true, nil
+	}
+
+	s.advanced[schedule.ScheduleID] = next
+	return true, nil
+}
+
+type testPublisher struct {
+	lastErrorLister
+	published []io.Reader
+	errors    []error
+}
+
+func (p *testPublisher) PublishReader(_ string, reader io.Reader) error {
+	p.published = append(p.published, reader)
+	return p.lastError(p.errors)
+}
+
+type deviceScheduleScaffold struct {
+	store     *testScheduleStore
+	index     *testDeviceIndex
+	pending   *testPendingStore
+	commands  *testCommandStore
+	publisher *testPublisher
+	processor *DeviceScheduleProcessor
+	log       *bytes.Buffer
+}
+
+func (s *deviceScheduleScaffold) Reset() {
+	s.store = &testScheduleStore{advanced: make(map[string]time.Time)}
+	s.index = &testDeviceIndex{}
+	s.pending = &testPendingStore{queued: make(map[string][]interchange.DeviceMessage)}
+	s.commands = &testCommandStore{statuses: make(map[string]string)}
+	s.publisher = &testPublisher{}
+	s.log = bytes.NewBuffer([]byte{})
+	s.processor = &DeviceScheduleProcessor{
+		Logger:    newTestLogger(s.log),
+		store:     s.store,
+		index:     s.index,
+		pending:   s.pending,
+		commands:  s.commands,
+		publisher: s.publisher,
+		interval:  time.Millisecond,
+	}
+}
+
+func (s *deviceScheduleScaffold) schedule(id, cron string) device.ScheduleDetails {
+	message := &interchange.ControlMessage{Frames: []*interchange.ControlFrame{{Red: 255}}}
+	return device.ScheduleDetails{ScheduleID: id, DeviceName: "desk-lamp", Cron: cron, Message: message}
+}
+
+func Test_DeviceSchedule(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("DeviceScheduleProcessor", func() {
+		s := &deviceScheduleScaffold{}
+		now := time.Date(2026, time.October, 17, 16, 20, 30, 0, time.Local)
+
+		g.BeforeEach(s.Reset)
+
+		g.It("successfully terminates when kill signal is given", func() {
+			wg, kill := &sync.WaitGroup{}, make(KillSwitch)
+			wg.Add(1)
+			go s.processor.Start(wg, kill)
+			kill <- struct{}{}
+			wg.Wait()
+		})
+
+		g.It("does nothing if unable to load the due schedules", func() {
+			s.store.due = []device.ScheduleDetails{s.schedule("one-time", "")}
+			s.store.dueErrors = []error{fmt.Errorf("bad-load")}
+			s.processor.run(now)
+			g.Assert(len(s.publisher.published)).Equal(0)
+			g.Assert(len(s.store.removed)).Equal(0)
+		})
+
+		g.It("does not publish schedules it was unable to claim", func() {
+			s.index.devices = []device.RegistrationDetails{{DeviceID: "device-id", Name: "desk-lamp"}}
+			s.store.due = []device.ScheduleDetails{s.schedule("one-time", "")}
+			s.store.claimErrors = []error{fmt.Errorf("bad-claim")}
+			s.processor.run(now)
+			g.Assert(len(s.publisher.published)).Equal(0)
+			g.Assert(strings.Contains(s.log.String(), "bad-claim")).Equal(true)
+		})
+
+		g.It("does not publish schedules that were claimed by another node", func() {
+			s.index.devices = []device.RegistrationDetails{{DeviceID: "device-id", Name: "desk-lamp"}}
+			s.store.due = []device.ScheduleDetails{s.schedule("one-time", "")}
+			s.store.unclaimed = true
+			s.processor.run(now)
+			g.Assert(len(s.publisher.published)).Equal(0)
+			g.Assert(len(s.pending.queued["desk-lamp"])).Equal(0)
+		})
+
+		g.Describe("having found a connected device", func() {
+			g.BeforeEach(func() {
+				s.index.devices = []device.RegistrationDetails{{DeviceID: "device-id", Name: "desk-lamp"}}
+			})
+
+			g.It("publishes and removes one-time schedules", func() {
+				s.store.due = []device.ScheduleDetails{s.schedule("one-time", "")}
+				s.processor.run(now)
+				g.Assert(len(s.publisher.published)).Equal(1)
+				g.Assert(s.store.removed).Equal([]string{"one-time"})
+			})
+
+			g.It("publishes and advances recurring schedules to their next run", func() {
+				s.store.due = []device.ScheduleDetails{s.schedule("recurring", "0 17 * * *")}
+				s.processor.run(now)
+				g.Assert(len(s.publisher.published)).Equal(1)
+				g.Assert(s.store.advanced["recurring"]).Equal(time.Date(2026, time.October, 17, 17, 0, 0, 0, time.Local))
+			})
+
+			g.It("logs published messages w/ the schedule as their credential", func() {
+				s.store.due = []device.ScheduleDetails{s.schedule("one-time", "")}
+				s.processor.run(now)
+				g.Assert(len(s.commands.logged)).Equal(1)
+				g.Assert(s.commands.logged[0].Credential).Equal(defs.SecurityCredentialSchedule)
+				g.Assert(s.commands.logged[0].CredentialID).Equal("one-time")
+				g.Assert(s.commands.logged[0].DeviceID).Equal("device-id")
+			})
+
+			g.It("logs the error if unable to log the published message", func() {
+				s.store.due = []device.ScheduleDetails{s.schedule("one-time", "")}
+				s.commands.errors = []error{fmt.Errorf("bad-log")}
+				s.processor.run(now)
+				g.Assert(len(s.publisher.published)).Equal(1)
+				g.Assert(strings.Contains(s.log.String(), "bad-log")).Equal(true)
+			})
+
+			g.It("removes recurring schedules w/ invalid cron expressions", func() {
+				s.store.due = []device.ScheduleDetails{s.schedule("recurring", "0 25 * * *")}
+				s.processor.run(now)
+				g.Assert(s.store.removed).Equal([]string{"recurring"})
+			})
+		})
+
+		g.It("queues and advances schedules for devices that are not connected w/o publishing", func() {
+			s.store.due = []device.ScheduleDetails{s.schedule("recurring", "0 17 * * *")}
+			s.processor.run(now)
+			g.Assert(len(s.publisher.published)).Equal(0)
+			g.Assert(len(s.pending.queued["desk-lamp"])).Equal(1)
+			g.Assert(len(s.store.advanced)).Equal(1)
+			g.Assert(s.commands.logged[0].DeviceName).Equal("desk-lamp")
+		})
+
+		g.It("logs the error if unable to queue the message for a disconnected device", func() {
+			s.store.due = []device.ScheduleDetails{s.schedule("one-time", "")}
+			s.pending.errors = []error{fmt.Errorf("bad-queue")}
+			s.processor.run(now)
+			g.Assert(strings.Contains(s.log.String(), "bad-queue")).Equal(true)
+			g.Assert(s.store.removed).Equal([]string{"one-time"})
+		})
+	})
+}
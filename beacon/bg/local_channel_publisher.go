@@ -0,0 +1,39 @@
+package bg
+
+import "io"
+import "sync"
+
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/logging"
+
+// NewLocalChannelPublisher returns a relay for an api node that does not share its channels w/ any other node, which is
+// the case for the stores that do not use redis.
+func NewLocalChannelPublisher(local ChannelStore) *LocalChannelPublisher {
+	logger := logging.New(defs.ChannelRelayLogPrefix, logging.Blue)
+	return &LocalChannelPublisher{logger, local}
+}
+
+// LocalChannelPublisher delivers every message to the local channel store. Device claims are not recorded since the
+// running node holds the connection of every device.
+type LocalChannelPublisher struct {
+	*logging.Logger
+	local ChannelStore
+}
+
+// ClaimDevice is the DeviceClaimer#ClaimDevice implementation; there is no other node to relay messages from.
+func (publisher *LocalChannelPublisher) ClaimDevice(deviceID string) error {
+	return nil
+}
+
+// PublishReader is the ChannelPublisher#PublishReader implementation.
+func (publisher *LocalChannelPublisher) PublishReader(name string, reader io.Reader) error {
+	return publisher.local.PublishReader(name, reader)
+}
+
+// Start is the Processor#Start implementation; there is nothing to relay so it only waits for the kill signal.
+func (publisher *LocalChannelPublisher) Start(wg *sync.WaitGroup, stop KillSwitch) {
+	defer wg.Done()
+	publisher.Infof("channel relay starting w/o other nodes")
+	<-stop
+	publisher.Infof("received kill signal, breaking")
+}
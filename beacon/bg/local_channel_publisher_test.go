@@ -0,0 +1,47 @@
+package bg
+
+import "io"
+import "sync"
+import "bytes"
+import "testing"
+import "io/ioutil"
+import "github.com/franela/goblin"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+
+func Test_LocalChannelPublisher(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("LocalChannelPublisher", func() {
+		var publisher *LocalChannelPublisher
+		var local ChannelStore
+
+		g.BeforeEach(func() {
+			local = ChannelStore{defs.DeviceControlChannelName: make(chan io.Reader, 1)}
+			publisher = NewLocalChannelPublisher(local)
+			publisher.Logger = newTestLogger(bytes.NewBuffer([]byte{}))
+		})
+
+		g.It("delivers messages to the local channel", func() {
+			g.Assert(publisher.PublishReader(defs.DeviceControlChannelName, bytes.NewBufferString("hi"))).Equal(nil)
+			data, _ := ioutil.ReadAll(<-local[defs.DeviceControlChannelName])
+			g.Assert(string(data)).Equal("hi")
+		})
+
+		g.It("errors when publishing to an unknown channel", func() {
+			e := publisher.PublishReader("unknown", bytes.NewBufferString("hi"))
+			g.Assert(e.Error()).Equal(defs.ErrInvalidBackgroundChannel)
+		})
+
+		g.It("accepts device claims w/o recording them", func() {
+			g.Assert(publisher.ClaimDevice("device-id")).Equal(nil)
+		})
+
+		g.It("successfully terminates when kill signal is given", func() {
+			wg, kill := &sync.WaitGroup{}, make(KillSwitch)
+			wg.Add(1)
+			go publisher.Start(wg, kill)
+			kill <- struct{}{}
+			wg.Wait()
+		})
+	})
+}
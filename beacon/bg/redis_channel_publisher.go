@@ -0,0 +1,208 @@
+package bg
+
+import "io"
+import "fmt"
+import "sync"
+import "time"
+import "bytes"
+import "strings"
+import "io/ioutil"
+
+import "github.com/garyburd/redigo/redis"
+import "github.com/golang/protobuf/proto"
+
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/device"
+import "github.com/dadleyy/beacon.api/beacon/logging"
+import "github.com/dadleyy/beacon.api/beacon/interchange"
+
+// RedisConnector defines an interface for retrieving redis connections, satisfied by the redis.Pool type.
+type RedisConnector interface {
+	Get() redis.Conn
+}
+
+// DeviceClaimer defines an interface for recording that the connection of a device is held by the running api node.
+type DeviceClaimer interface {
+	ClaimDevice(string) error
+}
+
+// ChannelRelay defines an interface for publishers that share the background channels between api nodes.
+type ChannelRelay interface {
+	ChannelPublisher
+	DeviceClaimer
+	Processor
+}
+
+// NewRedisChannelPublisher returns a publisher that relays messages for devices connected to other api nodes over
+// redis pub/sub, delivering everything else to the local channel store.
+func NewRedisChannelPublisher(
+	pool RedisConnector,
+	node string,
+	owners device.OwnershipStore,
+	local ChannelStore,
+) *RedisChannelPublisher {
+	logger := logging.New(defs.ChannelRelayLogPrefix, logging.Blue)
+	return &RedisChannelPublisher{logger, pool, node, owners, local, defs.DefaultRelayRetryDelay}
+}
+
+// RedisChannelPublisher allows several api nodes to share the background channels. Control messages are published to
+// the node holding the connection of the device they are addressed to, which relays them onto its own local channels.
+// Messages for devices that are not claimed by any node, or whose node is no longer listening, are delivered locally
+// so the control processor is able to queue them until the device reconnects.
+type RedisChannelPublisher struct {
+	*logging.Logger
+	pool   RedisConnector
+	node   string
+	owners device.OwnershipStore
+	local  ChannelStore
+	retry  time.Duration
+}
+
+// ClaimDevice records the running node as the owner of the device connection.
+func (publisher *RedisChannelPublisher) ClaimDevice(deviceID string) error {
+	return publisher.owners.ClaimDevice(deviceID, publisher.node)
+}
+
+// PublishReader is the ChannelPublisher#PublishReader implementation.
+func (publisher *RedisChannelPublisher) PublishReader(name string, reader io.Reader) error {
+	if _, ok := publisher.local[name]; ok != true {
+		return fmt.Errorf(defs.ErrInvalidBackgroundChannel)
+	}
+
+	data, e := ioutil.ReadAll(reader)
+
+	if e != nil {
+		return e
+	}
+
+	node := routeNode(publisher.owners, publisher.node, name, data)
+
+	if node == publisher.node {
+		return publisher.local.PublishReader(name, bytes.NewBuffer(data))
+	}
+
+	connection := publisher.pool.Get()
+	defer connection.Close()
+
+	receivers, e := redis.Int(connection.Do("PUBLISH", publisher.genChannelKey(node, name), data))
+
+	if e != nil {
+		return e
+	}
+
+	if receivers == 0 {
+		publisher.Warnf("node[%s] is not listening on %s, delivering locally", node, name)
+		return publisher.local.PublishReader(name, bytes.NewBuffer(data))
+	}
+
+	publisher.Debugf("relayed %s message to node[%s]", name, node)
+	return nil
+}
+
+// Start is the Processor#Start implementation; it subscribes to the channels of the running node and relays any
+// messages published to them onto the local channel store, resubscribing if the redis connection is lost.
+func (publisher *RedisChannelPublisher) Start(wg *sync.WaitGroup, stop KillSwitch) {
+	defer wg.Done()
+
+	publisher.Infof("channel relay starting for node[%s]", publisher.node)
+
+	for publisher.subscribe(stop) != true {
+		select {
+		case <-time.After(publisher.retry):
+		case <-stop:
+			publisher.Infof("received kill signal, breaking")
+			return
+		}
+	}
+}
+
+// subscribe relays messages until the kill signal is received (returning true) or the subscription fails.
+func (publisher *RedisChannelPublisher) subscribe(stop KillSwitch) bool {
+	connection := redis.PubSubConn{Conn: publisher.pool.Get()}
+	defer connection.Close()
+
+	channels := make([]interface{}, 0, len(publisher.local))
+
+	for name := range publisher.local {
+		channels = append(channels, publisher.genChannelKey(publisher.node, name))
+	}
+
+	if e := connection.Subscribe(channels...); e != nil {
+		publisher.Errorf("unable to subscribe to node channels: %s", e.Error())
+		return false
+	}
+
+	messages := make(chan redis.Message)
+
+	go func() {
+		defer close(messages)
+
+		for {
+			switch value := connection.Receive().(type) {
+			case redis.Message:
+				messages <- value
+			case redis.Subscription:
+				if value.Count == 0 {
+					return
+				}
+			case error:
+				publisher.Errorf("lost node channel subscription: %s", value.Error())
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case message, ok := <-messages:
+			if ok != true {
+				return false
+			}
+
+			publisher.relay(message)
+		case <-stop:
+			publisher.Infof("received kill signal, unsubscribing")
+			connection.Unsubscribe()
+
+			for range messages {
+			}
+
+			return true
+		}
+	}
+}
+
+// relay delivers a message received from another node onto the matching local channel.
+func (publisher *RedisChannelPublisher) relay(message redis.Message) {
+	name := strings.TrimPrefix(message.Channel, publisher.genChannelKey(publisher.node, ""))
+
+	if e := publisher.local.PublishReader(name, bytes.NewBuffer(message.Data)); e != nil {
+		publisher.Warnf("unable to relay message from %s: %s", message.Channel, e.Error())
+	}
+}
+
+// routeNode returns the id of the node that should receive the message. Control messages are sent to the node that
+// owns the device they are addressed to; everything else stays on the running node.
+func routeNode(owners device.OwnershipStore, node, name string, data []byte) string {
+	if name != defs.DeviceControlChannelName {
+		return node
+	}
+
+	message := interchange.DeviceMessage{}
+
+	if e := proto.Unmarshal(data, &message); e != nil {
+		return node
+	}
+
+	owner, e := owners.FindOwner(message.GetAuthentication().GetDeviceID())
+
+	if e != nil || owner == "" {
+		return node
+	}
+
+	return owner
+}
+
+func (publisher *RedisChannelPublisher) genChannelKey(node, name string) string {
+	return fmt.Sprintf("%s:%s:%s", defs.RedisNodeChannelKey, node, name)
+}
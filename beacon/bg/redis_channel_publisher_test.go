@@ -0,0 +1,260 @@
+package bg
+
+import "io"
+import "fmt"
+import "sync"
+import "time"
+import "bytes"
+import "strings"
+import "testing"
+import "io/ioutil"
+import "github.com/franela/goblin"
+import "github.com/golang/protobuf/proto"
+import "github.com/garyburd/redigo/redis"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/interchange"
+
+type testRedisConnection struct {
+	lastErrorLister
+	sync.Mutex
+	published [][]interface{}
+	commands  []string
+	responses map[string][]interface{}
+	sent      []string
+	replies   chan interface{}
+	receivers int64
+	errors    []error
+}
+
+func (c *testRedisConnection) Close() error {
+	return nil
+}
+
+func (c *testRedisConnection) Err() error {
+	return nil
+}
+
+func (c *testRedisConnection) Do(name string, args ...interface{}) (interface{}, error) {
+	c.Lock()
+	defer c.Unlock()
+	c.published, c.commands = append(c.published, args), append(c.commands, name)
+
+	if responses := c.responses[name]; len(responses) >= 1 {
+		c.responses[name] = responses[1:]
+		return responses[0], c.lastError(c.errors)
+	}
+
+	if name == "XREADGROUP" {
+		time.Sleep(time.Millisecond)
+		return nil, nil
+	}
+
+	return c.receivers, c.lastError(c.errors)
+}
+
+func (c *testRedisConnection) called(name string) [][]interface{} {
+	c.Lock()
+	defer c.Unlock()
+	results := make([][]interface{}, 0, len(c.commands))
+
+	for i, command := range c.commands {
+		if command == name {
+			results = append(results, c.published[i])
+		}
+	}
+
+	return results
+}
+
+func (c *testRedisConnection) Send(name string, args ...interface{}) error {
+	c.Lock()
+	defer c.Unlock()
+	c.sent = append(c.sent, name)
+
+	if name == "UNSUBSCRIBE" {
+		c.replies <- []interface{}{[]byte("unsubscribe"), []byte("all"), int64(0)}
+	}
+
+	return nil
+}
+
+func (c *testRedisConnection) Flush() error {
+	return nil
+}
+
+func (c *testRedisConnection) Receive() (interface{}, error) {
+	reply := <-c.replies
+
+	if e, ok := reply.(error); ok {
+		return nil, e
+	}
+
+	return reply, nil
+}
+
+func (c *testRedisConnection) subscriptions() int {
+	c.Lock()
+	defer c.Unlock()
+	count := 0
+
+	for _, name := range c.sent {
+		if name == "SUBSCRIBE" {
+			count++
+		}
+	}
+
+	return count
+}
+
+type testRedisConnector struct {
+	connection *testRedisConnection
+}
+
+func (c *testRedisConnector) Get() redis.Conn {
+	return c.connection
+}
+
+type testOwnershipStore struct {
+	owners map[string]string
+}
+
+func (s *testOwnershipStore) ClaimDevice(deviceID, nodeID string) error {
+	s.owners[deviceID] = nodeID
+	return nil
+}
+
+func (s *testOwnershipStore) FindOwner(deviceID string) (string, error) {
+	if owner, ok := s.owners[deviceID]; ok {
+		return owner, nil
+	}
+
+	return "", fmt.Errorf("not-found")
+}
+
+type redisChannelPublisherScaffold struct {
+	connection *testRedisConnection
+	owners     *testOwnershipStore
+	local      ChannelStore
+	publisher  *RedisChannelPublisher
+	log        *bytes.Buffer
+	wg         *sync.WaitGroup
+	kill       KillSwitch
+}
+
+func (s *redisChannelPublisherScaffold) Reset() {
+	s.connection = &testRedisConnection{replies: make(chan interface{}, 10), responses: make(map[string][]interface{})}
+	s.owners = &testOwnershipStore{owners: make(map[string]string)}
+	s.local = ChannelStore{defs.DeviceControlChannelName: make(chan io.Reader, 1)}
+	s.log = bytes.NewBuffer([]byte{})
+	s.wg = &sync.WaitGroup{}
+	s.kill = make(KillSwitch)
+	s.publisher = &RedisChannelPublisher{
+		Logger: newTestLogger(s.log),
+		pool:   &testRedisConnector{s.connection},
+		node:   "node-a",
+		owners: s.owners,
+		local:  s.local,
+		retry:  time.Millisecond,
+	}
+}
+
+func (s *redisChannelPublisherScaffold) message(deviceID string) []byte {
+	data, _ := proto.Marshal(&interchange.DeviceMessage{
+		Type:           interchange.DeviceMessageType_CONTROL,
+		Authentication: &interchange.DeviceMessageAuthentication{DeviceID: deviceID},
+	})
+	return data
+}
+
+func Test_RedisChannelPublisher(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("RedisChannelPublisher", func() {
+		s := &redisChannelPublisherScaffold{}
+
+		g.BeforeEach(s.Reset)
+
+		g.Describe("#ClaimDevice", func() {
+			g.It("claims the device for the running node", func() {
+				g.Assert(s.publisher.ClaimDevice("some-device")).Equal(nil)
+				g.Assert(s.owners.owners["some-device"]).Equal("node-a")
+			})
+		})
+
+		g.Describe("#PublishReader", func() {
+			control := defs.DeviceControlChannelName
+
+			g.It("returns an error for unknown channels", func() {
+				e := s.publisher.PublishReader("nope", bytes.NewBuffer(s.message("some-device")))
+				g.Assert(e.Error()).Equal(defs.ErrInvalidBackgroundChannel)
+			})
+
+			g.It("delivers messages for devices that have not been claimed locally", func() {
+				g.Assert(s.publisher.PublishReader(control, bytes.NewBuffer(s.message("some-device")))).Equal(nil)
+				g.Assert(len(s.local[control])).Equal(1)
+				g.Assert(len(s.connection.published)).Equal(0)
+			})
+
+			g.It("delivers messages for devices claimed by the running node locally", func() {
+				s.owners.owners["some-device"] = "node-a"
+				g.Assert(s.publisher.PublishReader(control, bytes.NewBuffer(s.message("some-device")))).Equal(nil)
+				g.Assert(len(s.local[control])).Equal(1)
+				g.Assert(len(s.connection.published)).Equal(0)
+			})
+
+			g.Describe("for devices claimed by another node", func() {
+				g.BeforeEach(func() {
+					s.owners.owners["some-device"] = "node-b"
+				})
+
+				g.It("publishes the message to the channel of the owning node", func() {
+					s.connection.receivers = 1
+					g.Assert(s.publisher.PublishReader(control, bytes.NewBuffer(s.message("some-device")))).Equal(nil)
+					g.Assert(len(s.local[control])).Equal(0)
+					g.Assert(s.connection.published[0][0]).Equal(defs.RedisNodeChannelKey + ":node-b:" + control)
+					g.Assert(s.connection.published[0][1]).Equal(s.message("some-device"))
+				})
+
+				g.It("delivers the message locally if the owning node is not listening", func() {
+					g.Assert(s.publisher.PublishReader(control, bytes.NewBuffer(s.message("some-device")))).Equal(nil)
+					g.Assert(len(s.local[control])).Equal(1)
+				})
+
+				g.It("returns the error from redis if unable to publish", func() {
+					s.connection.errors = []error{fmt.Errorf("bad-publish")}
+					e := s.publisher.PublishReader(control, bytes.NewBuffer(s.message("some-device")))
+					g.Assert(e.Error()).Equal("bad-publish")
+				})
+			})
+		})
+
+		g.Describe("#Start", func() {
+			channel := []byte(defs.RedisNodeChannelKey + ":node-a:" + defs.DeviceControlChannelName)
+
+			g.It("relays messages published to the node onto the local channels", func() {
+				s.connection.replies <- []interface{}{[]byte("message"), channel, []byte("hello")}
+				s.wg.Add(1)
+				go s.publisher.Start(s.wg, s.kill)
+				reader := <-s.local[defs.DeviceControlChannelName]
+				data, _ := ioutil.ReadAll(reader)
+				g.Assert(string(data)).Equal("hello")
+				s.kill <- struct{}{}
+				s.wg.Wait()
+			})
+
+			g.It("resubscribes after losing the subscription", func() {
+				s.connection.replies <- fmt.Errorf("bad-connection")
+				s.wg.Add(1)
+				go s.publisher.Start(s.wg, s.kill)
+
+				for s.connection.subscriptions() < 2 {
+					time.Sleep(time.Millisecond)
+				}
+
+				s.kill <- struct{}{}
+				s.wg.Wait()
+				g.Assert(strings.Contains(s.log.String(), "bad-connection")).Equal(true)
+			})
+		})
+	})
+}
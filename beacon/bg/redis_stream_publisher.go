@@ -0,0 +1,410 @@
+package bg
+
+import "io"
+import "fmt"
+import "sort"
+import "sync"
+import "time"
+import "bytes"
+import "strings"
+import "io/ioutil"
+
+import "github.com/garyburd/redigo/redis"
+
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/device"
+import "github.com/dadleyy/beacon.api/beacon/logging"
+
+// NewRedisStreamPublisher returns a publisher that appends messages to the redis streams of the api node that should
+// handle them, delivering the entries of the running node's streams to the local channel store.
+func NewRedisStreamPublisher(
+	pool RedisConnector,
+	node string,
+	owners device.OwnershipStore,
+	local ChannelStore,
+) *RedisStreamPublisher {
+	logger := logging.New(defs.ChannelStreamsLogPrefix, logging.Blue)
+
+	return &RedisStreamPublisher{
+		Logger:   logger,
+		pool:     pool,
+		node:     node,
+		owners:   owners,
+		local:    local,
+		outboxes: make(map[string]chan io.Reader),
+		retry:    defs.DefaultRelayRetryDelay,
+		block:    defs.DefaultStreamBlockTimeout,
+	}
+}
+
+// RedisStreamPublisher persists control & feedback messages in redis streams before they are handled. Entries are read
+// through a consumer group and only acknowledged once the processor reading them from the local channel is done w/
+// them, so entries that were not handled before a crash are delivered again when the node restarts w/ the same id.
+type RedisStreamPublisher struct {
+	*logging.Logger
+	pool     RedisConnector
+	node     string
+	owners   device.OwnershipStore
+	local    ChannelStore
+	outboxes map[string]chan io.Reader
+	retry    time.Duration
+	block    time.Duration
+}
+
+// streamReader is delivered to the local channels for each stream entry; the processor handling it acknowledges it.
+type streamReader struct {
+	io.Reader
+	acknowledge func()
+}
+
+// Acknowledge marks the stream entry as handled.
+func (reader *streamReader) Acknowledge() {
+	reader.acknowledge()
+}
+
+// streamEntry is a single entry read from one of the node streams.
+type streamEntry struct {
+	stream string
+	id     string
+	fields map[string][]byte
+}
+
+// ClaimDevice records the running node as the owner of the device connection.
+func (publisher *RedisStreamPublisher) ClaimDevice(deviceID string) error {
+	return publisher.owners.ClaimDevice(deviceID, publisher.node)
+}
+
+// Outbox returns a stream that publishes every reader written to it onto the named channel while the publisher is
+// running. It allows processors that write to a channel directly (e.g. device feedback) to have their messages stored.
+func (publisher *RedisStreamPublisher) Outbox(name string) WriteStream {
+	outbox := make(chan io.Reader, cap(publisher.local[name]))
+	publisher.outboxes[name] = outbox
+	return outbox
+}
+
+// PublishReader is the ChannelPublisher#PublishReader implementation.
+func (publisher *RedisStreamPublisher) PublishReader(name string, reader io.Reader) error {
+	if _, ok := publisher.local[name]; ok != true {
+		return fmt.Errorf(defs.ErrInvalidBackgroundChannel)
+	}
+
+	data, e := ioutil.ReadAll(reader)
+
+	if e != nil {
+		return e
+	}
+
+	deviceID := ""
+
+	if source, ok := reader.(*connectionReader); ok {
+		deviceID = source.deviceID
+	}
+
+	node := routeNode(publisher.owners, publisher.node, name, data)
+
+	connection := publisher.pool.Get()
+	defer connection.Close()
+
+	_, e = connection.Do(
+		"XADD",
+		publisher.genStreamKey(node, name),
+		"MAXLEN",
+		"~",
+		defs.RedisMaxStreamEntries,
+		"*",
+		defs.RedisStreamPayloadField,
+		data,
+		defs.RedisStreamDeviceIDField,
+		deviceID,
+	)
+
+	if e != nil {
+		return e
+	}
+
+	publisher.Debugf("appended %s message to stream of node[%s]", name, node)
+	return nil
+}
+
+// Start is the Processor#Start implementation; it reads the entries of the running node's streams onto the local
+// channels, starting w/ any entries that were delivered but never acknowledged.
+func (publisher *RedisStreamPublisher) Start(wg *sync.WaitGroup, stop KillSwitch) {
+	defer wg.Done()
+
+	publisher.Infof("channel streams starting for node[%s]", publisher.node)
+
+	forwarders, done := sync.WaitGroup{}, make(chan struct{})
+
+	for name, outbox := range publisher.outboxes {
+		forwarders.Add(1)
+		go publisher.forward(name, outbox, done, &forwarders)
+	}
+
+	defer forwarders.Wait()
+	defer close(done)
+
+	for publisher.createGroups() != nil {
+		if publisher.wait(stop) != true {
+			return
+		}
+	}
+
+	// Entries that were delivered to this node but never acknowledged are read before any new entries, continuing after
+	// the last pending entry of each stream until none are left.
+	pending, latest := publisher.offsets("0"), publisher.offsets(">")
+
+	for {
+		select {
+		case <-stop:
+			publisher.Infof("received kill signal, breaking")
+			return
+		default:
+		}
+
+		ids := latest
+
+		if pending != nil {
+			ids = pending
+		}
+
+		entries, e := publisher.read(ids)
+
+		if e != nil {
+			publisher.Errorf("unable to read node streams: %s", e.Error())
+
+			if publisher.wait(stop) != true {
+				return
+			}
+
+			continue
+		}
+
+		if pending != nil && len(entries) == 0 {
+			pending = nil
+			continue
+		}
+
+		if pending != nil {
+			publisher.Infof("redelivering %d unacknowledged entries", len(entries))
+		}
+
+		for _, entry := range entries {
+			if pending != nil {
+				pending[entry.stream] = entry.id
+			}
+
+			publisher.deliver(entry)
+		}
+	}
+}
+
+// wait returns false if the kill signal was received before the retry delay elapsed.
+func (publisher *RedisStreamPublisher) wait(stop KillSwitch) bool {
+	select {
+	case <-time.After(publisher.retry):
+		return true
+	case <-stop:
+		publisher.Infof("received kill signal, breaking")
+		return false
+	}
+}
+
+// forward publishes everything written to the outbox until the publisher is stopped.
+func (publisher *RedisStreamPublisher) forward(
+	name string,
+	outbox chan io.Reader,
+	done chan struct{},
+	wg *sync.WaitGroup,
+) {
+	defer wg.Done()
+
+	for {
+		select {
+		case reader := <-outbox:
+			if e := publisher.PublishReader(name, reader); e != nil {
+				publisher.Errorf("unable to store %s message: %s", name, e.Error())
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// createGroups creates the consumer group of each node stream, creating the streams themselves if necessary.
+func (publisher *RedisStreamPublisher) createGroups() error {
+	connection := publisher.pool.Get()
+	defer connection.Close()
+
+	for name := range publisher.local {
+		key := publisher.genStreamKey(publisher.node, name)
+		_, e := connection.Do("XGROUP", "CREATE", key, defs.RedisNodeStreamGroup, "0", "MKSTREAM")
+
+		if e != nil && strings.HasPrefix(e.Error(), "BUSYGROUP") != true {
+			publisher.Errorf("unable to create consumer group for %s: %s", key, e.Error())
+			return e
+		}
+	}
+
+	return nil
+}
+
+// offsets returns the id provided for the key of each node stream.
+func (publisher *RedisStreamPublisher) offsets(id string) map[string]string {
+	offsets := make(map[string]string, len(publisher.local))
+
+	for name := range publisher.local {
+		offsets[publisher.genStreamKey(publisher.node, name)] = id
+	}
+
+	return offsets
+}
+
+// read returns the entries of the node streams after the id of each stream key, waiting for new entries when reading
+// ">".
+func (publisher *RedisStreamPublisher) read(offsets map[string]string) ([]streamEntry, error) {
+	connection := publisher.pool.Get()
+	defer connection.Close()
+
+	names := make([]string, 0, len(offsets))
+
+	for key := range offsets {
+		names = append(names, key)
+	}
+
+	sort.Strings(names)
+	keys, ids := make([]interface{}, 0, len(offsets)), make([]interface{}, 0, len(offsets))
+
+	for _, key := range names {
+		keys, ids = append(keys, key), append(ids, offsets[key])
+	}
+
+	args := []interface{}{
+		"GROUP",
+		defs.RedisNodeStreamGroup,
+		publisher.node,
+		"COUNT",
+		defs.DefaultStreamReadCount,
+		"BLOCK",
+		int64(publisher.block / time.Millisecond),
+		"STREAMS",
+	}
+
+	response, e := redis.Values(connection.Do("XREADGROUP", append(append(args, keys...), ids...)...))
+
+	if e == redis.ErrNil {
+		return nil, nil
+	}
+
+	if e != nil {
+		return nil, e
+	}
+
+	results := make([]streamEntry, 0, len(response))
+
+	for _, item := range response {
+		stream, e := redis.Values(item, nil)
+
+		if e != nil || len(stream) != 2 {
+			return nil, fmt.Errorf(defs.ErrBadRedisResponse)
+		}
+
+		key, e := redis.String(stream[0], nil)
+
+		if e != nil {
+			return nil, fmt.Errorf(defs.ErrBadRedisResponse)
+		}
+
+		values, e := redis.Values(stream[1], nil)
+
+		if e != nil {
+			return nil, fmt.Errorf(defs.ErrBadRedisResponse)
+		}
+
+		entries, e := parseStreamEntries(key, values)
+
+		if e != nil {
+			return nil, e
+		}
+
+		results = append(results, entries...)
+	}
+
+	return results, nil
+}
+
+// deliver sends the entry onto the local channel of its stream. The entry is acknowledged once it has been handled.
+func (publisher *RedisStreamPublisher) deliver(entry streamEntry) {
+	name := strings.TrimPrefix(entry.stream, publisher.genStreamKey(publisher.node, ""))
+
+	var reader io.Reader = &streamReader{bytes.NewBuffer(entry.fields[defs.RedisStreamPayloadField]), func() {
+		publisher.acknowledge(entry)
+	}}
+
+	// Feedback entries keep the id of the connection they were read from so the sender is still able to be verified.
+	if deviceID := string(entry.fields[defs.RedisStreamDeviceIDField]); deviceID != "" {
+		reader = &connectionReader{reader, deviceID}
+	}
+
+	if e := publisher.local.PublishReader(name, reader); e != nil {
+		publisher.Warnf("unable to deliver entry[%s] from %s: %s", entry.id, entry.stream, e.Error())
+	}
+}
+
+func (publisher *RedisStreamPublisher) acknowledge(entry streamEntry) {
+	connection := publisher.pool.Get()
+	defer connection.Close()
+
+	if _, e := connection.Do("XACK", entry.stream, defs.RedisNodeStreamGroup, entry.id); e != nil {
+		publisher.Warnf("unable to acknowledge entry[%s] from %s: %s", entry.id, entry.stream, e.Error())
+	}
+}
+
+func (publisher *RedisStreamPublisher) genStreamKey(node, name string) string {
+	return fmt.Sprintf("%s:%s:%s", defs.RedisNodeStreamKey, node, name)
+}
+
+// acknowledge marks the reader as handled if it was delivered from a stream.
+func acknowledge(reader io.Reader) {
+	if source, ok := reader.(*connectionReader); ok {
+		reader = source.Reader
+	}
+
+	if entry, ok := reader.(*streamReader); ok {
+		entry.Acknowledge()
+	}
+}
+
+// parseStreamEntries parses the list of [id, [field, value, ...]] pairs returned by the stream commands.
+func parseStreamEntries(stream string, values []interface{}) ([]streamEntry, error) {
+	results := make([]streamEntry, 0, len(values))
+
+	for _, value := range values {
+		parts, e := redis.Values(value, nil)
+
+		if e != nil || len(parts) != 2 {
+			return nil, fmt.Errorf(defs.ErrBadRedisResponse)
+		}
+
+		id, e := redis.String(parts[0], nil)
+
+		if e != nil {
+			return nil, fmt.Errorf(defs.ErrBadRedisResponse)
+		}
+
+		fields, e := redis.ByteSlices(parts[1], nil)
+
+		if e != nil || len(fields)%2 != 0 {
+			return nil, fmt.Errorf(defs.ErrBadRedisResponse)
+		}
+
+		entry := streamEntry{stream: stream, id: id, fields: make(map[string][]byte)}
+
+		for i := 0; i < len(fields); i += 2 {
+			entry.fields[string(fields[i])] = fields[i+1]
+		}
+
+		results = append(results, entry)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,180 @@
+package bg
+
+import "io"
+import "fmt"
+import "sync"
+import "time"
+import "bytes"
+import "testing"
+import "io/ioutil"
+import "github.com/franela/goblin"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+
+type redisStreamPublisherScaffold struct {
+	connection *testRedisConnection
+	owners     *testOwnershipStore
+	local      ChannelStore
+	publisher  *RedisStreamPublisher
+	log        *bytes.Buffer
+	wg         *sync.WaitGroup
+	kill       KillSwitch
+}
+
+func (s *redisStreamPublisherScaffold) Reset() {
+	s.connection = &testRedisConnection{replies: make(chan interface{}, 10), responses: make(map[string][]interface{})}
+	s.owners = &testOwnershipStore{owners: make(map[string]string)}
+	s.local = ChannelStore{
+		defs.DeviceControlChannelName:  make(chan io.Reader, 1),
+		defs.DeviceFeedbackChannelName: make(chan io.Reader, 1),
+	}
+	s.log = bytes.NewBuffer([]byte{})
+	s.wg = &sync.WaitGroup{}
+	s.kill = make(KillSwitch)
+	s.publisher = &RedisStreamPublisher{
+		Logger:   newTestLogger(s.log),
+		pool:     &testRedisConnector{s.connection},
+		node:     "node-a",
+		owners:   s.owners,
+		local:    s.local,
+		outboxes: make(map[string]chan io.Reader),
+		retry:    time.Millisecond,
+		block:    time.Millisecond,
+	}
+}
+
+func (s *redisStreamPublisherScaffold) entry(id string, fields ...string) interface{} {
+	values := make([]interface{}, 0, len(fields))
+
+	for _, field := range fields {
+		values = append(values, []byte(field))
+	}
+
+	return []interface{}{[]byte(id), values}
+}
+
+func (s *redisStreamPublisherScaffold) stream(name string, entries ...interface{}) interface{} {
+	return []interface{}{[]interface{}{[]byte(defs.RedisNodeStreamKey + ":node-a:" + name), entries}}
+}
+
+func Test_RedisStreamPublisher(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("RedisStreamPublisher", func() {
+		s := &redisStreamPublisherScaffold{}
+		message := (&redisChannelPublisherScaffold{}).message
+		control, feedback := defs.DeviceControlChannelName, defs.DeviceFeedbackChannelName
+
+		g.BeforeEach(s.Reset)
+
+		g.Describe("#PublishReader", func() {
+			g.It("returns an error for unknown channels", func() {
+				e := s.publisher.PublishReader("nope", bytes.NewBuffer(message("some-device")))
+				g.Assert(e.Error()).Equal(defs.ErrInvalidBackgroundChannel)
+			})
+
+			g.It("returns the error from redis if unable to append the message", func() {
+				s.connection.errors = []error{fmt.Errorf("bad-xadd")}
+				e := s.publisher.PublishReader(control, bytes.NewBuffer(message("some-device")))
+				g.Assert(e.Error()).Equal("bad-xadd")
+			})
+
+			g.It("appends control messages to the stream of the node that owns the device", func() {
+				s.owners.owners["some-device"] = "node-b"
+				g.Assert(s.publisher.PublishReader(control, bytes.NewBuffer(message("some-device")))).Equal(nil)
+				added := s.connection.called("XADD")
+				g.Assert(added[0][0]).Equal(defs.RedisNodeStreamKey + ":node-b:" + control)
+				g.Assert(added[0][6]).Equal(message("some-device"))
+				g.Assert(len(s.local[control])).Equal(0)
+			})
+
+			g.It("stores the id of the connection that sent feedback", func() {
+				reader := &connectionReader{bytes.NewBuffer([]byte("feedback")), "some-device"}
+				g.Assert(s.publisher.PublishReader(feedback, reader)).Equal(nil)
+				added := s.connection.called("XADD")
+				g.Assert(len(added)).Equal(1)
+				g.Assert(added[0][0]).Equal(defs.RedisNodeStreamKey + ":node-a:" + feedback)
+				g.Assert(added[0][8]).Equal("some-device")
+			})
+		})
+
+		g.Describe("#Start", func() {
+			g.AfterEach(func() {
+				s.kill <- struct{}{}
+				s.wg.Wait()
+			})
+
+			g.It("creates the consumer groups and reads unacknowledged entries first", func() {
+				s.connection.responses["XREADGROUP"] = []interface{}{
+					s.stream(control, s.entry("1-0", defs.RedisStreamPayloadField, "hello")),
+				}
+				s.wg.Add(1)
+				go s.publisher.Start(s.wg, s.kill)
+				data, _ := ioutil.ReadAll(<-s.local[control])
+				g.Assert(string(data)).Equal("hello")
+				g.Assert(len(s.connection.called("XGROUP"))).Equal(2)
+				reads := s.connection.called("XREADGROUP")
+				g.Assert(reads[0][len(reads[0])-1]).Equal("0")
+			})
+
+			g.It("keeps reading unacknowledged entries after the last one read until none are left", func() {
+				s.connection.responses["XREADGROUP"] = []interface{}{
+					s.stream(control, s.entry("1-0", defs.RedisStreamPayloadField, "hello")),
+					s.stream(control, s.entry("2-0", defs.RedisStreamPayloadField, "world")),
+				}
+				s.wg.Add(1)
+				go s.publisher.Start(s.wg, s.kill)
+				first, _ := ioutil.ReadAll(<-s.local[control])
+				second, _ := ioutil.ReadAll(<-s.local[control])
+				g.Assert(string(first) + " " + string(second)).Equal("hello world")
+
+				for len(s.connection.called("XREADGROUP")) < 4 {
+					time.Sleep(time.Millisecond)
+				}
+
+				reads := s.connection.called("XREADGROUP")
+				g.Assert(reads[1][len(reads[1])-2 : len(reads[1])]).Equal([]interface{}{"1-0", "0"})
+				g.Assert(reads[2][len(reads[2])-2 : len(reads[2])]).Equal([]interface{}{"2-0", "0"})
+				g.Assert(reads[3][len(reads[3])-1]).Equal(">")
+			})
+
+			g.It("acknowledges entries once they have been handled", func() {
+				s.connection.responses["XREADGROUP"] = []interface{}{
+					s.stream(control, s.entry("1-0", defs.RedisStreamPayloadField, "hello")),
+				}
+				s.wg.Add(1)
+				go s.publisher.Start(s.wg, s.kill)
+				reader := <-s.local[control]
+				g.Assert(len(s.connection.called("XACK"))).Equal(0)
+				acknowledge(reader)
+				g.Assert(s.connection.called("XACK")[0][2]).Equal("1-0")
+			})
+
+			g.It("delivers feedback entries w/ the id of the connection that sent them", func() {
+				s.connection.responses["XREADGROUP"] = []interface{}{
+					nil,
+					s.stream(feedback, s.entry("1-0", defs.RedisStreamPayloadField, "hi", defs.RedisStreamDeviceIDField, "d")),
+				}
+				s.wg.Add(1)
+				go s.publisher.Start(s.wg, s.kill)
+				reader, ok := (<-s.local[feedback]).(*connectionReader)
+				g.Assert(ok).Equal(true)
+				g.Assert(reader.deviceID).Equal("d")
+				reads := s.connection.called("XREADGROUP")
+				g.Assert(reads[1][len(reads[1])-1]).Equal(">")
+			})
+
+			g.It("stores the messages written to an outbox", func() {
+				outbox := s.publisher.Outbox(feedback)
+				s.wg.Add(1)
+				go s.publisher.Start(s.wg, s.kill)
+				outbox <- &connectionReader{bytes.NewBuffer([]byte("feedback")), "some-device"}
+
+				for len(s.connection.called("XADD")) == 0 {
+					time.Sleep(time.Millisecond)
+				}
+
+				g.Assert(s.connection.called("XADD")[0][0]).Equal(defs.RedisNodeStreamKey + ":node-a:" + feedback)
+			})
+		})
+	})
+}
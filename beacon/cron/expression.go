@@ -0,0 +1,192 @@
+package cron
+
+import "fmt"
+import "time"
+import "strings"
+import "strconv"
+
+import "github.com/dadleyy/beacon.api/beacon/defs"
+
+// searchLimit is how far into the future Next will look for a matching time before giving up.
+const searchLimit = 5 * 365 * 24 * time.Hour
+
+type bounds struct {
+	min uint
+	max uint
+}
+
+var (
+	minuteBounds  = bounds{0, 59}
+	hourBounds    = bounds{0, 23}
+	dayBounds     = bounds{1, 31}
+	monthBounds   = bounds{1, 12}
+	weekdayBounds = bounds{0, 7}
+)
+
+// Expression is a parsed, five field cron expression: minute, hour, day of month, month and day of week. Each field is
+// stored as a bitset of the values it matches.
+type Expression struct {
+	minutes  uint64
+	hours    uint64
+	days     uint64
+	months   uint64
+	weekdays uint64
+
+	// When both the day of month and day of week are restricted a time matches if either of them match.
+	restrictedDays     bool
+	restrictedWeekdays bool
+}
+
+// Parse returns the expression for a standard five field cron string. Each field supports "*", single values, ranges
+// ("1-5"), lists ("1,15") and steps ("*/15"). Sunday may be either 0 or 7 in the day of week field.
+func Parse(value string) (Expression, error) {
+	fields := strings.Fields(value)
+
+	if len(fields) != 5 {
+		return Expression{}, fmt.Errorf(defs.ErrInvalidSchedule)
+	}
+
+	expression := Expression{
+		restrictedDays:     strings.HasPrefix(fields[2], "*") != true,
+		restrictedWeekdays: strings.HasPrefix(fields[4], "*") != true,
+	}
+
+	targets := []struct {
+		field  string
+		bounds bounds
+		bits   *uint64
+	}{
+		{fields[0], minuteBounds, &expression.minutes},
+		{fields[1], hourBounds, &expression.hours},
+		{fields[2], dayBounds, &expression.days},
+		{fields[3], monthBounds, &expression.months},
+		{fields[4], weekdayBounds, &expression.weekdays},
+	}
+
+	for _, t := range targets {
+		bits, e := parseField(t.field, t.bounds)
+
+		if e != nil {
+			return Expression{}, e
+		}
+
+		*t.bits = bits
+	}
+
+	// Fold a sunday of 7 into 0 so that it lines up w/ time.Weekday.
+	if expression.weekdays&(1<<7) != 0 {
+		expression.weekdays |= 1
+	}
+
+	return expression, nil
+}
+
+// Next returns the first minute after the time provided that matches the expression, in the location of the time
+// provided. The zero time is returned if nothing matches within the next five years (e.g "0 0 31 2 *").
+func (expression Expression) Next(after time.Time) time.Time {
+	next := after.Truncate(time.Minute).Add(time.Minute)
+	limit := after.Add(searchLimit)
+
+	for next.Before(limit) {
+		if expression.months&(1<<uint(next.Month())) == 0 {
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, next.Location())
+			continue
+		}
+
+		if expression.matchesDay(next) != true {
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, next.Location())
+			continue
+		}
+
+		if expression.hours&(1<<uint(next.Hour())) == 0 {
+			next = time.Date(next.Year(), next.Month(), next.Day(), next.Hour()+1, 0, 0, 0, next.Location())
+			continue
+		}
+
+		if expression.minutes&(1<<uint(next.Minute())) == 0 {
+			next = next.Add(time.Minute)
+			continue
+		}
+
+		return next
+	}
+
+	return time.Time{}
+}
+
+func (expression Expression) matchesDay(t time.Time) bool {
+	day := expression.days&(1<<uint(t.Day())) != 0
+	weekday := expression.weekdays&(1<<uint(t.Weekday())) != 0
+
+	if expression.restrictedDays && expression.restrictedWeekdays {
+		return day || weekday
+	}
+
+	return day && weekday
+}
+
+func parseField(field string, limits bounds) (uint64, error) {
+	var bits uint64
+
+	for _, item := range strings.Split(field, ",") {
+		start, end, step := limits.min, limits.max, uint(1)
+		parts := strings.SplitN(item, "/", 2)
+
+		if len(parts) == 2 {
+			value, e := strconv.ParseUint(parts[1], 10, 8)
+
+			if e != nil || value == 0 {
+				return 0, fmt.Errorf(defs.ErrInvalidSchedule)
+			}
+
+			step = uint(value)
+		}
+
+		if parts[0] != "*" {
+			first, last, e := parseRange(parts[0], len(parts) == 2, limits)
+
+			if e != nil {
+				return 0, e
+			}
+
+			start, end = first, last
+		}
+
+		for value := start; value <= end; value += step {
+			bits |= 1 << value
+		}
+	}
+
+	return bits, nil
+}
+
+// parseRange returns the start and end of a single value or range. A single value followed by a step runs through the
+// end of the field (e.g. "5/15" in the minute field is 5, 20, 35 and 50).
+func parseRange(item string, stepped bool, limits bounds) (uint, uint, error) {
+	values := strings.SplitN(item, "-", 2)
+	parsed := make([]uint, 0, 2)
+
+	for _, v := range values {
+		value, e := strconv.ParseUint(v, 10, 8)
+
+		if e != nil || uint(value) < limits.min || uint(value) > limits.max {
+			return 0, 0, fmt.Errorf(defs.ErrInvalidSchedule)
+		}
+
+		parsed = append(parsed, uint(value))
+	}
+
+	if len(parsed) == 2 && parsed[0] > parsed[1] {
+		return 0, 0, fmt.Errorf(defs.ErrInvalidSchedule)
+	}
+
+	if len(parsed) == 2 {
+		return parsed[0], parsed[1], nil
+	}
+
+	if stepped {
+		return parsed[0], limits.max, nil
+	}
+
+	return parsed[0], parsed[0], nil
+}
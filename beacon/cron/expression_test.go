@@ -0,0 +1,84 @@
+package cron
+
+import "time"
+import "testing"
+import "github.com/franela/goblin"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+
+func Test_Expression(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	// Saturday, October 17th 2026 at 16:20:30 UTC.
+	start := time.Date(2026, time.October, 17, 16, 20, 30, 0, time.UTC)
+
+	next := func(value string) time.Time {
+		expression, e := Parse(value)
+		g.Assert(e).Equal(nil)
+		return expression.Next(start)
+	}
+
+	g.Describe("Parse", func() {
+		g.It("returns an error without exactly five fields", func() {
+			_, e := Parse("* * * *")
+			g.Assert(e.Error()).Equal(defs.ErrInvalidSchedule)
+		})
+
+		g.It("returns an error for values outside of the field bounds", func() {
+			_, e := Parse("60 * * * *")
+			g.Assert(e.Error()).Equal(defs.ErrInvalidSchedule)
+		})
+
+		g.It("returns an error for reversed ranges", func() {
+			_, e := Parse("* 17-9 * * *")
+			g.Assert(e.Error()).Equal(defs.ErrInvalidSchedule)
+		})
+
+		g.It("returns an error for a zero step", func() {
+			_, e := Parse("*/0 * * * *")
+			g.Assert(e.Error()).Equal(defs.ErrInvalidSchedule)
+		})
+
+		g.It("returns an error for names", func() {
+			_, e := Parse("0 9 * * mon")
+			g.Assert(e.Error()).Equal(defs.ErrInvalidSchedule)
+		})
+	})
+
+	g.Describe("Next", func() {
+		g.It("returns the next minute for every minute", func() {
+			g.Assert(next("* * * * *")).Equal(time.Date(2026, time.October, 17, 16, 21, 0, 0, time.UTC))
+		})
+
+		g.It("returns a time later the same day", func() {
+			g.Assert(next("0 17 * * *")).Equal(time.Date(2026, time.October, 17, 17, 0, 0, 0, time.UTC))
+		})
+
+		g.It("rolls over to the next day once the time has passed", func() {
+			g.Assert(next("0 9 * * *")).Equal(time.Date(2026, time.October, 18, 9, 0, 0, 0, time.UTC))
+		})
+
+		g.It("supports steps", func() {
+			g.Assert(next("*/15 * * * *")).Equal(time.Date(2026, time.October, 17, 16, 30, 0, 0, time.UTC))
+		})
+
+		g.It("supports weekday ranges", func() {
+			g.Assert(next("0 9 * * 1-5")).Equal(time.Date(2026, time.October, 19, 9, 0, 0, 0, time.UTC))
+		})
+
+		g.It("treats 7 as sunday", func() {
+			g.Assert(next("0 9 * * 7")).Equal(time.Date(2026, time.October, 18, 9, 0, 0, 0, time.UTC))
+		})
+
+		g.It("supports lists of months and days", func() {
+			g.Assert(next("30 8 1 1,6 *")).Equal(time.Date(2027, time.January, 1, 8, 30, 0, 0, time.UTC))
+		})
+
+		g.It("matches either the day of month or day of week when both are restricted", func() {
+			g.Assert(next("0 0 20 * 0")).Equal(time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC))
+		})
+
+		g.It("returns the zero time if the expression never matches", func() {
+			g.Assert(next("0 0 31 2 *").IsZero()).Equal(true)
+		})
+	})
+}
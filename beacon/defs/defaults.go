@@ -1,5 +1,7 @@
 package defs
 
+import "time"
+
 const (
 	// DefaultPort is the port that the application will listen on unless otherwise specified.
 	DefaultPort = "8080"
@@ -9,4 +11,32 @@ const (
 
 	// DefaultHostname is the default hostname that will be bound to.
 	DefaultHostname = "0.0.0.0"
+
+	// DefaultScheduleInterval is how often the schedule processor checks for scheduled messages that are due.
+	DefaultScheduleInterval = 15 * time.Second
+
+	// DefaultConsistencyInterval is how often the consistency processor checks the store for inconsistent keys.
+	DefaultConsistencyInterval = 10 * time.Minute
+
+	// DefaultRelayRetryDelay is how long the channel relay waits before resubscribing after losing its connection.
+	DefaultRelayRetryDelay = 5 * time.Second
+
+	// DefaultStreamBlockTimeout is how long the stream publisher waits for new entries before checking for a kill signal.
+	DefaultStreamBlockTimeout = time.Second
+
+	// DefaultStreamReadCount is the maximum amount of entries the stream publisher reads at a time.
+	DefaultStreamReadCount = 10
+
+	// DefaultCommandLogPageSize is the amount of command log entries returned per page unless otherwise specified.
+	DefaultCommandLogPageSize = 20
+
+	// MaxCommandLogPageSize is the maximum amount of command log entries that can be requested per page.
+	MaxCommandLogPageSize = 100
+
+	// MaxFeedbackEntries is the maximum amount of feedback entries any store keeps for a device; filtered feedback requests
+	// load this many entries before applying their count.
+	MaxFeedbackEntries = 100
+
+	// DefaultEventBufferSize is the amount of events held for each event subscriber before new events are dropped.
+	DefaultEventBufferSize = 32
 )
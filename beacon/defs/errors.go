@@ -10,6 +10,9 @@ const (
 	// ErrInvalidTokenRequest is returned from the registry when allocation is requested with bad contents.
 	ErrInvalidTokenRequest = "invalid-request"
 
+	// ErrInvalidTokenExpiry is returned when a token is requested w/ an expiry in the past or both an expiry and ttl.
+	ErrInvalidTokenExpiry = "invalid-expiry"
+
 	// ErrInvalidRegistrationRequest is returned from the registry when allocation is requested with bad contents.
 	ErrInvalidRegistrationRequest = "invalid-registration"
 
@@ -45,4 +48,55 @@ const (
 
 	// ErrInvalidColorShorthand returned when the color shorthand request by the client is invalid.
 	ErrInvalidColorShorthand = "invalid-color-shorthand"
+
+	// ErrInvalidControlFrames returned when the frames sent along w/ a device message are invalid.
+	ErrInvalidControlFrames = "invalid-control-frames"
+
+	// ErrInvalidPresetName returned when a user attempts to save a preset w/ an invalid name.
+	ErrInvalidPresetName = "invalid-preset-name"
+
+	// ErrDuplicatePresetName returned when a user attempts to create a preset w/ a name that is already in use.
+	ErrDuplicatePresetName = "duplicate-preset-name"
+
+	// ErrTooManyPresets returned when a user attempts to create more presets than a device is allowed to have.
+	ErrTooManyPresets = "too-many-presets"
+
+	// ErrInvalidGroupName returned when a user attempts to create a device group w/ an invalid name.
+	ErrInvalidGroupName = "invalid-group-name"
+
+	// ErrDuplicateGroupName returned when a user attempts to create a device group w/ a name that is already in use.
+	ErrDuplicateGroupName = "duplicate-group-name"
+
+	// ErrInvalidSchedule returned when a user attempts to create a schedule w/o a valid time or cron expression.
+	ErrInvalidSchedule = "invalid-schedule"
+
+	// ErrTooManySchedules returned when a user attempts to create more schedules than a device is allowed to have.
+	ErrTooManySchedules = "too-many-schedules"
+
+	// ErrInvalidFeedbackType returned when feedback is requested w/ a type other than "error" or "report".
+	ErrInvalidFeedbackType = "invalid-feedback-type"
+
+	// ErrStreamingUnsupported returned when the response of a request is unable to stream events.
+	ErrStreamingUnsupported = "streaming-unsupported"
+
+	// ErrInvalidControlFrameTransition returned when a control frame is requested with an unknown transition.
+	ErrInvalidControlFrameTransition = "invalid-control-frame-transition"
+
+	// ErrInvalidAccessToken returned when a signed access token is malformed or its signature does not match.
+	ErrInvalidAccessToken = "invalid-access-token"
+
+	// ErrExpiredAccessToken returned when a signed access token is presented after its expiry.
+	ErrExpiredAccessToken = "expired-access-token"
+
+	// ErrInvalidAccessTokenRequest returned when an access token is requested w/o devices or w/ an invalid ttl.
+	ErrInvalidAccessTokenRequest = "invalid-access-token-request"
+
+	// ErrInvalidAccountName returned when a user attempts to create an account w/ an invalid name.
+	ErrInvalidAccountName = "invalid-account-name"
+
+	// ErrInvalidAccountRequest returned when an account permission is requested w/o an account or device.
+	ErrInvalidAccountRequest = "invalid-account-request"
+
+	// ErrUnauthorizedToken returned when a token is not approved for the permission requested on a device.
+	ErrUnauthorizedToken = "unauthorized-token"
 )
@@ -10,6 +10,9 @@ const (
 	// APIUserTokenHeader is the header key used by users to send a device token.
 	APIUserTokenHeader = "x-user-auth"
 
+	// APIEventStreamContentTypeHeader is the content type used for server-sent event responses.
+	APIEventStreamContentTypeHeader = "text/event-stream"
+
 	// APIFeedbackContentTypeHeader is the content type required for requests sent to the feedback api.
 	APIFeedbackContentTypeHeader = "application/octet-stream"
 )
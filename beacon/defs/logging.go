@@ -30,15 +30,45 @@ const (
 	// DevicesAPILogPrefix log prefix used by tokens api
 	DevicesAPILogPrefix = "[devices api] "
 
+	// DeviceGroupsAPILogPrefix log prefix used by device groups api
+	DeviceGroupsAPILogPrefix = "[device groups api] "
+
+	// DeviceEventsAPILogPrefix log prefix used by device events api
+	DeviceEventsAPILogPrefix = "[device events api] "
+
+	// DeviceSchedulesAPILogPrefix log prefix used by device schedules api
+	DeviceSchedulesAPILogPrefix = "[device schedules api] "
+
+	// PresetsAPILogPrefix log prefix used by presets api
+	PresetsAPILogPrefix = "[presets api] "
+
 	// TokensAPILogPrefix log prefix used by tokens api
 	TokensAPILogPrefix = "[tokens api] "
 
+	// AccountsAPILogPrefix log prefix used by accounts api
+	AccountsAPILogPrefix = "[accounts api] "
+
+	// AccessTokensAPILogPrefix log prefix used by access tokens api
+	AccessTokensAPILogPrefix = "[access tokens api] "
+
+	// ChannelRelayLogPrefix log prefix used by the redis channel publisher
+	ChannelRelayLogPrefix = "[channel relay] "
+
+	// ChannelStreamsLogPrefix log prefix used by the redis stream publisher
+	ChannelStreamsLogPrefix = "[channel streams] "
+
 	// ServerKeyLogPrefix log prefix used by server key
 	ServerKeyLogPrefix = "[server key] "
 
 	// RegistryLogPrefix is the log prefix for the device registry
 	RegistryLogPrefix = "[device registry] "
 
+	// SQLRegistryLogPrefix is the log prefix for the sql device registry
+	SQLRegistryLogPrefix = "[sql registry] "
+
+	// MemoryRegistryLogPrefix is the log prefix for the in-memory device registry
+	MemoryRegistryLogPrefix = "[memory registry] "
+
 	// ServerRuntimeLogPrefix is the log prefix for the http server runtime
 	ServerRuntimeLogPrefix = "[server runtime] "
 
@@ -51,6 +81,15 @@ const (
 	// DeviceFeedbackLogPrefix is the log prefix for the device feeback processor
 	DeviceFeedbackLogPrefix = "[device feedback] "
 
+	// DeviceScheduleLogPrefix is the log prefix for the device schedule processor
+	DeviceScheduleLogPrefix = "[device schedule] "
+
+	// ConsistencyCheckLogPrefix is the log prefix for the store consistency processor
+	ConsistencyCheckLogPrefix = "[consistency check] "
+
+	// DeviceEventsLogPrefix is the log prefix for the device event broker
+	DeviceEventsLogPrefix = "[device events] "
+
 	// DefaultLoggerFlags is the bitmask used to create default logging
 	DefaultLoggerFlags = log.Ldate | log.Ltime
 )
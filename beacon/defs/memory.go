@@ -0,0 +1,19 @@
+package defs
+
+const (
+	// MemoryMaxFeedbackEntries is the maximum amount of feedback entries the memory store keeps for each device.
+	MemoryMaxFeedbackEntries = MaxFeedbackEntries
+
+	// MemoryMaxPendingMessages is the maximum amount of messages the memory store keeps for a device name while it is not
+	// connected.
+	MemoryMaxPendingMessages = 10
+
+	// MemoryPendingMessageTTL is the amount of seconds the memory store keeps a message for while waiting for its device.
+	MemoryPendingMessageTTL = 60 * 60
+
+	// MemoryMaxCommandLogEntries is the maximum amount of audit entries the memory store keeps for each device name.
+	MemoryMaxCommandLogEntries = 1000
+
+	// MemoryDeviceCommandTTL is the amount of seconds the memory store keeps the status of a device command for.
+	MemoryDeviceCommandTTL = 60 * 60 * 24
+)
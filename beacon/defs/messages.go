@@ -6,4 +6,50 @@ const (
 
 	// DeviceMessageLabel is used during RSA OAEP signing
 	DeviceMessageLabel = "beacon"
+
+	// ControlMessageMaxFrames is the maximum amount of frames a single control message may contain.
+	ControlMessageMaxFrames = 64
+
+	// ControlMessageMaxLoopCount is the maximum amount of times a device will be asked to loop a control message.
+	ControlMessageMaxLoopCount = 100
+
+	// ControlFrameMaxDuration is the longest (in milliseconds) a single control frame may be displayed for.
+	ControlFrameMaxDuration = 60000
+
+	// ControlFrameMaxColorValue is the maximum value of any color channel in a control frame.
+	ControlFrameMaxColorValue = 255
+)
+
+const (
+	// CommandStatusQueued is the status of a command that has been published but not yet sent to the device.
+	CommandStatusQueued = "queued"
+
+	// CommandStatusSent is the status of a command that was written to the device connection.
+	CommandStatusSent = "sent"
+
+	// CommandStatusAcknowledged is the status of a command the device has sent feedback for.
+	CommandStatusAcknowledged = "acknowledged"
+
+	// CommandStatusFailed is the status of a command that could not be sent or the device reported an error for.
+	CommandStatusFailed = "failed"
+
+	// CommandStatusDeviceOffline is the status of a command whose device was not connected when it was handled.
+	CommandStatusDeviceOffline = "device-offline"
+)
+
+const (
+	// DeviceEventRegistered is published when a device connection is received by the control processor.
+	DeviceEventRegistered = "registered"
+
+	// DeviceEventWelcomed is published once a device has been sent its welcome message.
+	DeviceEventWelcomed = "welcomed"
+
+	// DeviceEventDisconnected is published when a device connection is removed from the pool.
+	DeviceEventDisconnected = "disconnected"
+
+	// DeviceEventCommandRelayed is published when a control message was written to a device connection.
+	DeviceEventCommandRelayed = "command-relayed"
+
+	// DeviceEventFeedbackReceived is published when feedback from a device connection has been stored.
+	DeviceEventFeedbackReceived = "feedback-received"
 )
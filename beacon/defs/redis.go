@@ -13,18 +13,140 @@ const (
 	// RedisRegistrationRequestListKey is the key used for registration requests
 	RedisRegistrationRequestListKey = "beacon:registration-requests"
 
+	// RedisDeviceNameIndexKey is the hash that maps the name of each device to its id
+	RedisDeviceNameIndexKey = "beacon:device-names"
+
+	// RedisRegistrationSecretIndexKey is the hash that maps the salted digest of each pending registration's shared
+	// secret to the id of the registration request
+	RedisRegistrationSecretIndexKey = "beacon:registration-secrets"
+
+	// RedisDeviceGroupIndexKey is the key used by the redis device registry to store device group ids
+	RedisDeviceGroupIndexKey = "beacon:device-group-index"
+
+	// RedisDeviceGroupKey is the key used by the redis device registry to store device group information
+	RedisDeviceGroupKey = "beacon:device-group"
+
+	// RedisDeviceGroupMembersKey is the key used by the redis device registry to store the set of group member names
+	RedisDeviceGroupMembersKey = "beacon:device-group-members"
+
+	// RedisDeviceGroupIDField is the field that contains the unique id of the device group
+	RedisDeviceGroupIDField = "group:uuid"
+
+	// RedisDeviceGroupNameField is the field that contains the unique name of the device group
+	RedisDeviceGroupNameField = "group:name"
+
+	// RedisDeviceGroupTokenField is the legacy field that contained the raw group token, only read when migrating
+	RedisDeviceGroupTokenField = "group:token"
+
+	// RedisDeviceGroupTokenDigestField is the field that contains the salted digest of the token used to authorize
+	// group commands
+	RedisDeviceGroupTokenDigestField = "group:token-digest"
+
+	// RedisDeviceScheduleIndexKey is the sorted set of schedule ids, scored by the unix time of their next run
+	RedisDeviceScheduleIndexKey = "beacon:device-schedule-index"
+
+	// RedisDeviceScheduleKey is the key used by the redis device registry to store schedule information
+	RedisDeviceScheduleKey = "beacon:device-schedule"
+
+	// RedisDeviceScheduleListKey is the set of schedule ids associated w/ each device name
+	RedisDeviceScheduleListKey = "device:schedule-list"
+
+	// RedisDeviceScheduleIDField is the field that contains the unique id of the schedule
+	RedisDeviceScheduleIDField = "schedule:uuid"
+
+	// RedisDeviceScheduleDeviceField is the field that contains the name of the device the schedule belongs to
+	RedisDeviceScheduleDeviceField = "schedule:device-name"
+
+	// RedisDeviceScheduleCronField is the field that contains the cron expression of recurring schedules
+	RedisDeviceScheduleCronField = "schedule:cron"
+
+	// RedisDeviceScheduleNextRunField is the field that contains the unix time of the next run of the schedule
+	RedisDeviceScheduleNextRunField = "schedule:next-run"
+
+	// RedisDeviceScheduleMessageField is the field that contains the control message sent when the schedule runs
+	RedisDeviceScheduleMessageField = "schedule:message"
+
+	// RedisDeviceStateKey is the hash of the last control message successfully sent to each device name
+	RedisDeviceStateKey = "beacon:device-state"
+
+	// RedisDevicePendingMessageKey is the key used by the redis device registry to store messages waiting for a device
+	RedisDevicePendingMessageKey = "beacon:device-pending-message"
+
+	// RedisDevicePendingListKey is the list of pending message ids associated w/ each device name, oldest first
+	RedisDevicePendingListKey = "device:pending-list"
+
+	// RedisDeviceCommandKey is the key used by the redis device registry to store the status of device commands
+	RedisDeviceCommandKey = "beacon:device-command"
+
+	// RedisDeviceCommandLogKey is the list of audit entries of the commands sent to each device name, newest first
+	RedisDeviceCommandLogKey = "device:command-log"
+
+	// RedisDeviceCommandIDField is the field that contains the unique id of the command
+	RedisDeviceCommandIDField = "command:uuid"
+
+	// RedisDeviceCommandDeviceIDField is the field that contains the id of the device the command was sent to
+	RedisDeviceCommandDeviceIDField = "command:device-id"
+
+	// RedisDeviceCommandStatusField is the field that contains the current status of the command
+	RedisDeviceCommandStatusField = "command:status"
+
+	// RedisDeviceCommandDigestField is the field that contains the salted digest of the token that created the command
+	RedisDeviceCommandDigestField = "command:auth-digest"
+
+	// RedisDeviceCommandCreatedField is the field that contains the unix time the command was created at
+	RedisDeviceCommandCreatedField = "command:created-at"
+
+	// RedisDeviceCommandUpdatedField is the field that contains the unix time of the last status change
+	RedisDeviceCommandUpdatedField = "command:updated-at"
+
 	// RedisDeviceIDField is the field that contains the unique id of the device
 	RedisDeviceIDField = "device:uuid"
 
 	// RedisDeviceNameField is the field that contains the unique name of the device
 	RedisDeviceNameField = "device:name"
 
-	// RedisDeviceTokenListKey is the field that contains the list of tokens associated w/ each device
+	// RedisDeviceTokenListKey is the legacy list of raw tokens associated w/ each device, only read when migrating
 	RedisDeviceTokenListKey = "device:token-list"
 
-	// RedisDeviceTokenRegistrationKey field for device token information (name)
+	// RedisDeviceTokenRegistrationKey is the legacy token information key (by raw token), only read when migrating
 	RedisDeviceTokenRegistrationKey = "device:token"
 
+	// RedisDeviceTokenDigestListKey is the list of token digests associated w/ each device
+	RedisDeviceTokenDigestListKey = "device:token-digest-list"
+
+	// RedisDeviceTokenDigestKey is the hash that contains the token information, keyed by the salted token digest
+	RedisDeviceTokenDigestKey = "device:token-digest"
+
+	// RedisTokenSaltKey contains the salt used for token digests when one is not provided in the api configuration
+	RedisTokenSaltKey = "beacon:token-salt"
+
+	// RedisRevokedAccessTokenKey is the key prefix of revoked access tokens, each expiring w/ the token itself
+	RedisRevokedAccessTokenKey = "beacon:revoked-access-token"
+
+	// RedisAccessTokenKey is the key prefix of the json records of issued access tokens, each expiring w/ the token
+	RedisAccessTokenKey = "beacon:access-token"
+
+	// RedisAccountKey is the key prefix of the hash that contains the details of each user account
+	RedisAccountKey = "beacon:account"
+
+	// RedisAccountIDField is the field that contains the id of the account
+	RedisAccountIDField = "account:id"
+
+	// RedisAccountNameField is the field that contains the name of the account
+	RedisAccountNameField = "account:name"
+
+	// RedisAccountTokenKey is the key prefix that maps the salted digest of each account token to its account id
+	RedisAccountTokenKey = "beacon:account-token"
+
+	// RedisAccountPermissionsKey is the hash of permission masks granted to each account, keyed by device name
+	RedisAccountPermissionsKey = "beacon:account-permissions"
+
+	// RedisDeviceAccountsKey is the key prefix of the set of account ids granted a permission for each device name
+	RedisDeviceAccountsKey = "beacon:device-accounts"
+
+	// RedisMigrationsKey is the hash of migrations that have been applied, keyed by migration name
+	RedisMigrationsKey = "beacon:migrations"
+
 	// RedisDeviceTokenNameField is the field that contains the unique name of the token
 	RedisDeviceTokenNameField = "device-token:name"
 
@@ -37,15 +159,63 @@ const (
 	// RedisDeviceTokenPermissionField is the field that contains the permission of the token
 	RedisDeviceTokenPermissionField = "device-token:permission"
 
+	// RedisDeviceTokenExpiresField contains the unix time after which the token is no longer accepted (if any)
+	RedisDeviceTokenExpiresField = "device-token:expires-at"
+
+	// RedisDevicePresetListKey is the hash that contains the named presets saved for each device name
+	RedisDevicePresetListKey = "device:preset-list"
+
+	// RedisDeviceNodeField is the field that contains the id of the api node holding the device's connection
+	RedisDeviceNodeField = "device:node-id"
+
+	// RedisNodeChannelKey is the prefix of the pub/sub channels used to relay background channel messages to api nodes
+	RedisNodeChannelKey = "beacon:node-channel"
+
+	// RedisNodeStreamKey is the prefix of the streams that hold the background channel messages of each api node
+	RedisNodeStreamKey = "beacon:node-stream"
+
+	// RedisNodeStreamGroup is the consumer group used by api nodes to read the messages of their streams
+	RedisNodeStreamGroup = "beacon-api"
+
+	// RedisStreamPayloadField is the stream entry field that contains the message data
+	RedisStreamPayloadField = "stream:payload"
+
+	// RedisStreamDeviceIDField is the stream entry field that contains the id of the device that sent the message
+	RedisStreamDeviceIDField = "stream:device-id"
+
 	// RedisDeviceSecretField is the field that contains the unique secret of the device
 	RedisDeviceSecretField = "device:secret"
 
+	// RedisDeviceOwnerField is the field that contains the salted digest of the owner token of the device
+	RedisDeviceOwnerField = "device:owner-digest"
+
 	// RedisRegistrationNameField is the redis key used to store registration names
 	RedisRegistrationNameField = "registration:name"
 
 	// RedisRegistrationSecretField is the redis key used to store registration secrets
 	RedisRegistrationSecretField = "registration:secret"
 
+	// RedisRegistrationOwnerField is the redis key used to store the digest of the owner token issued w/ a registration
+	RedisRegistrationOwnerField = "registration:owner-digest"
+
 	// RedisMaxFeedbackEntries is the maximum amount of entries a device is allowed to have at any given time.
-	RedisMaxFeedbackEntries = 100
+	RedisMaxFeedbackEntries = MaxFeedbackEntries
+
+	// RedisMaxPendingMessages is the maximum amount of messages kept for a device while it is not connected.
+	RedisMaxPendingMessages = 10
+
+	// RedisPendingMessageTTL is the amount of seconds a message is kept for while waiting for its device to connect.
+	RedisPendingMessageTTL = 60 * 60
+
+	// RedisMaxStreamEntries is the approximate amount of entries kept in each node stream.
+	RedisMaxStreamEntries = 1000
+
+	// RedisMaxCommandLogEntries is the maximum amount of audit entries kept for each device name.
+	RedisMaxCommandLogEntries = 1000
+
+	// RedisDeviceCommandTTL is the amount of seconds the status of a device command is kept for.
+	RedisDeviceCommandTTL = 60 * 60 * 24
+
+	// RedisConsistencyScanCount is the amount of keys the consistency check asks for w/ each SCAN.
+	RedisConsistencyScanCount = 100
 )
@@ -2,14 +2,14 @@ package defs
 
 import "regexp"
 
-var shorthandColors = "red|blue|green|off|rand|[0-9a-f]{6}"
+var shorthandValues = "[0-9a-z]+(?:-[0-9a-z]+)*"
 
 var (
 	// DeviceListRoute is the regular expression used for the device list route
 	DeviceListRoute = regexp.MustCompile("^/devices$")
 
 	// DeviceShorthandRoute is the regular expression used for the device shorthand route
-	DeviceShorthandRoute = regexp.MustCompile("^/devices/(?P<uuid>[\\d\\w\\-]+)/(?P<color>" + shorthandColors + ")$")
+	DeviceShorthandRoute = regexp.MustCompile("^/devices/(?P<uuid>[\\d\\w\\-]+)/(?P<color>" + shorthandValues + ")$")
 
 	// DeviceRegistrationRoute is used by devices to register with the server
 	DeviceRegistrationRoute = regexp.MustCompile("^/register$")
@@ -17,12 +17,48 @@ var (
 	// DeviceTokensRoute is used to create device tokens for a given device.
 	DeviceTokensRoute = regexp.MustCompile("^/device-tokens$")
 
+	// DeviceTokenRoute is used to remove a single device token.
+	DeviceTokenRoute = regexp.MustCompile("^/device-tokens/(?P<id>[\\d\\w\\-]+)$")
+
+	// AccessTokensRoute is used to create and revoke signed access tokens.
+	AccessTokensRoute = regexp.MustCompile("^/access-tokens$")
+
+	// AccountsRoute is used to create user accounts.
+	AccountsRoute = regexp.MustCompile("^/accounts$")
+
+	// AccountDevicesRoute is used to list the devices of an account and manage the permissions it is granted.
+	AccountDevicesRoute = regexp.MustCompile("^/account-devices$")
+
 	// DeviceFeedbackRoute is used to receive device feedback from clients.
 	DeviceFeedbackRoute = regexp.MustCompile("^/device-feedback$")
 
 	// DeviceMessagesRoute is used to create device messages.
 	DeviceMessagesRoute = regexp.MustCompile("^/device-messages$")
 
+	// DeviceMessageRoute is used to check the status of a single device message.
+	DeviceMessageRoute = regexp.MustCompile("^/device-messages/(?P<id>[\\d\\w\\-]+)$")
+
+	// DeviceGroupsRoute is used to create and list device groups.
+	DeviceGroupsRoute = regexp.MustCompile("^/device-groups$")
+
+	// DeviceGroupMembersRoute is used to add and remove devices from a device group.
+	DeviceGroupMembersRoute = regexp.MustCompile("^/device-groups/(?P<group>[\\d\\w\\-]+)/devices$")
+
+	// DeviceEventsRoute is used to stream device events to viewers over a websocket or server-sent events.
+	DeviceEventsRoute = regexp.MustCompile("^/device-events$")
+
+	// DeviceSchedulesRoute is used to create, list and remove scheduled device messages.
+	DeviceSchedulesRoute = regexp.MustCompile("^/device-schedules$")
+
+	// GroupMessagesRoute is used to create device messages for every device in a device group.
+	GroupMessagesRoute = regexp.MustCompile("^/group-messages$")
+
+	// PresetsRoute is used to list the animation presets available to devices and manage user-defined ones.
+	PresetsRoute = regexp.MustCompile("^/presets$")
+
+	// PresetNameRegex is used to validate the names of user-defined presets.
+	PresetNameRegex = regexp.MustCompile("^" + shorthandValues + "$")
+
 	// SystemRoute prints out system information
 	SystemRoute = regexp.MustCompile("^/system$")
 )
@@ -9,6 +9,27 @@ const (
 
 	// SecurityMinimumDeviceSharedSecretSize is the minimum size of shared secrets
 	SecurityMinimumDeviceSharedSecretSize = 20
+
+	// SecurityDeviceGroupNameMinLength is the minimum length of device group names
+	SecurityDeviceGroupNameMinLength = 4
+
+	// SecurityDevicePresetNameMaxLength is the maximum length of user-defined preset names
+	SecurityDevicePresetNameMaxLength = 40
+
+	// SecurityMaxDevicePresets is the maximum amount of user-defined presets a single device may have
+	SecurityMaxDevicePresets = 50
+
+	// SecurityMaxDeviceSchedules is the maximum amount of scheduled messages a single device may have
+	SecurityMaxDeviceSchedules = 50
+
+	// SecurityAccountNameMinLength is the minimum length of user account names
+	SecurityAccountNameMinLength = 4
+
+	// SecurityAccessTokenDefaultTTL is the amount of seconds signed access tokens are valid for when no ttl is requested
+	SecurityAccessTokenDefaultTTL = 60 * 60
+
+	// SecurityAccessTokenMaxTTL is the maximum amount of seconds signed access tokens may be valid for
+	SecurityAccessTokenMaxTTL = 60 * 60 * 24
 )
 
 // DeviceTokenPermissions is a bitmask used to authorize device actions
@@ -31,3 +52,23 @@ const (
 		SecurityDeviceTokenPermissionController |
 		SecurityDeviceTokenPermissionViewer
 )
+
+const (
+	// SecurityCredentialDeviceToken is the credential kind of commands sent w/ a device token
+	SecurityCredentialDeviceToken = "device-token"
+
+	// SecurityCredentialOwnerToken is the credential kind of commands sent w/ the owner token of the device
+	SecurityCredentialOwnerToken = "owner-token"
+
+	// SecurityCredentialAccountToken is the credential kind of commands sent w/ an account token
+	SecurityCredentialAccountToken = "account-token"
+
+	// SecurityCredentialAccessToken is the credential kind of commands sent w/ a signed access token
+	SecurityCredentialAccessToken = "access-token"
+
+	// SecurityCredentialGroupToken is the credential kind of commands sent to a device group w/ its group token
+	SecurityCredentialGroupToken = "group-token"
+
+	// SecurityCredentialSchedule is the credential kind of commands sent by a schedule when it was due
+	SecurityCredentialSchedule = "schedule"
+)
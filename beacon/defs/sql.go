@@ -0,0 +1,43 @@
+package defs
+
+const (
+	// StoreBackendRedis persists devices & everything kept about them in redis
+	StoreBackendRedis = "redis"
+
+	// StoreBackendMemory keeps devices & everything kept about them in memory, intended for local development
+	StoreBackendMemory = "memory"
+
+	// StoreBackendSQLite persists devices & everything kept about them in a sqlite database file
+	StoreBackendSQLite = "sqlite"
+
+	// StoreBackendPostgres persists devices & everything kept about them in a postgresql database
+	StoreBackendPostgres = "postgres"
+
+	// SQLDriverSQLite is the database/sql driver name used for sqlite databases
+	SQLDriverSQLite = "sqlite3"
+
+	// SQLDriverPostgres is the database/sql driver name used for postgresql databases
+	SQLDriverPostgres = "postgres"
+
+	// SQLMigrationsTable is the table that contains the name of every schema migration that has been applied
+	SQLMigrationsTable = "schema_migrations"
+
+	// SQLMaxFeedbackEntries is the maximum amount of feedback rows a device is allowed to have at any given time.
+	SQLMaxFeedbackEntries = MaxFeedbackEntries
+
+	// SQLMaxPendingMessages is the maximum amount of messages the sql store keeps for a device name while it is not
+	// connected.
+	SQLMaxPendingMessages = 10
+
+	// SQLPendingMessageTTL is the amount of seconds the sql store keeps a message for while waiting for its device.
+	SQLPendingMessageTTL = 60 * 60
+
+	// SQLMaxCommandLogEntries is the maximum amount of audit rows the sql store keeps for each device name.
+	SQLMaxCommandLogEntries = 1000
+
+	// SQLDeviceCommandTTL is the amount of seconds the sql store keeps the status of a device command for.
+	SQLDeviceCommandTTL = 60 * 60 * 24
+
+	// SQLTokenSaltSetting is the name of the settings row holding the token salt shared by every api node.
+	SQLTokenSaltSetting = "token-salt"
+)
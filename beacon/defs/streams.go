@@ -6,4 +6,13 @@ const (
 
 	// DeviceFeedbackChannelName is the name of the stream that will broacast messages received from devices
 	DeviceFeedbackChannelName = "chan:device-feedback"
+
+	// ChannelBackendPubSub relays background channel messages between api nodes w/ redis pub/sub
+	ChannelBackendPubSub = "pubsub"
+
+	// ChannelBackendStreams stores background channel messages in redis streams until they have been handled
+	ChannelBackendStreams = "streams"
+
+	// ChannelBackendLocal keeps background channel messages on the running api node, used by the stores w/o redis
+	ChannelBackendLocal = "local"
 )
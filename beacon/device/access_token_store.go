@@ -0,0 +1,114 @@
+package device
+
+import "fmt"
+import "time"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/security"
+
+// AccessTokenDetails holds the information returned when a signed access token is created. The signed token itself is
+// only ever returned to its creator; stored records of issued tokens leave it empty.
+type AccessTokenDetails struct {
+	TokenID    string    `json:"token_id"`
+	Token      string    `json:"token"`
+	Devices    []string  `json:"devices"`
+	Permission uint      `json:"permission"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// AccessTokenKey defines the interface for signing and verifying access tokens.
+type AccessTokenKey interface {
+	SignAccessToken(security.AccessClaims) (string, error)
+	ParseAccessToken(string, time.Time) (security.AccessClaims, error)
+}
+
+// RevocationStore defines the interface for revoking signed access tokens before they expire. Records of issued tokens
+// and revocations only need to be kept until the token expires, after which it is rejected by its own expiry.
+type RevocationStore interface {
+	SaveAccessToken(AccessTokenDetails) error
+	FindAccessToken(string) (AccessTokenDetails, error)
+	RevokeAccessToken(string, time.Time) error
+	AccessTokenRevoked(string) (bool, error)
+}
+
+// DeviceAuthorizer defines the interface for token stores able to approve a token for a device id w/o the device being
+// looked up, returning the id + name of the device.
+type DeviceAuthorizer interface {
+	AuthorizeDevice(string, string, uint) (RegistrationDetails, bool)
+}
+
+// FindAuthorizedDevice returns the device matching the query (id or name) if the token is approved for the permission.
+// Stores that are device authorizers are tried first, skipping the device lookup; otherwise the device is found in the
+// index and the token is authorized against its id. Tokens that are not approved result in ErrUnauthorizedToken.
+func FindAuthorizedDevice(
+	index Index,
+	store TokenStore,
+	query, token string,
+	permission uint,
+) (RegistrationDetails, error) {
+	if authorizer, ok := store.(DeviceAuthorizer); ok {
+		if details, ok := authorizer.AuthorizeDevice(query, token, permission); ok {
+			return details, nil
+		}
+	}
+
+	details, e := index.FindDevice(query)
+
+	if e != nil {
+		return RegistrationDetails{}, e
+	}
+
+	if token == "" || store.AuthorizeToken(details.DeviceID, token, permission) != true {
+		return RegistrationDetails{}, fmt.Errorf(defs.ErrUnauthorizedToken)
+	}
+
+	return details, nil
+}
+
+// AccessTokenStore wraps a token store, authorizing signed access tokens from their claims w/o looking up any token
+// details. Any other token is authorized by the wrapped store.
+type AccessTokenStore struct {
+	TokenStore
+	RevocationStore
+	Key AccessTokenKey
+}
+
+// AuthorizeToken approves the token + permission for the given device id.
+func (store *AccessTokenStore) AuthorizeToken(deviceID, token string, permission uint) bool {
+	if security.IsAccessToken(token) != true {
+		return store.TokenStore.AuthorizeToken(deviceID, token, permission)
+	}
+
+	_, ok := store.approve(deviceID, token, permission)
+	return ok
+}
+
+// AuthorizeDevice approves the signed access token + permission for the device id w/ only the claims of the token,
+// returning the id + name of the device listed in them. Any other token is not approved.
+func (store *AccessTokenStore) AuthorizeDevice(deviceID, token string, permission uint) (RegistrationDetails, bool) {
+	if security.IsAccessToken(token) != true {
+		return RegistrationDetails{}, false
+	}
+
+	claims, ok := store.approve(deviceID, token, permission)
+	name, listed := claims.Names[deviceID]
+
+	if ok != true || listed != true {
+		return RegistrationDetails{}, false
+	}
+
+	return RegistrationDetails{DeviceID: deviceID, Name: name}, true
+}
+
+// approve parses the signed access token, returning its claims if they grant the permission for the device id and the
+// token has not been revoked.
+func (store *AccessTokenStore) approve(deviceID, token string, permission uint) (security.AccessClaims, bool) {
+	claims, e := store.Key.ParseAccessToken(token, time.Now())
+
+	if e != nil || claims.Allows(deviceID, permission) != true {
+		return security.AccessClaims{}, false
+	}
+
+	revoked, e := store.AccessTokenRevoked(claims.TokenID)
+
+	return claims, e == nil && revoked != true
+}
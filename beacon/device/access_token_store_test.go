@@ -0,0 +1,173 @@
+package device
+
+import "fmt"
+import "time"
+import "testing"
+import "github.com/franela/goblin"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/security"
+
+type testAccessTokenKey struct {
+	claims security.AccessClaims
+	e      error
+}
+
+func (k *testAccessTokenKey) SignAccessToken(security.AccessClaims) (string, error) {
+	return "header.claims.signature", k.e
+}
+
+func (k *testAccessTokenKey) ParseAccessToken(string, time.Time) (security.AccessClaims, error) {
+	return k.claims, k.e
+}
+
+type testTokenStore struct {
+	TokenStore
+	authorized []string
+}
+
+func (t *testTokenStore) AuthorizeToken(_, token string, _ uint) bool {
+	t.authorized = append(t.authorized, token)
+	return true
+}
+
+type testIndex struct {
+	Index
+	found []string
+	e     error
+}
+
+func (t *testIndex) FindDevice(query string) (RegistrationDetails, error) {
+	t.found = append(t.found, query)
+
+	if t.e != nil {
+		return RegistrationDetails{}, t.e
+	}
+
+	return RegistrationDetails{DeviceID: "found-id", Name: "found-name"}, nil
+}
+
+type testRevocationStore struct {
+	revoked bool
+	e       error
+}
+
+func (t *testRevocationStore) SaveAccessToken(AccessTokenDetails) error {
+	return t.e
+}
+
+func (t *testRevocationStore) FindAccessToken(string) (AccessTokenDetails, error) {
+	return AccessTokenDetails{}, t.e
+}
+
+func (t *testRevocationStore) RevokeAccessToken(string, time.Time) error {
+	return t.e
+}
+
+func (t *testRevocationStore) AccessTokenRevoked(string) (bool, error) {
+	return t.revoked, t.e
+}
+
+func Test_AccessTokenStore(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("AccessTokenStore", func() {
+		var tokens *testTokenStore
+		var revocations *testRevocationStore
+		var key *testAccessTokenKey
+		var store *AccessTokenStore
+
+		g.BeforeEach(func() {
+			tokens, revocations = &testTokenStore{}, &testRevocationStore{}
+			key = &testAccessTokenKey{claims: security.AccessClaims{
+				TokenID:    "token-id",
+				Devices:    []string{"device-id"},
+				Names:      map[string]string{"device-id": "device-name"},
+				Permission: 3,
+			}}
+			store = &AccessTokenStore{TokenStore: tokens, RevocationStore: revocations, Key: key}
+		})
+
+		g.Describe("AuthorizeToken", func() {
+			g.It("authorizes opaque tokens w/ the wrapped token store", func() {
+				g.Assert(store.AuthorizeToken("device-id", "opaque-token", 1)).Equal(true)
+				g.Assert(tokens.authorized).Equal([]string{"opaque-token"})
+			})
+
+			g.It("rejects access tokens that cannot be parsed", func() {
+				key.e = fmt.Errorf("invalid-access-token")
+				g.Assert(store.AuthorizeToken("device-id", "header.claims.signature", 1)).Equal(false)
+			})
+
+			g.It("rejects access tokens for other devices", func() {
+				g.Assert(store.AuthorizeToken("other-id", "header.claims.signature", 1)).Equal(false)
+			})
+
+			g.It("rejects access tokens w/o the permission requested", func() {
+				g.Assert(store.AuthorizeToken("device-id", "header.claims.signature", 4)).Equal(false)
+			})
+
+			g.It("rejects access tokens that have been revoked", func() {
+				revocations.revoked = true
+				g.Assert(store.AuthorizeToken("device-id", "header.claims.signature", 1)).Equal(false)
+			})
+
+			g.It("rejects access tokens if unable to check the revocation list", func() {
+				revocations.e = fmt.Errorf("bad-exists")
+				g.Assert(store.AuthorizeToken("device-id", "header.claims.signature", 1)).Equal(false)
+			})
+
+			g.It("authorizes access tokens w/o looking up token details", func() {
+				g.Assert(store.AuthorizeToken("device-id", "header.claims.signature", 2)).Equal(true)
+				g.Assert(len(tokens.authorized)).Equal(0)
+			})
+		})
+
+		g.Describe("FindAuthorizedDevice", func() {
+			var index *testIndex
+
+			g.BeforeEach(func() {
+				index = &testIndex{}
+			})
+
+			g.It("returns the device listed in the access token claims w/o looking it up", func() {
+				details, e := FindAuthorizedDevice(index, store, "device-id", "header.claims.signature", 2)
+				g.Assert(e).Equal(nil)
+				g.Assert(details).Equal(RegistrationDetails{DeviceID: "device-id", Name: "device-name"})
+				g.Assert(len(index.found)).Equal(0)
+			})
+
+			g.It("looks up devices queried by name before authorizing access tokens against their id", func() {
+				_, e := FindAuthorizedDevice(index, store, "device-name", "header.claims.signature", 2)
+				g.Assert(e.Error()).Equal(defs.ErrUnauthorizedToken)
+				g.Assert(index.found).Equal([]string{"device-name"})
+			})
+
+			g.It("looks up the device before authorizing opaque tokens w/ the wrapped token store", func() {
+				details, e := FindAuthorizedDevice(index, store, "found-name", "opaque-token", 2)
+				g.Assert(e).Equal(nil)
+				g.Assert(details.DeviceID).Equal("found-id")
+				g.Assert(tokens.authorized).Equal([]string{"opaque-token"})
+			})
+
+			g.It("looks up the device if the access token has been revoked", func() {
+				revocations.revoked = true
+				_, e := FindAuthorizedDevice(index, store, "device-id", "header.claims.signature", 2)
+				g.Assert(e.Error()).Equal(defs.ErrUnauthorizedToken)
+				g.Assert(index.found).Equal([]string{"device-id"})
+			})
+
+			g.It("returns the lookup error for devices that are not found", func() {
+				index.e = fmt.Errorf(defs.ErrNotFound)
+				_, e := FindAuthorizedDevice(index, store, "other-id", "opaque-token", 2)
+				g.Assert(e.Error()).Equal(defs.ErrNotFound)
+				g.Assert(len(tokens.authorized)).Equal(0)
+			})
+
+			g.It("rejects empty tokens", func() {
+				_, e := FindAuthorizedDevice(index, store, "found-name", "", 2)
+				g.Assert(e.Error()).Equal(defs.ErrUnauthorizedToken)
+				g.Assert(len(tokens.authorized)).Equal(0)
+			})
+		})
+	})
+}
@@ -0,0 +1,27 @@
+package device
+
+import "github.com/dadleyy/beacon.api/beacon/defs"
+
+// AccountDetails holds the information about a user account. A single account token is authorized for every device
+// the account has been granted a permission bitmask for, keyed by device name so grants outlive reconnections.
+type AccountDetails struct {
+	AccountID   string                                 `json:"account_id"`
+	Name        string                                 `json:"name"`
+	Token       string                                 `json:"token,omitempty"`
+	Permissions map[string]defs.DeviceTokenPermissions `json:"permissions"`
+}
+
+// AccountDevice holds the registration details of a device along w/ the permission an account has been granted to it.
+type AccountDevice struct {
+	RegistrationDetails
+	Permission defs.DeviceTokenPermissions `json:"permission"`
+}
+
+// AccountStore defines the interface for creating user accounts and managing the devices they are authorized for. Grants
+// are made to device names; stores drop them once the last device w/ the name has been removed.
+type AccountStore interface {
+	CreateAccount(string) (AccountDetails, error)
+	FindAccount(string) (AccountDetails, error)
+	GrantAccountPermission(string, string, defs.DeviceTokenPermissions) error
+	RevokeAccountPermission(string, string) error
+}
@@ -0,0 +1,95 @@
+package device
+
+import "fmt"
+import "time"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/security"
+import "github.com/dadleyy/beacon.api/beacon/interchange"
+
+// CommandDetails holds the delivery status of a control message sent to a device.
+type CommandDetails struct {
+	CommandID string    `json:"command_id"`
+	DeviceID  string    `json:"device_id"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CommandLogEntry is the audit record of a control message sent to a device.
+type CommandLogEntry struct {
+	CommandID    string                      `json:"command_id"`
+	DeviceID     string                      `json:"device_id"`
+	DeviceName   string                      `json:"device_name"`
+	TokenID      string                      `json:"token_id,omitempty"`
+	TokenName    string                      `json:"token_name,omitempty"`
+	Credential   string                      `json:"credential"`
+	CredentialID string                      `json:"credential_id,omitempty"`
+	Route        string                      `json:"route"`
+	Message      *interchange.ControlMessage `json:"message"`
+	CreatedAt    time.Time                   `json:"created_at"`
+}
+
+// CommandStore defines an interface for tracking the lifecycle of commands sent to devices. Commands are created w/ the
+// token that was used to send them, which is the only token that is allowed to read their status. Every command is
+// also recorded in the command log of the device name it was sent to, along w/ the kind & id of the credential that
+// sent it.
+type CommandStore interface {
+	CreateCommand(string, string) (CommandDetails, error)
+	FindCommand(string) (CommandDetails, error)
+	AuthorizeCommand(string, string) bool
+	UpdateCommandStatus(string, string) error
+	LogCommand(string, CommandLogEntry) error
+	ListCommandLog(string, int, int) ([]CommandLogEntry, error)
+}
+
+// AcknowledgeCommand updates the status of the command the feedback message was sent in response to, provided the
+// command was sent to the device id the feedback came from. Error feedback marks the command as failed.
+func AcknowledgeCommand(store CommandStore, deviceID string, message interchange.FeedbackMessage) error {
+	command, e := store.FindCommand(message.CommandID)
+
+	if e != nil || command.DeviceID != deviceID {
+		return fmt.Errorf(defs.ErrNotFound)
+	}
+
+	status := defs.CommandStatusAcknowledged
+
+	if message.Type == interchange.FeedbackMessageType_ERROR {
+		status = defs.CommandStatusFailed
+	}
+
+	return store.UpdateCommandStatus(command.CommandID, status)
+}
+
+// credentialFinder is implemented by the stores that are able to look up the tokens commands are sent w/.
+type credentialFinder interface {
+	FindToken(string) (TokenDetails, error)
+	FindAccount(string) (AccountDetails, error)
+}
+
+// identifyCredential returns the command log entry w/ the kind & id of the credential the token belongs to, unless the
+// entry already has one (e.g. group tokens & schedules). Commands are only logged once their token was authorized for
+// the device, so tokens that are neither device, account nor access tokens are the owner token of the device.
+func identifyCredential(store credentialFinder, token string, entry CommandLogEntry) CommandLogEntry {
+	if entry.Credential != "" {
+		return entry
+	}
+
+	if security.IsAccessToken(token) {
+		entry.Credential, entry.CredentialID = defs.SecurityCredentialAccessToken, security.AccessTokenID(token)
+		return entry
+	}
+
+	if details, e := store.FindToken(token); e == nil {
+		entry.TokenID, entry.TokenName = details.TokenID, details.Name
+		entry.Credential, entry.CredentialID = defs.SecurityCredentialDeviceToken, details.TokenID
+		return entry
+	}
+
+	if account, e := store.FindAccount(token); e == nil {
+		entry.Credential, entry.CredentialID = defs.SecurityCredentialAccountToken, account.AccountID
+		return entry
+	}
+
+	entry.Credential, entry.CredentialID = defs.SecurityCredentialOwnerToken, entry.DeviceID
+	return entry
+}
@@ -0,0 +1,43 @@
+package device
+
+import "testing"
+import "github.com/franela/goblin"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/interchange"
+
+func Test_AcknowledgeCommand(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("AcknowledgeCommand", func() {
+		var store *MemoryRegistry
+		var command CommandDetails
+
+		g.BeforeEach(func() {
+			var e error
+			store = memorySubject(&fakeTokenGenerator{"command-token", nil})
+			command, e = store.CreateCommand("device-id", "command-token")
+			g.Assert(e).Equal(nil)
+		})
+
+		g.It("marks the command as acknowledged", func() {
+			message := interchange.FeedbackMessage{CommandID: command.CommandID, Type: interchange.FeedbackMessageType_REPORT}
+			g.Assert(AcknowledgeCommand(store, "device-id", message)).Equal(nil)
+			found, _ := store.FindCommand(command.CommandID)
+			g.Assert(found.Status).Equal(defs.CommandStatusAcknowledged)
+		})
+
+		g.It("marks the command as failed for error feedback", func() {
+			message := interchange.FeedbackMessage{CommandID: command.CommandID, Type: interchange.FeedbackMessageType_ERROR}
+			g.Assert(AcknowledgeCommand(store, "device-id", message)).Equal(nil)
+			found, _ := store.FindCommand(command.CommandID)
+			g.Assert(found.Status).Equal(defs.CommandStatusFailed)
+		})
+
+		g.It("does not acknowledge commands sent to other devices", func() {
+			message := interchange.FeedbackMessage{CommandID: command.CommandID}
+			g.Assert(AcknowledgeCommand(store, "other-id", message) != nil).Equal(true)
+			found, _ := store.FindCommand(command.CommandID)
+			g.Assert(found.Status).Equal(defs.CommandStatusQueued)
+		})
+	})
+}
@@ -0,0 +1,867 @@
+package device
+
+import "fmt"
+import "sync"
+import "time"
+import "testing"
+
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/interchange"
+
+// ConformanceStore is the combination of interfaces exercised by the conformance suite.
+type ConformanceStore interface {
+	Registry
+	TokenStore
+	FeedbackStore
+}
+
+// ConformanceBackend is the combination of every store interface a registry implements when it is able to back the
+// api w/o redis.
+type ConformanceBackend interface {
+	ConformanceStore
+	AccountStore
+	RevocationStore
+	StateStore
+	PresetStore
+	GroupStore
+	ScheduleStore
+	CommandStore
+	PendingMessageStore
+}
+
+// ConformanceSuite holds the behavior every registry implementation is expected to share, independent of how it is
+// persisted. New must return an empty store that creates its tokens w/ the generator provided; it is called once for
+// each test so no state leaks between them. NewBackend is optional and, like New, returns an empty store; when present
+// the remaining store roles are exercised as well.
+type ConformanceSuite struct {
+	New                func(TokenGenerator) (ConformanceStore, error)
+	NewBackend         func(TokenGenerator) (ConformanceBackend, error)
+	MaxFeedbackEntries int
+}
+
+// Run executes every test of the suite as a subtest of the test provided.
+func (suite ConformanceSuite) Run(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(*testing.T, ConformanceStore)
+	}{
+		{"allocation", suite.allocation},
+		{"fill", suite.fill},
+		{"lookup", suite.lookup},
+		{"removal", suite.removal},
+		{"token permissions", suite.tokenPermissions},
+		{"token listing", suite.tokenListing},
+		{"token expiry", suite.tokenExpiry},
+		{"token removal", suite.tokenRemoval},
+		{"feedback ordering", suite.feedbackOrdering},
+		{"feedback trimming", suite.feedbackTrimming},
+	}
+
+	for _, test := range tests {
+		run := test.run
+
+		t.Run(test.name, func(t *testing.T) {
+			store, e := suite.New(&conformanceTokenGenerator{})
+
+			if e != nil {
+				t.Fatalf("unable to create store: %s", e.Error())
+			}
+
+			run(t, store)
+		})
+	}
+
+	if suite.NewBackend != nil {
+		suite.runBackend(t)
+	}
+}
+
+// runBackend executes the tests of the store roles beyond devices, tokens & feedback as subtests of the test provided.
+func (suite ConformanceSuite) runBackend(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(*testing.T, ConformanceBackend)
+	}{
+		{"accounts", suite.accounts},
+		{"revocations", suite.revocations},
+		{"state", suite.state},
+		{"presets", suite.presets},
+		{"groups", suite.groups},
+		{"schedules", suite.schedules},
+		{"commands", suite.commands},
+		{"command log", suite.commandLog},
+		{"pending messages", suite.pendingMessages},
+	}
+
+	for _, test := range tests {
+		run := test.run
+
+		t.Run(test.name, func(t *testing.T) {
+			store, e := suite.NewBackend(&conformanceTokenGenerator{})
+
+			if e != nil {
+				t.Fatalf("unable to create store: %s", e.Error())
+			}
+
+			run(t, store)
+		})
+	}
+}
+
+func (suite ConformanceSuite) allocation(t *testing.T, store ConformanceStore) {
+	secret := conformanceSecret("device-name")
+
+	if _, e := store.AllocateRegistration(RegistrationRequest{Name: "abc", SharedSecret: secret}); e == nil {
+		t.Fatalf("expected registrations w/ a short name to be rejected")
+	}
+
+	if _, e := store.AllocateRegistration(RegistrationRequest{Name: "device-name", SharedSecret: "short"}); e == nil {
+		t.Fatalf("expected registrations w/ a short shared secret to be rejected")
+	}
+
+	owner, e := store.AllocateRegistration(RegistrationRequest{Name: "device-name", SharedSecret: secret})
+
+	if e != nil || owner == "" {
+		t.Fatalf("expected an owner token for a valid registration, got %q (%v)", owner, e)
+	}
+
+	if _, e := store.FindDevice("device-name"); e == nil {
+		t.Fatalf("expected devices to not be found before their registration is filled")
+	}
+}
+
+func (suite ConformanceSuite) fill(t *testing.T, store ConformanceStore) {
+	if e := store.FillRegistration(conformanceSecret("device-name"), "device-id"); e == nil {
+		t.Fatalf("expected filling an unknown registration to fail")
+	}
+
+	conformanceRegister(t, store, "device-name", "device-id")
+
+	if e := store.FillRegistration(conformanceSecret("device-name"), "other-id"); e == nil {
+		t.Fatalf("expected a registration to only be filled once")
+	}
+
+	conformanceRegister(t, store, "other-device", "other-id")
+
+	registrations, e := store.ListRegistrations()
+
+	if e != nil || len(registrations) != 2 {
+		t.Fatalf("expected both devices to be listed, got %v (%v)", registrations, e)
+	}
+
+	ids := map[string]string{}
+
+	for _, registration := range registrations {
+		ids[registration.DeviceID] = registration.Name
+	}
+
+	if ids["device-id"] != "device-name" || ids["other-id"] != "other-device" {
+		t.Fatalf("expected listed devices to match the filled registrations, got %v", registrations)
+	}
+}
+
+func (suite ConformanceSuite) lookup(t *testing.T, store ConformanceStore) {
+	conformanceRegister(t, store, "device-name", "device-id")
+
+	for _, query := range []string{"device-id", "device-name"} {
+		details, e := store.FindDevice(query)
+
+		if e != nil {
+			t.Fatalf("unable to find device by %q: %s", query, e.Error())
+		}
+
+		if details.DeviceID != "device-id" || details.Name != "device-name" {
+			t.Fatalf("expected device found by %q to match the registration, got %v", query, details)
+		}
+
+		if details.SharedSecret != conformanceSecret("device-name") {
+			t.Fatalf("expected device found by %q to include the shared secret", query)
+		}
+	}
+
+	if _, e := store.FindDevice("missing"); e == nil || e.Error() != defs.ErrNotFound {
+		t.Fatalf("expected missing device to not be found, got %v", e)
+	}
+}
+
+func (suite ConformanceSuite) removal(t *testing.T, store ConformanceStore) {
+	conformanceRegister(t, store, "device-name", "device-id")
+	token := conformanceToken(t, store, "device-id", defs.SecurityDeviceTokenPermissionViewer, nil)
+
+	if e := store.RemoveDevice("device-id"); e != nil {
+		t.Fatalf("unable to remove device: %s", e.Error())
+	}
+
+	if _, e := store.FindDevice("device-id"); e == nil {
+		t.Fatalf("expected removed device to not be found")
+	}
+
+	if store.AuthorizeToken("device-id", token.Token, defs.SecurityDeviceTokenPermissionViewer) {
+		t.Fatalf("expected tokens of removed device to no longer be authorized")
+	}
+}
+
+func (suite ConformanceSuite) tokenPermissions(t *testing.T, store ConformanceStore) {
+	owner := conformanceRegister(t, store, "device-name", "device-id")
+	conformanceRegister(t, store, "other-device", "other-id")
+
+	if _, e := store.CreateToken("missing", "token-name", defs.SecurityDeviceTokenPermissionViewer, nil); e == nil {
+		t.Fatalf("expected creating a token for a missing device to fail")
+	}
+
+	if store.AuthorizeToken("device-id", owner, defs.SecurityDeviceTokenPermissionAll) != true {
+		t.Fatalf("expected owner token to be authorized for every permission")
+	}
+
+	if store.AuthorizeToken("other-id", owner, defs.SecurityDeviceTokenPermissionViewer) {
+		t.Fatalf("expected owner token to not be authorized for other devices")
+	}
+
+	permission := uint(defs.SecurityDeviceTokenPermissionViewer | defs.SecurityDeviceTokenPermissionController)
+	token := conformanceToken(t, store, "device-id", permission, nil)
+
+	if token.Token == "" || token.TokenID == "" || token.Permission != permission {
+		t.Fatalf("expected created token to include the raw token, its id & permission, got %v", token)
+	}
+
+	if store.AuthorizeToken("device-id", token.Token, defs.SecurityDeviceTokenPermissionController) != true {
+		t.Fatalf("expected token to be authorized for a permission it was created w/")
+	}
+
+	if store.AuthorizeToken("device-name", token.Token, permission) != true {
+		t.Fatalf("expected token to be authorized when the device is found by name")
+	}
+
+	if store.AuthorizeToken("device-id", token.Token, defs.SecurityDeviceTokenPermissionAdmin) {
+		t.Fatalf("expected token to not be authorized for a permission it was not created w/")
+	}
+
+	if store.AuthorizeToken("other-id", token.Token, defs.SecurityDeviceTokenPermissionViewer) {
+		t.Fatalf("expected token to not be authorized for other devices")
+	}
+
+	if store.AuthorizeToken("device-id", "unknown-token", defs.SecurityDeviceTokenPermissionViewer) {
+		t.Fatalf("expected unknown token to not be authorized")
+	}
+}
+
+func (suite ConformanceSuite) tokenListing(t *testing.T, store ConformanceStore) {
+	conformanceRegister(t, store, "device-name", "device-id")
+	conformanceRegister(t, store, "other-device", "other-id")
+
+	if tokens, e := store.ListTokens("device-id"); e != nil || len(tokens) != 0 {
+		t.Fatalf("expected a device w/o tokens to list none, got %v (%v)", tokens, e)
+	}
+
+	first := conformanceToken(t, store, "device-id", defs.SecurityDeviceTokenPermissionViewer, nil)
+	second := conformanceToken(t, store, "device-id", defs.SecurityDeviceTokenPermissionAdmin, nil)
+	conformanceToken(t, store, "other-id", defs.SecurityDeviceTokenPermissionViewer, nil)
+
+	tokens, e := store.ListTokens("device-name")
+
+	if e != nil || len(tokens) != 2 {
+		t.Fatalf("expected only the tokens of the device to be listed, got %v (%v)", tokens, e)
+	}
+
+	if tokens[0].TokenID != second.TokenID || tokens[1].TokenID != first.TokenID {
+		t.Fatalf("expected tokens to be listed newest first, got %v", tokens)
+	}
+
+	if tokens[0].Name != second.Name || tokens[0].Permission != second.Permission || tokens[0].Token != "" {
+		t.Fatalf("expected listed token to match the created token w/o the raw token, got %v", tokens[0])
+	}
+
+	if _, e := store.ListTokens("missing"); e == nil {
+		t.Fatalf("expected listing the tokens of a missing device to fail")
+	}
+}
+
+func (suite ConformanceSuite) tokenExpiry(t *testing.T, store ConformanceStore) {
+	conformanceRegister(t, store, "device-name", "device-id")
+
+	future, past := time.Now().Add(time.Hour), time.Now().Add(-time.Hour)
+	active := conformanceToken(t, store, "device-id", defs.SecurityDeviceTokenPermissionViewer, &future)
+	expired := conformanceToken(t, store, "device-id", defs.SecurityDeviceTokenPermissionViewer, &past)
+
+	if store.AuthorizeToken("device-id", active.Token, defs.SecurityDeviceTokenPermissionViewer) != true {
+		t.Fatalf("expected token that has not yet expired to be authorized")
+	}
+
+	if store.AuthorizeToken("device-id", expired.Token, defs.SecurityDeviceTokenPermissionViewer) {
+		t.Fatalf("expected expired token to not be authorized")
+	}
+
+	tokens, e := store.ListTokens("device-id")
+
+	if e != nil || len(tokens) != 2 || tokens[1].ExpiresAt == nil || tokens[1].ExpiresAt.Unix() != future.Unix() {
+		t.Fatalf("expected listed tokens to include their expiry, got %v (%v)", tokens, e)
+	}
+}
+
+func (suite ConformanceSuite) tokenRemoval(t *testing.T, store ConformanceStore) {
+	conformanceRegister(t, store, "device-name", "device-id")
+	token := conformanceToken(t, store, "device-id", defs.SecurityDeviceTokenPermissionViewer, nil)
+
+	if e := store.RemoveToken("device-id", token.TokenID); e != nil {
+		t.Fatalf("unable to remove token: %s", e.Error())
+	}
+
+	if store.AuthorizeToken("device-id", token.Token, defs.SecurityDeviceTokenPermissionViewer) {
+		t.Fatalf("expected removed token to no longer be authorized")
+	}
+
+	if e := store.RemoveToken("device-id", token.TokenID); e == nil || e.Error() != defs.ErrNotFound {
+		t.Fatalf("expected removing a missing token to fail w/ not found, got %v", e)
+	}
+}
+
+func (suite ConformanceSuite) feedbackOrdering(t *testing.T, store ConformanceStore) {
+	conformanceRegister(t, store, "device-name", "device-id")
+
+	if e := store.LogFeedback(interchange.FeedbackMessage{}); e == nil {
+		t.Fatalf("expected feedback w/o authentication to be rejected")
+	}
+
+	if e := store.LogFeedback(conformanceFeedback("missing", "command")); e == nil {
+		t.Fatalf("expected feedback of a missing device to be rejected")
+	}
+
+	if entries, e := store.ListFeedback("device-id", 10); e != nil || len(entries) != 0 {
+		t.Fatalf("expected a device w/o feedback to list none, got %v (%v)", entries, e)
+	}
+
+	for _, command := range []string{"first", "second", "third"} {
+		if e := store.LogFeedback(conformanceFeedback("device-id", command)); e != nil {
+			t.Fatalf("unable to log feedback: %s", e.Error())
+		}
+	}
+
+	entries, e := store.ListFeedback("device-name", 10)
+
+	if e != nil || len(entries) != 3 {
+		t.Fatalf("expected every feedback entry to be listed, got %v (%v)", entries, e)
+	}
+
+	if entries[0].CommandID != "third" || entries[2].CommandID != "first" {
+		t.Fatalf("expected feedback to be listed newest first, got %v", entries)
+	}
+
+	if entries[0].ReceivedAt == 0 {
+		t.Fatalf("expected feedback to be stamped w/ the time it was received")
+	}
+}
+
+func (suite ConformanceSuite) feedbackTrimming(t *testing.T, store ConformanceStore) {
+	conformanceRegister(t, store, "device-name", "device-id")
+
+	for i := 0; i < suite.MaxFeedbackEntries+5; i++ {
+		if e := store.LogFeedback(conformanceFeedback("device-id", fmt.Sprintf("command-%d", i))); e != nil {
+			t.Fatalf("unable to log feedback: %s", e.Error())
+		}
+	}
+
+	entries, e := store.ListFeedback("device-id", suite.MaxFeedbackEntries*2)
+
+	if e != nil || len(entries) != suite.MaxFeedbackEntries {
+		t.Fatalf("expected feedback to be trimmed to %d entries, got %d (%v)", suite.MaxFeedbackEntries, len(entries), e)
+	}
+
+	if newest := fmt.Sprintf("command-%d", suite.MaxFeedbackEntries+4); entries[0].CommandID != newest {
+		t.Fatalf("expected the oldest feedback to be trimmed, got newest entry %s", entries[0].CommandID)
+	}
+}
+
+func (suite ConformanceSuite) accounts(t *testing.T, store ConformanceBackend) {
+	conformanceRegister(t, store, "device-name", "device-id")
+
+	if _, e := store.CreateAccount("abc"); e == nil {
+		t.Fatalf("expected accounts w/ a short name to be rejected")
+	}
+
+	account, e := store.CreateAccount("account-name")
+
+	if e != nil || account.AccountID == "" || account.Token == "" {
+		t.Fatalf("expected the account to be created w/ its token, got %v (%v)", account, e)
+	}
+
+	if _, e := store.FindAccount("unknown-token"); e == nil || e.Error() != defs.ErrNotFound {
+		t.Fatalf("expected an unknown account token to not be found, got %v", e)
+	}
+
+	viewer := defs.DeviceTokenPermissions(defs.SecurityDeviceTokenPermissionViewer)
+
+	if e := store.GrantAccountPermission("missing", "device-name", viewer); e == nil {
+		t.Fatalf("expected granting a permission to a missing account to fail")
+	}
+
+	if e := store.GrantAccountPermission(account.AccountID, "device-name", viewer); e != nil {
+		t.Fatalf("unable to grant permission: %s", e.Error())
+	}
+
+	found, e := store.FindAccount(account.Token)
+
+	if e != nil || found.Name != "account-name" || found.Token != "" || found.Permissions["device-name"] != viewer {
+		t.Fatalf("expected the account to be found w/ its permissions & w/o its token, got %v (%v)", found, e)
+	}
+
+	if store.AuthorizeToken("device-id", account.Token, defs.SecurityDeviceTokenPermissionViewer) != true {
+		t.Fatalf("expected the account token to be authorized for the permission it was granted")
+	}
+
+	if store.AuthorizeToken("device-id", account.Token, defs.SecurityDeviceTokenPermissionAdmin) {
+		t.Fatalf("expected the account token to not be authorized for a permission it was not granted")
+	}
+
+	if e := store.RevokeAccountPermission(account.AccountID, "device-name"); e != nil {
+		t.Fatalf("unable to revoke permission: %s", e.Error())
+	}
+
+	if store.AuthorizeToken("device-id", account.Token, defs.SecurityDeviceTokenPermissionViewer) {
+		t.Fatalf("expected the account token to no longer be authorized once revoked")
+	}
+
+	if e := store.RevokeAccountPermission(account.AccountID, "device-name"); e == nil {
+		t.Fatalf("expected revoking a permission that was not granted to fail")
+	}
+
+	if e := store.GrantAccountPermission(account.AccountID, "device-name", viewer); e != nil {
+		t.Fatalf("unable to grant permission: %s", e.Error())
+	}
+
+	conformanceRegister(t, store, "device-name", "replacement-id")
+
+	if e := store.RemoveDevice("device-id"); e != nil {
+		t.Fatalf("unable to remove device: %s", e.Error())
+	}
+
+	if store.AuthorizeToken("replacement-id", account.Token, defs.SecurityDeviceTokenPermissionViewer) != true {
+		t.Fatalf("expected the grant to be kept while another device w/ the name remains")
+	}
+
+	if e := store.RemoveDevice("replacement-id"); e != nil {
+		t.Fatalf("unable to remove device: %s", e.Error())
+	}
+
+	if found, e := store.FindAccount(account.Token); e != nil || len(found.Permissions) != 0 {
+		t.Fatalf("expected the grant to be dropped along w/ the last device w/ the name, got %v (%v)", found, e)
+	}
+}
+
+func (suite ConformanceSuite) revocations(t *testing.T, store ConformanceBackend) {
+	issued := AccessTokenDetails{
+		TokenID:    "issued-token",
+		Token:      "header.claims.signature",
+		Devices:    []string{"first-id", "second-id"},
+		Permission: defs.SecurityDeviceTokenPermissionController,
+		ExpiresAt:  time.Unix(time.Now().Add(time.Hour).Unix(), 0),
+	}
+
+	if e := store.SaveAccessToken(issued); e != nil {
+		t.Fatalf("unable to save access token: %s", e.Error())
+	}
+
+	expired := AccessTokenDetails{TokenID: "expired-token", Devices: []string{"first-id"}, ExpiresAt: time.Now()}
+
+	if e := store.SaveAccessToken(expired); e != nil {
+		t.Fatalf("unable to save expired access token: %s", e.Error())
+	}
+
+	found, e := store.FindAccessToken("issued-token")
+
+	if e != nil {
+		t.Fatalf("unable to find access token: %s", e.Error())
+	}
+
+	if found.Token != "" || len(found.Devices) != 2 || found.Devices[1] != "second-id" {
+		t.Fatalf("expected the access token record w/o the signed token, got %v", found)
+	}
+
+	if found.Permission != issued.Permission || found.ExpiresAt.Equal(issued.ExpiresAt) != true {
+		t.Fatalf("expected the access token record to keep its permission + expiry, got %v", found)
+	}
+
+	for _, id := range []string{"expired-token", "unknown-token"} {
+		if _, e := store.FindAccessToken(id); e == nil || e.Error() != defs.ErrNotFound {
+			t.Fatalf("expected access token %s to not be found, got %v", id, e)
+		}
+	}
+
+	if e := store.RevokeAccessToken("active-token", time.Now().Add(time.Hour)); e != nil {
+		t.Fatalf("unable to revoke access token: %s", e.Error())
+	}
+
+	if e := store.RevokeAccessToken("expired-token", time.Now().Add(-time.Hour)); e != nil {
+		t.Fatalf("unable to revoke expired access token: %s", e.Error())
+	}
+
+	for id, expected := range map[string]bool{"active-token": true, "expired-token": false, "unknown-token": false} {
+		if revoked, e := store.AccessTokenRevoked(id); e != nil || revoked != expected {
+			t.Fatalf("expected revocation of %s to be %v, got %v (%v)", id, expected, revoked, e)
+		}
+	}
+}
+
+func (suite ConformanceSuite) state(t *testing.T, store ConformanceBackend) {
+	conformanceRegister(t, store, "device-name", "device-id")
+
+	if _, e := store.FindState("device-name"); e == nil {
+		t.Fatalf("expected a device w/o state to not be found")
+	}
+
+	if e := store.SaveState("device-name", conformanceControl(10)); e != nil {
+		t.Fatalf("unable to save state: %s", e.Error())
+	}
+
+	if state, e := store.FindState("device-name"); e != nil || state.Frames[0].Red != 10 {
+		t.Fatalf("expected the saved state to be found, got %v (%v)", state, e)
+	}
+
+	registrations, e := store.ListRegistrations()
+
+	if e != nil || len(registrations) != 1 || registrations[0].State == nil || registrations[0].State.Frames[0].Red != 10 {
+		t.Fatalf("expected listed devices to include their state, got %v (%v)", registrations, e)
+	}
+}
+
+func (suite ConformanceSuite) presets(t *testing.T, store ConformanceBackend) {
+	conformanceRegister(t, store, "device-name", "device-id")
+
+	if _, e := store.FindPreset("device-name", "deploy"); e == nil || e.Error() != defs.ErrNotFound {
+		t.Fatalf("expected a missing preset to not be found, got %v", e)
+	}
+
+	for i, name := range []string{"deploy", "rollback", "deploy"} {
+		if e := store.SavePreset("device-name", name, conformanceControl(uint32(i))); e != nil {
+			t.Fatalf("unable to save preset: %s", e.Error())
+		}
+	}
+
+	preset, e := store.FindPreset("device-name", "deploy")
+
+	if e != nil || preset.DeviceName != "device-name" || preset.Message.Frames[0].Red != 2 {
+		t.Fatalf("expected the preset to be replaced by the last save, got %v (%v)", preset, e)
+	}
+
+	if e := store.RemoveDevice("device-id"); e != nil {
+		t.Fatalf("unable to remove device: %s", e.Error())
+	}
+
+	if presets, e := store.ListPresets("device-name"); e != nil || len(presets) != 2 {
+		t.Fatalf("expected presets to be kept for the device name after removal, got %v (%v)", presets, e)
+	}
+
+	if e := store.RemovePreset("device-name", "deploy"); e != nil {
+		t.Fatalf("unable to remove preset: %s", e.Error())
+	}
+
+	if e := store.RemovePreset("device-name", "deploy"); e == nil || e.Error() != defs.ErrNotFound {
+		t.Fatalf("expected removing a missing preset to fail w/ not found, got %v", e)
+	}
+}
+
+func (suite ConformanceSuite) groups(t *testing.T, store ConformanceBackend) {
+	if _, e := store.CreateGroup("abc"); e == nil {
+		t.Fatalf("expected groups w/ a short name to be rejected")
+	}
+
+	group, e := store.CreateGroup("group-name")
+
+	if e != nil || group.GroupID == "" || group.Token == "" {
+		t.Fatalf("expected the group to be created w/ its token, got %v (%v)", group, e)
+	}
+
+	if _, e := store.CreateGroup("group-name"); e == nil || e.Error() != defs.ErrDuplicateGroupName {
+		t.Fatalf("expected groups w/ a duplicate name to be rejected, got %v", e)
+	}
+
+	for _, name := range []string{"device-name", "other-device"} {
+		if e := store.AddGroupDevice(group.GroupID, name); e != nil {
+			t.Fatalf("unable to add group device: %s", e.Error())
+		}
+	}
+
+	if e := store.RemoveGroupDevice(group.GroupID, "other-device"); e != nil {
+		t.Fatalf("unable to remove group device: %s", e.Error())
+	}
+
+	if e := store.RemoveGroupDevice(group.GroupID, "other-device"); e == nil || e.Error() != defs.ErrNotFound {
+		t.Fatalf("expected removing a device that is not a member to fail w/ not found, got %v", e)
+	}
+
+	for _, query := range []string{group.GroupID, "group-name"} {
+		found, e := store.FindGroup(query)
+
+		if e != nil || found.GroupID != group.GroupID || found.Token != "" || len(found.Devices) != 1 {
+			t.Fatalf("expected group found by %q to include its members w/o its token, got %v (%v)", query, found, e)
+		}
+	}
+
+	if groups, e := store.ListGroups(); e != nil || len(groups) != 1 || groups[0].Devices[0] != "device-name" {
+		t.Fatalf("expected the group to be listed w/ its members, got %v (%v)", groups, e)
+	}
+
+	if store.AuthorizeGroup(group.GroupID, group.Token) != true {
+		t.Fatalf("expected the group token to be authorized")
+	}
+
+	if store.AuthorizeGroup(group.GroupID, "unknown-token") || store.AuthorizeGroup("missing", group.Token) {
+		t.Fatalf("expected unknown tokens & groups to not be authorized")
+	}
+
+	conformanceRegister(t, store, "device-name", "device-id")
+
+	if e := store.RemoveDevice("device-id"); e != nil {
+		t.Fatalf("unable to remove device: %s", e.Error())
+	}
+
+	if found, e := store.FindGroup(group.GroupID); e != nil || len(found.Devices) != 0 {
+		t.Fatalf("expected the name of a removed device to be dropped from the group, got %v (%v)", found, e)
+	}
+}
+
+func (suite ConformanceSuite) schedules(t *testing.T, store ConformanceBackend) {
+	now := time.Unix(time.Now().Unix(), 0)
+
+	if _, e := store.CreateSchedule(ScheduleDetails{DeviceName: "device-name", NextRun: now}); e == nil {
+		t.Fatalf("expected schedules w/o a message to be rejected")
+	}
+
+	message := conformanceControl(10)
+	schedule := func(name, cron string, next time.Time) ScheduleDetails {
+		created, e := store.CreateSchedule(ScheduleDetails{DeviceName: name, Cron: cron, NextRun: next, Message: &message})
+
+		if e != nil || created.ScheduleID == "" {
+			t.Fatalf("expected the schedule to be created w/ an id, got %v (%v)", created, e)
+		}
+
+		return created
+	}
+
+	later, due := schedule("device-name", "", now.Add(time.Hour)), schedule("device-name", "* * * * *", now)
+	schedule("other-device", "", now.Add(time.Hour))
+
+	schedules, e := store.ListSchedules("device-name")
+
+	if e != nil || len(schedules) != 2 || schedules[0].ScheduleID != due.ScheduleID || schedules[0].Cron != "* * * * *" {
+		t.Fatalf("expected the schedules of the device name to be listed, got %v (%v)", schedules, e)
+	}
+
+	if found, e := store.DueSchedules(now); e != nil || len(found) != 1 || found[0].Message.Frames[0].Red != 10 {
+		t.Fatalf("expected only the due schedule to be found, got %v (%v)", found, e)
+	}
+
+	if claimed, e := store.ClaimSchedule(due, now.Add(time.Minute)); e != nil || claimed != true {
+		t.Fatalf("expected the due schedule to be claimed, got %v (%v)", claimed, e)
+	}
+
+	if claimed, e := store.ClaimSchedule(due, now.Add(time.Minute)); e != nil || claimed {
+		t.Fatalf("expected a schedule that was already claimed to not be claimed again, got %v (%v)", claimed, e)
+	}
+
+	if found, e := store.DueSchedules(now); e != nil || len(found) != 0 {
+		t.Fatalf("expected the advanced schedule to no longer be due, got %v (%v)", found, e)
+	}
+
+	if e := store.RemoveSchedule("other-device", later.ScheduleID); e == nil {
+		t.Fatalf("expected removing the schedule of another device name to fail")
+	}
+
+	if e := store.RemoveSchedule("device-name", later.ScheduleID); e != nil {
+		t.Fatalf("unable to remove schedule: %s", e.Error())
+	}
+
+	if schedules, e := store.ListSchedules("device-name"); e != nil || len(schedules) != 1 {
+		t.Fatalf("expected the removed schedule to no longer be listed, got %v (%v)", schedules, e)
+	}
+
+	due.NextRun = now.Add(time.Minute)
+
+	if claimed, e := store.ClaimSchedule(due, time.Time{}); e != nil || claimed != true {
+		t.Fatalf("expected the schedule to be claimed w/o a next run, got %v (%v)", claimed, e)
+	}
+
+	if schedules, e := store.ListSchedules("device-name"); e != nil || len(schedules) != 0 {
+		t.Fatalf("expected a schedule claimed w/o a next run to be removed, got %v (%v)", schedules, e)
+	}
+}
+
+func (suite ConformanceSuite) commands(t *testing.T, store ConformanceBackend) {
+	command, e := store.CreateCommand("device-id", "sender-token")
+
+	if e != nil || command.CommandID == "" || command.Status != defs.CommandStatusQueued {
+		t.Fatalf("expected the command to be created as queued, got %v (%v)", command, e)
+	}
+
+	if store.AuthorizeCommand(command.CommandID, "sender-token") != true {
+		t.Fatalf("expected the token used to send the command to be authorized")
+	}
+
+	if store.AuthorizeCommand(command.CommandID, "other-token") || store.AuthorizeCommand("missing", "sender-token") {
+		t.Fatalf("expected other tokens & commands to not be authorized")
+	}
+
+	if e := store.UpdateCommandStatus(command.CommandID, defs.CommandStatusAcknowledged); e != nil {
+		t.Fatalf("unable to update command status: %s", e.Error())
+	}
+
+	if e := store.UpdateCommandStatus(command.CommandID, defs.CommandStatusSent); e != nil {
+		t.Fatalf("unable to update command status: %s", e.Error())
+	}
+
+	if found, e := store.FindCommand(command.CommandID); e != nil || found.Status != defs.CommandStatusAcknowledged {
+		t.Fatalf("expected acknowledged commands to not be moved back to sent, got %v (%v)", found, e)
+	}
+
+	if e := store.UpdateCommandStatus("missing", defs.CommandStatusSent); e == nil || e.Error() != defs.ErrNotFound {
+		t.Fatalf("expected updating a missing command to fail w/ not found, got %v", e)
+	}
+
+	if _, e := store.FindCommand("missing"); e == nil || e.Error() != defs.ErrNotFound {
+		t.Fatalf("expected a missing command to not be found, got %v", e)
+	}
+}
+
+func (suite ConformanceSuite) commandLog(t *testing.T, store ConformanceBackend) {
+	owner := conformanceRegister(t, store, "device-name", "device-id")
+	token := conformanceToken(t, store, "device-id", defs.SecurityDeviceTokenPermissionController, nil)
+	message := conformanceControl(10)
+
+	for _, id := range []string{"first", "second", "third"} {
+		entry := CommandLogEntry{CommandID: id, DeviceID: "device-id", DeviceName: "device-name", Message: &message}
+
+		if e := store.LogCommand(token.Token, entry); e != nil {
+			t.Fatalf("unable to log command: %s", e.Error())
+		}
+	}
+
+	entries, e := store.ListCommandLog("device-name", 1, 5)
+
+	if e != nil || len(entries) != 2 || entries[0].CommandID != "second" || entries[1].CommandID != "first" {
+		t.Fatalf("expected the command log to be listed newest first from the offset, got %v (%v)", entries, e)
+	}
+
+	if entries[0].TokenID != token.TokenID || entries[0].TokenName != token.Name || entries[0].CreatedAt.IsZero() {
+		t.Fatalf("expected logged commands to include the identity of their token, got %v", entries[0])
+	}
+
+	if entries[0].Credential != defs.SecurityCredentialDeviceToken || entries[0].CredentialID != token.TokenID {
+		t.Fatalf("expected logged commands to include the kind & id of their credential, got %v", entries[0])
+	}
+
+	account, e := store.CreateAccount("account-name")
+
+	if e != nil {
+		t.Fatalf("unable to create account: %s", e.Error())
+	}
+
+	credentials := []struct {
+		token string
+		entry CommandLogEntry
+		kind  string
+		id    string
+	}{
+		{owner, CommandLogEntry{}, defs.SecurityCredentialOwnerToken, "device-id"},
+		{account.Token, CommandLogEntry{}, defs.SecurityCredentialAccountToken, account.AccountID},
+		{"group-token", CommandLogEntry{Credential: defs.SecurityCredentialGroupToken, CredentialID: "group-id"},
+			defs.SecurityCredentialGroupToken, "group-id"},
+	}
+
+	for _, credential := range credentials {
+		entry := credential.entry
+		entry.CommandID, entry.DeviceID, entry.DeviceName = "fourth", "device-id", "device-name"
+
+		if e := store.LogCommand(credential.token, entry); e != nil {
+			t.Fatalf("unable to log command: %s", e.Error())
+		}
+
+		latest, e := store.ListCommandLog("device-name", 0, 1)
+
+		if e != nil || len(latest) != 1 {
+			t.Fatalf("unable to list command log: %v", e)
+		}
+
+		if latest[0].Credential != credential.kind || latest[0].CredentialID != credential.id {
+			t.Fatalf("expected the command to be logged as sent w/ %s[%s], got %v", credential.kind, credential.id, latest[0])
+		}
+	}
+
+	if entries[0].Message == nil || entries[0].Message.Frames[0].Red != 10 {
+		t.Fatalf("expected logged commands to include their message, got %v", entries[0])
+	}
+
+	if entries, e := store.ListCommandLog("other-device", 0, 5); e != nil || len(entries) != 0 {
+		t.Fatalf("expected a device name w/o commands to list none, got %v (%v)", entries, e)
+	}
+}
+
+func (suite ConformanceSuite) pendingMessages(t *testing.T, store ConformanceBackend) {
+	for _, id := range []string{"first", "second"} {
+		if e := store.QueueMessage("device-name", interchange.DeviceMessage{CommandID: id}); e != nil {
+			t.Fatalf("unable to queue message: %s", e.Error())
+		}
+	}
+
+	messages, e := store.DequeueMessages("device-name")
+
+	if e != nil || len(messages) != 2 || messages[0].CommandID != "first" || messages[1].CommandID != "second" {
+		t.Fatalf("expected the pending messages to be dequeued oldest first, got %v (%v)", messages, e)
+	}
+
+	if messages, e := store.DequeueMessages("device-name"); e != nil || len(messages) != 0 {
+		t.Fatalf("expected dequeued messages to no longer be pending, got %v (%v)", messages, e)
+	}
+}
+
+// conformanceRegister allocates & fills a registration for the device, returning its owner token.
+func conformanceRegister(t *testing.T, store ConformanceStore, name, id string) string {
+	request := RegistrationRequest{Name: name, SharedSecret: conformanceSecret(name)}
+	owner, e := store.AllocateRegistration(request)
+
+	if e != nil {
+		t.Fatalf("unable to allocate registration for %s: %s", name, e.Error())
+	}
+
+	if e := store.FillRegistration(request.SharedSecret, id); e != nil {
+		t.Fatalf("unable to fill registration for %s: %s", name, e.Error())
+	}
+
+	return owner
+}
+
+func conformanceToken(t *testing.T, store ConformanceStore, id string, permission uint, expiry *time.Time) TokenDetails {
+	token, e := store.CreateToken(id, fmt.Sprintf("token-%b", permission), permission, expiry)
+
+	if e != nil {
+		t.Fatalf("unable to create token for %s: %s", id, e.Error())
+	}
+
+	return token
+}
+
+func conformanceFeedback(id, command string) interchange.FeedbackMessage {
+	return interchange.FeedbackMessage{
+		Authentication: &interchange.DeviceMessageAuthentication{DeviceID: id},
+		CommandID:      command,
+	}
+}
+
+func conformanceControl(red uint32) interchange.ControlMessage {
+	return interchange.ControlMessage{Frames: []*interchange.ControlFrame{{Red: red}}}
+}
+
+func conformanceSecret(name string) string {
+	return fmt.Sprintf("%s-shared-secret-0123456789", name)
+}
+
+// conformanceTokenGenerator returns a distinct token each time it is called.
+type conformanceTokenGenerator struct {
+	sync.Mutex
+	count int
+}
+
+func (generator *conformanceTokenGenerator) GenerateToken() (string, error) {
+	generator.Lock()
+	defer generator.Unlock()
+	generator.count++
+	return fmt.Sprintf("conformance-token-%d", generator.count), nil
+}
@@ -0,0 +1,14 @@
+package device
+
+// ConsistencyIssue describes a key that is out of step w/ the rest of the store, e.g. an index entry left behind by a
+// write that was interrupted part way.
+type ConsistencyIssue struct {
+	Key         string
+	Description string
+	Repaired    bool
+}
+
+// ConsistencyChecker defines an interface for stores that are able to find (and optionally repair) inconsistent keys.
+type ConsistencyChecker interface {
+	CheckConsistency(bool) ([]ConsistencyIssue, error)
+}
@@ -0,0 +1,21 @@
+package device
+
+// GroupDetails holds the information about a named collection of devices. Members are tracked by device name and are
+// dropped once the device is removed from the registry, so a device registered later under the same name is never
+// sent group messages w/o being authorized & added again.
+type GroupDetails struct {
+	GroupID string   `json:"group_id"`
+	Name    string   `json:"name"`
+	Token   string   `json:"token,omitempty"`
+	Devices []string `json:"devices,omitempty"`
+}
+
+// GroupStore defines the interface for creating device groups and managing their membership.
+type GroupStore interface {
+	CreateGroup(string) (GroupDetails, error)
+	FindGroup(string) (GroupDetails, error)
+	ListGroups() ([]GroupDetails, error)
+	AddGroupDevice(string, string) error
+	RemoveGroupDevice(string, string) error
+	AuthorizeGroup(string, string) bool
+}
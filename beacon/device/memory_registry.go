@@ -0,0 +1,1125 @@
+package device
+
+import "fmt"
+import "sort"
+import "sync"
+import "time"
+import "crypto/subtle"
+import "github.com/satori/go.uuid"
+import "github.com/golang/protobuf/proto"
+
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/logging"
+import "github.com/dadleyy/beacon.api/beacon/interchange"
+
+// NewMemoryRegistry returns an empty in-memory registry that generates tokens w/ the generator provided.
+func NewMemoryRegistry(generator TokenGenerator) *MemoryRegistry {
+	return &MemoryRegistry{
+		Logger:         logging.New(defs.MemoryRegistryLogPrefix, logging.Green),
+		TokenGenerator: generator,
+		devices:        make(map[string]memoryDevice),
+		tokens:         make(map[string]memoryToken),
+		feedback:       make(map[string][]interchange.FeedbackMessage),
+		accounts:       make(map[string]AccountDetails),
+		accountTokens:  make(map[string]string),
+		accessTokens:   make(map[string]AccessTokenDetails),
+		revocations:    make(map[string]time.Time),
+		states:         make(map[string]*interchange.ControlMessage),
+		presets:        make(map[string]map[string]*interchange.ControlMessage),
+		members:        make(map[string]map[string]bool),
+		schedules:      make(map[string]ScheduleDetails),
+		commands:       make(map[string]memoryCommand),
+		commandLog:     make(map[string][]CommandLogEntry),
+		pending:        make(map[string][]memoryMessage),
+	}
+}
+
+type memoryRequest struct {
+	RegistrationRequest
+	owner string
+}
+
+type memoryDevice struct {
+	RegistrationDetails
+	owner string
+}
+
+type memoryToken struct {
+	TokenDetails
+	createdAt time.Time
+}
+
+type memoryGroup struct {
+	GroupDetails
+	digest string
+}
+
+type memoryCommand struct {
+	CommandDetails
+	digest    string
+	expiresAt time.Time
+}
+
+type memoryMessage struct {
+	message   *interchange.DeviceMessage
+	expiresAt time.Time
+}
+
+// MemoryRegistry implements the `Registry`, `TokenStore` & `FeedbackStore` interfaces along w/ every other store used
+// by the api - accounts, revocations, presets, groups, schedules, commands, state & pending messages - w/ maps guarded
+// by a mutex. It is intended for local development and tests on a single api node; nothing survives a restart. Like
+// the other registries, tokens are only kept as salted digests.
+type MemoryRegistry struct {
+	*logging.Logger
+	TokenGenerator
+	TokenSalt        string
+	LegacySecretAuth bool
+
+	lock          sync.RWMutex
+	requests      []memoryRequest
+	devices       map[string]memoryDevice
+	tokens        map[string]memoryToken
+	feedback      map[string][]interchange.FeedbackMessage
+	accounts      map[string]AccountDetails
+	accountTokens map[string]string
+	accessTokens  map[string]AccessTokenDetails
+	revocations   map[string]time.Time
+	states        map[string]*interchange.ControlMessage
+	presets       map[string]map[string]*interchange.ControlMessage
+	groups        []memoryGroup
+	members       map[string]map[string]bool
+	schedules     map[string]ScheduleDetails
+	commands      map[string]memoryCommand
+	commandLog    map[string][]CommandLogEntry
+	pending       map[string][]memoryMessage
+}
+
+// FindDevice searches the registry for the device whose id or name matches the query.
+func (registry *MemoryRegistry) FindDevice(query string) (RegistrationDetails, error) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	device, ok := registry.findDevice(query)
+
+	if ok != true {
+		registry.Warnf("did not find matching device: %s", query)
+		return RegistrationDetails{}, fmt.Errorf(defs.ErrNotFound)
+	}
+
+	return device.RegistrationDetails, nil
+}
+
+// ListRegistrations returns every registered device along w/ its last known state, ordered by name.
+func (registry *MemoryRegistry) ListRegistrations() ([]RegistrationDetails, error) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	var results []RegistrationDetails
+
+	for _, device := range registry.devices {
+		details := device.RegistrationDetails
+
+		if state, ok := registry.states[details.Name]; ok {
+			details.State = cloneControl(state)
+		}
+
+		results = append(results, details)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+
+	return results, nil
+}
+
+// RemoveDevice deletes the device along w/ its tokens and feedback, dropping its name from every device group. Account
+// grants of the name are dropped only if no other device w/ the name remains.
+func (registry *MemoryRegistry) RemoveDevice(id string) error {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if device, ok := registry.devices[id]; ok {
+		for _, members := range registry.members {
+			delete(members, device.Name)
+		}
+
+		if registry.namedElsewhere(device.Name, id) != true {
+			for _, account := range registry.accounts {
+				delete(account.Permissions, device.Name)
+			}
+		}
+	}
+
+	for digest, token := range registry.tokens {
+		if token.DeviceID == id {
+			delete(registry.tokens, digest)
+		}
+	}
+
+	delete(registry.feedback, id)
+	delete(registry.devices, id)
+
+	return nil
+}
+
+// AllocateRegistration reserves a spot in the registry to be filled later, returning the owner token of the device.
+func (registry *MemoryRegistry) AllocateRegistration(details RegistrationRequest) (string, error) {
+	if len(details.Name) < 4 || len(details.SharedSecret) < defs.SecurityMinimumDeviceSharedSecretSize {
+		return "", fmt.Errorf(defs.ErrInvalidRegistrationRequest)
+	}
+
+	owner, e := registry.GenerateToken()
+
+	if e != nil {
+		return "", e
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	request := RegistrationRequest{Name: details.Name, SharedSecret: details.SharedSecret}
+	registry.requests = append(registry.requests, memoryRequest{request, registry.tokenDigest(owner)})
+
+	return owner, nil
+}
+
+// FillRegistration moves the pending registration w/ the matching secret into the registry under the uuid.
+func (registry *MemoryRegistry) FillRegistration(secret, uuid string) error {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	for i, request := range registry.requests {
+		if request.SharedSecret != secret {
+			continue
+		}
+
+		registry.Infof("filling device registry w/ name[%s] id[%s]", request.Name, uuid)
+
+		registry.requests = append(registry.requests[:i], registry.requests[i+1:]...)
+		registry.devices[uuid] = memoryDevice{
+			RegistrationDetails: RegistrationDetails{DeviceID: uuid, Name: request.Name, SharedSecret: secret},
+			owner:               request.owner,
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf(defs.ErrNotFound)
+}
+
+// CreateToken creates a new auth token for a given device id.
+func (registry *MemoryRegistry) CreateToken(
+	deviceID, tokenName string,
+	permission uint,
+	expiresAt *time.Time,
+) (TokenDetails, error) {
+	rawToken, e := registry.GenerateToken()
+
+	if e != nil {
+		return TokenDetails{}, e
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if _, ok := registry.findDevice(deviceID); ok != true {
+		return TokenDetails{}, fmt.Errorf(defs.ErrNotFound)
+	}
+
+	details := TokenDetails{
+		TokenID:    uuid.NewV4().String(),
+		DeviceID:   deviceID,
+		Token:      rawToken,
+		Name:       tokenName,
+		Permission: permission,
+		ExpiresAt:  expiresAt,
+	}
+
+	stored := details
+	stored.Token = ""
+	registry.tokens[registry.tokenDigest(rawToken)] = memoryToken{stored, time.Now()}
+
+	return details, nil
+}
+
+// ListTokens returns the tokens of the device matching the query, newest first.
+func (registry *MemoryRegistry) ListTokens(query string) ([]TokenDetails, error) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	device, ok := registry.findDevice(query)
+
+	if ok != true {
+		return nil, fmt.Errorf(defs.ErrNotFound)
+	}
+
+	tokens := make([]memoryToken, 0)
+
+	for _, token := range registry.tokens {
+		if token.DeviceID == device.DeviceID {
+			tokens = append(tokens, token)
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].createdAt.After(tokens[j].createdAt)
+	})
+
+	results := make([]TokenDetails, 0, len(tokens))
+
+	for _, token := range tokens {
+		results = append(results, token.TokenDetails)
+	}
+
+	return results, nil
+}
+
+// FindToken searches the token store for the token details given the raw token.
+func (registry *MemoryRegistry) FindToken(token string) (TokenDetails, error) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	details, ok := registry.tokens[registry.tokenDigest(token)]
+
+	if ok != true {
+		return TokenDetails{}, fmt.Errorf(defs.ErrNotFound)
+	}
+
+	return details.TokenDetails, nil
+}
+
+// AuthorizeToken approves the token + permission for the given device id. The owner token of the device is approved
+// for every permission.
+func (registry *MemoryRegistry) AuthorizeToken(deviceID, token string, permission uint) bool {
+	digest := registry.tokenDigest(token)
+
+	registry.lock.RLock()
+	device, ok := registry.findDevice(deviceID)
+	requester, found := registry.tokens[digest]
+	registry.lock.RUnlock()
+
+	if ok != true {
+		return false
+	}
+
+	if device.owner != "" && subtle.ConstantTimeCompare([]byte(device.owner), []byte(digest)) == 1 {
+		return true
+	}
+
+	legacy := registry.LegacySecretAuth && device.owner == ""
+
+	if legacy && subtle.ConstantTimeCompare([]byte(token), []byte(device.SharedSecret)) == 1 {
+		registry.Warnf("authorized device[%s] shared secret w/o an owner token (legacy)", device.DeviceID)
+		return true
+	}
+
+	if found != true {
+		return registry.authorizeAccount(device.Name, token, permission)
+	}
+
+	if requester.DeviceID != device.DeviceID {
+		registry.Warnf("rejecting token[%s] of device[%s] for device[%s]", requester.TokenID, requester.DeviceID, deviceID)
+		return false
+	}
+
+	if requester.Expired(time.Now()) {
+		registry.Warnf("rejecting expired token: %s (expired: %v)", requester.TokenID, requester.ExpiresAt)
+		return false
+	}
+
+	return requester.Permission&permission == permission
+}
+
+// RemoveToken deletes the token w/ the given id from the device.
+func (registry *MemoryRegistry) RemoveToken(deviceID, tokenID string) error {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	for digest, token := range registry.tokens {
+		if token.DeviceID == deviceID && token.TokenID == tokenID {
+			delete(registry.tokens, digest)
+			return nil
+		}
+	}
+
+	return fmt.Errorf(defs.ErrNotFound)
+}
+
+// LogFeedback prepends a feedback item to the feedback of the device, stamped w/ the time it was received, dropping the
+// oldest entries beyond defs.MemoryMaxFeedbackEntries.
+func (registry *MemoryRegistry) LogFeedback(message interchange.FeedbackMessage) error {
+	auth := message.GetAuthentication()
+
+	if auth == nil {
+		return fmt.Errorf(defs.ErrBadInterchangeAuthentication)
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	device, ok := registry.findDevice(auth.DeviceID)
+
+	if ok != true {
+		return fmt.Errorf(defs.ErrNotFound)
+	}
+
+	message.ReceivedAt = time.Now().Unix()
+
+	entries := append([]interchange.FeedbackMessage{message}, registry.feedback[device.DeviceID]...)
+
+	if len(entries) > defs.MemoryMaxFeedbackEntries {
+		entries = entries[:defs.MemoryMaxFeedbackEntries]
+	}
+
+	registry.feedback[device.DeviceID] = entries
+
+	return nil
+}
+
+// ListFeedback retrieves the latest feedback for a given device id, newest first. Like the redis registry, the count is
+// treated as an inclusive end index.
+func (registry *MemoryRegistry) ListFeedback(id string, count int) ([]interchange.FeedbackMessage, error) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	device, ok := registry.findDevice(id)
+
+	if ok != true {
+		return nil, fmt.Errorf(defs.ErrNotFound)
+	}
+
+	entries := registry.feedback[device.DeviceID]
+
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	if count+1 < len(entries) {
+		entries = entries[:count+1]
+	}
+
+	return append([]interchange.FeedbackMessage{}, entries...), nil
+}
+
+// CreateAccount allocates a new user account along w/ its token. The token is only returned here; just its salted
+// digest is kept.
+func (registry *MemoryRegistry) CreateAccount(name string) (AccountDetails, error) {
+	if len(name) < defs.SecurityAccountNameMinLength {
+		return AccountDetails{}, fmt.Errorf(defs.ErrInvalidAccountName)
+	}
+
+	token, e := registry.GenerateToken()
+
+	if e != nil {
+		return AccountDetails{}, e
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	accountID := uuid.NewV4().String()
+	registry.accounts[accountID] = AccountDetails{
+		AccountID:   accountID,
+		Name:        name,
+		Permissions: map[string]defs.DeviceTokenPermissions{},
+	}
+	registry.accountTokens[registry.tokenDigest(token)] = accountID
+
+	registry.Infof("created account[%s] id[%s]", name, accountID)
+
+	details := AccountDetails{
+		AccountID:   accountID,
+		Name:        name,
+		Token:       token,
+		Permissions: map[string]defs.DeviceTokenPermissions{},
+	}
+
+	return details, nil
+}
+
+// FindAccount loads the account identified by the account token provided along w/ the permissions it was granted.
+func (registry *MemoryRegistry) FindAccount(token string) (AccountDetails, error) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	account, ok := registry.accounts[registry.accountTokens[registry.tokenDigest(token)]]
+
+	if ok != true {
+		return AccountDetails{}, fmt.Errorf(defs.ErrNotFound)
+	}
+
+	permissions := make(map[string]defs.DeviceTokenPermissions, len(account.Permissions))
+
+	for deviceID, permission := range account.Permissions {
+		permissions[deviceID] = permission
+	}
+
+	account.Permissions = permissions
+
+	return account, nil
+}
+
+// GrantAccountPermission sets the permission bitmask of the account for the device name, replacing any previous grant.
+func (registry *MemoryRegistry) GrantAccountPermission(
+	accountID, deviceName string,
+	permission defs.DeviceTokenPermissions,
+) error {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	account, ok := registry.accounts[accountID]
+
+	if ok != true {
+		return fmt.Errorf(defs.ErrNotFound)
+	}
+
+	account.Permissions[deviceName] = permission
+
+	return nil
+}
+
+// RevokeAccountPermission removes any permission the account was granted for the device name.
+func (registry *MemoryRegistry) RevokeAccountPermission(accountID, deviceName string) error {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	account, ok := registry.accounts[accountID]
+
+	if ok != true {
+		return fmt.Errorf(defs.ErrNotFound)
+	}
+
+	if _, ok := account.Permissions[deviceName]; ok != true {
+		return fmt.Errorf(defs.ErrNotFound)
+	}
+
+	delete(account.Permissions, deviceName)
+
+	return nil
+}
+
+// SaveAccessToken keeps the record of an issued access token until its expiry. Records that have since expired are
+// dropped along the way.
+func (registry *MemoryRegistry) SaveAccessToken(details AccessTokenDetails) error {
+	now := time.Now()
+
+	if details.ExpiresAt.After(now) != true {
+		return nil
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	for id, existing := range registry.accessTokens {
+		if existing.ExpiresAt.After(now) != true {
+			delete(registry.accessTokens, id)
+		}
+	}
+
+	details.Token = ""
+	registry.accessTokens[details.TokenID] = details
+
+	return nil
+}
+
+// FindAccessToken returns the record of the issued access token w/ the given id if it has not yet expired.
+func (registry *MemoryRegistry) FindAccessToken(tokenID string) (AccessTokenDetails, error) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	details, ok := registry.accessTokens[tokenID]
+
+	if ok != true || details.ExpiresAt.After(time.Now()) != true {
+		return AccessTokenDetails{}, fmt.Errorf(defs.ErrNotFound)
+	}
+
+	return details, nil
+}
+
+// RevokeAccessToken rejects the signed access token w/ the given id until its expiry. Revocations that have since
+// expired are dropped along the way.
+func (registry *MemoryRegistry) RevokeAccessToken(tokenID string, expiresAt time.Time) error {
+	now := time.Now()
+
+	if expiresAt.After(now) != true {
+		return nil
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	for id, expiry := range registry.revocations {
+		if expiry.After(now) != true {
+			delete(registry.revocations, id)
+		}
+	}
+
+	registry.revocations[tokenID] = expiresAt
+
+	return nil
+}
+
+// AccessTokenRevoked returns true if the signed access token w/ the given id has been revoked.
+func (registry *MemoryRegistry) AccessTokenRevoked(tokenID string) (bool, error) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	expiry, ok := registry.revocations[tokenID]
+
+	return ok && expiry.After(time.Now()), nil
+}
+
+// SavePreset stores the control message under the preset name provided for the device name, replacing any existing
+// entry.
+func (registry *MemoryRegistry) SavePreset(deviceName, name string, message interchange.ControlMessage) error {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if _, ok := registry.presets[deviceName]; ok != true {
+		registry.presets[deviceName] = make(map[string]*interchange.ControlMessage)
+	}
+
+	registry.presets[deviceName][name] = cloneControl(&message)
+
+	return nil
+}
+
+// FindPreset loads the preset saved under the preset name provided for the device name.
+func (registry *MemoryRegistry) FindPreset(deviceName, name string) (PresetDetails, error) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	message, ok := registry.presets[deviceName][name]
+
+	if ok != true {
+		return PresetDetails{}, fmt.Errorf(defs.ErrNotFound)
+	}
+
+	return PresetDetails{DeviceName: deviceName, Name: name, Message: cloneControl(message)}, nil
+}
+
+// ListPresets returns every preset saved for the device name, ordered by name.
+func (registry *MemoryRegistry) ListPresets(deviceName string) ([]PresetDetails, error) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	results := make([]PresetDetails, 0, len(registry.presets[deviceName]))
+
+	for name, message := range registry.presets[deviceName] {
+		results = append(results, PresetDetails{DeviceName: deviceName, Name: name, Message: cloneControl(message)})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+
+	return results, nil
+}
+
+// RemovePreset deletes the preset saved under the preset name provided for the device name.
+func (registry *MemoryRegistry) RemovePreset(deviceName, name string) error {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if _, ok := registry.presets[deviceName][name]; ok != true {
+		return fmt.Errorf(defs.ErrNotFound)
+	}
+
+	delete(registry.presets[deviceName], name)
+
+	return nil
+}
+
+// CreateGroup allocates a new device group along w/ the token used to authorize commands sent to its members. The token
+// is only returned here; just its salted digest is kept.
+func (registry *MemoryRegistry) CreateGroup(name string) (GroupDetails, error) {
+	if len(name) < defs.SecurityDeviceGroupNameMinLength {
+		return GroupDetails{}, fmt.Errorf(defs.ErrInvalidGroupName)
+	}
+
+	token, e := registry.GenerateToken()
+
+	if e != nil {
+		return GroupDetails{}, e
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if _, ok := registry.findGroup(name); ok {
+		return GroupDetails{}, fmt.Errorf(defs.ErrDuplicateGroupName)
+	}
+
+	groupID := uuid.NewV4().String()
+	group := memoryGroup{GroupDetails{GroupID: groupID, Name: name}, registry.tokenDigest(token)}
+
+	registry.groups = append([]memoryGroup{group}, registry.groups...)
+	registry.members[groupID] = make(map[string]bool)
+
+	registry.Infof("created device group[%s] id[%s]", name, groupID)
+
+	return GroupDetails{GroupID: groupID, Name: name, Token: token, Devices: []string{}}, nil
+}
+
+// FindGroup searches the registry for the device group matching either the id or name provided.
+func (registry *MemoryRegistry) FindGroup(query string) (GroupDetails, error) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	group, ok := registry.findGroup(query)
+
+	if ok != true {
+		return GroupDetails{}, fmt.Errorf(defs.ErrNotFound)
+	}
+
+	return registry.loadGroup(group), nil
+}
+
+// ListGroups returns every device group in the registry, newest first.
+func (registry *MemoryRegistry) ListGroups() ([]GroupDetails, error) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	results := make([]GroupDetails, 0, len(registry.groups))
+
+	for _, group := range registry.groups {
+		results = append(results, registry.loadGroup(group))
+	}
+
+	return results, nil
+}
+
+// AddGroupDevice adds the device name to the set of group members.
+func (registry *MemoryRegistry) AddGroupDevice(groupID, name string) error {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	members, ok := registry.members[groupID]
+
+	if ok != true {
+		return fmt.Errorf(defs.ErrNotFound)
+	}
+
+	members[name] = true
+
+	return nil
+}
+
+// RemoveGroupDevice removes the device name from the set of group members.
+func (registry *MemoryRegistry) RemoveGroupDevice(groupID, name string) error {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if registry.members[groupID][name] != true {
+		return fmt.Errorf(defs.ErrNotFound)
+	}
+
+	delete(registry.members[groupID], name)
+
+	return nil
+}
+
+// AuthorizeGroup returns true if the digest of the token provided matches the digest kept for the device group.
+func (registry *MemoryRegistry) AuthorizeGroup(groupID, token string) bool {
+	registry.lock.RLock()
+	group, ok := registry.findGroup(groupID)
+	registry.lock.RUnlock()
+
+	if ok != true || group.GroupID != groupID {
+		registry.Warnf("unable to find group[%s]", groupID)
+		return false
+	}
+
+	return len(token) >= 1 && subtle.ConstantTimeCompare([]byte(registry.tokenDigest(token)), []byte(group.digest)) == 1
+}
+
+// CreateSchedule stores the schedule, assigning it a new id.
+func (registry *MemoryRegistry) CreateSchedule(schedule ScheduleDetails) (ScheduleDetails, error) {
+	if schedule.Message == nil {
+		return ScheduleDetails{}, fmt.Errorf(defs.ErrInvalidSchedule)
+	}
+
+	schedule.ScheduleID = uuid.NewV4().String()
+	schedule.NextRun = time.Unix(schedule.NextRun.Unix(), 0)
+	schedule.Message = cloneControl(schedule.Message)
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	registry.schedules[schedule.ScheduleID] = schedule
+
+	registry.Infof("created schedule[%s] for device[%s]", schedule.ScheduleID, schedule.DeviceName)
+
+	return registry.loadSchedule(schedule), nil
+}
+
+// ListSchedules returns every schedule associated w/ the device name, ordered by their next run.
+func (registry *MemoryRegistry) ListSchedules(name string) ([]ScheduleDetails, error) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	return registry.filterSchedules(func(schedule ScheduleDetails) bool {
+		return schedule.DeviceName == name
+	}), nil
+}
+
+// RemoveSchedule deletes the schedule of the device name.
+func (registry *MemoryRegistry) RemoveSchedule(name, scheduleID string) error {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if schedule, ok := registry.schedules[scheduleID]; ok != true || schedule.DeviceName != name {
+		return fmt.Errorf(defs.ErrNotFound)
+	}
+
+	delete(registry.schedules, scheduleID)
+
+	return nil
+}
+
+// DueSchedules returns every schedule whose next run is at or before the time provided, ordered by their next run.
+func (registry *MemoryRegistry) DueSchedules(now time.Time) ([]ScheduleDetails, error) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	return registry.filterSchedules(func(schedule ScheduleDetails) bool {
+		return schedule.NextRun.Unix() <= now.Unix()
+	}), nil
+}
+
+// ClaimSchedule moves the schedule to its next run, or removes it when the next run is zero, returning false if it was
+// already claimed.
+func (registry *MemoryRegistry) ClaimSchedule(due ScheduleDetails, next time.Time) (bool, error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	schedule, ok := registry.schedules[due.ScheduleID]
+
+	if ok != true || schedule.NextRun.Unix() != due.NextRun.Unix() {
+		return false, nil
+	}
+
+	if next.IsZero() {
+		delete(registry.schedules, due.ScheduleID)
+		return true, nil
+	}
+
+	schedule.NextRun = time.Unix(next.Unix(), 0)
+	registry.schedules[due.ScheduleID] = schedule
+
+	return true, nil
+}
+
+// CreateCommand stores a new queued command for the device along w/ a digest of the token used to send it. Commands
+// expire after defs.MemoryDeviceCommandTTL seconds; expired commands are dropped whenever a new one is created.
+func (registry *MemoryRegistry) CreateCommand(deviceID, token string) (CommandDetails, error) {
+	now := time.Unix(time.Now().Unix(), 0)
+
+	details := CommandDetails{
+		CommandID: uuid.NewV4().String(),
+		DeviceID:  deviceID,
+		Status:    defs.CommandStatusQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	for id, command := range registry.commands {
+		if command.expiresAt.After(now) != true {
+			delete(registry.commands, id)
+		}
+	}
+
+	expiresAt := now.Add(defs.MemoryDeviceCommandTTL * time.Second)
+	registry.commands[details.CommandID] = memoryCommand{details, registry.tokenDigest(token), expiresAt}
+
+	return details, nil
+}
+
+// FindCommand loads the current status of the command.
+func (registry *MemoryRegistry) FindCommand(commandID string) (CommandDetails, error) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	command, ok := registry.findCommand(commandID)
+
+	if ok != true {
+		return CommandDetails{}, fmt.Errorf(defs.ErrNotFound)
+	}
+
+	return command.CommandDetails, nil
+}
+
+// AuthorizeCommand returns true if the token provided is the same token that was used to create the command.
+func (registry *MemoryRegistry) AuthorizeCommand(commandID, token string) bool {
+	registry.lock.RLock()
+	command, ok := registry.findCommand(commandID)
+	registry.lock.RUnlock()
+
+	if ok != true {
+		registry.Warnf("unable to find command[%s]", commandID)
+		return false
+	}
+
+	return len(token) >= 1 && subtle.ConstantTimeCompare([]byte(registry.tokenDigest(token)), []byte(command.digest)) == 1
+}
+
+// UpdateCommandStatus sets the status of the command. Like the redis registry, commands that have already been
+// acknowledged or failed are not moved back to sent.
+func (registry *MemoryRegistry) UpdateCommandStatus(commandID, status string) error {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	command, ok := registry.findCommand(commandID)
+
+	if ok != true {
+		return fmt.Errorf(defs.ErrNotFound)
+	}
+
+	current := command.Status
+	final := current == defs.CommandStatusAcknowledged || current == defs.CommandStatusFailed
+
+	if final && status == defs.CommandStatusSent {
+		registry.Debugf("command[%s] already %s, ignoring %s", commandID, current, status)
+		return nil
+	}
+
+	command.Status, command.UpdatedAt = status, time.Unix(time.Now().Unix(), 0)
+	registry.commands[commandID] = command
+
+	return nil
+}
+
+// LogCommand records the entry in the command log of its device name, keeping the newest
+// defs.MemoryMaxCommandLogEntries entries. The kind & id of the credential the token belongs to are added to the entry.
+func (registry *MemoryRegistry) LogCommand(token string, entry CommandLogEntry) error {
+	entry = identifyCredential(registry, token, entry)
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+
+	if entry.Message != nil {
+		entry.Message = cloneControl(entry.Message)
+	}
+
+	entries := append([]CommandLogEntry{entry}, registry.commandLog[entry.DeviceName]...)
+
+	if len(entries) > defs.MemoryMaxCommandLogEntries {
+		entries = entries[:defs.MemoryMaxCommandLogEntries]
+	}
+
+	registry.commandLog[entry.DeviceName] = entries
+
+	return nil
+}
+
+// ListCommandLog returns up to count entries from the command log of the device name, newest first, skipping the first
+// entries up to the offset.
+func (registry *MemoryRegistry) ListCommandLog(name string, offset, count int) ([]CommandLogEntry, error) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	entries := registry.commandLog[name]
+
+	if offset < 0 || offset >= len(entries) || count <= 0 {
+		return []CommandLogEntry{}, nil
+	}
+
+	if end := offset + count; end < len(entries) {
+		entries = entries[:end]
+	}
+
+	return append([]CommandLogEntry{}, entries[offset:]...), nil
+}
+
+// SaveState stores the control message as the last known state of the device name.
+func (registry *MemoryRegistry) SaveState(name string, message interchange.ControlMessage) error {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	registry.states[name] = cloneControl(&message)
+
+	return nil
+}
+
+// FindState returns the last control message that was successfully sent to the device name.
+func (registry *MemoryRegistry) FindState(name string) (*interchange.ControlMessage, error) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	state, ok := registry.states[name]
+
+	if ok != true {
+		return nil, fmt.Errorf(defs.ErrNotFound)
+	}
+
+	return cloneControl(state), nil
+}
+
+// QueueMessage keeps a message for the device name to be sent once the device connects. Each message expires after
+// defs.MemoryPendingMessageTTL seconds and only the newest defs.MemoryMaxPendingMessages messages are kept.
+func (registry *MemoryRegistry) QueueMessage(name string, message interchange.DeviceMessage) error {
+	pending := memoryMessage{
+		message:   proto.Clone(&message).(*interchange.DeviceMessage),
+		expiresAt: time.Now().Add(defs.MemoryPendingMessageTTL * time.Second),
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	queue := append(registry.pending[name], pending)
+
+	if len(queue) > defs.MemoryMaxPendingMessages {
+		queue = queue[len(queue)-defs.MemoryMaxPendingMessages:]
+	}
+
+	registry.pending[name] = queue
+
+	return nil
+}
+
+// DequeueMessages removes and returns the messages that are still pending for the device name, oldest first.
+func (registry *MemoryRegistry) DequeueMessages(name string) ([]interchange.DeviceMessage, error) {
+	registry.lock.Lock()
+	queue := registry.pending[name]
+	delete(registry.pending, name)
+	registry.lock.Unlock()
+
+	now, results := time.Now(), make([]interchange.DeviceMessage, 0, len(queue))
+
+	for _, pending := range queue {
+		if pending.expiresAt.After(now) != true {
+			registry.Debugf("pending message for device[%s] expired", name)
+			continue
+		}
+
+		results = append(results, *pending.message)
+	}
+
+	return results, nil
+}
+
+// authorizeAccount approves the token + permission for the given device name if the token belongs to an account that
+// was granted the permission for the name.
+func (registry *MemoryRegistry) authorizeAccount(deviceName, token string, permission uint) bool {
+	account, e := registry.FindAccount(token)
+
+	if e != nil {
+		registry.Warnf("unable to find token or account for device[%s]", deviceName)
+		return false
+	}
+
+	granted, ok := account.Permissions[deviceName]
+
+	if ok != true {
+		registry.Warnf("account[%s] has not been granted any permission for device[%s]", account.AccountID, deviceName)
+		return false
+	}
+
+	return uint(granted)&permission == permission
+}
+
+// namedElsewhere returns true if a device other than the one w/ the given id has the name; the lock must be held by
+// the caller.
+func (registry *MemoryRegistry) namedElsewhere(name, id string) bool {
+	for other, device := range registry.devices {
+		if other != id && device.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findDevice returns the device whose id or name matches the query; the lock must be held by the caller.
+func (registry *MemoryRegistry) findDevice(query string) (memoryDevice, bool) {
+	if device, ok := registry.devices[query]; ok {
+		return device, true
+	}
+
+	for _, device := range registry.devices {
+		if device.Name == query {
+			return device, true
+		}
+	}
+
+	return memoryDevice{}, false
+}
+
+// tokenDigest returns the salted digest of the token.
+func (registry *MemoryRegistry) tokenDigest(token string) string {
+	return saltedDigest(registry.TokenSalt, token)
+}
+
+// findGroup returns the group whose id or name matches the query; the lock must be held by the caller.
+func (registry *MemoryRegistry) findGroup(query string) (memoryGroup, bool) {
+	for _, group := range registry.groups {
+		if group.GroupID == query || group.Name == query {
+			return group, true
+		}
+	}
+
+	return memoryGroup{}, false
+}
+
+// loadGroup returns the group details (w/o the group token) along w/ its members, ordered by name; the lock must be
+// held by the caller.
+func (registry *MemoryRegistry) loadGroup(group memoryGroup) GroupDetails {
+	details := group.GroupDetails
+	details.Devices = make([]string, 0, len(registry.members[group.GroupID]))
+
+	for name := range registry.members[group.GroupID] {
+		details.Devices = append(details.Devices, name)
+	}
+
+	sort.Strings(details.Devices)
+
+	return details
+}
+
+// filterSchedules returns the schedules matching the filter, ordered by their next run; the lock must be held by the
+// caller.
+func (registry *MemoryRegistry) filterSchedules(filter func(ScheduleDetails) bool) []ScheduleDetails {
+	results := make([]ScheduleDetails, 0)
+
+	for _, schedule := range registry.schedules {
+		if filter(schedule) {
+			results = append(results, registry.loadSchedule(schedule))
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].NextRun.Before(results[j].NextRun)
+	})
+
+	return results
+}
+
+// loadSchedule returns a copy of the schedule that does not share its message w/ the registry.
+func (registry *MemoryRegistry) loadSchedule(schedule ScheduleDetails) ScheduleDetails {
+	schedule.Message = cloneControl(schedule.Message)
+	return schedule
+}
+
+// findCommand returns the command w/ the given id unless it has expired; the lock must be held by the caller.
+func (registry *MemoryRegistry) findCommand(commandID string) (memoryCommand, bool) {
+	command, ok := registry.commands[commandID]
+
+	if ok != true || command.expiresAt.After(time.Now()) != true {
+		return memoryCommand{}, false
+	}
+
+	return command, true
+}
+
+// cloneControl returns a deep copy of the control message so callers are unable to modify the stored messages.
+func cloneControl(message *interchange.ControlMessage) *interchange.ControlMessage {
+	return proto.Clone(message).(*interchange.ControlMessage)
+}
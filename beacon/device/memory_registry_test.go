@@ -0,0 +1,107 @@
+package device
+
+import "log"
+import "sync"
+import "bytes"
+import "testing"
+import "github.com/franela/goblin"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/logging"
+
+func memorySubject(generator TokenGenerator) *MemoryRegistry {
+	out := bytes.NewBuffer([]byte{})
+	logger := log.New(out, "", 0)
+	logger.SetFlags(0)
+
+	registry := NewMemoryRegistry(generator)
+	registry.Logger = &logging.Logger{Logger: logger}
+	registry.TokenSalt = "test-salt"
+	return registry
+}
+
+func Test_MemoryRegistryConformance(t *testing.T) {
+	suite := ConformanceSuite{
+		New: func(generator TokenGenerator) (ConformanceStore, error) {
+			return memorySubject(generator), nil
+		},
+		NewBackend: func(generator TokenGenerator) (ConformanceBackend, error) {
+			return memorySubject(generator), nil
+		},
+		MaxFeedbackEntries: defs.MemoryMaxFeedbackEntries,
+	}
+
+	suite.Run(t)
+}
+
+func Test_MemoryRegistry(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("MemoryRegistry", func() {
+		var r *MemoryRegistry
+
+		secret := "some-long-shared-secret-value"
+
+		g.BeforeEach(func() {
+			generator = fakeTokenGenerator{"owner-token", nil}
+			r = memorySubject(&generator)
+		})
+
+		register := func(name, id string) {
+			_, e := r.AllocateRegistration(RegistrationRequest{Name: name, SharedSecret: secret + name})
+			g.Assert(e).Equal(nil)
+			g.Assert(r.FillRegistration(secret+name, id)).Equal(nil)
+		}
+
+		g.Describe("tokens", func() {
+			g.BeforeEach(func() {
+				register("device-name", "device-id")
+				register("other-device", "other-id")
+				generator = fakeTokenGenerator{"device-token", nil}
+			})
+
+			g.It("does not keep the raw token", func() {
+				_, e := r.CreateToken("device-id", "token-name", defs.SecurityDeviceTokenPermissionViewer, nil)
+				g.Assert(e).Equal(nil)
+				_, ok := r.tokens["device-token"]
+				g.Assert(ok).Equal(false)
+				found, e := r.FindToken("device-token")
+				g.Assert(e).Equal(nil)
+				g.Assert(found.Token).Equal("")
+			})
+
+			g.It("authorizes account tokens w/ the permission granted to the account", func() {
+				generator = fakeTokenGenerator{"account-token", nil}
+				account, e := r.CreateAccount("account-name")
+				g.Assert(e).Equal(nil)
+				viewer := defs.DeviceTokenPermissions(defs.SecurityDeviceTokenPermissionViewer)
+				g.Assert(r.GrantAccountPermission(account.AccountID, "device-name", viewer)).Equal(nil)
+				g.Assert(r.AuthorizeToken("device-id", "account-token", defs.SecurityDeviceTokenPermissionViewer)).Equal(true)
+				g.Assert(r.AuthorizeToken("other-id", "account-token", defs.SecurityDeviceTokenPermissionViewer)).Equal(false)
+			})
+		})
+
+		g.Describe("concurrent use", func() {
+			g.It("creates tokens from several goroutines", func() {
+				r = memorySubject(&conformanceTokenGenerator{})
+				register("device-name", "device-id")
+				wg := sync.WaitGroup{}
+
+				for i := 0; i < 20; i++ {
+					wg.Add(1)
+
+					go func() {
+						defer wg.Done()
+						r.CreateToken("device-id", "token-name", defs.SecurityDeviceTokenPermissionViewer, nil)
+						r.AuthorizeToken("device-id", "conformance-token-1", defs.SecurityDeviceTokenPermissionViewer)
+					}()
+				}
+
+				wg.Wait()
+
+				l, e := r.ListTokens("device-id")
+				g.Assert(e).Equal(nil)
+				g.Assert(len(l)).Equal(20)
+			})
+		})
+	})
+}
@@ -0,0 +1,8 @@
+package device
+
+// OwnershipStore defines an interface for tracking which api node holds the connection of each device so that messages
+// for the device can be relayed to that node.
+type OwnershipStore interface {
+	ClaimDevice(string, string) error
+	FindOwner(string) (string, error)
+}
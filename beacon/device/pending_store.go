@@ -0,0 +1,10 @@
+package device
+
+import "github.com/dadleyy/beacon.api/beacon/interchange"
+
+// PendingMessageStore defines an interface for holding on to device messages that could not be delivered because the
+// device was not connected. Messages are stored by device name so they survive the device reconnecting w/ a new id.
+type PendingMessageStore interface {
+	QueueMessage(string, interchange.DeviceMessage) error
+	DequeueMessages(string) ([]interchange.DeviceMessage, error)
+}
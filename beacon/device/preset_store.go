@@ -0,0 +1,19 @@
+package device
+
+import "github.com/dadleyy/beacon.api/beacon/interchange"
+
+// PresetDetails holds a named control message saved for a given device. Presets are scoped to the device name so they
+// outlive the connection the device was registered w/.
+type PresetDetails struct {
+	DeviceName string                      `json:"device_name"`
+	Name       string                      `json:"name"`
+	Message    *interchange.ControlMessage `json:"message"`
+}
+
+// PresetStore defines an interface for persisting named control messages scoped to a device name.
+type PresetStore interface {
+	SavePreset(string, string, interchange.ControlMessage) error
+	FindPreset(string, string) (PresetDetails, error)
+	ListPresets(string) ([]PresetDetails, error)
+	RemovePreset(string, string) error
+}
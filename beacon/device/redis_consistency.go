@@ -0,0 +1,278 @@
+package device
+
+import "fmt"
+import "strings"
+import "github.com/garyburd/redigo/redis"
+
+import "github.com/dadleyy/beacon.api/beacon/defs"
+
+// CheckConsistency looks for keys left behind by multi-key writes that were interrupted part way: device ids indexed
+// w/o a device hash, devices missing from the name index, name & registration secret index entries w/o the key they
+// refer to and tokens that are either listed w/o details or stored w/o being listed. Issues are repaired when
+// requested, otherwise they are only reported.
+func (registry *RedisRegistry) CheckConsistency(repair bool) ([]ConsistencyIssue, error) {
+	checks := []func(bool) ([]ConsistencyIssue, error){
+		registry.checkDeviceIndex,
+		registry.checkNameIndex,
+		registry.checkSecretIndex,
+		registry.checkTokenDetails,
+	}
+
+	issues := make([]ConsistencyIssue, 0)
+
+	for _, check := range checks {
+		found, e := check(repair)
+		issues = append(issues, found...)
+
+		if e != nil {
+			return issues, e
+		}
+	}
+
+	return issues, nil
+}
+
+// checkDeviceIndex looks for device ids indexed w/o a device hash, devices whose name is not indexed and token list
+// entries w/o token details. Devices w/o a hash are removed entirely so their tokens are no longer authorized.
+func (registry *RedisRegistry) checkDeviceIndex(repair bool) ([]ConsistencyIssue, error) {
+	ids, e := registry.lrangestr(defs.RedisDeviceIndexKey, 0, -1)
+
+	if e != nil {
+		return nil, e
+	}
+
+	issues := make([]ConsistencyIssue, 0)
+
+	for _, id := range ids {
+		deviceKey := registry.genRegistryKey(id)
+		name, e := registry.hgetstr(deviceKey, defs.RedisDeviceNameField)
+
+		if e == redis.ErrNil {
+			issue := ConsistencyIssue{Key: deviceKey, Description: fmt.Sprintf("device[%s] is indexed w/o details", id)}
+			issues = append(issues, registry.repairIssue(issue, repair, func() error {
+				return registry.RemoveDevice(id)
+			}))
+			continue
+		}
+
+		if e != nil {
+			return issues, e
+		}
+
+		if _, e := registry.hgetstr(defs.RedisDeviceNameIndexKey, name); e == redis.ErrNil {
+			issue := ConsistencyIssue{
+				Key:         defs.RedisDeviceNameIndexKey,
+				Description: fmt.Sprintf("name[%s] of device[%s] is not indexed", name, id),
+			}
+			issues = append(issues, registry.repairIssue(issue, repair, func() error {
+				return registry.hset(defs.RedisDeviceNameIndexKey, name, id)
+			}))
+		} else if e != nil {
+			return issues, e
+		}
+
+		tokenIssues, e := registry.checkTokenList(id, repair)
+		issues = append(issues, tokenIssues...)
+
+		if e != nil {
+			return issues, e
+		}
+	}
+
+	return issues, nil
+}
+
+// checkTokenList looks for digests in the token list of the device whose token details no longer exist.
+func (registry *RedisRegistry) checkTokenList(id string, repair bool) ([]ConsistencyIssue, error) {
+	listKey := registry.genTokenListKey(id)
+	digests, e := registry.lrangestr(listKey, 0, -1)
+
+	if e != nil {
+		return nil, e
+	}
+
+	issues := make([]ConsistencyIssue, 0)
+
+	for _, digest := range digests {
+		found, e := registry.exists(registry.genTokenDigestKey(digest))
+
+		if e != nil {
+			return issues, e
+		}
+
+		if found {
+			continue
+		}
+
+		issue := ConsistencyIssue{Key: listKey, Description: fmt.Sprintf("token[%s] is listed w/o details", digest)}
+		issues = append(issues, registry.repairIssue(issue, repair, func() error {
+			_, e := registry.Do("LREM", listKey, 0, digest)
+			return e
+		}))
+	}
+
+	return issues, nil
+}
+
+// checkNameIndex looks for names indexed for devices that no longer exist or have since been renamed.
+func (registry *RedisRegistry) checkNameIndex(repair bool) ([]ConsistencyIssue, error) {
+	names, e := redis.StringMap(registry.Do("HGETALL", defs.RedisDeviceNameIndexKey))
+
+	if e != nil {
+		return nil, e
+	}
+
+	issues := make([]ConsistencyIssue, 0)
+
+	for name, id := range names {
+		current, e := registry.hgetstr(registry.genRegistryKey(id), defs.RedisDeviceNameField)
+
+		if e != nil && e != redis.ErrNil {
+			return issues, e
+		}
+
+		if e == nil && current == name {
+			continue
+		}
+
+		issue := ConsistencyIssue{
+			Key:         defs.RedisDeviceNameIndexKey,
+			Description: fmt.Sprintf("name[%s] is indexed for missing device[%s]", name, id),
+		}
+		issues = append(issues, registry.repairIssue(issue, repair, func() error {
+			_, e := registry.eval(unindexScript, defs.RedisDeviceNameIndexKey, name, id)
+			return e
+		}))
+	}
+
+	return issues, nil
+}
+
+// checkSecretIndex looks for registration secrets indexed for registration requests that no longer exist.
+func (registry *RedisRegistry) checkSecretIndex(repair bool) ([]ConsistencyIssue, error) {
+	secrets, e := redis.StringMap(registry.Do("HGETALL", defs.RedisRegistrationSecretIndexKey))
+
+	if e != nil {
+		return nil, e
+	}
+
+	issues := make([]ConsistencyIssue, 0)
+
+	for digest, requestID := range secrets {
+		found, e := registry.exists(registry.genAllocationKey(requestID))
+
+		if e != nil {
+			return issues, e
+		}
+
+		if found {
+			continue
+		}
+
+		issue := ConsistencyIssue{
+			Key:         defs.RedisRegistrationSecretIndexKey,
+			Description: fmt.Sprintf("registration request[%s] is indexed w/o details", requestID),
+		}
+		issues = append(issues, registry.repairIssue(issue, repair, func() error {
+			_, e := registry.eval(unindexScript, defs.RedisRegistrationSecretIndexKey, digest, requestID)
+			return e
+		}))
+	}
+
+	return issues, nil
+}
+
+// checkTokenDetails walks the token details w/ SCAN, looking for tokens that are not in the token list of their device.
+// These tokens are still authorized but can neither be listed nor removed through the api, so they are deleted.
+func (registry *RedisRegistry) checkTokenDetails(repair bool) ([]ConsistencyIssue, error) {
+	prefix := fmt.Sprintf("%s:", defs.RedisDeviceTokenDigestKey)
+	issues, cursor := make([]ConsistencyIssue, 0), 0
+
+	for {
+		values, e := redis.Values(registry.Do(
+			"SCAN", cursor, "MATCH", fmt.Sprintf("%s*", prefix), "COUNT", defs.RedisConsistencyScanCount,
+		))
+
+		if e != nil {
+			return issues, e
+		}
+
+		if len(values) != 2 {
+			return issues, fmt.Errorf(defs.ErrBadRedisResponse)
+		}
+
+		if cursor, e = redis.Int(values[0], nil); e != nil {
+			return issues, e
+		}
+
+		keys, e := redis.Strings(values[1], nil)
+
+		if e != nil {
+			return issues, e
+		}
+
+		for _, key := range keys {
+			listed, e := registry.tokenListed(key, strings.TrimPrefix(key, prefix))
+
+			if e != nil {
+				return issues, e
+			}
+
+			if listed {
+				continue
+			}
+
+			issue := ConsistencyIssue{Key: key, Description: "token details are not in the token list of a device"}
+			issues = append(issues, registry.repairIssue(issue, repair, func() error {
+				return registry.del(key)
+			}))
+		}
+
+		if cursor == 0 {
+			return issues, nil
+		}
+	}
+}
+
+// tokenListed returns whether the token details stored at the key are in the token list of the device they belong to.
+func (registry *RedisRegistry) tokenListed(key, digest string) (bool, error) {
+	deviceID, e := registry.hgetstr(key, defs.RedisDeviceTokenDeviceIDField)
+
+	if e == redis.ErrNil {
+		return false, nil
+	}
+
+	if e != nil {
+		return false, e
+	}
+
+	digests, e := registry.lrangestr(registry.genTokenListKey(deviceID), 0, -1)
+
+	if e != nil {
+		return false, e
+	}
+
+	for _, listed := range digests {
+		if listed == digest {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// repairIssue runs the fix for the issue right away when repairs were requested, marking the issue as repaired if it
+// succeeded.
+func (registry *RedisRegistry) repairIssue(issue ConsistencyIssue, repair bool, fix func() error) ConsistencyIssue {
+	if repair != true {
+		return issue
+	}
+
+	if e := fix(); e != nil {
+		registry.Warnf("unable to repair %s: %s", issue.Key, e.Error())
+		return issue
+	}
+
+	issue.Repaired = true
+	return issue
+}
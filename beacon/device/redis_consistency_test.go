@@ -0,0 +1,202 @@
+package device
+
+import "fmt"
+import "testing"
+import "github.com/franela/goblin"
+import "github.com/rafaeljusto/redigomock"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+
+func Test_RedisConsistency(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("CheckConsistency", func() {
+		r, mock := subject()
+
+		g.BeforeEach(mock.Clear)
+
+		g.AfterEach(func() {
+			g.Assert(mock.ExpectationsWereMet()).Equal(nil)
+		})
+
+		prefix := fmt.Sprintf("%s:*", defs.RedisDeviceTokenDigestKey)
+
+		scan := func() *redigomock.Cmd {
+			return mock.Command("SCAN", 0, "MATCH", prefix, "COUNT", defs.RedisConsistencyScanCount)
+		}
+
+		g.BeforeEach(func() {
+			mock.Command("LRANGE", defs.RedisDeviceIndexKey, 0, -1).ExpectSlice()
+			mock.Command("HGETALL", defs.RedisDeviceNameIndexKey).ExpectSlice()
+			mock.Command("HGETALL", defs.RedisRegistrationSecretIndexKey).ExpectSlice()
+			scan().Expect([]interface{}{[]byte("0"), []interface{}{}})
+		})
+
+		g.It("errors if unable to load the device index", func() {
+			mock.Command("LRANGE", defs.RedisDeviceIndexKey, 0, -1).ExpectError(fmt.Errorf("bad-index"))
+			_, e := r.CheckConsistency(false)
+			g.Assert(e.Error()).Equal("bad-index")
+		})
+
+		g.It("reports nothing when every key is consistent", func() {
+			issues, e := r.CheckConsistency(true)
+			g.Assert(e).Equal(nil)
+			g.Assert(len(issues)).Equal(0)
+		})
+
+		g.It("errors if the scan reply is malformed", func() {
+			scan().Expect([]interface{}{[]byte("0")})
+			_, e := r.CheckConsistency(false)
+			g.Assert(e.Error()).Equal(defs.ErrBadRedisResponse)
+		})
+
+		g.Describe("w/ a device indexed w/o details", func() {
+			g.BeforeEach(func() {
+				mock.Command("LRANGE", defs.RedisDeviceIndexKey, 0, -1).ExpectSlice([]byte("device-id"))
+				mock.Command("HGET", r.genRegistryKey("device-id"), defs.RedisDeviceNameField).Expect(nil)
+			})
+
+			g.It("only reports the device when not repairing", func() {
+				issues, e := r.CheckConsistency(false)
+				g.Assert(e).Equal(nil)
+				g.Assert(len(issues)).Equal(1)
+				g.Assert(issues[0].Key).Equal(r.genRegistryKey("device-id"))
+				g.Assert(issues[0].Repaired).Equal(false)
+			})
+
+			g.It("removes the device when repairing", func() {
+				mock.Command("LRANGE", r.genTokenListKey("device-id"), 0, -1).ExpectSlice([]byte("token-digest"))
+				mock.Command(
+					"EVALSHA",
+					redigomock.NewAnyData(),
+					7,
+					r.genRegistryKey("device-id"),
+					r.genFeedbackKey("device-id"),
+					r.genTokenListKey("device-id"),
+					defs.RedisDeviceIndexKey,
+					defs.RedisDeviceNameIndexKey,
+					r.genDeviceAccountsKey(""),
+					r.genTokenDigestKey("token-digest"),
+					"device-id",
+					"",
+					1,
+					0,
+				).Expect(int64(1))
+				issues, e := r.CheckConsistency(true)
+				g.Assert(e).Equal(nil)
+				g.Assert(len(issues)).Equal(1)
+				g.Assert(issues[0].Repaired).Equal(true)
+			})
+
+			g.It("leaves the issue unrepaired if the device could not be removed", func() {
+				mock.Command("EVALSHA").ExpectError(fmt.Errorf("bad-eval"))
+				issues, e := r.CheckConsistency(true)
+				g.Assert(e).Equal(nil)
+				g.Assert(issues[0].Repaired).Equal(false)
+			})
+		})
+
+		g.Describe("w/ an indexed device", func() {
+			listKey := r.genTokenListKey("device-id")
+
+			g.BeforeEach(func() {
+				mock.Command("LRANGE", defs.RedisDeviceIndexKey, 0, -1).ExpectSlice([]byte("device-id"))
+				mock.Command("HGET", r.genRegistryKey("device-id"), defs.RedisDeviceNameField).Expect([]byte("device-name"))
+				mock.Command("HGET", defs.RedisDeviceNameIndexKey, "device-name").Expect([]byte("device-id"))
+				mock.Command("LRANGE", listKey, 0, -1).ExpectSlice([]byte("listed-digest"))
+				mock.Command("EXISTS", r.genTokenDigestKey("listed-digest")).Expect(int64(1))
+			})
+
+			g.It("indexes the name of the device if it is missing from the name index", func() {
+				mock.Command("HGET", defs.RedisDeviceNameIndexKey, "device-name").Expect(nil)
+				mock.Command("HSET", defs.RedisDeviceNameIndexKey, "device-name", "device-id").Expect(int64(1))
+				issues, e := r.CheckConsistency(true)
+				g.Assert(e).Equal(nil)
+				g.Assert(len(issues)).Equal(1)
+				g.Assert(issues[0].Key).Equal(defs.RedisDeviceNameIndexKey)
+				g.Assert(issues[0].Repaired).Equal(true)
+			})
+
+			g.It("removes token list entries w/o token details", func() {
+				mock.Command("EXISTS", r.genTokenDigestKey("listed-digest")).Expect(int64(0))
+				mock.Command("LREM", listKey, 0, "listed-digest").Expect(int64(1))
+				issues, e := r.CheckConsistency(true)
+				g.Assert(e).Equal(nil)
+				g.Assert(len(issues)).Equal(1)
+				g.Assert(issues[0].Key).Equal(listKey)
+				g.Assert(issues[0].Repaired).Equal(true)
+			})
+
+			g.It("leaves token details that are in the token list of their device", func() {
+				tokenKey := r.genTokenDigestKey("listed-digest")
+				scan().Expect([]interface{}{[]byte("0"), []interface{}{[]byte(tokenKey)}})
+				mock.Command("HGET", tokenKey, defs.RedisDeviceTokenDeviceIDField).Expect([]byte("device-id"))
+				issues, e := r.CheckConsistency(true)
+				g.Assert(e).Equal(nil)
+				g.Assert(len(issues)).Equal(0)
+			})
+
+			g.It("deletes token details that are not in the token list of their device", func() {
+				tokenKey := r.genTokenDigestKey("unlisted-digest")
+				scan().Expect([]interface{}{[]byte("0"), []interface{}{[]byte(tokenKey)}})
+				mock.Command("HGET", tokenKey, defs.RedisDeviceTokenDeviceIDField).Expect([]byte("device-id"))
+				mock.Command("DEL", tokenKey).Expect(int64(1))
+				issues, e := r.CheckConsistency(true)
+				g.Assert(e).Equal(nil)
+				g.Assert(len(issues)).Equal(1)
+				g.Assert(issues[0].Key).Equal(tokenKey)
+				g.Assert(issues[0].Repaired).Equal(true)
+			})
+		})
+
+		g.It("continues scanning until the cursor returns to zero", func() {
+			first, second := r.genTokenDigestKey("first-digest"), r.genTokenDigestKey("second-digest")
+			scan().Expect([]interface{}{[]byte("12"), []interface{}{[]byte(first)}})
+			mock.Command("SCAN", 12, "MATCH", prefix, "COUNT", defs.RedisConsistencyScanCount).Expect(
+				[]interface{}{[]byte("0"), []interface{}{[]byte(second)}},
+			)
+			mock.Command("HGET", first, defs.RedisDeviceTokenDeviceIDField).Expect(nil)
+			mock.Command("HGET", second, defs.RedisDeviceTokenDeviceIDField).Expect(nil)
+			issues, e := r.CheckConsistency(false)
+			g.Assert(e).Equal(nil)
+			g.Assert(len(issues)).Equal(2)
+		})
+
+		g.It("unindexes names whose device no longer exists", func() {
+			mock.Command("HGETALL", defs.RedisDeviceNameIndexKey).ExpectSlice([]byte("device-name"), []byte("device-id"))
+			mock.Command("HGET", r.genRegistryKey("device-id"), defs.RedisDeviceNameField).Expect(nil)
+			mock.Command(
+				"EVALSHA",
+				redigomock.NewAnyData(),
+				1,
+				defs.RedisDeviceNameIndexKey,
+				"device-name",
+				"device-id",
+			).Expect(int64(1))
+			issues, e := r.CheckConsistency(true)
+			g.Assert(e).Equal(nil)
+			g.Assert(len(issues)).Equal(1)
+			g.Assert(issues[0].Repaired).Equal(true)
+		})
+
+		g.It("unindexes registration secrets whose request no longer exists", func() {
+			mock.Command("HGETALL", defs.RedisRegistrationSecretIndexKey).ExpectSlice(
+				[]byte("secret-digest"),
+				[]byte("request-id"),
+			)
+			mock.Command("EXISTS", r.genAllocationKey("request-id")).Expect(int64(0))
+			mock.Command(
+				"EVALSHA",
+				redigomock.NewAnyData(),
+				1,
+				defs.RedisRegistrationSecretIndexKey,
+				"secret-digest",
+				"request-id",
+			).Expect(int64(1))
+			issues, e := r.CheckConsistency(true)
+			g.Assert(e).Equal(nil)
+			g.Assert(len(issues)).Equal(1)
+			g.Assert(issues[0].Key).Equal(defs.RedisRegistrationSecretIndexKey)
+			g.Assert(issues[0].Repaired).Equal(true)
+		})
+	})
+}
@@ -0,0 +1,278 @@
+package device
+
+import "fmt"
+import "time"
+import "strconv"
+import "strings"
+import "github.com/garyburd/redigo/redis"
+
+import "github.com/dadleyy/beacon.api/beacon/defs"
+
+// redisMigration is a change to the layout of the data stored in redis, applied once per redis server.
+type redisMigration struct {
+	name string
+	run  func(*RedisRegistry) error
+}
+
+// redisMigrations is the ordered list of migrations applied by Migrate.
+var redisMigrations = []redisMigration{
+	{"token-digests", (*RedisRegistry).migrateTokenDigests},
+	{"device-indexes", (*RedisRegistry).migrateDeviceIndexes},
+	{"group-token-digests", (*RedisRegistry).migrateGroupTokenDigests},
+	{"device-name-presets", (*RedisRegistry).migrateDevicePresets},
+	{"account-device-names", (*RedisRegistry).migrateAccountDeviceNames},
+}
+
+// Migrate applies every migration that has not yet been applied to the redis server. Each migration is claimed before
+// it runs so api nodes starting at the same time do not apply it twice; failed migrations are released so they are
+// attempted again the next time the api starts.
+func (registry *RedisRegistry) Migrate() error {
+	for _, migration := range redisMigrations {
+		applied := strconv.FormatInt(time.Now().Unix(), 10)
+		claimed, e := redis.Int(registry.Do("HSETNX", defs.RedisMigrationsKey, migration.name, applied))
+
+		if e != nil {
+			return e
+		}
+
+		if claimed == 0 {
+			continue
+		}
+
+		registry.Infof("applying migration %s", migration.name)
+
+		if e := migration.run(registry); e != nil {
+			registry.Errorf("unable to apply migration %s: %s", migration.name, e.Error())
+			registry.Do("HDEL", defs.RedisMigrationsKey, migration.name)
+			return e
+		}
+	}
+
+	return nil
+}
+
+// migrateTokenDigests moves the tokens of every registered device from keys containing the raw token to keys
+// containing its salted digest, replacing the legacy token list w/ a list of digests.
+func (registry *RedisRegistry) migrateTokenDigests() error {
+	ids, e := registry.lrangestr(defs.RedisDeviceIndexKey, 0, -1)
+
+	if e != nil {
+		return e
+	}
+
+	for _, id := range ids {
+		legacyListKey := fmt.Sprintf("%s:%s", defs.RedisDeviceTokenListKey, id)
+
+		tokens, e := registry.lrangestr(legacyListKey, 0, -1)
+
+		if e != nil {
+			return e
+		}
+
+		for _, token := range tokens {
+			legacyKey, digest := fmt.Sprintf("%s:%s", defs.RedisDeviceTokenRegistrationKey, token), registry.tokenDigest(token)
+
+			if _, e := registry.Do("RENAME", legacyKey, registry.genTokenDigestKey(digest)); e != nil {
+				registry.Warnf("unable to migrate token of device[%s]: %s", id, e.Error())
+				continue
+			}
+
+			if _, e := registry.Do("RPUSH", registry.genTokenListKey(id), digest); e != nil {
+				return e
+			}
+		}
+
+		if e := registry.del(legacyListKey); e != nil {
+			return e
+		}
+
+		registry.Infof("migrated %d tokens of device[%s]", len(tokens), id)
+	}
+
+	return nil
+}
+
+// migrateDeviceIndexes builds the device name index from the device index and the registration secret index from the
+// pending registration requests. This is the only place pending requests are found w/ a KEYS scan.
+func (registry *RedisRegistry) migrateDeviceIndexes() error {
+	ids, e := registry.lrangestr(defs.RedisDeviceIndexKey, 0, -1)
+
+	if e != nil {
+		return e
+	}
+
+	for _, id := range ids {
+		name, e := registry.hgetstr(registry.genRegistryKey(id), defs.RedisDeviceNameField)
+
+		if e != nil {
+			registry.Warnf("unable to index name of device[%s]: %s", id, e.Error())
+			continue
+		}
+
+		if e := registry.hset(defs.RedisDeviceNameIndexKey, name, id); e != nil {
+			return e
+		}
+	}
+
+	prefix := fmt.Sprintf("%s:", defs.RedisRegistrationRequestListKey)
+	requestKeys, e := redis.Strings(registry.Do("KEYS", fmt.Sprintf("%s*", prefix)))
+
+	if e != nil {
+		return e
+	}
+
+	for _, requestKey := range requestKeys {
+		secret, e := registry.hgetstr(requestKey, defs.RedisRegistrationSecretField)
+
+		if e != nil {
+			registry.Warnf("unable to index registration request[%s]: %s", requestKey, e.Error())
+			continue
+		}
+
+		requestID := strings.TrimPrefix(requestKey, prefix)
+
+		if e := registry.hset(defs.RedisRegistrationSecretIndexKey, registry.tokenDigest(secret), requestID); e != nil {
+			return e
+		}
+	}
+
+	registry.Infof("indexed %d devices and %d registration requests", len(ids), len(requestKeys))
+
+	return nil
+}
+
+// migrateGroupTokenDigests replaces the raw token of every device group w/ its salted digest.
+func (registry *RedisRegistry) migrateGroupTokenDigests() error {
+	ids, e := registry.lrangestr(defs.RedisDeviceGroupIndexKey, 0, -1)
+
+	if e != nil {
+		return e
+	}
+
+	migrated := 0
+
+	for _, id := range ids {
+		groupKey := registry.genGroupKey(id)
+		token, e := registry.hgetstr(groupKey, defs.RedisDeviceGroupTokenField)
+
+		if e == redis.ErrNil {
+			continue
+		}
+
+		if e != nil {
+			return e
+		}
+
+		if e := registry.hset(groupKey, defs.RedisDeviceGroupTokenDigestField, registry.tokenDigest(token)); e != nil {
+			return e
+		}
+
+		if _, e := registry.Do("HDEL", groupKey, defs.RedisDeviceGroupTokenField); e != nil {
+			return e
+		}
+
+		migrated++
+	}
+
+	registry.Infof("migrated the tokens of %d device groups", migrated)
+
+	return nil
+}
+
+// migrateDevicePresets moves the presets saved for every registered device from the hash keyed by the device id to the
+// hash keyed by its name. Presets already saved under the name are kept.
+func (registry *RedisRegistry) migrateDevicePresets() error {
+	ids, e := registry.lrangestr(defs.RedisDeviceIndexKey, 0, -1)
+
+	if e != nil {
+		return e
+	}
+
+	migrated := 0
+
+	for _, id := range ids {
+		name, e := registry.hgetstr(registry.genRegistryKey(id), defs.RedisDeviceNameField)
+
+		if e != nil {
+			registry.Warnf("unable to migrate presets of device[%s]: %s", id, e.Error())
+			continue
+		}
+
+		legacyKey := registry.genPresetListKey(id)
+		entries, e := redis.StringMap(registry.Do("HGETALL", legacyKey))
+
+		if e != nil {
+			return e
+		}
+
+		for preset, entry := range entries {
+			if _, e := registry.Do("HSETNX", registry.genPresetListKey(name), preset, entry); e != nil {
+				return e
+			}
+		}
+
+		if e := registry.del(legacyKey); e != nil {
+			return e
+		}
+
+		migrated += len(entries)
+	}
+
+	registry.Infof("migrated %d device presets", migrated)
+
+	return nil
+}
+
+// migrateAccountDeviceNames moves the permissions granted to every account from fields keyed by device id to fields
+// keyed by the device name, adding the account to the accounts set of the name. Grants of devices that are no longer
+// registered are dropped. Accounts are not indexed, so their permission hashes are found w/ a KEYS scan.
+func (registry *RedisRegistry) migrateAccountDeviceNames() error {
+	prefix := fmt.Sprintf("%s:", defs.RedisAccountPermissionsKey)
+	permissionKeys, e := redis.Strings(registry.Do("KEYS", fmt.Sprintf("%s*", prefix)))
+
+	if e != nil {
+		return e
+	}
+
+	migrated := 0
+
+	for _, permissionKey := range permissionKeys {
+		accountID := strings.TrimPrefix(permissionKey, prefix)
+		masks, e := redis.StringMap(registry.Do("HGETALL", permissionKey))
+
+		if e != nil {
+			return e
+		}
+
+		for deviceID, mask := range masks {
+			name, e := registry.hgetstr(registry.genRegistryKey(deviceID), defs.RedisDeviceNameField)
+
+			if e != nil && e != redis.ErrNil {
+				return e
+			}
+
+			if _, e := registry.Do("HDEL", permissionKey, deviceID); e != nil {
+				return e
+			}
+
+			if name == "" {
+				registry.Warnf("dropping grant of account[%s] for unregistered device[%s]", accountID, deviceID)
+				continue
+			}
+
+			if _, e := registry.Do("HSETNX", permissionKey, name, mask); e != nil {
+				return e
+			}
+
+			if _, e := registry.Do("SADD", registry.genDeviceAccountsKey(name), accountID); e != nil {
+				return e
+			}
+
+			migrated++
+		}
+	}
+
+	registry.Infof("migrated %d account grants", migrated)
+
+	return nil
+}
@@ -0,0 +1,221 @@
+package device
+
+import "fmt"
+import "testing"
+import "github.com/franela/goblin"
+import "github.com/rafaeljusto/redigomock"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+
+func Test_RedisMigrations(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Migrate", func() {
+		r, mock := subject()
+
+		g.BeforeEach(mock.Clear)
+
+		g.AfterEach(func() {
+			g.Assert(mock.ExpectationsWereMet()).Equal(nil)
+		})
+
+		claim := func(name string) *redigomock.Cmd {
+			return mock.Command("HSETNX", defs.RedisMigrationsKey, name, redigomock.NewAnyData())
+		}
+
+		g.BeforeEach(func() {
+			claim("device-indexes").Expect(int64(0))
+			claim("group-token-digests").Expect(int64(0))
+			claim("device-name-presets").Expect(int64(0))
+			claim("account-device-names").Expect(int64(0))
+		})
+
+		g.It("errors if unable to claim a migration", func() {
+			claim("token-digests").ExpectError(fmt.Errorf("bad-claim"))
+			g.Assert(r.Migrate().Error()).Equal("bad-claim")
+		})
+
+		g.It("skips migrations that have already been claimed", func() {
+			claim("token-digests").Expect(int64(0))
+			g.Assert(r.Migrate()).Equal(nil)
+		})
+
+		g.Describe("having claimed the token digest migration", func() {
+			g.BeforeEach(func() {
+				claim("token-digests").Expect(int64(1))
+			})
+
+			g.It("releases the claim if the migration fails", func() {
+				mock.Command("LRANGE", defs.RedisDeviceIndexKey, 0, -1).ExpectError(fmt.Errorf("bad-range"))
+				mock.Command("HDEL", defs.RedisMigrationsKey, "token-digests").Expect(int64(1))
+				g.Assert(r.Migrate().Error()).Equal("bad-range")
+			})
+
+			g.Describe("w/ a device that has tokens stored by raw value", func() {
+				legacyListKey := fmt.Sprintf("%s:%s", defs.RedisDeviceTokenListKey, "device-id")
+
+				g.BeforeEach(func() {
+					mock.Command("LRANGE", defs.RedisDeviceIndexKey, 0, -1).ExpectSlice([]byte("device-id"))
+					mock.Command("LRANGE", legacyListKey, 0, -1).ExpectSlice([]byte("first-token"), []byte("second-token"))
+				})
+
+				g.It("moves each token to the key of its digest", func() {
+					first, second := r.tokenDigest("first-token"), r.tokenDigest("second-token")
+					legacyFirst := fmt.Sprintf("%s:%s", defs.RedisDeviceTokenRegistrationKey, "first-token")
+					legacySecond := fmt.Sprintf("%s:%s", defs.RedisDeviceTokenRegistrationKey, "second-token")
+					mock.Command("RENAME", legacyFirst, r.genTokenDigestKey(first)).Expect("OK")
+					mock.Command("RENAME", legacySecond, r.genTokenDigestKey(second)).Expect("OK")
+					mock.Command("RPUSH", r.genTokenListKey("device-id"), first).Expect(int64(1))
+					mock.Command("RPUSH", r.genTokenListKey("device-id"), second).Expect(int64(2))
+					mock.Command("DEL", legacyListKey).Expect(int64(1))
+					g.Assert(r.Migrate()).Equal(nil)
+					g.Assert(r.genTokenRegistrationKey("first-token")).Equal(r.genTokenDigestKey(first))
+				})
+
+				g.It("skips tokens whose details are missing", func() {
+					second := r.tokenDigest("second-token")
+					legacyFirst := fmt.Sprintf("%s:%s", defs.RedisDeviceTokenRegistrationKey, "first-token")
+					legacySecond := fmt.Sprintf("%s:%s", defs.RedisDeviceTokenRegistrationKey, "second-token")
+					mock.Command("RENAME", legacyFirst, redigomock.NewAnyData()).ExpectError(fmt.Errorf("no such key"))
+					mock.Command("RENAME", legacySecond, r.genTokenDigestKey(second)).Expect("OK")
+					mock.Command("RPUSH", r.genTokenListKey("device-id"), second).Expect(int64(1))
+					mock.Command("DEL", legacyListKey).Expect(int64(1))
+					g.Assert(r.Migrate()).Equal(nil)
+				})
+
+				g.It("errors w/o removing the legacy list if unable to push into the digest list", func() {
+					mock.Command("RENAME").Expect("OK")
+					mock.Command("RPUSH").ExpectError(fmt.Errorf("bad-push"))
+					mock.Command("HDEL", defs.RedisMigrationsKey, "token-digests").Expect(int64(1))
+					g.Assert(r.Migrate().Error()).Equal("bad-push")
+				})
+			})
+		})
+
+		g.Describe("having claimed the device index migration", func() {
+			requestKey := r.genAllocationKey("request-id")
+
+			g.BeforeEach(func() {
+				claim("token-digests").Expect(int64(0))
+				claim("device-indexes").Expect(int64(1))
+			})
+
+			g.It("indexes the names of devices and the secrets of pending registrations", func() {
+				mock.Command("LRANGE", defs.RedisDeviceIndexKey, 0, -1).ExpectSlice([]byte("device-id"))
+				mock.Command("HGET", r.genRegistryKey("device-id"), defs.RedisDeviceNameField).Expect([]byte("device-name"))
+				mock.Command("HSET", defs.RedisDeviceNameIndexKey, "device-name", "device-id").Expect(int64(1))
+				mock.Command("KEYS", fmt.Sprintf("%s:*", defs.RedisRegistrationRequestListKey)).ExpectSlice([]byte(requestKey))
+				mock.Command("HGET", requestKey, defs.RedisRegistrationSecretField).Expect([]byte("request-secret"))
+				mock.Command(
+					"HSET",
+					defs.RedisRegistrationSecretIndexKey,
+					r.tokenDigest("request-secret"),
+					"request-id",
+				).Expect(int64(1))
+				g.Assert(r.Migrate()).Equal(nil)
+			})
+
+			g.It("releases the claim if unable to find the pending registrations", func() {
+				mock.Command("LRANGE", defs.RedisDeviceIndexKey, 0, -1).ExpectSlice()
+				mock.Command("KEYS").ExpectError(fmt.Errorf("bad-keys"))
+				mock.Command("HDEL", defs.RedisMigrationsKey, "device-indexes").Expect(int64(1))
+				g.Assert(r.Migrate().Error()).Equal("bad-keys")
+			})
+		})
+
+		g.Describe("having claimed the group token digest migration", func() {
+			groupKey := r.genGroupKey("group-id")
+
+			g.BeforeEach(func() {
+				claim("token-digests").Expect(int64(0))
+				claim("group-token-digests").Expect(int64(1))
+				mock.Command("LRANGE", defs.RedisDeviceGroupIndexKey, 0, -1).ExpectSlice([]byte("group-id"))
+			})
+
+			g.It("replaces the raw group token w/ its digest", func() {
+				mock.Command("HGET", groupKey, defs.RedisDeviceGroupTokenField).Expect([]byte("group-token"))
+				mock.Command(
+					"HSET",
+					groupKey,
+					defs.RedisDeviceGroupTokenDigestField,
+					r.tokenDigest("group-token"),
+				).Expect(int64(1))
+				mock.Command("HDEL", groupKey, defs.RedisDeviceGroupTokenField).Expect(int64(1))
+				g.Assert(r.Migrate()).Equal(nil)
+			})
+
+			g.It("skips groups that have already been migrated", func() {
+				mock.Command("HGET", groupKey, defs.RedisDeviceGroupTokenField).Expect(nil)
+				g.Assert(r.Migrate()).Equal(nil)
+			})
+
+			g.It("releases the claim if unable to store the digest", func() {
+				mock.Command("HGET", groupKey, defs.RedisDeviceGroupTokenField).Expect([]byte("group-token"))
+				mock.Command("HSET").ExpectError(fmt.Errorf("bad-set"))
+				mock.Command("HDEL", defs.RedisMigrationsKey, "group-token-digests").Expect(int64(1))
+				g.Assert(r.Migrate().Error()).Equal("bad-set")
+			})
+		})
+
+		g.Describe("having claimed the device preset migration", func() {
+			legacyKey, presetKey := r.genPresetListKey("device-id"), r.genPresetListKey("device-name")
+
+			g.BeforeEach(func() {
+				claim("token-digests").Expect(int64(0))
+				claim("device-name-presets").Expect(int64(1))
+				mock.Command("LRANGE", defs.RedisDeviceIndexKey, 0, -1).ExpectSlice([]byte("device-id"))
+				mock.Command("HGET", r.genRegistryKey("device-id"), defs.RedisDeviceNameField).Expect([]byte("device-name"))
+			})
+
+			g.It("moves the presets of the device to the hash keyed by its name", func() {
+				mock.Command("HGETALL", legacyKey).ExpectSlice([]byte("deploy"), []byte("Frames: <Red: 255>"))
+				mock.Command("HSETNX", presetKey, "deploy", "Frames: <Red: 255>").Expect(int64(1))
+				mock.Command("DEL", legacyKey).Expect(int64(1))
+				g.Assert(r.Migrate()).Equal(nil)
+			})
+
+			g.It("skips devices w/o details", func() {
+				mock.Command("HGET", r.genRegistryKey("device-id"), defs.RedisDeviceNameField).Expect(nil)
+				g.Assert(r.Migrate()).Equal(nil)
+			})
+
+			g.It("releases the claim if unable to load the presets of the device", func() {
+				mock.Command("HGETALL", legacyKey).ExpectError(fmt.Errorf("bad-hgetall"))
+				mock.Command("HDEL", defs.RedisMigrationsKey, "device-name-presets").Expect(int64(1))
+				g.Assert(r.Migrate().Error()).Equal("bad-hgetall")
+			})
+		})
+
+		g.Describe("having claimed the account device name migration", func() {
+			permissionsKey := r.genAccountPermissionsKey("account-id")
+
+			g.BeforeEach(func() {
+				claim("token-digests").Expect(int64(0))
+				claim("account-device-names").Expect(int64(1))
+				pattern := fmt.Sprintf("%s:*", defs.RedisAccountPermissionsKey)
+				mock.Command("KEYS", pattern).ExpectSlice([]byte(permissionsKey))
+				mock.Command("HGETALL", permissionsKey).ExpectSlice([]byte("device-id"), []byte("11"))
+				mock.Command("HDEL", permissionsKey, "device-id").Expect(int64(1))
+			})
+
+			g.It("moves the grants of the account to the field of the device name", func() {
+				mock.Command("HGET", r.genRegistryKey("device-id"), defs.RedisDeviceNameField).Expect([]byte("device-name"))
+				mock.Command("HSETNX", permissionsKey, "device-name", "11").Expect(int64(1))
+				mock.Command("SADD", r.genDeviceAccountsKey("device-name"), "account-id").Expect(int64(1))
+				g.Assert(r.Migrate()).Equal(nil)
+			})
+
+			g.It("drops the grants of devices that are no longer registered", func() {
+				mock.Command("HGET", r.genRegistryKey("device-id"), defs.RedisDeviceNameField).Expect(nil)
+				g.Assert(r.Migrate()).Equal(nil)
+			})
+
+			g.It("releases the claim if unable to index the account under the device name", func() {
+				mock.Command("HGET", r.genRegistryKey("device-id"), defs.RedisDeviceNameField).Expect([]byte("device-name"))
+				mock.Command("HSETNX", permissionsKey, "device-name", "11").Expect(int64(1))
+				mock.Command("SADD", r.genDeviceAccountsKey("device-name"), "account-id").ExpectError(fmt.Errorf("bad-add"))
+				mock.Command("HDEL", defs.RedisMigrationsKey, "account-device-names").Expect(int64(1))
+				g.Assert(r.Migrate().Error()).Equal("bad-add")
+			})
+		})
+	})
+}
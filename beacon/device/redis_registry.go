@@ -1,8 +1,11 @@
 package device
 
 import "fmt"
+import "time"
 import "bytes"
 import "strconv"
+import "crypto/subtle"
+import "encoding/json"
 import "github.com/satori/go.uuid"
 import "github.com/garyburd/redigo/redis"
 import "github.com/golang/protobuf/proto"
@@ -11,14 +14,34 @@ import "github.com/dadleyy/beacon.api/beacon/defs"
 import "github.com/dadleyy/beacon.api/beacon/logging"
 import "github.com/dadleyy/beacon.api/beacon/interchange"
 
-// RedisRegistry implements the `Registry` interface w/ a redis backend
+// RedisRegistry implements the `Registry` interface w/ a redis backend. Device tokens are only stored as digests salted
+// w/ the TokenSalt, which must be the same for every api node sharing the redis server. When LegacySecretAuth is set,
+// devices registered before owner tokens were issued still accept their shared secret in place of a user token.
 type RedisRegistry struct {
 	*logging.Logger
 	*redis.Pool
 	TokenGenerator
+	TokenSalt        string
+	LegacySecretAuth bool
 }
 
-// FindDevice searches the registry based on a query string for the first matching device id
+// SharedTokenSalt returns the token salt stored in redis, generating one if no api node has done so yet. This is used
+// when no salt was provided in the api configuration.
+func (registry *RedisRegistry) SharedTokenSalt() (string, error) {
+	salt, e := registry.GenerateToken()
+
+	if e != nil {
+		return "", e
+	}
+
+	if _, e := registry.Do("SET", defs.RedisTokenSaltKey, salt, "NX"); e != nil {
+		return "", e
+	}
+
+	return redis.String(registry.Do("GET", defs.RedisTokenSaltKey))
+}
+
+// FindDevice searches the registry for the device whose id matches the query, falling back to the device name index.
 func (registry *RedisRegistry) FindDevice(query string) (RegistrationDetails, error) {
 	registryKey := registry.genRegistryKey(query)
 
@@ -32,33 +55,18 @@ func (registry *RedisRegistry) FindDevice(query string) (RegistrationDetails, er
 		return registry.loadDetails(registryKey)
 	}
 
-	response, e := registry.Do("KEYS", fmt.Sprintf("%s*", defs.RedisDeviceRegistryKey))
+	id, e := registry.hgetstr(defs.RedisDeviceNameIndexKey, query)
 
-	if e != nil {
-		return RegistrationDetails{}, e
+	if e == redis.ErrNil {
+		registry.Warnf("did not find matching device: %s", query)
+		return RegistrationDetails{}, fmt.Errorf(defs.ErrNotFound)
 	}
 
-	registryKeys, e := redis.Strings(response, e)
-
 	if e != nil {
 		return RegistrationDetails{}, e
 	}
 
-	for _, k := range registryKeys {
-		fields, e := registry.hmgetstr(k, defs.RedisDeviceNameField, defs.RedisDeviceIDField, defs.RedisDeviceSecretField)
-
-		if e != nil {
-			return RegistrationDetails{}, e
-		}
-
-		if fields[0] == query || fields[1] == query {
-			d := RegistrationDetails{SharedSecret: fields[2], DeviceID: fields[1], Name: fields[0]}
-			return d, nil
-		}
-	}
-
-	registry.Warnf("did not find matching device: %s", query)
-	return RegistrationDetails{}, fmt.Errorf(defs.ErrNotFound)
+	return registry.loadDetails(registry.genRegistryKey(id))
 }
 
 // ListFeedback retrieves the latest feedback for a given device id.
@@ -87,7 +95,7 @@ func (registry *RedisRegistry) ListFeedback(id string, count int) ([]interchange
 		message := interchange.FeedbackMessage{}
 
 		if e := proto.UnmarshalText(entry, &message); e != nil {
-			registry.Warnf("invalid feedback item device[%d]: %s", feedbackKey, e.Error())
+			registry.Warnf("invalid feedback item device[%s]: %s", feedbackKey, e.Error())
 			return nil, fmt.Errorf(defs.ErrBadInterchangeData)
 		}
 
@@ -98,7 +106,7 @@ func (registry *RedisRegistry) ListFeedback(id string, count int) ([]interchange
 	return results, nil
 }
 
-// LogFeedback inserts a feedback item into the redis store.
+// LogFeedback inserts a feedback item into the redis store, stamped w/ the time it was received.
 func (registry *RedisRegistry) LogFeedback(message interchange.FeedbackMessage) error {
 	auth := message.GetAuthentication()
 
@@ -129,6 +137,8 @@ func (registry *RedisRegistry) LogFeedback(message interchange.FeedbackMessage)
 		}
 	}
 
+	message.ReceivedAt = time.Now().Unix()
+
 	if e := proto.MarshalText(textBuffer, &message); e != nil {
 		return e
 	}
@@ -142,54 +152,58 @@ func (registry *RedisRegistry) LogFeedback(message interchange.FeedbackMessage)
 	return nil
 }
 
-// AllocateRegistration reserves a spot in the registry to be filled later
-func (registry *RedisRegistry) AllocateRegistration(details RegistrationRequest) error {
+// AllocateRegistration reserves a spot in the registry to be filled later, returning the owner token of the device.
+// Only the digest of the owner token is stored; it is carried over to the device when the registration is filled. The
+// request is indexed by the digest of its shared secret so it can be filled w/o scanning every pending request.
+func (registry *RedisRegistry) AllocateRegistration(details RegistrationRequest) (string, error) {
 	allocationID := uuid.NewV4().String()
 	registryKey := registry.genAllocationKey(allocationID)
 
 	if len(details.Name) < 4 || len(details.SharedSecret) < defs.SecurityMinimumDeviceSharedSecretSize {
-		return fmt.Errorf(defs.ErrInvalidRegistrationRequest)
+		return "", fmt.Errorf(defs.ErrInvalidRegistrationRequest)
 	}
 
-	nameField, secretField := defs.RedisRegistrationNameField, defs.RedisRegistrationSecretField
-
-	return registry.hmset(registryKey, nameField, details.Name, secretField, details.SharedSecret)
-}
-
-// FillRegistration searches the pending registrations and adds the new uuid to the index
-func (registry *RedisRegistry) FillRegistration(secret, uuid string) error {
-	response, e := registry.Do("KEYS", fmt.Sprintf("%s*", defs.RedisRegistrationRequestListKey))
+	owner, e := registry.GenerateToken()
 
 	if e != nil {
-		return e
+		return "", e
 	}
 
-	requestKeys, e := redis.Strings(response, e)
-
-	if e != nil {
-		return fmt.Errorf(defs.ErrBadRedisResponse)
+	f := struct {
+		name   string
+		secret string
+		owner  string
+	}{defs.RedisRegistrationNameField, defs.RedisRegistrationSecretField, defs.RedisRegistrationOwnerField}
+
+	args := []interface{}{
+		registryKey, defs.RedisRegistrationSecretIndexKey,
+		allocationID, registry.tokenDigest(details.SharedSecret),
+		f.name, details.Name,
+		f.secret, details.SharedSecret,
+		f.owner, registry.tokenDigest(owner),
 	}
 
-	for _, k := range requestKeys {
-		response, e := registry.Do("HGET", k, defs.RedisRegistrationSecretField)
+	if _, e := registry.eval(allocateRegistrationScript, args...); e != nil {
+		return "", e
+	}
 
-		if e != nil {
-			continue
-		}
+	return owner, nil
+}
 
-		s, e := redis.String(response, e)
+// FillRegistration looks up the pending registration by the digest of its secret and adds the new uuid to the index
+func (registry *RedisRegistry) FillRegistration(secret, uuid string) error {
+	requestID, e := registry.hgetstr(defs.RedisRegistrationSecretIndexKey, registry.tokenDigest(secret))
 
-		if e != nil {
-			continue
-		}
+	if e == redis.ErrNil {
+		return fmt.Errorf(defs.ErrNotFound)
+	}
 
-		if s == secret {
-			registry.Debugf("found matching secret for device[%s], filling", uuid)
-			return registry.fill(k, uuid)
-		}
+	if e != nil {
+		return e
 	}
 
-	return fmt.Errorf(defs.ErrNotFound)
+	registry.Debugf("found matching secret for device[%s], filling", uuid)
+	return registry.fill(registry.genAllocationKey(requestID), uuid)
 }
 
 // ListTokens searches the token store for the token details given the token key.
@@ -222,8 +236,8 @@ func (registry *RedisRegistry) ListTokens(query string) ([]TokenDetails, error)
 		defs.RedisDeviceTokenPermissionField,
 	}
 
-	for _, tokenValue := range tokenEntries {
-		registryKey := registry.genTokenRegistrationKey(tokenValue)
+	for _, digest := range tokenEntries {
+		registryKey := registry.genTokenDigestKey(digest)
 		details, e := registry.hmgetstr(registryKey, fields.id, fields.name, fields.device, fields.permission)
 
 		if e != nil {
@@ -236,11 +250,18 @@ func (registry *RedisRegistry) ListTokens(query string) ([]TokenDetails, error)
 			continue
 		}
 
+		expiresAt, e := registry.loadTokenExpiry(registryKey)
+
+		if e != nil {
+			continue
+		}
+
 		results = append(results, TokenDetails{
 			TokenID:    details[0],
 			Name:       details[1],
 			DeviceID:   details[2],
 			Permission: uint(permission),
+			ExpiresAt:  expiresAt,
 		})
 	}
 
@@ -255,14 +276,14 @@ func (registry *RedisRegistry) FindToken(token string) (TokenDetails, error) {
 	permissionMask, e := registry.hgetstr(registryKey, defs.RedisDeviceTokenPermissionField)
 
 	if e != nil {
-		registry.Errorf("unable to find token by registry key %s (token: %s)", registryKey, token)
+		registry.Errorf("unable to find token by registry key %s", registryKey)
 		return TokenDetails{}, e
 	}
 
 	permission, e := strconv.ParseUint(permissionMask, 2, 32)
 
 	if e != nil {
-		registry.Errorf("invalid token permission mask %s (token: %s)", registryKey, token)
+		registry.Errorf("invalid token permission mask %s", registryKey)
 		return TokenDetails{}, e
 	}
 
@@ -275,7 +296,14 @@ func (registry *RedisRegistry) FindToken(token string) (TokenDetails, error) {
 	r, e := registry.hmgetstr(registryKey, fields.id, fields.name, fields.device)
 
 	if e != nil {
-		registry.Errorf("unable to find token details by registry key %s (token: %s)", registryKey, token)
+		registry.Errorf("unable to find token details by registry key %s", registryKey)
+		return TokenDetails{}, e
+	}
+
+	expiresAt, e := registry.loadTokenExpiry(registryKey)
+
+	if e != nil {
+		registry.Errorf("unable to load token expiry by registry key %s", registryKey)
 		return TokenDetails{}, e
 	}
 
@@ -284,12 +312,14 @@ func (registry *RedisRegistry) FindToken(token string) (TokenDetails, error) {
 		TokenID:    r[0],
 		Name:       r[1],
 		DeviceID:   r[2],
+		ExpiresAt:  expiresAt,
 	}
 
 	return details, nil
 }
 
-// AuthorizeToken approves the token + permission for the given device id
+// AuthorizeToken approves the token + permission for the given device id. The owner token of the device is approved
+// for every permission.
 func (registry *RedisRegistry) AuthorizeToken(deviceID, token string, permission uint) bool {
 	registration, e := registry.FindDevice(deviceID)
 
@@ -297,14 +327,32 @@ func (registry *RedisRegistry) AuthorizeToken(deviceID, token string, permission
 		return false
 	}
 
-	if token == registration.SharedSecret {
+	owner, e := registry.hgetstr(registry.genRegistryKey(registration.DeviceID), defs.RedisDeviceOwnerField)
+
+	if e == nil && owner != "" && subtle.ConstantTimeCompare([]byte(owner), []byte(registry.tokenDigest(token))) == 1 {
+		return true
+	}
+
+	legacy := registry.LegacySecretAuth && (e != nil || owner == "")
+
+	if legacy && subtle.ConstantTimeCompare([]byte(token), []byte(registration.SharedSecret)) == 1 {
+		registry.Warnf("authorized device[%s] shared secret w/o an owner token (legacy)", registration.DeviceID)
 		return true
 	}
 
 	requester, e := registry.FindToken(token)
 
 	if e != nil {
-		registry.Errorf("unable to find token: %s", e.Error())
+		return registry.authorizeAccount(registration.Name, token, permission)
+	}
+
+	if requester.DeviceID != registration.DeviceID {
+		registry.Warnf("rejecting token[%s] of device[%s] for device[%s]", requester.TokenID, requester.DeviceID, deviceID)
+		return false
+	}
+
+	if requester.Expired(time.Now()) {
+		registry.Warnf("rejecting expired token: %s (expired: %v)", requester.TokenID, requester.ExpiresAt)
 		return false
 	}
 
@@ -313,8 +361,13 @@ func (registry *RedisRegistry) AuthorizeToken(deviceID, token string, permission
 	return requester.Permission&permission == permission
 }
 
-// CreateToken creates a new auth token for a given device id
-func (registry *RedisRegistry) CreateToken(deviceID, tokenName string, permission uint) (TokenDetails, error) {
+// CreateToken creates a new auth token for a given device id. Tokens created w/ an expiry are no longer authorized once
+// that time has passed. The token details and its token list entry are written together by a single script.
+func (registry *RedisRegistry) CreateToken(
+	deviceID, tokenName string,
+	permission uint,
+	expiresAt *time.Time,
+) (TokenDetails, error) {
 	listKey := registry.genTokenListKey(deviceID)
 	empty, permissionMask, tokenID := TokenDetails{}, fmt.Sprintf("%b", permission), uuid.NewV4().String()
 
@@ -328,11 +381,8 @@ func (registry *RedisRegistry) CreateToken(deviceID, tokenName string, permissio
 		return empty, e
 	}
 
-	if _, e := registry.Do("LPUSH", listKey, rawToken); e != nil {
-		return empty, e
-	}
-
-	registryKey := registry.genTokenRegistrationKey(rawToken)
+	digest := registry.tokenDigest(rawToken)
+	registryKey := registry.genTokenDigestKey(digest)
 
 	fields := struct {
 		name       string
@@ -352,275 +402,1419 @@ func (registry *RedisRegistry) CreateToken(deviceID, tokenName string, permissio
 		Token:      rawToken,
 		Name:       tokenName,
 		Permission: permission,
+		ExpiresAt:  expiresAt,
 	}
 
-	return details, registry.hmset(
-		registryKey,
+	pairs := []string{
 		fields.name, tokenName,
 		fields.permission, permissionMask,
 		fields.id, tokenID,
 		fields.deviceID, deviceID,
-	)
-}
-
-// ListRegistrations prints out a list of all the registered devices
-func (registry *RedisRegistry) ListRegistrations() ([]RegistrationDetails, error) {
-	var results []RegistrationDetails
-
-	ids, e := registry.lrangestr(defs.RedisDeviceIndexKey, 0, -1)
+	}
 
-	if e != nil {
-		return nil, e
+	if expiresAt != nil {
+		pairs = append(pairs, defs.RedisDeviceTokenExpiresField, strconv.FormatInt(expiresAt.Unix(), 10))
 	}
 
-	for _, k := range ids {
-		details, e := registry.loadDetails(registry.genRegistryKey(k))
+	args := []interface{}{listKey, registryKey, digest}
 
-		if e != nil {
-			return nil, e
-		}
+	for _, value := range pairs {
+		args = append(args, value)
+	}
 
-		results = append(results, details)
+	if _, e := registry.eval(createTokenScript, args...); e != nil {
+		return empty, e
 	}
 
-	return results, nil
+	return details, nil
 }
 
-// RemoveDevice executes the LREM command to the redis connection
-func (registry *RedisRegistry) RemoveDevice(id string) error {
-	regKey, feedKey := registry.genRegistryKey(id), registry.genFeedbackKey(id)
-
-	if e := registry.del(regKey); e != nil {
-		return e
-	}
+// RemoveToken deletes the token w/ the given id from the device's token list along w/ its details in a single script.
+func (registry *RedisRegistry) RemoveToken(deviceID, tokenID string) error {
+	listKey := registry.genTokenListKey(deviceID)
 
-	if e := registry.del(feedKey); e != nil {
-		return e
-	}
+	digests, e := registry.lrangestr(listKey, 0, -1)
 
-	if _, e := registry.Do("LREM", defs.RedisDeviceIndexKey, 1, id); e != nil {
+	if e != nil {
 		return e
 	}
 
-	tokensListKey := registry.genTokenListKey(id)
+	for _, digest := range digests {
+		registryKey := registry.genTokenDigestKey(digest)
 
-	tokens, e := registry.lrangestr(tokensListKey, 0, -1)
+		if id, e := registry.hgetstr(registryKey, defs.RedisDeviceTokenIDField); e != nil || id != tokenID {
+			continue
+		}
 
-	if e != nil {
+		_, e := registry.eval(removeTokenScript, listKey, registryKey, digest)
 		return e
 	}
 
-	for _, t := range tokens {
-		registry.del(registry.genTokenRegistrationKey(t))
-	}
-
-	return registry.del(tokensListKey)
+	return fmt.Errorf(defs.ErrNotFound)
 }
 
-// exists extracts the full list of device keys and searches for the target id
-func (registry *RedisRegistry) exists(key string) (bool, error) {
-	response, e := registry.Do("EXISTS", key)
+// CreateAccount allocates a new user account along w/ its token. The token is only returned here; just its salted
+// digest is stored.
+func (registry *RedisRegistry) CreateAccount(name string) (AccountDetails, error) {
+	if len(name) < defs.SecurityAccountNameMinLength {
+		return AccountDetails{}, fmt.Errorf(defs.ErrInvalidAccountName)
+	}
+
+	token, e := registry.GenerateToken()
 
 	if e != nil {
-		return false, e
+		return AccountDetails{}, e
 	}
 
-	return redis.Bool(response, e)
-}
+	accountID := uuid.NewV4().String()
 
-// loadDetails returns the device registration details based on a provided device key
-func (registry *RedisRegistry) loadDetails(deviceKey string) (RegistrationDetails, error) {
-	f := struct {
+	fields := struct {
 		id   string
 		name string
-		key  string
-	}{defs.RedisDeviceIDField, defs.RedisDeviceNameField, defs.RedisDeviceSecretField}
-	values, e := registry.hmgetstr(deviceKey, f.id, f.name, f.key)
+	}{defs.RedisAccountIDField, defs.RedisAccountNameField}
+
+	_, e = registry.eval(
+		createAccountScript,
+		registry.genAccountKey(accountID),
+		registry.genAccountTokenKey(token),
+		accountID,
+		fields.id, accountID,
+		fields.name, name,
+	)
 
 	if e != nil {
-		return RegistrationDetails{}, e
+		return AccountDetails{}, e
 	}
 
-	for _, v := range values {
-		if filled := len(v) > 1; !filled {
-			return RegistrationDetails{}, fmt.Errorf("invalid-device")
-		}
+	registry.Infof("created account[%s] id[%s]", name, accountID)
+
+	details := AccountDetails{
+		AccountID:   accountID,
+		Name:        name,
+		Token:       token,
+		Permissions: map[string]defs.DeviceTokenPermissions{},
 	}
 
-	return RegistrationDetails{
-		DeviceID:     values[0],
-		Name:         values[1],
-		SharedSecret: values[2],
-	}, nil
+	return details, nil
 }
 
-// loadRequest loads the registration request associated w/ a given key
-func (registry *RedisRegistry) loadRequest(requestKey string) (RegistrationRequest, error) {
-	f := struct {
-		secret string
-		name   string
-	}{defs.RedisRegistrationSecretField, defs.RedisRegistrationNameField}
-	values, e := registry.hmgetstr(requestKey, f.secret, f.name)
+// FindAccount loads the account identified by the account token provided along w/ the permissions it was granted.
+func (registry *RedisRegistry) FindAccount(token string) (AccountDetails, error) {
+	accountID, e := redis.String(registry.Do("GET", registry.genAccountTokenKey(token)))
+
+	if e == redis.ErrNil {
+		return AccountDetails{}, fmt.Errorf(defs.ErrNotFound)
+	}
 
 	if e != nil {
-		return RegistrationRequest{}, e
+		return AccountDetails{}, e
 	}
 
-	for _, v := range values {
-		if filled := len(v) > 1; !filled {
-			return RegistrationRequest{}, fmt.Errorf("invalid-request")
-		}
+	values, e := registry.hmgetstr(registry.genAccountKey(accountID), defs.RedisAccountIDField, defs.RedisAccountNameField)
+
+	if e != nil {
+		return AccountDetails{}, e
 	}
 
-	return RegistrationRequest{SharedSecret: values[0], Name: values[1]}, nil
-}
-func (registry *RedisRegistry) genAllocationKey(id string) string {
-	return fmt.Sprintf("%s:%s", defs.RedisRegistrationRequestListKey, id)
-}
+	masks, e := redis.StringMap(registry.Do("HGETALL", registry.genAccountPermissionsKey(accountID)))
 
-func (registry *RedisRegistry) genTokenRegistrationKey(token string) string {
-	return fmt.Sprintf("%s:%s", defs.RedisDeviceTokenRegistrationKey, token)
-}
+	if e != nil {
+		return AccountDetails{}, fmt.Errorf(defs.ErrBadRedisResponse)
+	}
 
-func (registry *RedisRegistry) genRegistryKey(id string) string {
-	return fmt.Sprintf("%s:%s", defs.RedisDeviceRegistryKey, id)
-}
+	permissions := make(map[string]defs.DeviceTokenPermissions, len(masks))
 
-func (registry *RedisRegistry) genFeedbackKey(id string) string {
-	return fmt.Sprintf("%s:%s", defs.RedisDeviceFeedbackKey, id)
-}
+	for name, mask := range masks {
+		permission, e := strconv.ParseUint(mask, 2, 32)
 
-func (registry *RedisRegistry) genTokenListKey(id string) string {
-	return fmt.Sprintf("%s:%s", defs.RedisDeviceTokenListKey, id)
+		if e != nil {
+			registry.Warnf("invalid permission mask for account[%s] device[%s]: %s", accountID, name, mask)
+			continue
+		}
+
+		permissions[name] = defs.DeviceTokenPermissions(permission)
+	}
+
+	return AccountDetails{AccountID: values[0], Name: values[1], Permissions: permissions}, nil
 }
 
-// hmgetstr is a wrapper around the redis HMGET command where all fields are expected to be strings
-func (registry *RedisRegistry) hmgetstr(key string, fields ...string) ([]string, error) {
-	args := []interface{}{key}
+// GrantAccountPermission sets the permission bitmask of the account for the device name, replacing any previous grant.
+// The account is added to the accounts set of the name so the grant can be dropped along w/ the last device.
+func (registry *RedisRegistry) GrantAccountPermission(
+	accountID, deviceName string,
+	permission defs.DeviceTokenPermissions,
+) error {
+	exists, e := registry.exists(registry.genAccountKey(accountID))
 
-	for _, f := range fields {
-		args = append(args, f)
+	if e != nil {
+		return e
 	}
 
-	response, e := registry.Do("HMGET", args...)
+	if exists != true {
+		return fmt.Errorf(defs.ErrNotFound)
+	}
 
-	if e != nil {
-		return nil, e
+	if _, e := registry.Do("SADD", registry.genDeviceAccountsKey(deviceName), accountID); e != nil {
+		return e
 	}
 
-	list, e := redis.Strings(response, e)
+	return registry.hset(registry.genAccountPermissionsKey(accountID), deviceName, fmt.Sprintf("%b", permission))
+}
+
+// RevokeAccountPermission removes any permission the account was granted for the device name.
+func (registry *RedisRegistry) RevokeAccountPermission(accountID, deviceName string) error {
+	response, e := registry.Do("HDEL", registry.genAccountPermissionsKey(accountID), deviceName)
 
 	if e != nil {
-		return nil, e
+		return e
 	}
 
-	for i, s := range list {
-		if empty := len(s) == 0; empty {
-			return nil, fmt.Errorf("invalid-entry[%s:%s]", fields[i], s)
-		}
+	if count, e := redis.Int(response, e); e != nil || count != 1 {
+		return fmt.Errorf(defs.ErrNotFound)
 	}
 
-	return list, nil
-}
-
-// del is a wrapper around DEL that casts to a string
-func (registry *RedisRegistry) del(key string) error {
-	_, e := registry.Do("DEL", key)
+	_, e = registry.Do("SREM", registry.genDeviceAccountsKey(deviceName), accountID)
 	return e
 }
 
-// llen is a wrapper around HGET that casts to a string
-func (registry *RedisRegistry) llen(key string) (int, error) {
-	response, e := registry.Do("LLEN", key)
+// SaveAccessToken keeps the json record of an issued access token, expiring w/ the token itself.
+func (registry *RedisRegistry) SaveAccessToken(details AccessTokenDetails) error {
+	ttl := int64(details.ExpiresAt.Sub(time.Now()) / time.Second)
+
+	if ttl <= 0 {
+		return nil
+	}
+
+	details.Token = ""
+	data, e := json.Marshal(details)
 
 	if e != nil {
-		return -1, e
+		return e
 	}
 
-	return redis.Int(response, e)
+	_, e = registry.Do("SET", registry.genAccessTokenKey(details.TokenID), string(data), "EX", ttl)
+	return e
 }
 
-// lrangestr is a wrapper around HGET that casts to a string
-func (registry *RedisRegistry) lrangestr(key string, start, end int) ([]string, error) {
-	response, e := registry.Do("LRANGE", key, start, end)
+// FindAccessToken returns the record of the issued access token w/ the given id if it has not yet expired.
+func (registry *RedisRegistry) FindAccessToken(tokenID string) (AccessTokenDetails, error) {
+	data, e := redis.String(registry.Do("GET", registry.genAccessTokenKey(tokenID)))
+
+	if e == redis.ErrNil {
+		return AccessTokenDetails{}, fmt.Errorf(defs.ErrNotFound)
+	}
 
 	if e != nil {
-		return nil, e
+		return AccessTokenDetails{}, e
 	}
 
-	result, e := redis.Strings(response, e)
+	details := AccessTokenDetails{}
 
-	if e != nil {
-		return nil, fmt.Errorf(defs.ErrBadRedisResponse)
+	if e := json.Unmarshal([]byte(data), &details); e != nil {
+		return AccessTokenDetails{}, e
 	}
 
-	return result, nil
+	return details, nil
 }
 
-// hmset is a wrapper around hset
-func (registry *RedisRegistry) hmset(key string, pairs ...string) error {
-	args := []interface{}{key}
+// RevokeAccessToken rejects the signed access token w/ the given id until its expiry.
+func (registry *RedisRegistry) RevokeAccessToken(tokenID string, expiresAt time.Time) error {
+	ttl := int64(expiresAt.Sub(time.Now()) / time.Second)
 
-	for _, i := range pairs {
-		args = append(args, i)
+	if ttl <= 0 {
+		return nil
 	}
 
-	_, e := registry.Do("HMSET", args...)
-
+	_, e := registry.Do("SET", registry.genRevokedAccessTokenKey(tokenID), expiresAt.Unix(), "EX", ttl)
 	return e
 }
 
-// hset is a wrapper around hset
-func (registry *RedisRegistry) hset(key, field, value string) error {
-	_, e := registry.Do("HSET", key, field, value)
-	return e
+// AccessTokenRevoked returns true if the signed access token w/ the given id has been revoked.
+func (registry *RedisRegistry) AccessTokenRevoked(tokenID string) (bool, error) {
+	return registry.exists(registry.genRevokedAccessTokenKey(tokenID))
 }
 
-// hgetstr is a wrapper around HGET that casts to a string
-func (registry *RedisRegistry) hgetstr(key, field string) (string, error) {
-	response, e := registry.Do("HGET", key, field)
+// SavePreset stores the control message under the preset name provided for the device name, replacing any existing
+// entry.
+func (registry *RedisRegistry) SavePreset(deviceName, name string, message interchange.ControlMessage) error {
+	textBuffer := bytes.NewBuffer([]byte{})
 
-	if e != nil {
-		return "", e
+	if e := proto.MarshalText(textBuffer, &message); e != nil {
+		return e
 	}
 
-	return redis.String(response, e)
+	return registry.hset(registry.genPresetListKey(deviceName), name, textBuffer.String())
 }
 
-// fill is responsible for loading the information stored during the registration request and creating records in both
-// the device registry index as well as the device registry (keys w/ device hash information)
-func (registry *RedisRegistry) fill(requestKey, deviceID string) error {
-	request, e := registry.loadRequest(requestKey)
+// FindPreset loads the preset saved under the preset name provided for the device name.
+func (registry *RedisRegistry) FindPreset(deviceName, name string) (PresetDetails, error) {
+	entry, e := registry.hgetstr(registry.genPresetListKey(deviceName), name)
 
-	if e != nil {
-		return e
+	if e == redis.ErrNil {
+		return PresetDetails{}, fmt.Errorf(defs.ErrNotFound)
 	}
 
-	if _, e := registry.Do("LPUSH", defs.RedisDeviceIndexKey, deviceID); e != nil {
-		return e
+	if e != nil {
+		return PresetDetails{}, e
 	}
 
-	registryKey := registry.genRegistryKey(deviceID)
-
-	f := struct {
-		id   string
-		name string
-		key  string
-	}{defs.RedisDeviceIDField, defs.RedisDeviceNameField, defs.RedisDeviceSecretField}
+	return registry.loadPreset(deviceName, name, entry)
+}
 
-	_, e = registry.Do("HMSET", registryKey, f.id, deviceID, f.name, request.Name, f.key, request.SharedSecret)
+// ListPresets returns every preset saved for the device name.
+func (registry *RedisRegistry) ListPresets(deviceName string) ([]PresetDetails, error) {
+	response, e := registry.Do("HGETALL", registry.genPresetListKey(deviceName))
 
 	if e != nil {
-		return e
+		return nil, e
 	}
 
-	registry.Infof("filling device registry w/ name[%s] id[%s]", request.Name, deviceID)
-
-	defer registry.Do("DEL", requestKey)
+	entries, e := redis.StringMap(response, e)
+
+	if e != nil {
+		return nil, fmt.Errorf(defs.ErrBadRedisResponse)
+	}
+
+	results := make([]PresetDetails, 0, len(entries))
+
+	for name, entry := range entries {
+		preset, e := registry.loadPreset(deviceName, name, entry)
+
+		if e != nil {
+			return nil, e
+		}
+
+		results = append(results, preset)
+	}
+
+	return results, nil
+}
+
+// RemovePreset deletes the preset saved under the preset name provided for the device name.
+func (registry *RedisRegistry) RemovePreset(deviceName, name string) error {
+	response, e := registry.Do("HDEL", registry.genPresetListKey(deviceName), name)
+
+	if e != nil {
+		return e
+	}
+
+	if count, e := redis.Int(response, e); e != nil || count != 1 {
+		return fmt.Errorf(defs.ErrNotFound)
+	}
+
+	return nil
+}
+
+// CreateGroup allocates a new device group along w/ the token used to authorize commands sent to its members. The token
+// is only returned here; just its salted digest is stored.
+func (registry *RedisRegistry) CreateGroup(name string) (GroupDetails, error) {
+	if len(name) < defs.SecurityDeviceGroupNameMinLength {
+		return GroupDetails{}, fmt.Errorf(defs.ErrInvalidGroupName)
+	}
+
+	if _, e := registry.FindGroup(name); e == nil {
+		return GroupDetails{}, fmt.Errorf(defs.ErrDuplicateGroupName)
+	}
+
+	token, e := registry.GenerateToken()
+
+	if e != nil {
+		return GroupDetails{}, e
+	}
+
+	groupID := uuid.NewV4().String()
+
+	fields := struct {
+		id     string
+		name   string
+		digest string
+	}{defs.RedisDeviceGroupIDField, defs.RedisDeviceGroupNameField, defs.RedisDeviceGroupTokenDigestField}
+
+	groupKey, digest := registry.genGroupKey(groupID), registry.tokenDigest(token)
+
+	_, e = registry.eval(
+		createGroupScript,
+		groupKey,
+		defs.RedisDeviceGroupIndexKey,
+		groupID,
+		fields.id, groupID,
+		fields.name, name,
+		fields.digest, digest,
+	)
+
+	if e != nil {
+		return GroupDetails{}, e
+	}
+
+	registry.Infof("created device group[%s] id[%s]", name, groupID)
+
+	return GroupDetails{GroupID: groupID, Name: name, Token: token, Devices: []string{}}, nil
+}
+
+// FindGroup searches the registry for the device group matching either the id or name provided.
+func (registry *RedisRegistry) FindGroup(query string) (GroupDetails, error) {
+	exists, e := registry.exists(registry.genGroupKey(query))
+
+	if e != nil {
+		return GroupDetails{}, e
+	}
+
+	if exists {
+		return registry.loadGroup(query)
+	}
+
+	groups, e := registry.ListGroups()
+
+	if e != nil {
+		return GroupDetails{}, e
+	}
+
+	for _, group := range groups {
+		if group.Name == query {
+			return group, nil
+		}
+	}
+
+	return GroupDetails{}, fmt.Errorf(defs.ErrNotFound)
+}
+
+// ListGroups returns every device group in the registry.
+func (registry *RedisRegistry) ListGroups() ([]GroupDetails, error) {
+	ids, e := registry.lrangestr(defs.RedisDeviceGroupIndexKey, 0, -1)
+
+	if e != nil {
+		return nil, e
+	}
+
+	results := make([]GroupDetails, 0, len(ids))
+
+	for _, id := range ids {
+		group, e := registry.loadGroup(id)
+
+		if e != nil {
+			return nil, e
+		}
+
+		results = append(results, group)
+	}
+
+	return results, nil
+}
+
+// AddGroupDevice adds the device name to the set of group members.
+func (registry *RedisRegistry) AddGroupDevice(groupID, name string) error {
+	_, e := registry.Do("SADD", registry.genGroupMembersKey(groupID), name)
+	return e
+}
+
+// RemoveGroupDevice removes the device name from the set of group members.
+func (registry *RedisRegistry) RemoveGroupDevice(groupID, name string) error {
+	response, e := registry.Do("SREM", registry.genGroupMembersKey(groupID), name)
+
+	if e != nil {
+		return e
+	}
+
+	if count, e := redis.Int(response, e); e != nil || count != 1 {
+		return fmt.Errorf(defs.ErrNotFound)
+	}
+
+	return nil
+}
+
+// AuthorizeGroup returns true if the digest of the token provided matches the digest stored for the device group.
+func (registry *RedisRegistry) AuthorizeGroup(groupID, token string) bool {
+	digest, e := registry.hgetstr(registry.genGroupKey(groupID), defs.RedisDeviceGroupTokenDigestField)
+
+	if e != nil {
+		registry.Errorf("unable to load token digest for group[%s]: %s", groupID, e.Error())
+		return false
+	}
+
+	return len(token) >= 1 && subtle.ConstantTimeCompare([]byte(registry.tokenDigest(token)), []byte(digest)) == 1
+}
+
+// CreateSchedule stores the schedule and adds it to the index of schedules ordered by their next run in a single
+// script.
+func (registry *RedisRegistry) CreateSchedule(schedule ScheduleDetails) (ScheduleDetails, error) {
+	textBuffer := bytes.NewBuffer([]byte{})
+
+	if schedule.Message == nil {
+		return ScheduleDetails{}, fmt.Errorf(defs.ErrInvalidSchedule)
+	}
+
+	if e := proto.MarshalText(textBuffer, schedule.Message); e != nil {
+		return ScheduleDetails{}, e
+	}
+
+	schedule.ScheduleID = uuid.NewV4().String()
+	scheduleKey, nextRun := registry.genScheduleKey(schedule.ScheduleID), schedule.NextRun.Unix()
+
+	fields := struct {
+		id      string
+		device  string
+		cron    string
+		nextRun string
+		message string
+	}{
+		defs.RedisDeviceScheduleIDField,
+		defs.RedisDeviceScheduleDeviceField,
+		defs.RedisDeviceScheduleCronField,
+		defs.RedisDeviceScheduleNextRunField,
+		defs.RedisDeviceScheduleMessageField,
+	}
+
+	_, e := registry.eval(
+		createScheduleScript,
+		scheduleKey,
+		registry.genScheduleListKey(schedule.DeviceName),
+		defs.RedisDeviceScheduleIndexKey,
+		schedule.ScheduleID,
+		nextRun,
+		fields.id, schedule.ScheduleID,
+		fields.device, schedule.DeviceName,
+		fields.cron, schedule.Cron,
+		fields.nextRun, strconv.FormatInt(nextRun, 10),
+		fields.message, textBuffer.String(),
+	)
+
+	if e != nil {
+		return ScheduleDetails{}, e
+	}
+
+	registry.Infof("created schedule[%s] for device[%s]", schedule.ScheduleID, schedule.DeviceName)
+
+	return schedule, nil
+}
+
+// ListSchedules returns every schedule associated w/ the device name.
+func (registry *RedisRegistry) ListSchedules(name string) ([]ScheduleDetails, error) {
+	response, e := registry.Do("SMEMBERS", registry.genScheduleListKey(name))
+
+	if e != nil {
+		return nil, e
+	}
+
+	ids, e := redis.Strings(response, e)
+
+	if e != nil {
+		return nil, fmt.Errorf(defs.ErrBadRedisResponse)
+	}
+
+	return registry.loadSchedules(ids)
+}
+
+// RemoveSchedule deletes the schedule from the device's schedules and the schedule index.
+func (registry *RedisRegistry) RemoveSchedule(name, scheduleID string) error {
+	response, e := registry.Do("SREM", registry.genScheduleListKey(name), scheduleID)
+
+	if e != nil {
+		return e
+	}
+
+	if count, e := redis.Int(response, e); e != nil || count != 1 {
+		return fmt.Errorf(defs.ErrNotFound)
+	}
+
+	if _, e := registry.Do("ZREM", defs.RedisDeviceScheduleIndexKey, scheduleID); e != nil {
+		return e
+	}
+
+	return registry.del(registry.genScheduleKey(scheduleID))
+}
+
+// DueSchedules returns every schedule whose next run is at or before the time provided. Entries in the index that can
+// no longer be loaded are dropped from the index so that they do not prevent other schedules from running.
+func (registry *RedisRegistry) DueSchedules(now time.Time) ([]ScheduleDetails, error) {
+	response, e := registry.Do("ZRANGEBYSCORE", defs.RedisDeviceScheduleIndexKey, "-inf", now.Unix())
+
+	if e != nil {
+		return nil, e
+	}
+
+	ids, e := redis.Strings(response, e)
+
+	if e != nil {
+		return nil, fmt.Errorf(defs.ErrBadRedisResponse)
+	}
+
+	results := make([]ScheduleDetails, 0, len(ids))
+
+	for _, id := range ids {
+		schedule, e := registry.loadSchedule(id)
+
+		if e != nil {
+			registry.Warnf("removing invalid schedule[%s] from index: %s", id, e.Error())
+			registry.Do("ZREM", defs.RedisDeviceScheduleIndexKey, id)
+			continue
+		}
+
+		results = append(results, schedule)
+	}
+
+	return results, nil
+}
+
+// ClaimSchedule moves the schedule to its next run in both the schedule hash and the schedule index, or removes it when
+// the next run is zero. The script only applies the change while the hash still holds the run the schedule was loaded
+// w/, returning false otherwise.
+func (registry *RedisRegistry) ClaimSchedule(due ScheduleDetails, next time.Time) (bool, error) {
+	nextRun := ""
+
+	if next.IsZero() != true {
+		nextRun = strconv.FormatInt(next.Unix(), 10)
+	}
+
+	claimed, e := redis.Int(registry.eval(
+		claimScheduleScript,
+		registry.genScheduleKey(due.ScheduleID),
+		defs.RedisDeviceScheduleIndexKey,
+		registry.genScheduleListKey(due.DeviceName),
+		due.ScheduleID, defs.RedisDeviceScheduleNextRunField, strconv.FormatInt(due.NextRun.Unix(), 10), nextRun,
+	))
+
+	return claimed == 1, e
+}
+
+// CreateCommand stores a new queued command for the device along w/ a digest of the token used to send it. Commands
+// expire after defs.RedisDeviceCommandTTL seconds.
+func (registry *RedisRegistry) CreateCommand(deviceID, token string) (CommandDetails, error) {
+	commandID, now := uuid.NewV4().String(), time.Now()
+	commandKey, timestamp := registry.genCommandKey(commandID), strconv.FormatInt(now.Unix(), 10)
+
+	fields := struct {
+		id      string
+		device  string
+		status  string
+		digest  string
+		created string
+		updated string
+	}{
+		defs.RedisDeviceCommandIDField,
+		defs.RedisDeviceCommandDeviceIDField,
+		defs.RedisDeviceCommandStatusField,
+		defs.RedisDeviceCommandDigestField,
+		defs.RedisDeviceCommandCreatedField,
+		defs.RedisDeviceCommandUpdatedField,
+	}
+
+	e := registry.hmset(
+		commandKey,
+		fields.id, commandID,
+		fields.device, deviceID,
+		fields.status, defs.CommandStatusQueued,
+		fields.digest, registry.tokenDigest(token),
+		fields.created, timestamp,
+		fields.updated, timestamp,
+	)
+
+	if e != nil {
+		return CommandDetails{}, e
+	}
+
+	if _, e := registry.Do("EXPIRE", commandKey, defs.RedisDeviceCommandTTL); e != nil {
+		return CommandDetails{}, e
+	}
+
+	return CommandDetails{
+		CommandID: commandID,
+		DeviceID:  deviceID,
+		Status:    defs.CommandStatusQueued,
+		CreatedAt: time.Unix(now.Unix(), 0),
+		UpdatedAt: time.Unix(now.Unix(), 0),
+	}, nil
+}
+
+// FindCommand loads the current status of the command.
+func (registry *RedisRegistry) FindCommand(commandID string) (CommandDetails, error) {
+	commandKey := registry.genCommandKey(commandID)
+
+	exists, e := registry.exists(commandKey)
+
+	if e != nil {
+		return CommandDetails{}, e
+	}
+
+	if exists != true {
+		return CommandDetails{}, fmt.Errorf(defs.ErrNotFound)
+	}
+
+	values, e := registry.hmgetstr(
+		commandKey,
+		defs.RedisDeviceCommandDeviceIDField,
+		defs.RedisDeviceCommandStatusField,
+		defs.RedisDeviceCommandCreatedField,
+		defs.RedisDeviceCommandUpdatedField,
+	)
+
+	if e != nil {
+		return CommandDetails{}, e
+	}
+
+	created, e := strconv.ParseInt(values[2], 10, 64)
+
+	if e != nil {
+		return CommandDetails{}, fmt.Errorf(defs.ErrBadRedisResponse)
+	}
+
+	updated, e := strconv.ParseInt(values[3], 10, 64)
+
+	if e != nil {
+		return CommandDetails{}, fmt.Errorf(defs.ErrBadRedisResponse)
+	}
+
+	return CommandDetails{
+		CommandID: commandID,
+		DeviceID:  values[0],
+		Status:    values[1],
+		CreatedAt: time.Unix(created, 0),
+		UpdatedAt: time.Unix(updated, 0),
+	}, nil
+}
+
+// AuthorizeCommand returns true if the token provided is the same token that was used to create the command.
+func (registry *RedisRegistry) AuthorizeCommand(commandID, token string) bool {
+	digest, e := registry.hgetstr(registry.genCommandKey(commandID), defs.RedisDeviceCommandDigestField)
+
+	if e != nil {
+		registry.Warnf("unable to load digest for command[%s]: %s", commandID, e.Error())
+		return false
+	}
+
+	return len(token) >= 1 && subtle.ConstantTimeCompare([]byte(registry.tokenDigest(token)), []byte(digest)) == 1
+}
+
+// UpdateCommandStatus sets the status of the command. Commands that have already been acknowledged or failed are not
+// moved back to sent, which can happen when a device's feedback arrives before the control processor has finished.
+func (registry *RedisRegistry) UpdateCommandStatus(commandID, status string) error {
+	commandKey := registry.genCommandKey(commandID)
+
+	current, e := registry.hgetstr(commandKey, defs.RedisDeviceCommandStatusField)
+
+	if e == redis.ErrNil {
+		return fmt.Errorf(defs.ErrNotFound)
+	}
+
+	if e != nil {
+		return e
+	}
+
+	final := current == defs.CommandStatusAcknowledged || current == defs.CommandStatusFailed
+
+	if final && status == defs.CommandStatusSent {
+		registry.Debugf("command[%s] already %s, ignoring %s", commandID, current, status)
+		return nil
+	}
+
+	updated := strconv.FormatInt(time.Now().Unix(), 10)
+
+	return registry.hmset(
+		commandKey,
+		defs.RedisDeviceCommandStatusField, status,
+		defs.RedisDeviceCommandUpdatedField, updated,
+	)
+}
+
+// LogCommand records the entry in the command log of its device name, keeping the newest
+// defs.RedisMaxCommandLogEntries entries. The kind & id of the credential the token belongs to are added to the entry.
+func (registry *RedisRegistry) LogCommand(token string, entry CommandLogEntry) error {
+	entry = identifyCredential(registry, token, entry)
+
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+
+	data, e := json.Marshal(entry)
+
+	if e != nil {
+		return e
+	}
+
+	logKey := registry.genCommandLogKey(entry.DeviceName)
+
+	if _, e := registry.Do("LPUSH", logKey, data); e != nil {
+		return e
+	}
+
+	_, e = registry.Do("LTRIM", logKey, 0, defs.RedisMaxCommandLogEntries-1)
+	return e
+}
+
+// ListCommandLog returns up to count entries from the command log of the device name, newest first, skipping the first
+// entries up to the offset.
+func (registry *RedisRegistry) ListCommandLog(name string, offset, count int) ([]CommandLogEntry, error) {
+	list, e := registry.lrangestr(registry.genCommandLogKey(name), offset, offset+count-1)
+
+	if e != nil {
+		return nil, e
+	}
+
+	results := make([]CommandLogEntry, 0, len(list))
+
+	for _, item := range list {
+		entry := CommandLogEntry{}
+
+		if e := json.Unmarshal([]byte(item), &entry); e != nil {
+			registry.Warnf("skipping invalid command log entry of device[%s]: %s", name, e.Error())
+			continue
+		}
+
+		results = append(results, entry)
+	}
+
+	return results, nil
+}
+
+// SaveState stores the control message as the last known state of the device name.
+func (registry *RedisRegistry) SaveState(name string, message interchange.ControlMessage) error {
+	textBuffer := bytes.NewBuffer([]byte{})
+
+	if e := proto.MarshalText(textBuffer, &message); e != nil {
+		return e
+	}
+
+	return registry.hset(defs.RedisDeviceStateKey, name, textBuffer.String())
+}
+
+// FindState returns the last control message that was successfully sent to the device name.
+func (registry *RedisRegistry) FindState(name string) (*interchange.ControlMessage, error) {
+	text, e := registry.hgetstr(defs.RedisDeviceStateKey, name)
+
+	if e == redis.ErrNil {
+		return nil, fmt.Errorf(defs.ErrNotFound)
+	}
+
+	if e != nil {
+		return nil, e
+	}
+
+	message := interchange.ControlMessage{}
+
+	if e := proto.UnmarshalText(text, &message); e != nil {
+		registry.Warnf("invalid state for device[%s]: %s", name, e.Error())
+		return nil, fmt.Errorf(defs.ErrBadInterchangeData)
+	}
+
+	return &message, nil
+}
+
+// ClaimDevice records the node id as the api node holding the connection of the device. The claim is stored w/ the
+// device registration so that it is removed along w/ the device.
+func (registry *RedisRegistry) ClaimDevice(deviceID, nodeID string) error {
+	registryKey := registry.genRegistryKey(deviceID)
+
+	exists, e := registry.exists(registryKey)
+
+	if e != nil {
+		return e
+	}
+
+	if exists != true {
+		return fmt.Errorf(defs.ErrNotFound)
+	}
+
+	return registry.hset(registryKey, defs.RedisDeviceNodeField, nodeID)
+}
+
+// FindOwner returns the id of the api node holding the connection of the device.
+func (registry *RedisRegistry) FindOwner(deviceID string) (string, error) {
+	nodeID, e := registry.hgetstr(registry.genRegistryKey(deviceID), defs.RedisDeviceNodeField)
+
+	if e == redis.ErrNil {
+		return "", fmt.Errorf(defs.ErrNotFound)
+	}
+
+	return nodeID, e
+}
+
+// QueueMessage stores a message for the device name to be sent once the device connects. Each message expires after
+// defs.RedisPendingMessageTTL seconds and only the newest defs.RedisMaxPendingMessages messages are kept.
+func (registry *RedisRegistry) QueueMessage(name string, message interchange.DeviceMessage) error {
+	messageID, listKey, textBuffer := uuid.NewV4().String(), registry.genPendingListKey(name), bytes.NewBuffer([]byte{})
+
+	if e := proto.MarshalText(textBuffer, &message); e != nil {
+		return e
+	}
+
+	_, e := registry.eval(
+		queueMessageScript,
+		registry.genPendingMessageKey(messageID),
+		listKey,
+		messageID,
+		textBuffer.String(),
+		defs.RedisPendingMessageTTL,
+		defs.RedisMaxPendingMessages,
+	)
+
+	return e
+}
+
+// DequeueMessages removes and returns the messages that are still pending for the device name, oldest first. The list
+// is read & cleared by a single script so messages queued at the same time are either returned or left for later.
+func (registry *RedisRegistry) DequeueMessages(name string) ([]interchange.DeviceMessage, error) {
+	ids, e := redis.Strings(registry.eval(dequeueScript, registry.genPendingListKey(name)))
+
+	if e != nil {
+		return nil, e
+	}
+
+	results := make([]interchange.DeviceMessage, 0, len(ids))
+
+	for _, id := range ids {
+		messageKey := registry.genPendingMessageKey(id)
+		text, e := redis.String(registry.Do("GET", messageKey))
+
+		if e == redis.ErrNil {
+			registry.Debugf("pending message[%s] for device[%s] expired", id, name)
+			continue
+		}
+
+		if e != nil {
+			return nil, e
+		}
+
+		message := interchange.DeviceMessage{}
+
+		if e := proto.UnmarshalText(text, &message); e != nil {
+			registry.Warnf("invalid pending message[%s] for device[%s]: %s", id, name, e.Error())
+			continue
+		}
+
+		registry.del(messageKey)
+		results = append(results, message)
+	}
+
+	return results, nil
+}
+
+// ListRegistrations prints out a list of all the registered devices along w/ their last known state, if any.
+func (registry *RedisRegistry) ListRegistrations() ([]RegistrationDetails, error) {
+	var results []RegistrationDetails
+
+	ids, e := registry.lrangestr(defs.RedisDeviceIndexKey, 0, -1)
+
+	if e != nil {
+		return nil, e
+	}
+
+	for _, k := range ids {
+		details, e := registry.loadDetails(registry.genRegistryKey(k))
+
+		if e != nil {
+			return nil, e
+		}
+
+		if state, e := registry.FindState(details.Name); e == nil {
+			details.State = state
+		}
+
+		results = append(results, details)
+	}
+
+	return results, nil
+}
+
+// RemoveDevice deletes the device along w/ its feedback & tokens, removing it from the device indexes. Presets are kept
+// since they belong to the device name rather than the connection, while the name is dropped from every device group;
+// the group token holder only authorized the device that was registered when it was added. Account grants of the name
+// are dropped only once no other device w/ the name is indexed. Every key touched is loaded first and then removed by a
+// single script so an interrupted removal does not leave orphaned tokens or grants behind.
+func (registry *RedisRegistry) RemoveDevice(id string) error {
+	name, e := registry.hgetstr(registry.genRegistryKey(id), defs.RedisDeviceNameField)
+
+	if e != nil && e != redis.ErrNil {
+		return e
+	}
+
+	digests, e := registry.lrangestr(registry.genTokenListKey(id), 0, -1)
+
+	if e != nil {
+		return e
+	}
+
+	var groups, accounts []string
+
+	if name != "" {
+		if groups, e = registry.lrangestr(defs.RedisDeviceGroupIndexKey, 0, -1); e != nil {
+			return e
+		}
+
+		if accounts, e = redis.Strings(registry.Do("SMEMBERS", registry.genDeviceAccountsKey(name))); e != nil {
+			return e
+		}
+	}
+
+	keys := []interface{}{
+		registry.genRegistryKey(id),
+		registry.genFeedbackKey(id),
+		registry.genTokenListKey(id),
+		defs.RedisDeviceIndexKey,
+		defs.RedisDeviceNameIndexKey,
+		registry.genDeviceAccountsKey(name),
+	}
+
+	for _, digest := range digests {
+		keys = append(keys, registry.genTokenDigestKey(digest))
+	}
+
+	for _, groupID := range groups {
+		keys = append(keys, registry.genGroupMembersKey(groupID))
+	}
+
+	for _, accountID := range accounts {
+		keys = append(keys, registry.genAccountPermissionsKey(accountID))
+	}
+
+	args := append([]interface{}{len(keys)}, keys...)
+	_, e = registry.eval(removeDeviceScript, append(args, id, name, len(digests), len(groups))...)
+	return e
+}
+
+// authorizeAccount approves the token + permission for the given device name if the token belongs to an account that
+// was granted the permission for the name.
+func (registry *RedisRegistry) authorizeAccount(deviceName, token string, permission uint) bool {
+	accountID, e := redis.String(registry.Do("GET", registry.genAccountTokenKey(token)))
+
+	if e != nil {
+		registry.Warnf("unable to find token or account for device[%s]", deviceName)
+		return false
+	}
+
+	mask, e := registry.hgetstr(registry.genAccountPermissionsKey(accountID), deviceName)
+
+	if e != nil {
+		registry.Warnf("account[%s] has not been granted any permission for device[%s]", accountID, deviceName)
+		return false
+	}
+
+	granted, e := strconv.ParseUint(mask, 2, 32)
+
+	if e != nil {
+		registry.Errorf("invalid permission mask for account[%s] device[%s]: %s", accountID, deviceName, mask)
+		return false
+	}
+
+	registry.Infof("auth account: %s (account: %b, requested: %b)", accountID, granted, permission)
+
+	return uint(granted)&permission == permission
+}
+
+// exists extracts the full list of device keys and searches for the target id
+func (registry *RedisRegistry) exists(key string) (bool, error) {
+	response, e := registry.Do("EXISTS", key)
+
+	if e != nil {
+		return false, e
+	}
+
+	return redis.Bool(response, e)
+}
+
+// loadDetails returns the device registration details based on a provided device key
+func (registry *RedisRegistry) loadDetails(deviceKey string) (RegistrationDetails, error) {
+	f := struct {
+		id   string
+		name string
+		key  string
+	}{defs.RedisDeviceIDField, defs.RedisDeviceNameField, defs.RedisDeviceSecretField}
+	values, e := registry.hmgetstr(deviceKey, f.id, f.name, f.key)
+
+	if e != nil {
+		return RegistrationDetails{}, e
+	}
+
+	for _, v := range values {
+		if filled := len(v) > 1; !filled {
+			return RegistrationDetails{}, fmt.Errorf("invalid-device")
+		}
+	}
+
+	return RegistrationDetails{
+		DeviceID:     values[0],
+		Name:         values[1],
+		SharedSecret: values[2],
+	}, nil
+}
+
+// loadRequest loads the registration request associated w/ a given key
+func (registry *RedisRegistry) loadRequest(requestKey string) (RegistrationRequest, error) {
+	f := struct {
+		secret string
+		name   string
+	}{defs.RedisRegistrationSecretField, defs.RedisRegistrationNameField}
+	values, e := registry.hmgetstr(requestKey, f.secret, f.name)
+
+	if e != nil {
+		return RegistrationRequest{}, e
+	}
+
+	for _, v := range values {
+		if filled := len(v) > 1; !filled {
+			return RegistrationRequest{}, fmt.Errorf("invalid-request")
+		}
+	}
+
+	return RegistrationRequest{SharedSecret: values[0], Name: values[1]}, nil
+}
+
+// loadGroup returns the device group details (w/o the group token) along w/ its members
+func (registry *RedisRegistry) loadGroup(groupID string) (GroupDetails, error) {
+	groupKey := registry.genGroupKey(groupID)
+	values, e := registry.hmgetstr(groupKey, defs.RedisDeviceGroupIDField, defs.RedisDeviceGroupNameField)
+
+	if e != nil {
+		return GroupDetails{}, e
+	}
+
+	response, e := registry.Do("SMEMBERS", registry.genGroupMembersKey(groupID))
+
+	if e != nil {
+		return GroupDetails{}, e
+	}
+
+	members, e := redis.Strings(response, e)
+
+	if e != nil {
+		return GroupDetails{}, fmt.Errorf(defs.ErrBadRedisResponse)
+	}
+
+	return GroupDetails{GroupID: values[0], Name: values[1], Devices: members}, nil
+}
+
+// loadSchedules loads the schedule hash for each of the schedule ids provided.
+func (registry *RedisRegistry) loadSchedules(ids []string) ([]ScheduleDetails, error) {
+	results := make([]ScheduleDetails, 0, len(ids))
+
+	for _, id := range ids {
+		schedule, e := registry.loadSchedule(id)
+
+		if e != nil {
+			return nil, e
+		}
+
+		results = append(results, schedule)
+	}
+
+	return results, nil
+}
+
+// loadSchedule unmarshals the schedule hash. The cron field is empty for one-time schedules so the fields are read
+// w/o the empty value check done by hmgetstr.
+func (registry *RedisRegistry) loadSchedule(scheduleID string) (ScheduleDetails, error) {
+	response, e := registry.Do(
+		"HMGET",
+		registry.genScheduleKey(scheduleID),
+		defs.RedisDeviceScheduleDeviceField,
+		defs.RedisDeviceScheduleCronField,
+		defs.RedisDeviceScheduleNextRunField,
+		defs.RedisDeviceScheduleMessageField,
+	)
+
+	if e != nil {
+		return ScheduleDetails{}, e
+	}
+
+	values, e := redis.Strings(response, e)
+
+	if e != nil || len(values) != 4 {
+		return ScheduleDetails{}, fmt.Errorf(defs.ErrBadRedisResponse)
+	}
+
+	nextRun, e := strconv.ParseInt(values[2], 10, 64)
+
+	if e != nil {
+		registry.Warnf("invalid next run for schedule[%s]: %s", scheduleID, values[2])
+		return ScheduleDetails{}, fmt.Errorf(defs.ErrBadRedisResponse)
+	}
+
+	message := interchange.ControlMessage{}
+
+	if e := proto.UnmarshalText(values[3], &message); e != nil {
+		registry.Warnf("invalid message for schedule[%s]: %s", scheduleID, e.Error())
+		return ScheduleDetails{}, fmt.Errorf(defs.ErrBadInterchangeData)
+	}
+
+	return ScheduleDetails{
+		ScheduleID: scheduleID,
+		DeviceName: values[0],
+		Cron:       values[1],
+		NextRun:    time.Unix(nextRun, 0),
+		Message:    &message,
+	}, nil
+}
+
+// loadPreset unmarshals a preset entry stored in the device's preset hash
+func (registry *RedisRegistry) loadPreset(deviceName, name, entry string) (PresetDetails, error) {
+	message := interchange.ControlMessage{}
+
+	if e := proto.UnmarshalText(entry, &message); e != nil {
+		registry.Warnf("invalid preset item device[%s] preset[%s]: %s", deviceName, name, e.Error())
+		return PresetDetails{}, fmt.Errorf(defs.ErrBadInterchangeData)
+	}
+
+	return PresetDetails{DeviceName: deviceName, Name: name, Message: &message}, nil
+}
+
+// loadTokenExpiry returns the expiry stored in the token hash, or nil if the token does not expire.
+func (registry *RedisRegistry) loadTokenExpiry(registryKey string) (*time.Time, error) {
+	value, e := registry.hgetstr(registryKey, defs.RedisDeviceTokenExpiresField)
+
+	if e == redis.ErrNil {
+		return nil, nil
+	}
+
+	if e != nil {
+		return nil, e
+	}
+
+	seconds, e := strconv.ParseInt(value, 10, 64)
+
+	if e != nil {
+		return nil, fmt.Errorf(defs.ErrBadRedisResponse)
+	}
+
+	expiresAt := time.Unix(seconds, 0)
+	return &expiresAt, nil
+}
+
+func (registry *RedisRegistry) genAllocationKey(id string) string {
+	return fmt.Sprintf("%s:%s", defs.RedisRegistrationRequestListKey, id)
+}
+
+// genTokenRegistrationKey returns the key of the token information for the raw token provided.
+func (registry *RedisRegistry) genTokenRegistrationKey(token string) string {
+	return registry.genTokenDigestKey(registry.tokenDigest(token))
+}
+
+func (registry *RedisRegistry) genAccountKey(id string) string {
+	return fmt.Sprintf("%s:%s", defs.RedisAccountKey, id)
+}
+
+// genAccountTokenKey returns the key of the account id for the raw account token provided.
+func (registry *RedisRegistry) genAccountTokenKey(token string) string {
+	return fmt.Sprintf("%s:%s", defs.RedisAccountTokenKey, registry.tokenDigest(token))
+}
+
+func (registry *RedisRegistry) genAccountPermissionsKey(id string) string {
+	return fmt.Sprintf("%s:%s", defs.RedisAccountPermissionsKey, id)
+}
+
+func (registry *RedisRegistry) genDeviceAccountsKey(name string) string {
+	return fmt.Sprintf("%s:%s", defs.RedisDeviceAccountsKey, name)
+}
+
+func (registry *RedisRegistry) genAccessTokenKey(id string) string {
+	return fmt.Sprintf("%s:%s", defs.RedisAccessTokenKey, id)
+}
+
+func (registry *RedisRegistry) genRevokedAccessTokenKey(id string) string {
+	return fmt.Sprintf("%s:%s", defs.RedisRevokedAccessTokenKey, id)
+}
+
+func (registry *RedisRegistry) genTokenDigestKey(digest string) string {
+	return fmt.Sprintf("%s:%s", defs.RedisDeviceTokenDigestKey, digest)
+}
+
+func (registry *RedisRegistry) genRegistryKey(id string) string {
+	return fmt.Sprintf("%s:%s", defs.RedisDeviceRegistryKey, id)
+}
+
+func (registry *RedisRegistry) genFeedbackKey(id string) string {
+	return fmt.Sprintf("%s:%s", defs.RedisDeviceFeedbackKey, id)
+}
+
+func (registry *RedisRegistry) genTokenListKey(id string) string {
+	return fmt.Sprintf("%s:%s", defs.RedisDeviceTokenDigestListKey, id)
+}
+
+func (registry *RedisRegistry) genPresetListKey(name string) string {
+	return fmt.Sprintf("%s:%s", defs.RedisDevicePresetListKey, name)
+}
+
+func (registry *RedisRegistry) genPendingMessageKey(id string) string {
+	return fmt.Sprintf("%s:%s", defs.RedisDevicePendingMessageKey, id)
+}
+
+func (registry *RedisRegistry) genPendingListKey(name string) string {
+	return fmt.Sprintf("%s:%s", defs.RedisDevicePendingListKey, name)
+}
+
+func (registry *RedisRegistry) genCommandLogKey(name string) string {
+	return fmt.Sprintf("%s:%s", defs.RedisDeviceCommandLogKey, name)
+}
+
+func (registry *RedisRegistry) genCommandKey(id string) string {
+	return fmt.Sprintf("%s:%s", defs.RedisDeviceCommandKey, id)
+}
+
+func (registry *RedisRegistry) genScheduleKey(id string) string {
+	return fmt.Sprintf("%s:%s", defs.RedisDeviceScheduleKey, id)
+}
+
+func (registry *RedisRegistry) genScheduleListKey(name string) string {
+	return fmt.Sprintf("%s:%s", defs.RedisDeviceScheduleListKey, name)
+}
+
+func (registry *RedisRegistry) genGroupKey(id string) string {
+	return fmt.Sprintf("%s:%s", defs.RedisDeviceGroupKey, id)
+}
+
+func (registry *RedisRegistry) genGroupMembersKey(id string) string {
+	return fmt.Sprintf("%s:%s", defs.RedisDeviceGroupMembersKey, id)
+}
+
+// tokenDigest returns the hex encoded sha256 hmac of the token, keyed by the token salt
+func (registry *RedisRegistry) tokenDigest(token string) string {
+	return saltedDigest(registry.TokenSalt, token)
+}
+
+// hmgetstr is a wrapper around the redis HMGET command where all fields are expected to be strings
+func (registry *RedisRegistry) hmgetstr(key string, fields ...string) ([]string, error) {
+	args := []interface{}{key}
+
+	for _, f := range fields {
+		args = append(args, f)
+	}
+
+	response, e := registry.Do("HMGET", args...)
+
+	if e != nil {
+		return nil, e
+	}
+
+	list, e := redis.Strings(response, e)
+
+	if e != nil {
+		return nil, e
+	}
+
+	for i, s := range list {
+		if empty := len(s) == 0; empty {
+			return nil, fmt.Errorf("invalid-entry[%s:%s]", fields[i], s)
+		}
+	}
+
+	return list, nil
+}
+
+// del is a wrapper around DEL that casts to a string
+func (registry *RedisRegistry) del(key string) error {
+	_, e := registry.Do("DEL", key)
+	return e
+}
+
+// llen is a wrapper around HGET that casts to a string
+func (registry *RedisRegistry) llen(key string) (int, error) {
+	response, e := registry.Do("LLEN", key)
+
+	if e != nil {
+		return -1, e
+	}
+
+	return redis.Int(response, e)
+}
+
+// lrangestr is a wrapper around HGET that casts to a string
+func (registry *RedisRegistry) lrangestr(key string, start, end int) ([]string, error) {
+	response, e := registry.Do("LRANGE", key, start, end)
+
+	if e != nil {
+		return nil, e
+	}
+
+	result, e := redis.Strings(response, e)
+
+	if e != nil {
+		return nil, fmt.Errorf(defs.ErrBadRedisResponse)
+	}
+
+	return result, nil
+}
+
+// hmset is a wrapper around hset
+func (registry *RedisRegistry) hmset(key string, pairs ...string) error {
+	args := []interface{}{key}
+
+	for _, i := range pairs {
+		args = append(args, i)
+	}
+
+	_, e := registry.Do("HMSET", args...)
+
+	return e
+}
+
+// hset is a wrapper around hset
+func (registry *RedisRegistry) hset(key, field, value string) error {
+	_, e := registry.Do("HSET", key, field, value)
+	return e
+}
+
+// hgetstr is a wrapper around HGET that casts to a string
+func (registry *RedisRegistry) hgetstr(key, field string) (string, error) {
+	response, e := registry.Do("HGET", key, field)
+
+	if e != nil {
+		return "", e
+	}
+
+	return redis.String(response, e)
+}
+
+// fill is responsible for loading the information stored during the registration request and creating records in both
+// the device registry index as well as the device registry (keys w/ device hash information). Requests allocated before
+// owner tokens were issued are filled w/o one.
+func (registry *RedisRegistry) fill(requestKey, deviceID string) error {
+	request, e := registry.loadRequest(requestKey)
+
+	if e != nil {
+		return e
+	}
+
+	owner, e := registry.hgetstr(requestKey, defs.RedisRegistrationOwnerField)
+
+	if e != nil && e != redis.ErrNil {
+		return e
+	}
+
+	f := struct {
+		id   string
+		name string
+		key  string
+	}{defs.RedisDeviceIDField, defs.RedisDeviceNameField, defs.RedisDeviceSecretField}
+
+	args := []interface{}{
+		defs.RedisDeviceIndexKey,
+		registry.genRegistryKey(deviceID),
+		defs.RedisDeviceNameIndexKey,
+		requestKey,
+		defs.RedisRegistrationSecretIndexKey,
+		deviceID, request.Name, registry.tokenDigest(request.SharedSecret),
+		f.id, deviceID,
+		f.name, request.Name,
+		f.key, request.SharedSecret,
+	}
+
+	if owner != "" {
+		args = append(args, defs.RedisDeviceOwnerField, owner)
+	}
+
+	if _, e := registry.eval(fillRegistrationScript, args...); e != nil {
+		return e
+	}
+
+	registry.Infof("filling device registry w/ name[%s] id[%s]", request.Name, deviceID)
 
 	return nil
 }
 
+// eval runs the lua script on a single connection from the pool, loading it into the script cache if necessary.
+func (registry *RedisRegistry) eval(script *redis.Script, keysAndArgs ...interface{}) (interface{}, error) {
+	conn := registry.Pool.Get()
+	defer conn.Close()
+	return script.Do(conn, keysAndArgs...)
+}
+
 // Do attempts to get an available connection from the pool and execute a command against it.
 func (registry *RedisRegistry) Do(commandName string, args ...interface{}) (reply interface{}, err error) {
 	conn := registry.Pool.Get()
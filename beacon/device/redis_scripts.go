@@ -0,0 +1,145 @@
+package device
+
+import "github.com/garyburd/redigo/redis"
+
+// allocateRegistrationScript writes the registration request hash (KEYS[1]) and indexes it by the digest of its shared
+// secret (KEYS[2]). ARGV holds the request id, the secret digest and then the field/value pairs of the request hash.
+var allocateRegistrationScript = redis.NewScript(2, `
+redis.call('HMSET', KEYS[1], unpack(ARGV, 3))
+redis.call('HSET', KEYS[2], ARGV[2], ARGV[1])
+return 1
+`)
+
+// fillRegistrationScript moves a registration request into the device registry: the device id is pushed onto the
+// device index (KEYS[1]), the device hash (KEYS[2]) is written, the device name is indexed (KEYS[3]) and the request
+// (KEYS[4]) is removed along w/ its secret index entry (KEYS[5]). ARGV holds the device id, device name, the secret
+// digest and then the field/value pairs of the device hash.
+var fillRegistrationScript = redis.NewScript(5, `
+redis.call('LPUSH', KEYS[1], ARGV[1])
+redis.call('HMSET', KEYS[2], unpack(ARGV, 4))
+redis.call('HSET', KEYS[3], ARGV[2], ARGV[1])
+redis.call('DEL', KEYS[4])
+redis.call('HDEL', KEYS[5], ARGV[3])
+return 1
+`)
+
+// createTokenScript writes the token hash (KEYS[2]) and pushes its digest onto the device's token list (KEYS[1]) so a
+// token is never listed w/o its details. ARGV holds the token digest and then the field/value pairs of the token hash.
+var createTokenScript = redis.NewScript(2, `
+redis.call('HMSET', KEYS[2], unpack(ARGV, 2))
+redis.call('LPUSH', KEYS[1], ARGV[1])
+return 1
+`)
+
+// removeDeviceScript deletes the device hash (KEYS[1]), feedback (KEYS[2]) and token list (KEYS[3]) before removing
+// the device from the device index (KEYS[4]) and the name index (KEYS[5]). The name is only unindexed if it still
+// refers to the device. KEYS[6] is the set of accounts granted the device name; the keys after it are the token hashes
+// of the device, the member sets of every group and the permission hashes of those accounts. ARGV holds the device id,
+// the device name and the amount of token hashes & group member sets. The name is dropped from every group, and from
+// the accounts once no other device w/ the name is indexed.
+var removeDeviceScript = redis.NewScript(-1, `
+local tokens, groups = tonumber(ARGV[3]), tonumber(ARGV[4])
+if ARGV[2] ~= '' and redis.call('HGET', KEYS[5], ARGV[2]) == ARGV[1] then
+  redis.call('HDEL', KEYS[5], ARGV[2])
+end
+for i = 7, 6 + tokens do
+  redis.call('DEL', KEYS[i])
+end
+redis.call('DEL', KEYS[1], KEYS[2], KEYS[3])
+redis.call('LREM', KEYS[4], 1, ARGV[1])
+if ARGV[2] == '' then
+  return 1
+end
+for i = 7 + tokens, 6 + tokens + groups do
+  redis.call('SREM', KEYS[i], ARGV[2])
+end
+if redis.call('HEXISTS', KEYS[5], ARGV[2]) == 1 then
+  return 1
+end
+for i = 7 + tokens + groups, #KEYS do
+  redis.call('HDEL', KEYS[i], ARGV[2])
+end
+redis.call('DEL', KEYS[6])
+return 1
+`)
+
+// removeTokenScript deletes the token hash (KEYS[2]) along w/ its digest (ARGV[1]) in the device's token list
+// (KEYS[1]).
+var removeTokenScript = redis.NewScript(2, `
+redis.call('DEL', KEYS[2])
+redis.call('LREM', KEYS[1], 0, ARGV[1])
+return 1
+`)
+
+// createAccountScript writes the account hash (KEYS[1]) and points the account token key (KEYS[2]) at the account id
+// (ARGV[1]). The rest of ARGV holds the field/value pairs of the account hash.
+var createAccountScript = redis.NewScript(2, `
+redis.call('HMSET', KEYS[1], unpack(ARGV, 2))
+redis.call('SET', KEYS[2], ARGV[1])
+return 1
+`)
+
+// createGroupScript writes the group hash (KEYS[1]) and pushes the group id (ARGV[1]) onto the group index (KEYS[2]).
+// The rest of ARGV holds the field/value pairs of the group hash.
+var createGroupScript = redis.NewScript(2, `
+redis.call('HMSET', KEYS[1], unpack(ARGV, 2))
+redis.call('LPUSH', KEYS[2], ARGV[1])
+return 1
+`)
+
+// createScheduleScript writes the schedule hash (KEYS[1]) before adding the schedule id (ARGV[1]) to the device's
+// schedule set (KEYS[2]) and the schedule index (KEYS[3]) at its next run (ARGV[2]). The rest of ARGV holds the
+// field/value pairs of the schedule hash.
+var createScheduleScript = redis.NewScript(3, `
+redis.call('HMSET', KEYS[1], unpack(ARGV, 3))
+redis.call('SADD', KEYS[2], ARGV[1])
+redis.call('ZADD', KEYS[3], ARGV[2], ARGV[1])
+return 1
+`)
+
+// queueMessageScript stores the message text (ARGV[2]) at its key (KEYS[1]) and appends its id (ARGV[1]) to the
+// device's pending list (KEYS[2]). The message & list expire after ARGV[3] seconds and the list is trimmed to the
+// newest ARGV[4] ids.
+var queueMessageScript = redis.NewScript(2, `
+redis.call('SET', KEYS[1], ARGV[2], 'EX', ARGV[3])
+redis.call('RPUSH', KEYS[2], ARGV[1])
+redis.call('LTRIM', KEYS[2], -tonumber(ARGV[4]), -1)
+redis.call('EXPIRE', KEYS[2], ARGV[3])
+return 1
+`)
+
+// dequeueScript returns the ids in the pending message list (KEYS[1]) and deletes the list in one step so ids pushed
+// by another node while the list is being read are never dropped.
+var dequeueScript = redis.NewScript(1, `
+local ids = redis.call('LRANGE', KEYS[1], 0, -1)
+redis.call('DEL', KEYS[1])
+return ids
+`)
+
+// unindexScript removes the field (ARGV[1]) from the index hash (KEYS[1]) only if it still refers to the value
+// (ARGV[2]); an entry that was since replaced, e.g. by a newer device w/ the same name, keeps its place.
+var unindexScript = redis.NewScript(1, `
+if redis.call('HGET', KEYS[1], ARGV[1]) == ARGV[2] then
+  return redis.call('HDEL', KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+// claimScheduleScript compares the next run field (ARGV[2]) of the schedule hash (KEYS[1]) w/ the run the schedule was
+// loaded w/ (ARGV[3]) and, if they still match, either moves the schedule (ARGV[1]) to its next run (ARGV[4]) in the
+// hash & the schedule index (KEYS[2]) or, when no next run is given, deletes it along w/ its entry in the device's
+// schedule list (KEYS[3]).
+var claimScheduleScript = redis.NewScript(3, `
+if redis.call('HGET', KEYS[1], ARGV[2]) ~= ARGV[3] then
+  return 0
+end
+if ARGV[4] == '' then
+  redis.call('DEL', KEYS[1])
+  redis.call('ZREM', KEYS[2], ARGV[1])
+  redis.call('SREM', KEYS[3], ARGV[1])
+else
+  redis.call('HSET', KEYS[1], ARGV[2], ARGV[4])
+  redis.call('ZADD', KEYS[2], ARGV[4], ARGV[1])
+end
+return 1
+`)
@@ -1,16 +1,21 @@
 package device
 
-// RegistrationRequest holds the information for a pending registration
+import "github.com/dadleyy/beacon.api/beacon/interchange"
+
+// RegistrationRequest holds the information for a pending registration. The owner token is only known when the
+// registration is allocated; it is the credential used to authorize the first admin token of the device.
 type RegistrationRequest struct {
 	SharedSecret string `json:"-"`
 	Name         string `json:"name"`
+	OwnerToken   string `json:"owner_token,omitempty"`
 }
 
 // RegistrationDetails holds the information about a given device connection
 type RegistrationDetails struct {
-	SharedSecret string `json:"-"`
-	Name         string `json:"name"`
-	DeviceID     string `json:"device_id"`
+	SharedSecret string                      `json:"-"`
+	Name         string                      `json:"name"`
+	DeviceID     string                      `json:"device_id"`
+	State        *interchange.ControlMessage `json:"state,omitempty"`
 }
 
 // Registry is an interface for allocating and filling registration requests
@@ -18,5 +23,5 @@ type Registry interface {
 	Index
 	ListRegistrations() ([]RegistrationDetails, error)
 	FillRegistration(string, string) error
-	AllocateRegistration(RegistrationRequest) error
+	AllocateRegistration(RegistrationRequest) (string, error)
 }
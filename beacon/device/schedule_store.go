@@ -0,0 +1,26 @@
+package device
+
+import "time"
+import "github.com/dadleyy/beacon.api/beacon/interchange"
+
+// ScheduleDetails holds a control message that should be sent to a device at a later time. Schedules w/o a cron
+// expression are removed after they have run once. Schedules are tracked by device name so that they survive devices
+// reconnecting to the api.
+type ScheduleDetails struct {
+	ScheduleID string                      `json:"schedule_id"`
+	DeviceName string                      `json:"device_name"`
+	Cron       string                      `json:"cron,omitempty"`
+	NextRun    time.Time                   `json:"next_run"`
+	Message    *interchange.ControlMessage `json:"message"`
+}
+
+// ScheduleStore defines an interface for persisting scheduled control messages. A due schedule is claimed by moving it
+// to its next run - or removing it when the next run is zero - only if its next run still matches the one it was loaded
+// w/, so that when several api nodes find the same due schedule only one of them sends it.
+type ScheduleStore interface {
+	CreateSchedule(ScheduleDetails) (ScheduleDetails, error)
+	ListSchedules(string) ([]ScheduleDetails, error)
+	RemoveSchedule(string, string) error
+	DueSchedules(time.Time) ([]ScheduleDetails, error)
+	ClaimSchedule(ScheduleDetails, time.Time) (bool, error)
+}
@@ -0,0 +1,245 @@
+package device
+
+import "fmt"
+import "time"
+
+import "github.com/dadleyy/beacon.api/beacon/defs"
+
+// sqlMigration is a change to the schema of the sql store, applied once per database in a single transaction.
+type sqlMigration struct {
+	name       string
+	statements []string
+}
+
+// sqlMigrations is the ordered list of migrations applied by Migrate. The statements are limited to the subset of sql
+// shared by sqlite & postgresql.
+var sqlMigrations = []sqlMigration{
+	{"create-devices", []string{
+		`CREATE TABLE registration_requests (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			shared_secret TEXT NOT NULL,
+			owner_digest TEXT NOT NULL
+		)`,
+		`CREATE INDEX registration_requests_secret ON registration_requests (shared_secret)`,
+		`CREATE TABLE devices (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			shared_secret TEXT NOT NULL,
+			owner_digest TEXT NOT NULL
+		)`,
+		`CREATE INDEX devices_name ON devices (name)`,
+	}},
+	{"create-device-tokens", []string{
+		`CREATE TABLE device_tokens (
+			digest TEXT PRIMARY KEY,
+			id TEXT NOT NULL UNIQUE,
+			device_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			permission INTEGER NOT NULL,
+			expires_at BIGINT,
+			created_at BIGINT NOT NULL
+		)`,
+		`CREATE INDEX device_tokens_device ON device_tokens (device_id, created_at)`,
+	}},
+	{"create-device-feedback", []string{
+		`CREATE TABLE device_feedback (
+			id TEXT PRIMARY KEY,
+			device_id TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			created_at BIGINT NOT NULL
+		)`,
+		`CREATE INDEX device_feedback_device ON device_feedback (device_id, created_at)`,
+	}},
+	{"create-settings", []string{
+		`CREATE TABLE settings (
+			name TEXT PRIMARY KEY,
+			value TEXT NOT NULL
+		)`,
+	}},
+	{"create-accounts", []string{
+		`CREATE TABLE accounts (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			digest TEXT NOT NULL UNIQUE,
+			created_at BIGINT NOT NULL
+		)`,
+		`CREATE TABLE account_permissions (
+			account_id TEXT NOT NULL,
+			device_id TEXT NOT NULL,
+			permission INTEGER NOT NULL,
+			PRIMARY KEY (account_id, device_id)
+		)`,
+		`CREATE TABLE access_token_revocations (
+			id TEXT PRIMARY KEY,
+			expires_at BIGINT NOT NULL
+		)`,
+	}},
+	{"create-device-states", []string{
+		`CREATE TABLE device_states (
+			device_name TEXT PRIMARY KEY,
+			payload TEXT NOT NULL
+		)`,
+		`CREATE TABLE device_presets (
+			device_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			PRIMARY KEY (device_id, name)
+		)`,
+	}},
+	{"create-device-groups", []string{
+		`CREATE TABLE device_groups (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE,
+			digest TEXT NOT NULL,
+			created_at BIGINT NOT NULL
+		)`,
+		`CREATE TABLE device_group_members (
+			group_id TEXT NOT NULL,
+			device_name TEXT NOT NULL,
+			PRIMARY KEY (group_id, device_name)
+		)`,
+	}},
+	{"create-device-schedules", []string{
+		`CREATE TABLE device_schedules (
+			id TEXT PRIMARY KEY,
+			device_name TEXT NOT NULL,
+			cron TEXT NOT NULL,
+			next_run BIGINT NOT NULL,
+			payload TEXT NOT NULL
+		)`,
+		`CREATE INDEX device_schedules_device ON device_schedules (device_name, next_run)`,
+		`CREATE INDEX device_schedules_next_run ON device_schedules (next_run)`,
+	}},
+	{"create-device-commands", []string{
+		`CREATE TABLE device_commands (
+			id TEXT PRIMARY KEY,
+			device_id TEXT NOT NULL,
+			digest TEXT NOT NULL,
+			status TEXT NOT NULL,
+			created_at BIGINT NOT NULL,
+			updated_at BIGINT NOT NULL,
+			expires_at BIGINT NOT NULL
+		)`,
+		`CREATE INDEX device_commands_expiry ON device_commands (expires_at)`,
+		`CREATE TABLE device_command_log (
+			id TEXT PRIMARY KEY,
+			command_id TEXT NOT NULL,
+			device_id TEXT NOT NULL,
+			device_name TEXT NOT NULL,
+			token_id TEXT NOT NULL,
+			token_name TEXT NOT NULL,
+			route TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			created_at BIGINT NOT NULL
+		)`,
+		`CREATE INDEX device_command_log_device ON device_command_log (device_name, created_at)`,
+	}},
+	{"create-pending-messages", []string{
+		`CREATE TABLE pending_messages (
+			id TEXT PRIMARY KEY,
+			device_name TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			created_at BIGINT NOT NULL,
+			expires_at BIGINT NOT NULL
+		)`,
+		`CREATE INDEX pending_messages_device ON pending_messages (device_name, created_at)`,
+	}},
+	{"key-device-presets-by-name", []string{
+		`CREATE TABLE device_name_presets (
+			device_name TEXT NOT NULL,
+			name TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			PRIMARY KEY (device_name, name)
+		)`,
+		`INSERT INTO device_name_presets (device_name, name, payload)
+			SELECT devices.name, device_presets.name, MIN(device_presets.payload)
+			FROM device_presets JOIN devices ON devices.id = device_presets.device_id
+			GROUP BY devices.name, device_presets.name`,
+		`DROP TABLE device_presets`,
+		`ALTER TABLE device_name_presets RENAME TO device_presets`,
+	}},
+	{"log-command-credentials", []string{
+		`ALTER TABLE device_command_log ADD COLUMN credential TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE device_command_log ADD COLUMN credential_id TEXT NOT NULL DEFAULT ''`,
+	}},
+	{"create-access-tokens", []string{
+		`CREATE TABLE access_tokens (
+			id TEXT PRIMARY KEY,
+			devices TEXT NOT NULL,
+			permission BIGINT NOT NULL,
+			expires_at BIGINT NOT NULL
+		)`,
+	}},
+	{"key-account-permissions-by-name", []string{
+		`CREATE TABLE account_name_permissions (
+			account_id TEXT NOT NULL,
+			device_name TEXT NOT NULL,
+			permission INTEGER NOT NULL,
+			PRIMARY KEY (account_id, device_name)
+		)`,
+		`INSERT INTO account_name_permissions (account_id, device_name, permission)
+			SELECT account_permissions.account_id, devices.name, MAX(account_permissions.permission)
+			FROM account_permissions JOIN devices ON devices.id = account_permissions.device_id
+			GROUP BY account_permissions.account_id, devices.name`,
+		`DROP TABLE account_permissions`,
+		`ALTER TABLE account_name_permissions RENAME TO account_permissions`,
+	}},
+}
+
+// Migrate creates the migrations table if needed and applies every migration that has not yet been applied.
+func (registry *SQLRegistry) Migrate() error {
+	create := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (name TEXT PRIMARY KEY, applied_at BIGINT NOT NULL)",
+		defs.SQLMigrationsTable)
+
+	if _, e := registry.Exec(create); e != nil {
+		return e
+	}
+
+	for _, migration := range sqlMigrations {
+		count := 0
+		query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE name = ?", defs.SQLMigrationsTable)
+
+		if e := registry.QueryRow(registry.rebind(query), migration.name).Scan(&count); e != nil {
+			return e
+		}
+
+		if count != 0 {
+			continue
+		}
+
+		registry.Infof("applying migration %s", migration.name)
+
+		if e := registry.apply(migration); e != nil {
+			registry.Errorf("unable to apply migration %s: %s", migration.name, e.Error())
+			return e
+		}
+	}
+
+	return nil
+}
+
+// apply runs the statements of the migration and records it in the migrations table in a single transaction.
+func (registry *SQLRegistry) apply(migration sqlMigration) error {
+	tx, e := registry.Begin()
+
+	if e != nil {
+		return e
+	}
+
+	defer tx.Rollback()
+
+	for _, statement := range migration.statements {
+		if _, e := tx.Exec(statement); e != nil {
+			return e
+		}
+	}
+
+	insert := fmt.Sprintf("INSERT INTO %s (name, applied_at) VALUES (?, ?)", defs.SQLMigrationsTable)
+
+	if _, e := tx.Exec(registry.rebind(insert), migration.name, time.Now().Unix()); e != nil {
+		return e
+	}
+
+	return tx.Commit()
+}
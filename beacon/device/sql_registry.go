@@ -0,0 +1,1419 @@
+package device
+
+import "fmt"
+import "time"
+import "bytes"
+import "strings"
+import "crypto/subtle"
+import "database/sql"
+import "github.com/satori/go.uuid"
+import "github.com/golang/protobuf/proto"
+
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/logging"
+import "github.com/dadleyy/beacon.api/beacon/interchange"
+
+// SQLRegistry implements the `Registry`, `TokenStore` & `FeedbackStore` interfaces along w/ every other store used by
+// the api - accounts, revocations, presets, groups, schedules, commands, state & pending messages - w/ a database/sql
+// backend. Queries are written w/ `?` placeholders, which are rebound for the postgresql driver. Like the redis
+// registry, tokens are only stored as digests salted w/ the TokenSalt and messages are stored in the protobuf text
+// format.
+type SQLRegistry struct {
+	*logging.Logger
+	*sql.DB
+	TokenGenerator
+	Driver           string
+	TokenSalt        string
+	LegacySecretAuth bool
+}
+
+// sqlStatement is a query along w/ its arguments, used to run several statements in a single transaction.
+type sqlStatement struct {
+	query string
+	args  []interface{}
+}
+
+// SharedTokenSalt returns the token salt stored in the settings table, generating one if no api node has done so yet.
+// This is used when no salt was provided in the api configuration.
+func (registry *SQLRegistry) SharedTokenSalt() (string, error) {
+	salt, e := registry.GenerateToken()
+
+	if e != nil {
+		return "", e
+	}
+
+	insert := strings.Join([]string{
+		"INSERT INTO settings (name, value) SELECT CAST(? AS TEXT), CAST(? AS TEXT)",
+		"WHERE NOT EXISTS (SELECT 1 FROM settings WHERE name = ?)",
+	}, " ")
+	args := []interface{}{defs.SQLTokenSaltSetting, salt, defs.SQLTokenSaltSetting}
+
+	// Another api node storing its salt first fails the insert; the salt it stored is loaded below either way.
+	if _, e := registry.Exec(registry.rebind(insert), args...); e != nil {
+		registry.Warnf("unable to store generated token salt: %s", e.Error())
+	}
+
+	statement := registry.rebind("SELECT value FROM settings WHERE name = ?")
+
+	if e := registry.QueryRow(statement, defs.SQLTokenSaltSetting).Scan(&salt); e != nil {
+		return "", e
+	}
+
+	return salt, nil
+}
+
+// FindDevice searches the registry for the device whose id or name matches the query.
+func (registry *SQLRegistry) FindDevice(query string) (RegistrationDetails, error) {
+	details := RegistrationDetails{}
+	statement := "SELECT id, name, shared_secret FROM devices WHERE id = ? OR name = ? LIMIT 1"
+	row := registry.QueryRow(registry.rebind(statement), query, query)
+
+	if e := row.Scan(&details.DeviceID, &details.Name, &details.SharedSecret); e == sql.ErrNoRows {
+		registry.Warnf("did not find matching device: %s", query)
+		return RegistrationDetails{}, fmt.Errorf(defs.ErrNotFound)
+	} else if e != nil {
+		return RegistrationDetails{}, e
+	}
+
+	return details, nil
+}
+
+// ListRegistrations returns every registered device along w/ its last known state, ordered by name.
+func (registry *SQLRegistry) ListRegistrations() ([]RegistrationDetails, error) {
+	statement := strings.Join([]string{
+		"SELECT devices.id, devices.name, devices.shared_secret, device_states.payload FROM devices",
+		"LEFT JOIN device_states ON device_states.device_name = devices.name ORDER BY devices.name",
+	}, " ")
+
+	rows, e := registry.Query(statement)
+
+	if e != nil {
+		return nil, e
+	}
+
+	defer rows.Close()
+
+	var results []RegistrationDetails
+
+	for rows.Next() {
+		details, state := RegistrationDetails{}, sql.NullString{}
+
+		if e := rows.Scan(&details.DeviceID, &details.Name, &details.SharedSecret, &state); e != nil {
+			return nil, e
+		}
+
+		if state.Valid {
+			details.State = &interchange.ControlMessage{}
+
+			if e := proto.UnmarshalText(state.String, details.State); e != nil {
+				registry.Warnf("invalid state of device[%s]: %s", details.Name, e.Error())
+				details.State = nil
+			}
+		}
+
+		results = append(results, details)
+	}
+
+	return results, rows.Err()
+}
+
+// RemoveDevice deletes the device along w/ its tokens and feedback, dropping its name from every device group. Account
+// grants of the name are dropped only if no other device w/ the name remains.
+func (registry *SQLRegistry) RemoveDevice(id string) error {
+	tx, e := registry.Begin()
+
+	if e != nil {
+		return e
+	}
+
+	defer tx.Rollback()
+
+	members := "DELETE FROM device_group_members WHERE device_name IN (SELECT name FROM devices WHERE id = ?)"
+
+	if _, e := tx.Exec(registry.rebind(members), id); e != nil {
+		return e
+	}
+
+	grants := `DELETE FROM account_permissions WHERE device_name IN (SELECT name FROM devices WHERE id = ?)
+		AND device_name NOT IN (SELECT name FROM devices WHERE id <> ?)`
+
+	if _, e := tx.Exec(registry.rebind(grants), id, id); e != nil {
+		return e
+	}
+
+	for _, table := range []string{"device_feedback", "device_tokens"} {
+		if _, e := tx.Exec(registry.rebind(fmt.Sprintf("DELETE FROM %s WHERE device_id = ?", table)), id); e != nil {
+			return e
+		}
+	}
+
+	if _, e := tx.Exec(registry.rebind("DELETE FROM devices WHERE id = ?"), id); e != nil {
+		return e
+	}
+
+	return tx.Commit()
+}
+
+// AllocateRegistration reserves a spot in the registry to be filled later, returning the owner token of the device.
+func (registry *SQLRegistry) AllocateRegistration(details RegistrationRequest) (string, error) {
+	if len(details.Name) < 4 || len(details.SharedSecret) < defs.SecurityMinimumDeviceSharedSecretSize {
+		return "", fmt.Errorf(defs.ErrInvalidRegistrationRequest)
+	}
+
+	owner, e := registry.GenerateToken()
+
+	if e != nil {
+		return "", e
+	}
+
+	statement := "INSERT INTO registration_requests (id, name, shared_secret, owner_digest) VALUES (?, ?, ?, ?)"
+	args := []interface{}{uuid.NewV4().String(), details.Name, details.SharedSecret, registry.tokenDigest(owner)}
+
+	if _, e := registry.Exec(registry.rebind(statement), args...); e != nil {
+		return "", e
+	}
+
+	return owner, nil
+}
+
+// FillRegistration moves the pending registration w/ the matching secret into the devices table under the uuid.
+func (registry *SQLRegistry) FillRegistration(secret, uuid string) error {
+	tx, e := registry.Begin()
+
+	if e != nil {
+		return e
+	}
+
+	defer tx.Rollback()
+
+	requestID, request, owner := "", RegistrationRequest{SharedSecret: secret}, ""
+	statement := "SELECT id, name, owner_digest FROM registration_requests WHERE shared_secret = ? LIMIT 1"
+
+	if e := tx.QueryRow(registry.rebind(statement), secret).Scan(&requestID, &request.Name, &owner); e != nil {
+		if e == sql.ErrNoRows {
+			return fmt.Errorf(defs.ErrNotFound)
+		}
+
+		return e
+	}
+
+	insert := "INSERT INTO devices (id, name, shared_secret, owner_digest) VALUES (?, ?, ?, ?)"
+
+	if _, e := tx.Exec(registry.rebind(insert), uuid, request.Name, request.SharedSecret, owner); e != nil {
+		return e
+	}
+
+	if _, e := tx.Exec(registry.rebind("DELETE FROM registration_requests WHERE id = ?"), requestID); e != nil {
+		return e
+	}
+
+	registry.Infof("filling device registry w/ name[%s] id[%s]", request.Name, uuid)
+
+	return tx.Commit()
+}
+
+// CreateToken creates a new auth token for a given device id.
+func (registry *SQLRegistry) CreateToken(
+	deviceID, tokenName string,
+	permission uint,
+	expiresAt *time.Time,
+) (TokenDetails, error) {
+	if _, e := registry.FindDevice(deviceID); e != nil {
+		return TokenDetails{}, e
+	}
+
+	rawToken, e := registry.GenerateToken()
+
+	if e != nil {
+		return TokenDetails{}, e
+	}
+
+	details := TokenDetails{
+		TokenID:    uuid.NewV4().String(),
+		DeviceID:   deviceID,
+		Token:      rawToken,
+		Name:       tokenName,
+		Permission: permission,
+		ExpiresAt:  expiresAt,
+	}
+
+	var expiry sql.NullInt64
+
+	if expiresAt != nil {
+		expiry = sql.NullInt64{Int64: expiresAt.Unix(), Valid: true}
+	}
+
+	statement := strings.Join([]string{
+		"INSERT INTO device_tokens (digest, id, device_id, name, permission, expires_at, created_at)",
+		"VALUES (?, ?, ?, ?, ?, ?, ?)",
+	}, " ")
+
+	args := []interface{}{
+		registry.tokenDigest(rawToken),
+		details.TokenID,
+		deviceID,
+		tokenName,
+		int64(permission),
+		expiry,
+		time.Now().UnixNano(),
+	}
+
+	if _, e := registry.Exec(registry.rebind(statement), args...); e != nil {
+		return TokenDetails{}, e
+	}
+
+	return details, nil
+}
+
+// ListTokens returns the tokens of the device matching the query, newest first.
+func (registry *SQLRegistry) ListTokens(query string) ([]TokenDetails, error) {
+	device, e := registry.FindDevice(query)
+
+	if e != nil {
+		return nil, e
+	}
+
+	statement := strings.Join([]string{
+		"SELECT id, device_id, name, permission, expires_at FROM device_tokens",
+		"WHERE device_id = ? ORDER BY created_at DESC",
+	}, " ")
+
+	rows, e := registry.Query(registry.rebind(statement), device.DeviceID)
+
+	if e != nil {
+		return nil, e
+	}
+
+	defer rows.Close()
+
+	results := make([]TokenDetails, 0)
+
+	for rows.Next() {
+		details, e := registry.scanToken(rows)
+
+		if e != nil {
+			return nil, e
+		}
+
+		results = append(results, details)
+	}
+
+	return results, rows.Err()
+}
+
+// FindToken searches the token store for the token details given the raw token.
+func (registry *SQLRegistry) FindToken(token string) (TokenDetails, error) {
+	statement := "SELECT id, device_id, name, permission, expires_at FROM device_tokens WHERE digest = ?"
+	details, e := registry.scanToken(registry.QueryRow(registry.rebind(statement), registry.tokenDigest(token)))
+
+	if e == sql.ErrNoRows {
+		return TokenDetails{}, fmt.Errorf(defs.ErrNotFound)
+	}
+
+	return details, e
+}
+
+// AuthorizeToken approves the token + permission for the given device id. The owner token of the device is approved
+// for every permission.
+func (registry *SQLRegistry) AuthorizeToken(deviceID, token string, permission uint) bool {
+	registration, e := registry.FindDevice(deviceID)
+
+	if e != nil {
+		return false
+	}
+
+	owner := ""
+	statement := registry.rebind("SELECT owner_digest FROM devices WHERE id = ?")
+
+	if e := registry.QueryRow(statement, registration.DeviceID).Scan(&owner); e != nil {
+		return false
+	}
+
+	digest := registry.tokenDigest(token)
+
+	if owner != "" && subtle.ConstantTimeCompare([]byte(owner), []byte(digest)) == 1 {
+		return true
+	}
+
+	legacy := registry.LegacySecretAuth && owner == ""
+
+	if legacy && subtle.ConstantTimeCompare([]byte(token), []byte(registration.SharedSecret)) == 1 {
+		registry.Warnf("authorized device[%s] shared secret w/o an owner token (legacy)", registration.DeviceID)
+		return true
+	}
+
+	requester, e := registry.FindToken(token)
+
+	if e != nil {
+		return registry.authorizeAccount(registration.Name, token, permission)
+	}
+
+	if requester.DeviceID != registration.DeviceID {
+		registry.Warnf("rejecting token[%s] of device[%s] for device[%s]", requester.TokenID, requester.DeviceID, deviceID)
+		return false
+	}
+
+	if requester.Expired(time.Now()) {
+		registry.Warnf("rejecting expired token: %s (expired: %v)", requester.TokenID, requester.ExpiresAt)
+		return false
+	}
+
+	return requester.Permission&permission == permission
+}
+
+// authorizeAccount approves the token + permission for the given device name if the token belongs to an account that
+// was granted the permission for the name.
+func (registry *SQLRegistry) authorizeAccount(deviceName, token string, permission uint) bool {
+	account, e := registry.FindAccount(token)
+
+	if e != nil {
+		registry.Warnf("unable to find token or account for device[%s]", deviceName)
+		return false
+	}
+
+	granted, ok := account.Permissions[deviceName]
+
+	if ok != true {
+		registry.Warnf("account[%s] has not been granted any permission for device[%s]", account.AccountID, deviceName)
+		return false
+	}
+
+	return uint(granted)&permission == permission
+}
+
+// RemoveToken deletes the token w/ the given id from the device.
+func (registry *SQLRegistry) RemoveToken(deviceID, tokenID string) error {
+	statement := registry.rebind("DELETE FROM device_tokens WHERE device_id = ? AND id = ?")
+	result, e := registry.Exec(statement, deviceID, tokenID)
+
+	if e != nil {
+		return e
+	}
+
+	if count, e := result.RowsAffected(); e != nil || count == 0 {
+		return fmt.Errorf(defs.ErrNotFound)
+	}
+
+	return nil
+}
+
+// LogFeedback inserts a feedback item into the store, stamped w/ the time it was received, removing the oldest entries
+// of the device beyond defs.SQLMaxFeedbackEntries.
+func (registry *SQLRegistry) LogFeedback(message interchange.FeedbackMessage) error {
+	auth := message.GetAuthentication()
+
+	if auth == nil {
+		return fmt.Errorf(defs.ErrBadInterchangeAuthentication)
+	}
+
+	details, e := registry.FindDevice(auth.DeviceID)
+
+	if e != nil {
+		return e
+	}
+
+	now := time.Now()
+	message.ReceivedAt = now.Unix()
+
+	payload, e := registry.marshalText(&message)
+
+	if e != nil {
+		return e
+	}
+
+	insert := "INSERT INTO device_feedback (id, device_id, payload, created_at) VALUES (?, ?, ?, ?)"
+	args := []interface{}{uuid.NewV4().String(), details.DeviceID, payload, now.UnixNano()}
+
+	if _, e := registry.Exec(registry.rebind(insert), args...); e != nil {
+		return e
+	}
+
+	trim := strings.Join([]string{
+		"DELETE FROM device_feedback WHERE device_id = ? AND id NOT IN (",
+		"SELECT id FROM device_feedback WHERE device_id = ? ORDER BY created_at DESC LIMIT ?",
+		")",
+	}, " ")
+
+	_, e = registry.Exec(registry.rebind(trim), details.DeviceID, details.DeviceID, defs.SQLMaxFeedbackEntries)
+	return e
+}
+
+// ListFeedback retrieves the latest feedback for a given device id, newest first. Like the redis registry, the count is
+// treated as an inclusive end index.
+func (registry *SQLRegistry) ListFeedback(id string, count int) ([]interchange.FeedbackMessage, error) {
+	details, e := registry.FindDevice(id)
+
+	if e != nil {
+		return nil, e
+	}
+
+	statement := "SELECT payload FROM device_feedback WHERE device_id = ? ORDER BY created_at DESC LIMIT ?"
+	rows, e := registry.Query(registry.rebind(statement), details.DeviceID, count+1)
+
+	if e != nil {
+		return nil, e
+	}
+
+	defer rows.Close()
+
+	var results []interchange.FeedbackMessage
+
+	for rows.Next() {
+		entry, message := "", interchange.FeedbackMessage{}
+
+		if e := rows.Scan(&entry); e != nil {
+			return nil, e
+		}
+
+		if e := proto.UnmarshalText(entry, &message); e != nil {
+			registry.Warnf("invalid feedback item device[%s]: %s", details.DeviceID, e.Error())
+			return nil, fmt.Errorf(defs.ErrBadInterchangeData)
+		}
+
+		results = append(results, message)
+	}
+
+	return results, rows.Err()
+}
+
+// CreateAccount allocates a new user account along w/ its token. The token is only returned here; just its salted
+// digest is stored.
+func (registry *SQLRegistry) CreateAccount(name string) (AccountDetails, error) {
+	if len(name) < defs.SecurityAccountNameMinLength {
+		return AccountDetails{}, fmt.Errorf(defs.ErrInvalidAccountName)
+	}
+
+	token, e := registry.GenerateToken()
+
+	if e != nil {
+		return AccountDetails{}, e
+	}
+
+	accountID := uuid.NewV4().String()
+	insert := "INSERT INTO accounts (id, name, digest, created_at) VALUES (?, ?, ?, ?)"
+	args := []interface{}{accountID, name, registry.tokenDigest(token), time.Now().UnixNano()}
+
+	if _, e := registry.Exec(registry.rebind(insert), args...); e != nil {
+		return AccountDetails{}, e
+	}
+
+	registry.Infof("created account[%s] id[%s]", name, accountID)
+
+	details := AccountDetails{
+		AccountID:   accountID,
+		Name:        name,
+		Token:       token,
+		Permissions: map[string]defs.DeviceTokenPermissions{},
+	}
+
+	return details, nil
+}
+
+// FindAccount loads the account identified by the account token provided along w/ the permissions it was granted.
+func (registry *SQLRegistry) FindAccount(token string) (AccountDetails, error) {
+	account := AccountDetails{Permissions: map[string]defs.DeviceTokenPermissions{}}
+	statement := registry.rebind("SELECT id, name FROM accounts WHERE digest = ?")
+
+	if e := registry.QueryRow(statement, registry.tokenDigest(token)).Scan(&account.AccountID, &account.Name); e != nil {
+		if e == sql.ErrNoRows {
+			return AccountDetails{}, fmt.Errorf(defs.ErrNotFound)
+		}
+
+		return AccountDetails{}, e
+	}
+
+	statement = registry.rebind("SELECT device_name, permission FROM account_permissions WHERE account_id = ?")
+	rows, e := registry.Query(statement, account.AccountID)
+
+	if e != nil {
+		return AccountDetails{}, e
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		name, permission := "", int64(0)
+
+		if e := rows.Scan(&name, &permission); e != nil {
+			return AccountDetails{}, e
+		}
+
+		account.Permissions[name] = defs.DeviceTokenPermissions(permission)
+	}
+
+	return account, rows.Err()
+}
+
+// GrantAccountPermission sets the permission bitmask of the account for the device name, replacing any previous grant.
+func (registry *SQLRegistry) GrantAccountPermission(
+	accountID, deviceName string,
+	permission defs.DeviceTokenPermissions,
+) error {
+	if exists, e := registry.exists("accounts", "id = ?", accountID); e != nil || exists != true {
+		return registry.missing(e)
+	}
+
+	insert := "INSERT INTO account_permissions (account_id, device_name, permission) VALUES (?, ?, ?)"
+
+	return registry.transact(
+		sqlStatement{"DELETE FROM account_permissions WHERE account_id = ? AND device_name = ?", []interface{}{
+			accountID, deviceName,
+		}},
+		sqlStatement{insert, []interface{}{accountID, deviceName, int64(permission)}},
+	)
+}
+
+// RevokeAccountPermission removes any permission the account was granted for the device name.
+func (registry *SQLRegistry) RevokeAccountPermission(accountID, deviceName string) error {
+	statement := "DELETE FROM account_permissions WHERE account_id = ? AND device_name = ?"
+	return registry.execOne(statement, accountID, deviceName)
+}
+
+// SaveAccessToken keeps the record of an issued access token until its expiry, its device ids joined by commas. Records
+// that have since expired are deleted along the way.
+func (registry *SQLRegistry) SaveAccessToken(details AccessTokenDetails) error {
+	now := time.Now()
+
+	if details.ExpiresAt.After(now) != true {
+		return nil
+	}
+
+	return registry.transact(
+		sqlStatement{"DELETE FROM access_tokens WHERE expires_at <= ? OR id = ?", []interface{}{
+			now.Unix(), details.TokenID,
+		}},
+		sqlStatement{"INSERT INTO access_tokens (id, devices, permission, expires_at) VALUES (?, ?, ?, ?)", []interface{}{
+			details.TokenID, strings.Join(details.Devices, ","), details.Permission, details.ExpiresAt.Unix(),
+		}},
+	)
+}
+
+// FindAccessToken returns the record of the issued access token w/ the given id if it has not yet expired.
+func (registry *SQLRegistry) FindAccessToken(tokenID string) (AccessTokenDetails, error) {
+	query := "SELECT devices, permission, expires_at FROM access_tokens WHERE id = ? AND expires_at > ?"
+	statement := registry.rebind(query)
+	devices, permission, expiresAt := "", uint(0), int64(0)
+
+	if e := registry.QueryRow(statement, tokenID, time.Now().Unix()).Scan(&devices, &permission, &expiresAt); e != nil {
+		return AccessTokenDetails{}, registry.missing(e)
+	}
+
+	return AccessTokenDetails{
+		TokenID:    tokenID,
+		Devices:    strings.Split(devices, ","),
+		Permission: permission,
+		ExpiresAt:  time.Unix(expiresAt, 0),
+	}, nil
+}
+
+// RevokeAccessToken rejects the signed access token w/ the given id until its expiry. Revocations that have since
+// expired are deleted along the way.
+func (registry *SQLRegistry) RevokeAccessToken(tokenID string, expiresAt time.Time) error {
+	now := time.Now()
+
+	if expiresAt.After(now) != true {
+		return nil
+	}
+
+	return registry.transact(
+		sqlStatement{"DELETE FROM access_token_revocations WHERE expires_at <= ? OR id = ?", []interface{}{
+			now.Unix(), tokenID,
+		}},
+		sqlStatement{"INSERT INTO access_token_revocations (id, expires_at) VALUES (?, ?)", []interface{}{
+			tokenID, expiresAt.Unix(),
+		}},
+	)
+}
+
+// AccessTokenRevoked returns true if the signed access token w/ the given id has been revoked.
+func (registry *SQLRegistry) AccessTokenRevoked(tokenID string) (bool, error) {
+	return registry.exists("access_token_revocations", "id = ? AND expires_at > ?", tokenID, time.Now().Unix())
+}
+
+// SavePreset stores the control message under the preset name provided for the device name, replacing any existing
+// entry.
+func (registry *SQLRegistry) SavePreset(deviceName, name string, message interchange.ControlMessage) error {
+	payload, e := registry.marshalText(&message)
+
+	if e != nil {
+		return e
+	}
+
+	return registry.transact(
+		sqlStatement{"DELETE FROM device_presets WHERE device_name = ? AND name = ?", []interface{}{deviceName, name}},
+		sqlStatement{"INSERT INTO device_presets (device_name, name, payload) VALUES (?, ?, ?)", []interface{}{
+			deviceName, name, payload,
+		}},
+	)
+}
+
+// FindPreset loads the preset saved under the preset name provided for the device name.
+func (registry *SQLRegistry) FindPreset(deviceName, name string) (PresetDetails, error) {
+	statement := registry.rebind("SELECT payload FROM device_presets WHERE device_name = ? AND name = ?")
+	message, e := registry.scanControl(registry.QueryRow(statement, deviceName, name))
+
+	if e != nil {
+		return PresetDetails{}, registry.missing(e)
+	}
+
+	return PresetDetails{DeviceName: deviceName, Name: name, Message: message}, nil
+}
+
+// ListPresets returns every preset saved for the device name, ordered by name.
+func (registry *SQLRegistry) ListPresets(deviceName string) ([]PresetDetails, error) {
+	statement := registry.rebind("SELECT name, payload FROM device_presets WHERE device_name = ? ORDER BY name")
+	rows, e := registry.Query(statement, deviceName)
+
+	if e != nil {
+		return nil, e
+	}
+
+	defer rows.Close()
+
+	results := make([]PresetDetails, 0)
+
+	for rows.Next() {
+		name, payload := "", ""
+
+		if e := rows.Scan(&name, &payload); e != nil {
+			return nil, e
+		}
+
+		message := &interchange.ControlMessage{}
+
+		if e := proto.UnmarshalText(payload, message); e != nil {
+			registry.Warnf("invalid preset[%s] of device[%s]: %s", name, deviceName, e.Error())
+			return nil, fmt.Errorf(defs.ErrBadInterchangeData)
+		}
+
+		results = append(results, PresetDetails{DeviceName: deviceName, Name: name, Message: message})
+	}
+
+	return results, rows.Err()
+}
+
+// RemovePreset deletes the preset saved under the preset name provided for the device name.
+func (registry *SQLRegistry) RemovePreset(deviceName, name string) error {
+	return registry.execOne("DELETE FROM device_presets WHERE device_name = ? AND name = ?", deviceName, name)
+}
+
+// CreateGroup allocates a new device group along w/ the token used to authorize commands sent to its members. The token
+// is only returned here; just its salted digest is stored.
+func (registry *SQLRegistry) CreateGroup(name string) (GroupDetails, error) {
+	if len(name) < defs.SecurityDeviceGroupNameMinLength {
+		return GroupDetails{}, fmt.Errorf(defs.ErrInvalidGroupName)
+	}
+
+	exists, e := registry.exists("device_groups", "name = ?", name)
+
+	if e != nil {
+		return GroupDetails{}, e
+	}
+
+	if exists {
+		return GroupDetails{}, fmt.Errorf(defs.ErrDuplicateGroupName)
+	}
+
+	token, e := registry.GenerateToken()
+
+	if e != nil {
+		return GroupDetails{}, e
+	}
+
+	groupID := uuid.NewV4().String()
+	insert := "INSERT INTO device_groups (id, name, digest, created_at) VALUES (?, ?, ?, ?)"
+	args := []interface{}{groupID, name, registry.tokenDigest(token), time.Now().UnixNano()}
+
+	if _, e := registry.Exec(registry.rebind(insert), args...); e != nil {
+		return GroupDetails{}, e
+	}
+
+	registry.Infof("created device group[%s] id[%s]", name, groupID)
+
+	return GroupDetails{GroupID: groupID, Name: name, Token: token, Devices: []string{}}, nil
+}
+
+// FindGroup searches the registry for the device group matching either the id or name provided.
+func (registry *SQLRegistry) FindGroup(query string) (GroupDetails, error) {
+	group := GroupDetails{}
+	statement := registry.rebind("SELECT id, name FROM device_groups WHERE id = ? OR name = ? LIMIT 1")
+
+	if e := registry.QueryRow(statement, query, query).Scan(&group.GroupID, &group.Name); e != nil {
+		return GroupDetails{}, registry.missing(e)
+	}
+
+	return registry.loadGroup(group)
+}
+
+// ListGroups returns every device group in the registry, newest first.
+func (registry *SQLRegistry) ListGroups() ([]GroupDetails, error) {
+	rows, e := registry.Query("SELECT id, name FROM device_groups ORDER BY created_at DESC")
+
+	if e != nil {
+		return nil, e
+	}
+
+	groups := make([]GroupDetails, 0)
+
+	for rows.Next() {
+		group := GroupDetails{}
+
+		if e := rows.Scan(&group.GroupID, &group.Name); e != nil {
+			rows.Close()
+			return nil, e
+		}
+
+		groups = append(groups, group)
+	}
+
+	// The rows are closed before loading the members so the connection they hold is released.
+	if e := rows.Close(); e != nil {
+		return nil, e
+	}
+
+	for i, group := range groups {
+		if groups[i], e = registry.loadGroup(group); e != nil {
+			return nil, e
+		}
+	}
+
+	return groups, nil
+}
+
+// AddGroupDevice adds the device name to the set of group members.
+func (registry *SQLRegistry) AddGroupDevice(groupID, name string) error {
+	if exists, e := registry.exists("device_groups", "id = ?", groupID); e != nil || exists != true {
+		return registry.missing(e)
+	}
+
+	return registry.transact(
+		sqlStatement{"DELETE FROM device_group_members WHERE group_id = ? AND device_name = ?", []interface{}{
+			groupID, name,
+		}},
+		sqlStatement{"INSERT INTO device_group_members (group_id, device_name) VALUES (?, ?)", []interface{}{
+			groupID, name,
+		}},
+	)
+}
+
+// RemoveGroupDevice removes the device name from the set of group members.
+func (registry *SQLRegistry) RemoveGroupDevice(groupID, name string) error {
+	return registry.execOne("DELETE FROM device_group_members WHERE group_id = ? AND device_name = ?", groupID, name)
+}
+
+// AuthorizeGroup returns true if the digest of the token provided matches the digest stored for the device group.
+func (registry *SQLRegistry) AuthorizeGroup(groupID, token string) bool {
+	digest := ""
+	statement := registry.rebind("SELECT digest FROM device_groups WHERE id = ?")
+
+	if e := registry.QueryRow(statement, groupID).Scan(&digest); e != nil {
+		registry.Warnf("unable to find group[%s]", groupID)
+		return false
+	}
+
+	return len(token) >= 1 && subtle.ConstantTimeCompare([]byte(registry.tokenDigest(token)), []byte(digest)) == 1
+}
+
+// CreateSchedule stores the schedule, assigning it a new id.
+func (registry *SQLRegistry) CreateSchedule(schedule ScheduleDetails) (ScheduleDetails, error) {
+	if schedule.Message == nil {
+		return ScheduleDetails{}, fmt.Errorf(defs.ErrInvalidSchedule)
+	}
+
+	payload, e := registry.marshalText(schedule.Message)
+
+	if e != nil {
+		return ScheduleDetails{}, e
+	}
+
+	schedule.ScheduleID = uuid.NewV4().String()
+	schedule.NextRun = time.Unix(schedule.NextRun.Unix(), 0)
+
+	insert := "INSERT INTO device_schedules (id, device_name, cron, next_run, payload) VALUES (?, ?, ?, ?, ?)"
+	args := []interface{}{schedule.ScheduleID, schedule.DeviceName, schedule.Cron, schedule.NextRun.Unix(), payload}
+
+	if _, e := registry.Exec(registry.rebind(insert), args...); e != nil {
+		return ScheduleDetails{}, e
+	}
+
+	registry.Infof("created schedule[%s] for device[%s]", schedule.ScheduleID, schedule.DeviceName)
+
+	return schedule, nil
+}
+
+// ListSchedules returns every schedule associated w/ the device name, ordered by their next run.
+func (registry *SQLRegistry) ListSchedules(name string) ([]ScheduleDetails, error) {
+	return registry.querySchedules("device_name = ?", name)
+}
+
+// RemoveSchedule deletes the schedule of the device name.
+func (registry *SQLRegistry) RemoveSchedule(name, scheduleID string) error {
+	return registry.execOne("DELETE FROM device_schedules WHERE device_name = ? AND id = ?", name, scheduleID)
+}
+
+// DueSchedules returns every schedule whose next run is at or before the time provided, ordered by their next run.
+func (registry *SQLRegistry) DueSchedules(now time.Time) ([]ScheduleDetails, error) {
+	return registry.querySchedules("next_run <= ?", now.Unix())
+}
+
+// ClaimSchedule moves the schedule to its next run, or deletes it when the next run is zero, in a single statement that
+// only matches the row while its next run is the one the schedule was loaded w/.
+func (registry *SQLRegistry) ClaimSchedule(due ScheduleDetails, next time.Time) (bool, error) {
+	statement, args := "DELETE FROM device_schedules WHERE id = ? AND next_run = ?", []interface{}{}
+
+	if next.IsZero() != true {
+		statement, args = "UPDATE device_schedules SET next_run = ? WHERE id = ? AND next_run = ?", []interface{}{next.Unix()}
+	}
+
+	e := registry.execOne(statement, append(args, due.ScheduleID, due.NextRun.Unix())...)
+
+	if e != nil && e.Error() == defs.ErrNotFound {
+		return false, nil
+	}
+
+	return e == nil, e
+}
+
+// CreateCommand stores a new queued command for the device along w/ a digest of the token used to send it. Commands
+// expire after defs.SQLDeviceCommandTTL seconds; expired commands are deleted whenever a new one is created.
+func (registry *SQLRegistry) CreateCommand(deviceID, token string) (CommandDetails, error) {
+	now := time.Unix(time.Now().Unix(), 0)
+
+	details := CommandDetails{
+		CommandID: uuid.NewV4().String(),
+		DeviceID:  deviceID,
+		Status:    defs.CommandStatusQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	insert := strings.Join([]string{
+		"INSERT INTO device_commands (id, device_id, digest, status, created_at, updated_at, expires_at)",
+		"VALUES (?, ?, ?, ?, ?, ?, ?)",
+	}, " ")
+
+	args := []interface{}{
+		details.CommandID,
+		deviceID,
+		registry.tokenDigest(token),
+		details.Status,
+		now.Unix(),
+		now.Unix(),
+		now.Add(defs.SQLDeviceCommandTTL * time.Second).Unix(),
+	}
+
+	e := registry.transact(
+		sqlStatement{"DELETE FROM device_commands WHERE expires_at <= ?", []interface{}{now.Unix()}},
+		sqlStatement{insert, args},
+	)
+
+	if e != nil {
+		return CommandDetails{}, e
+	}
+
+	return details, nil
+}
+
+// FindCommand loads the current status of the command.
+func (registry *SQLRegistry) FindCommand(commandID string) (CommandDetails, error) {
+	details, _, e := registry.findCommand(registry.DB, commandID)
+	return details, e
+}
+
+// AuthorizeCommand returns true if the token provided is the same token that was used to create the command.
+func (registry *SQLRegistry) AuthorizeCommand(commandID, token string) bool {
+	_, digest, e := registry.findCommand(registry.DB, commandID)
+
+	if e != nil {
+		registry.Warnf("unable to find command[%s]", commandID)
+		return false
+	}
+
+	return len(token) >= 1 && subtle.ConstantTimeCompare([]byte(registry.tokenDigest(token)), []byte(digest)) == 1
+}
+
+// UpdateCommandStatus sets the status of the command. Like the redis registry, commands that have already been
+// acknowledged or failed are not moved back to sent.
+func (registry *SQLRegistry) UpdateCommandStatus(commandID, status string) error {
+	tx, e := registry.Begin()
+
+	if e != nil {
+		return e
+	}
+
+	defer tx.Rollback()
+
+	command, _, e := registry.findCommand(tx, commandID)
+
+	if e != nil {
+		return e
+	}
+
+	current := command.Status
+	final := current == defs.CommandStatusAcknowledged || current == defs.CommandStatusFailed
+
+	if final && status == defs.CommandStatusSent {
+		registry.Debugf("command[%s] already %s, ignoring %s", commandID, current, status)
+		return nil
+	}
+
+	update := registry.rebind("UPDATE device_commands SET status = ?, updated_at = ? WHERE id = ?")
+
+	if _, e := tx.Exec(update, status, time.Now().Unix(), commandID); e != nil {
+		return e
+	}
+
+	return tx.Commit()
+}
+
+// LogCommand records the entry in the command log of its device name, keeping the newest defs.SQLMaxCommandLogEntries
+// rows. The kind & id of the credential the token belongs to are added to the entry.
+func (registry *SQLRegistry) LogCommand(token string, entry CommandLogEntry) error {
+	entry = identifyCredential(registry, token, entry)
+
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+
+	payload := ""
+
+	if entry.Message != nil {
+		encoded, e := registry.marshalText(entry.Message)
+
+		if e != nil {
+			return e
+		}
+
+		payload = encoded
+	}
+
+	insert := strings.Join([]string{
+		"INSERT INTO device_command_log",
+		"(id, command_id, device_id, device_name, token_id, token_name, credential, credential_id, route, payload,",
+		"created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+	}, " ")
+
+	args := []interface{}{
+		uuid.NewV4().String(),
+		entry.CommandID,
+		entry.DeviceID,
+		entry.DeviceName,
+		entry.TokenID,
+		entry.TokenName,
+		entry.Credential,
+		entry.CredentialID,
+		entry.Route,
+		payload,
+		entry.CreatedAt.UnixNano(),
+	}
+
+	trim := strings.Join([]string{
+		"DELETE FROM device_command_log WHERE device_name = ? AND id NOT IN (",
+		"SELECT id FROM device_command_log WHERE device_name = ? ORDER BY created_at DESC LIMIT ?",
+		")",
+	}, " ")
+
+	return registry.transact(
+		sqlStatement{insert, args},
+		sqlStatement{trim, []interface{}{entry.DeviceName, entry.DeviceName, defs.SQLMaxCommandLogEntries}},
+	)
+}
+
+// ListCommandLog returns up to count entries from the command log of the device name, newest first, skipping the first
+// entries up to the offset.
+func (registry *SQLRegistry) ListCommandLog(name string, offset, count int) ([]CommandLogEntry, error) {
+	results := make([]CommandLogEntry, 0)
+
+	if offset < 0 || count <= 0 {
+		return results, nil
+	}
+
+	statement := strings.Join([]string{
+		"SELECT command_id, device_id, device_name, token_id, token_name, credential, credential_id, route, payload,",
+		"created_at",
+		"FROM device_command_log WHERE device_name = ? ORDER BY created_at DESC LIMIT ? OFFSET ?",
+	}, " ")
+
+	rows, e := registry.Query(registry.rebind(statement), name, count, offset)
+
+	if e != nil {
+		return nil, e
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		entry, payload, createdAt := CommandLogEntry{}, "", int64(0)
+		fields := []interface{}{
+			&entry.CommandID,
+			&entry.DeviceID,
+			&entry.DeviceName,
+			&entry.TokenID,
+			&entry.TokenName,
+			&entry.Credential,
+			&entry.CredentialID,
+			&entry.Route,
+			&payload,
+			&createdAt,
+		}
+
+		if e := rows.Scan(fields...); e != nil {
+			return nil, e
+		}
+
+		entry.CreatedAt = time.Unix(0, createdAt)
+
+		if payload != "" {
+			entry.Message = &interchange.ControlMessage{}
+
+			if e := proto.UnmarshalText(payload, entry.Message); e != nil {
+				registry.Warnf("invalid command log entry of device[%s]: %s", name, e.Error())
+				return nil, fmt.Errorf(defs.ErrBadInterchangeData)
+			}
+		}
+
+		results = append(results, entry)
+	}
+
+	return results, rows.Err()
+}
+
+// SaveState stores the control message as the last known state of the device name.
+func (registry *SQLRegistry) SaveState(name string, message interchange.ControlMessage) error {
+	payload, e := registry.marshalText(&message)
+
+	if e != nil {
+		return e
+	}
+
+	return registry.transact(
+		sqlStatement{"DELETE FROM device_states WHERE device_name = ?", []interface{}{name}},
+		sqlStatement{"INSERT INTO device_states (device_name, payload) VALUES (?, ?)", []interface{}{name, payload}},
+	)
+}
+
+// FindState returns the last control message that was successfully sent to the device name.
+func (registry *SQLRegistry) FindState(name string) (*interchange.ControlMessage, error) {
+	statement := registry.rebind("SELECT payload FROM device_states WHERE device_name = ?")
+	message, e := registry.scanControl(registry.QueryRow(statement, name))
+
+	if e != nil {
+		return nil, registry.missing(e)
+	}
+
+	return message, nil
+}
+
+// QueueMessage keeps a message for the device name to be sent once the device connects. Each message expires after
+// defs.SQLPendingMessageTTL seconds and only the newest defs.SQLMaxPendingMessages rows are kept.
+func (registry *SQLRegistry) QueueMessage(name string, message interchange.DeviceMessage) error {
+	payload, e := registry.marshalText(&message)
+
+	if e != nil {
+		return e
+	}
+
+	now := time.Now()
+	insert := "INSERT INTO pending_messages (id, device_name, payload, created_at, expires_at) VALUES (?, ?, ?, ?, ?)"
+	args := []interface{}{
+		uuid.NewV4().String(),
+		name,
+		payload,
+		now.UnixNano(),
+		now.Add(defs.SQLPendingMessageTTL * time.Second).Unix(),
+	}
+
+	trim := strings.Join([]string{
+		"DELETE FROM pending_messages WHERE device_name = ? AND id NOT IN (",
+		"SELECT id FROM pending_messages WHERE device_name = ? ORDER BY created_at DESC LIMIT ?",
+		")",
+	}, " ")
+
+	return registry.transact(
+		sqlStatement{insert, args},
+		sqlStatement{trim, []interface{}{name, name, defs.SQLMaxPendingMessages}},
+	)
+}
+
+// DequeueMessages removes and returns the messages that are still pending for the device name, oldest first. Each row
+// is only returned by the call that deleted it, so messages are never handed to more than one caller.
+func (registry *SQLRegistry) DequeueMessages(name string) ([]interchange.DeviceMessage, error) {
+	statement := "SELECT id, payload, expires_at FROM pending_messages WHERE device_name = ? ORDER BY created_at"
+	rows, e := registry.Query(registry.rebind(statement), name)
+
+	if e != nil {
+		return nil, e
+	}
+
+	type pendingRow struct {
+		id        string
+		payload   string
+		expiresAt int64
+	}
+
+	var pending []pendingRow
+
+	for rows.Next() {
+		row := pendingRow{}
+
+		if e := rows.Scan(&row.id, &row.payload, &row.expiresAt); e != nil {
+			rows.Close()
+			return nil, e
+		}
+
+		pending = append(pending, row)
+	}
+
+	if e := rows.Close(); e != nil {
+		return nil, e
+	}
+
+	now, results := time.Now().Unix(), make([]interchange.DeviceMessage, 0, len(pending))
+
+	for _, row := range pending {
+		result, e := registry.Exec(registry.rebind("DELETE FROM pending_messages WHERE id = ?"), row.id)
+
+		if e != nil {
+			return nil, e
+		}
+
+		if count, e := result.RowsAffected(); e != nil || count == 0 {
+			continue
+		}
+
+		if row.expiresAt <= now {
+			registry.Debugf("pending message for device[%s] expired", name)
+			continue
+		}
+
+		message := interchange.DeviceMessage{}
+
+		if e := proto.UnmarshalText(row.payload, &message); e != nil {
+			registry.Warnf("invalid pending message for device[%s]: %s", name, e.Error())
+			continue
+		}
+
+		results = append(results, message)
+	}
+
+	return results, nil
+}
+
+// sqlScanner is implemented by both sql.Row and sql.Rows.
+type sqlScanner interface {
+	Scan(...interface{}) error
+}
+
+// scanToken loads the token details from a row of the id, device id, name, permission & expiry columns.
+func (registry *SQLRegistry) scanToken(row sqlScanner) (TokenDetails, error) {
+	details, permission, expiry := TokenDetails{}, int64(0), sql.NullInt64{}
+
+	if e := row.Scan(&details.TokenID, &details.DeviceID, &details.Name, &permission, &expiry); e != nil {
+		return TokenDetails{}, e
+	}
+
+	details.Permission = uint(permission)
+
+	if expiry.Valid {
+		expiresAt := time.Unix(expiry.Int64, 0)
+		details.ExpiresAt = &expiresAt
+	}
+
+	return details, nil
+}
+
+// sqlQueryer is implemented by both sql.DB and sql.Tx.
+type sqlQueryer interface {
+	QueryRow(string, ...interface{}) *sql.Row
+}
+
+// transact runs the statements in order in a single transaction.
+func (registry *SQLRegistry) transact(statements ...sqlStatement) error {
+	tx, e := registry.Begin()
+
+	if e != nil {
+		return e
+	}
+
+	defer tx.Rollback()
+
+	for _, statement := range statements {
+		if _, e := tx.Exec(registry.rebind(statement.query), statement.args...); e != nil {
+			return e
+		}
+	}
+
+	return tx.Commit()
+}
+
+// execOne runs the statement, returning a not found error unless it affected at least one row.
+func (registry *SQLRegistry) execOne(statement string, args ...interface{}) error {
+	result, e := registry.Exec(registry.rebind(statement), args...)
+
+	if e != nil {
+		return e
+	}
+
+	if count, e := result.RowsAffected(); e != nil || count == 0 {
+		return fmt.Errorf(defs.ErrNotFound)
+	}
+
+	return nil
+}
+
+// exists returns true if the table has at least one row matching the condition.
+func (registry *SQLRegistry) exists(table, condition string, args ...interface{}) (bool, error) {
+	count := 0
+	statement := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", table, condition)
+
+	if e := registry.QueryRow(registry.rebind(statement), args...).Scan(&count); e != nil {
+		return false, e
+	}
+
+	return count > 0, nil
+}
+
+// missing returns the not found error for rows that do not exist, which is the case for a nil error or sql.ErrNoRows.
+func (registry *SQLRegistry) missing(e error) error {
+	if e == nil || e == sql.ErrNoRows {
+		return fmt.Errorf(defs.ErrNotFound)
+	}
+
+	return e
+}
+
+// marshalText returns the protobuf text format of the message, which is how messages are stored.
+func (registry *SQLRegistry) marshalText(message proto.Message) (string, error) {
+	buffer := bytes.NewBuffer([]byte{})
+
+	if e := proto.MarshalText(buffer, message); e != nil {
+		return "", e
+	}
+
+	return buffer.String(), nil
+}
+
+// scanControl loads the control message stored in the payload column of the row.
+func (registry *SQLRegistry) scanControl(row sqlScanner) (*interchange.ControlMessage, error) {
+	payload, message := "", &interchange.ControlMessage{}
+
+	if e := row.Scan(&payload); e != nil {
+		return nil, e
+	}
+
+	if e := proto.UnmarshalText(payload, message); e != nil {
+		registry.Warnf("invalid control message: %s", e.Error())
+		return nil, fmt.Errorf(defs.ErrBadInterchangeData)
+	}
+
+	return message, nil
+}
+
+// loadGroup adds the members of the group, ordered by name.
+func (registry *SQLRegistry) loadGroup(group GroupDetails) (GroupDetails, error) {
+	statement := registry.rebind("SELECT device_name FROM device_group_members WHERE group_id = ? ORDER BY device_name")
+	rows, e := registry.Query(statement, group.GroupID)
+
+	if e != nil {
+		return GroupDetails{}, e
+	}
+
+	defer rows.Close()
+
+	group.Devices = make([]string, 0)
+
+	for rows.Next() {
+		name := ""
+
+		if e := rows.Scan(&name); e != nil {
+			return GroupDetails{}, e
+		}
+
+		group.Devices = append(group.Devices, name)
+	}
+
+	return group, rows.Err()
+}
+
+// querySchedules returns the schedules matching the condition, ordered by their next run.
+func (registry *SQLRegistry) querySchedules(condition string, args ...interface{}) ([]ScheduleDetails, error) {
+	statement := fmt.Sprintf(
+		"SELECT id, device_name, cron, next_run, payload FROM device_schedules WHERE %s ORDER BY next_run",
+		condition,
+	)
+
+	rows, e := registry.Query(registry.rebind(statement), args...)
+
+	if e != nil {
+		return nil, e
+	}
+
+	defer rows.Close()
+
+	results := make([]ScheduleDetails, 0)
+
+	for rows.Next() {
+		schedule, nextRun, payload := ScheduleDetails{}, int64(0), ""
+
+		if e := rows.Scan(&schedule.ScheduleID, &schedule.DeviceName, &schedule.Cron, &nextRun, &payload); e != nil {
+			return nil, e
+		}
+
+		schedule.NextRun, schedule.Message = time.Unix(nextRun, 0), &interchange.ControlMessage{}
+
+		if e := proto.UnmarshalText(payload, schedule.Message); e != nil {
+			registry.Warnf("invalid schedule[%s]: %s", schedule.ScheduleID, e.Error())
+			return nil, fmt.Errorf(defs.ErrBadInterchangeData)
+		}
+
+		results = append(results, schedule)
+	}
+
+	return results, rows.Err()
+}
+
+// findCommand loads the command w/ the given id along w/ the digest of its token, unless it has expired.
+func (registry *SQLRegistry) findCommand(db sqlQueryer, commandID string) (CommandDetails, string, error) {
+	details, digest, createdAt, updatedAt := CommandDetails{}, "", int64(0), int64(0)
+	statement := strings.Join([]string{
+		"SELECT id, device_id, digest, status, created_at, updated_at FROM device_commands",
+		"WHERE id = ? AND expires_at > ?",
+	}, " ")
+
+	row := db.QueryRow(registry.rebind(statement), commandID, time.Now().Unix())
+	fields := []interface{}{&details.CommandID, &details.DeviceID, &digest, &details.Status, &createdAt, &updatedAt}
+
+	if e := row.Scan(fields...); e != nil {
+		return CommandDetails{}, "", registry.missing(e)
+	}
+
+	details.CreatedAt, details.UpdatedAt = time.Unix(createdAt, 0), time.Unix(updatedAt, 0)
+
+	return details, digest, nil
+}
+
+// rebind replaces the `?` placeholders of the statement w/ the numbered placeholders used by postgresql.
+func (registry *SQLRegistry) rebind(statement string) string {
+	if registry.Driver != defs.SQLDriverPostgres {
+		return statement
+	}
+
+	result, index := bytes.NewBuffer([]byte{}), 0
+
+	for _, c := range statement {
+		if c != '?' {
+			result.WriteRune(c)
+			continue
+		}
+
+		index++
+		fmt.Fprintf(result, "$%d", index)
+	}
+
+	return result.String()
+}
+
+// tokenDigest returns the salted digest of the token.
+func (registry *SQLRegistry) tokenDigest(token string) string {
+	return saltedDigest(registry.TokenSalt, token)
+}
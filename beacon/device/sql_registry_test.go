@@ -0,0 +1,238 @@
+package device
+
+import "log"
+import "fmt"
+import "time"
+import "bytes"
+import "testing"
+import "database/sql"
+import "github.com/franela/goblin"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/logging"
+import "github.com/dadleyy/beacon.api/beacon/interchange"
+
+import _ "github.com/mattn/go-sqlite3"
+
+func sqlSubject(generator TokenGenerator) (*SQLRegistry, error) {
+	out := bytes.NewBuffer([]byte{})
+	logger := log.New(out, "", 0)
+	logger.SetFlags(0)
+
+	db, e := sql.Open(defs.SQLDriverSQLite, ":memory:")
+
+	if e != nil {
+		return nil, e
+	}
+
+	// Every connection to an in-memory sqlite database opens a new, empty database.
+	db.SetMaxOpenConns(1)
+
+	registry := &SQLRegistry{
+		Logger:         &logging.Logger{Logger: logger},
+		DB:             db,
+		TokenGenerator: generator,
+		Driver:         defs.SQLDriverSQLite,
+		TokenSalt:      "test-salt",
+	}
+
+	return registry, registry.Migrate()
+}
+
+func Test_SQLRegistryConformance(t *testing.T) {
+	suite := ConformanceSuite{
+		New: func(generator TokenGenerator) (ConformanceStore, error) {
+			return sqlSubject(generator)
+		},
+		NewBackend: func(generator TokenGenerator) (ConformanceBackend, error) {
+			return sqlSubject(generator)
+		},
+		MaxFeedbackEntries: defs.SQLMaxFeedbackEntries,
+	}
+
+	suite.Run(t)
+}
+
+func Test_SQLRegistry(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("SQLRegistry", func() {
+		var r *SQLRegistry
+
+		secret := "some-long-shared-secret-value"
+
+		g.BeforeEach(func() {
+			var e error
+			generator = fakeTokenGenerator{"owner-token", nil}
+			r, e = sqlSubject(&generator)
+			g.Assert(e).Equal(nil)
+		})
+
+		g.AfterEach(func() {
+			r.Close()
+		})
+
+		register := func(name, id string) {
+			_, e := r.AllocateRegistration(RegistrationRequest{Name: name, SharedSecret: secret + name})
+			g.Assert(e).Equal(nil)
+			g.Assert(r.FillRegistration(secret+name, id)).Equal(nil)
+		}
+
+		g.Describe("Migrate", func() {
+			g.It("does not re-apply migrations that have already been applied", func() {
+				g.Assert(r.Migrate()).Equal(nil)
+			})
+
+			g.It("moves presets saved by device id to the name of their device", func() {
+				register("device-name", "device-id")
+				statements := []string{
+					"DROP TABLE device_presets",
+					"CREATE TABLE device_presets (device_id TEXT, name TEXT, payload TEXT, PRIMARY KEY (device_id, name))",
+					"INSERT INTO device_presets (device_id, name, payload) VALUES ('device-id', 'deploy', '')",
+					"DELETE FROM schema_migrations WHERE name = 'key-device-presets-by-name'",
+				}
+
+				for _, statement := range statements {
+					_, e := r.Exec(statement)
+					g.Assert(e).Equal(nil)
+				}
+
+				g.Assert(r.Migrate()).Equal(nil)
+				preset, e := r.FindPreset("device-name", "deploy")
+				g.Assert(e).Equal(nil)
+				g.Assert(preset.DeviceName).Equal("device-name")
+			})
+		})
+
+		g.Describe("SharedTokenSalt", func() {
+			g.It("stores the generated salt once and returns it to every caller", func() {
+				generator = fakeTokenGenerator{"first-salt", nil}
+				salt, e := r.SharedTokenSalt()
+				g.Assert(e).Equal(nil)
+				g.Assert(salt).Equal("first-salt")
+				generator = fakeTokenGenerator{"second-salt", nil}
+				salt, e = r.SharedTokenSalt()
+				g.Assert(e).Equal(nil)
+				g.Assert(salt).Equal("first-salt")
+			})
+
+			g.It("returns an error if unable to generate the salt", func() {
+				generator = fakeTokenGenerator{"", fmt.Errorf("bad-token")}
+				_, e := r.SharedTokenSalt()
+				g.Assert(e.Error()).Equal("bad-token")
+			})
+		})
+
+		g.Describe("rebind", func() {
+			g.It("leaves placeholders untouched for sqlite", func() {
+				g.Assert(r.rebind("a = ? AND b = ?")).Equal("a = ? AND b = ?")
+			})
+
+			g.It("numbers placeholders for postgres", func() {
+				r.Driver = defs.SQLDriverPostgres
+				g.Assert(r.rebind("a = ? AND b = ?")).Equal("a = $1 AND b = $2")
+			})
+		})
+
+		g.Describe("AllocateRegistration", func() {
+			g.It("returns an error if unable to generate the owner token", func() {
+				generator = fakeTokenGenerator{"", fmt.Errorf("bad-token")}
+				_, e := r.AllocateRegistration(RegistrationRequest{Name: "device-name", SharedSecret: secret})
+				g.Assert(e.Error()).Equal("bad-token")
+			})
+		})
+
+		g.Describe("ListRegistrations", func() {
+			g.It("returns the registered devices ordered by name", func() {
+				register("second-device", "second-id")
+				register("first-device", "first-id")
+				l, e := r.ListRegistrations()
+				g.Assert(e).Equal(nil)
+				g.Assert(len(l)).Equal(2)
+				g.Assert(l[0].DeviceID).Equal("first-id")
+			})
+		})
+
+		g.Describe("RemoveDevice", func() {
+			g.It("removes the device along w/ its tokens", func() {
+				register("device-name", "device-id")
+				generator = fakeTokenGenerator{"device-token", nil}
+				_, e := r.CreateToken("device-id", "token-name", defs.SecurityDeviceTokenPermissionViewer, nil)
+				g.Assert(e).Equal(nil)
+				g.Assert(r.RemoveDevice("device-id")).Equal(nil)
+				_, e = r.FindDevice("device-id")
+				g.Assert(e.Error()).Equal(defs.ErrNotFound)
+				_, e = r.FindToken("device-token")
+				g.Assert(e.Error()).Equal(defs.ErrNotFound)
+			})
+		})
+
+		g.Describe("pending messages", func() {
+			g.It("only keeps the newest messages of the device name", func() {
+				for i := 0; i < defs.SQLMaxPendingMessages+2; i++ {
+					g.Assert(r.QueueMessage("device-name", interchange.DeviceMessage{CommandID: fmt.Sprintf("%d", i)})).Equal(nil)
+				}
+
+				messages, e := r.DequeueMessages("device-name")
+				g.Assert(e).Equal(nil)
+				g.Assert(len(messages)).Equal(defs.SQLMaxPendingMessages)
+				g.Assert(messages[0].CommandID).Equal("2")
+			})
+
+			g.It("skips messages that have expired", func() {
+				g.Assert(r.QueueMessage("device-name", interchange.DeviceMessage{CommandID: "expired"})).Equal(nil)
+				_, e := r.Exec("UPDATE pending_messages SET expires_at = ?", time.Now().Unix())
+				g.Assert(e).Equal(nil)
+				messages, e := r.DequeueMessages("device-name")
+				g.Assert(e).Equal(nil)
+				g.Assert(len(messages)).Equal(0)
+			})
+		})
+
+		g.Describe("tokens", func() {
+			g.BeforeEach(func() {
+				register("device-name", "device-id")
+				register("other-device", "other-id")
+				generator = fakeTokenGenerator{"device-token", nil}
+			})
+
+			g.It("stores a digest of the token rather than the token itself", func() {
+				_, e := r.CreateToken("device-id", "token-name", defs.SecurityDeviceTokenPermissionViewer, nil)
+				g.Assert(e).Equal(nil)
+				count := 0
+				row := r.QueryRow("SELECT COUNT(*) FROM device_tokens WHERE digest = ?", "device-token")
+				g.Assert(row.Scan(&count)).Equal(nil)
+				g.Assert(count).Equal(0)
+			})
+
+			g.It("finds created tokens by the raw token", func() {
+				expiresAt := time.Unix(time.Now().Add(time.Hour).Unix(), 0)
+				created, e := r.CreateToken("device-id", "token-name", defs.SecurityDeviceTokenPermissionViewer, &expiresAt)
+				g.Assert(e).Equal(nil)
+				found, e := r.FindToken("device-token")
+				g.Assert(e).Equal(nil)
+				g.Assert(found.TokenID).Equal(created.TokenID)
+				g.Assert(found.Permission).Equal(uint(defs.SecurityDeviceTokenPermissionViewer))
+				g.Assert(found.ExpiresAt.Equal(expiresAt)).Equal(true)
+			})
+
+			g.It("only authorizes the shared secret of devices w/o an owner in legacy mode", func() {
+				r.LegacySecretAuth = true
+				g.Assert(r.AuthorizeToken("device-id", secret+"device-name", defs.SecurityDeviceTokenPermissionAll)).Equal(false)
+				_, e := r.Exec("UPDATE devices SET owner_digest = '' WHERE id = ?", "device-id")
+				g.Assert(e).Equal(nil)
+				g.Assert(r.AuthorizeToken("device-id", secret+"device-name", defs.SecurityDeviceTokenPermissionAll)).Equal(true)
+			})
+
+			g.It("authorizes account tokens w/ the permission granted to the account", func() {
+				generator = fakeTokenGenerator{"account-token", nil}
+				account, e := r.CreateAccount("account-name")
+				g.Assert(e).Equal(nil)
+				viewer := defs.DeviceTokenPermissions(defs.SecurityDeviceTokenPermissionViewer)
+				g.Assert(r.GrantAccountPermission(account.AccountID, "device-name", viewer)).Equal(nil)
+				g.Assert(r.AuthorizeToken("device-id", "account-token", defs.SecurityDeviceTokenPermissionViewer)).Equal(true)
+				g.Assert(r.AuthorizeToken("device-id", "account-token", defs.SecurityDeviceTokenPermissionAdmin)).Equal(false)
+				g.Assert(r.AuthorizeToken("other-id", "account-token", defs.SecurityDeviceTokenPermissionViewer)).Equal(false)
+			})
+		})
+	})
+}
@@ -0,0 +1,10 @@
+package device
+
+import "github.com/dadleyy/beacon.api/beacon/interchange"
+
+// StateStore defines an interface for remembering the last control message that was successfully sent to each device
+// name, which is used to restore the device after it reconnects.
+type StateStore interface {
+	SaveState(string, interchange.ControlMessage) error
+	FindState(string) (*interchange.ControlMessage, error)
+}
@@ -0,0 +1,13 @@
+package device
+
+import "crypto/hmac"
+import "crypto/sha256"
+import "encoding/hex"
+
+// saltedDigest returns the hex encoded sha256 hmac of the token, keyed by the salt. Stores only persist these digests so
+// that tokens cannot be recovered from the stored data.
+func saltedDigest(salt, token string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -1,17 +1,26 @@
 package device
 
+import "time"
+
 // TokenDetails holds permission information for a given device token.
 type TokenDetails struct {
-	TokenID    string `json:"token_id"`
-	DeviceID   string `json:"device_id"`
-	Token      string `json:"token"`
-	Name       string `json:"name"`
-	Permission uint   `json:"permission"`
+	TokenID    string     `json:"token_id"`
+	DeviceID   string     `json:"device_id"`
+	Token      string     `json:"token"`
+	Name       string     `json:"name"`
+	Permission uint       `json:"permission"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// Expired returns true if the token has an expiry that is not after the time provided.
+func (details TokenDetails) Expired(now time.Time) bool {
+	return details.ExpiresAt != nil && details.ExpiresAt.After(now) != true
 }
 
 // TokenStore defines the interface for creating tokens.
 type TokenStore interface {
-	CreateToken(string, string, uint) (TokenDetails, error)
+	CreateToken(string, string, uint, *time.Time) (TokenDetails, error)
 	ListTokens(string) ([]TokenDetails, error)
 	AuthorizeToken(string, string, uint) bool
+	RemoveToken(string, string) error
 }
@@ -0,0 +1,21 @@
+package net
+
+import "io"
+import "fmt"
+import "net/http"
+
+// EventStream writes server-sent events to the response of a request.
+type EventStream struct {
+	writer  io.Writer
+	flusher http.Flusher
+}
+
+// WriteEvent writes the named event and its data to the response, flushing it to the client immediately.
+func (stream *EventStream) WriteEvent(name string, data []byte) error {
+	if _, e := fmt.Fprintf(stream.writer, "event: %s\ndata: %s\n\n", name, data); e != nil {
+		return e
+	}
+
+	stream.flusher.Flush()
+	return nil
+}
@@ -61,3 +61,21 @@ func (runtime *RequestRuntime) Websocket() (defs.Streamer, error) {
 	responseWriter, request := runtime.responseWriter, runtime.Request
 	return runtime.UpgradeWebsocket(responseWriter, request, nil)
 }
+
+// EventStream writes the headers for a server-sent event response and returns the stream used to send events.
+func (runtime *RequestRuntime) EventStream() (*EventStream, error) {
+	flusher, ok := runtime.responseWriter.(http.Flusher)
+
+	if ok != true {
+		return nil, fmt.Errorf(defs.ErrStreamingUnsupported)
+	}
+
+	header := runtime.responseWriter.Header()
+	header.Set(defs.APIContentTypeHeader, defs.APIEventStreamContentTypeHeader)
+	header.Set("Cache-Control", "no-cache")
+
+	runtime.responseWriter.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return &EventStream{runtime.responseWriter, flusher}, nil
+}
@@ -86,6 +86,25 @@ func Test_RequestRuntime(t *testing.T) {
 			})
 		})
 
+		g.Describe("#EventStream", func() {
+
+			g.It("returns an error if the response is unable to stream", func() {
+				_, e := s.runtime.EventStream()
+				g.Assert(e.Error()).Equal(defs.ErrStreamingUnsupported)
+			})
+
+			g.It("writes the event stream headers and flushes each event", func() {
+				recorder := httptest.NewRecorder()
+				s.runtime.responseWriter = recorder
+				stream, e := s.runtime.EventStream()
+				g.Assert(e).Equal(nil)
+				g.Assert(recorder.Header().Get(defs.APIContentTypeHeader)).Equal(defs.APIEventStreamContentTypeHeader)
+				g.Assert(stream.WriteEvent("welcomed", []byte("{}"))).Equal(nil)
+				g.Assert(recorder.Body.String()).Equal("event: welcomed\ndata: {}\n\n")
+				g.Assert(recorder.Flushed).Equal(true)
+			})
+		})
+
 		g.Describe("#ServerError", func() {
 
 			g.It("returns the error string in the appropriate error response", func() {
@@ -0,0 +1,52 @@
+package presets
+
+import "fmt"
+import "regexp"
+import "math/rand"
+import "encoding/hex"
+
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/interchange"
+
+var (
+	hexColorRegex = regexp.MustCompile("^[0-9a-f]{6}$")
+)
+
+// ParseColor returns a single control frame for the color shorthand provided - either one of the named colors
+// (red, green, blue, off, rand) or a six character hex code.
+func ParseColor(color string) (interchange.ControlFrame, error) {
+	frame := interchange.ControlFrame{}
+
+	switch {
+	case color == "green":
+		frame.Green = defs.ControlFrameMaxColorValue
+	case color == "red":
+		frame.Red = defs.ControlFrameMaxColorValue
+	case color == "blue":
+		frame.Blue = defs.ControlFrameMaxColorValue
+	case color == "rand":
+		frame = interchange.ControlFrame{
+			Red:   randColorValue(),
+			Green: randColorValue(),
+			Blue:  randColorValue(),
+		}
+	case color == "off":
+		break
+	case hexColorRegex.MatchString(color):
+		buff, e := hex.DecodeString(color)
+
+		if e != nil {
+			return frame, fmt.Errorf(defs.ErrInvalidColorShorthand)
+		}
+
+		frame.Red, frame.Green, frame.Blue = uint32(buff[0]), uint32(buff[1]), uint32(buff[2])
+	default:
+		return frame, fmt.Errorf(defs.ErrInvalidColorShorthand)
+	}
+
+	return frame, nil
+}
+
+func randColorValue() uint32 {
+	return uint32(rand.Intn(defs.ControlFrameMaxColorValue))
+}
@@ -0,0 +1,43 @@
+package presets
+
+import "testing"
+import "github.com/franela/goblin"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+
+func Test_ParseColor(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("ParseColor", func() {
+
+		g.It("returns an error for unknown colors", func() {
+			_, e := ParseColor("purple")
+			g.Assert(e.Error()).Equal(defs.ErrInvalidColorShorthand)
+		})
+
+		g.It("returns an error for hex codes that are too long", func() {
+			_, e := ParseColor("ffffff00")
+			g.Assert(e.Error()).Equal(defs.ErrInvalidColorShorthand)
+		})
+
+		g.It("returns a full red frame for \"red\"", func() {
+			f, e := ParseColor("red")
+			g.Assert(e).Equal(nil)
+			g.Assert(f.Red).Equal(uint32(255))
+			g.Assert(f.Green + f.Blue).Equal(uint32(0))
+		})
+
+		g.It("returns an empty frame for \"off\"", func() {
+			f, e := ParseColor("off")
+			g.Assert(e).Equal(nil)
+			g.Assert(f.Red + f.Green + f.Blue).Equal(uint32(0))
+		})
+
+		g.It("decodes each channel of a valid hex code", func() {
+			f, e := ParseColor("ff8001")
+			g.Assert(e).Equal(nil)
+			g.Assert(f.Red).Equal(uint32(255))
+			g.Assert(f.Green).Equal(uint32(128))
+			g.Assert(f.Blue).Equal(uint32(1))
+		})
+	})
+}
@@ -0,0 +1,114 @@
+package presets
+
+import "fmt"
+import "strings"
+
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/interchange"
+
+// Preset is a named animation that expands into a multi-frame control message. Presets that accept a color are
+// addressed as "<name>-<color>" where the color is any value accepted by ParseColor.
+type Preset struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Colored     bool   `json:"colored"`
+	expand      func(interchange.ControlFrame) *interchange.ControlMessage
+}
+
+var library = []Preset{
+	{
+		Name:        "pulse",
+		Description: "fades the color in and out",
+		Colored:     true,
+		expand: func(color interchange.ControlFrame) *interchange.ControlMessage {
+			return sequence(5, fade(color, 500), fade(off(), 500))
+		},
+	},
+	{
+		Name:        "blink",
+		Description: "quickly turns the color on and off",
+		Colored:     true,
+		expand: func(color interchange.ControlFrame) *interchange.ControlMessage {
+			return sequence(10, hold(color, 250), hold(off(), 250))
+		},
+	},
+	{
+		Name:        "breathe",
+		Description: "slowly fades the color in and out",
+		Colored:     true,
+		expand: func(color interchange.ControlFrame) *interchange.ControlMessage {
+			return sequence(10, fade(color, 2000), fade(off(), 2000))
+		},
+	},
+	{
+		Name:        "police",
+		Description: "alternates between red and blue flashes",
+		expand: func(interchange.ControlFrame) *interchange.ControlMessage {
+			red, blue := interchange.ControlFrame{Red: 255}, interchange.ControlFrame{Blue: 255}
+			return sequence(20, hold(red, 150), hold(off(), 50), hold(blue, 150), hold(off(), 50))
+		},
+	},
+	{
+		Name:        "rainbow",
+		Description: "fades through each color of the rainbow",
+		expand: func(interchange.ControlFrame) *interchange.ControlMessage {
+			return sequence(
+				5,
+				fade(interchange.ControlFrame{Red: 255}, 1000),
+				fade(interchange.ControlFrame{Red: 255, Green: 127}, 1000),
+				fade(interchange.ControlFrame{Red: 255, Green: 255}, 1000),
+				fade(interchange.ControlFrame{Green: 255}, 1000),
+				fade(interchange.ControlFrame{Blue: 255}, 1000),
+				fade(interchange.ControlFrame{Red: 75, Blue: 130}, 1000),
+			)
+		},
+	},
+}
+
+// List returns the presets available to every device.
+func List() []Preset {
+	return append([]Preset{}, library...)
+}
+
+// Find expands the preset addressed by the name provided into the control message that should be sent to the device.
+func Find(name string) (*interchange.ControlMessage, error) {
+	for _, preset := range library {
+		if preset.Colored != true && preset.Name == name {
+			return preset.expand(off()), nil
+		}
+
+		prefix := fmt.Sprintf("%s-", preset.Name)
+
+		if preset.Colored != true || strings.HasPrefix(name, prefix) != true {
+			continue
+		}
+
+		color, e := ParseColor(strings.TrimPrefix(name, prefix))
+
+		if e != nil {
+			return nil, e
+		}
+
+		return preset.expand(color), nil
+	}
+
+	return nil, fmt.Errorf(defs.ErrNotFound)
+}
+
+func sequence(loops uint32, frames ...*interchange.ControlFrame) *interchange.ControlMessage {
+	return &interchange.ControlMessage{Frames: frames, LoopCount: loops}
+}
+
+func fade(color interchange.ControlFrame, duration uint32) *interchange.ControlFrame {
+	color.Duration, color.Transition = duration, interchange.ControlFrameTransition_FADE
+	return &color
+}
+
+func hold(color interchange.ControlFrame, duration uint32) *interchange.ControlFrame {
+	color.Duration, color.Transition = duration, interchange.ControlFrameTransition_INSTANT
+	return &color
+}
+
+func off() interchange.ControlFrame {
+	return interchange.ControlFrame{}
+}
@@ -0,0 +1,79 @@
+package presets
+
+import "testing"
+import "github.com/franela/goblin"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/interchange"
+
+func Test_Library(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("List", func() {
+
+		g.It("returns every preset in the library", func() {
+			g.Assert(len(List())).Equal(len(library))
+		})
+
+		g.It("returns a copy that cannot modify the library", func() {
+			list := List()
+			list[0].Name = "changed"
+			g.Assert(library[0].Name == "changed").Equal(false)
+		})
+	})
+
+	g.Describe("Find", func() {
+
+		g.It("returns not found for unknown presets", func() {
+			_, e := Find("disco")
+			g.Assert(e.Error()).Equal(defs.ErrNotFound)
+		})
+
+		g.It("returns not found for colored presets without a color", func() {
+			_, e := Find("pulse")
+			g.Assert(e.Error()).Equal(defs.ErrNotFound)
+		})
+
+		g.It("returns an error for colored presets w/ an invalid color", func() {
+			_, e := Find("pulse-purple")
+			g.Assert(e.Error()).Equal(defs.ErrInvalidColorShorthand)
+		})
+
+		g.It("expands presets that do not accept a color", func() {
+			m, e := Find("police")
+			g.Assert(e).Equal(nil)
+			g.Assert(len(m.Frames) > 1).Equal(true)
+			g.Assert(m.Frames[0].Red).Equal(uint32(255))
+		})
+
+		g.It("expands colored presets using a named color", func() {
+			m, e := Find("pulse-red")
+			g.Assert(e).Equal(nil)
+			g.Assert(len(m.Frames)).Equal(2)
+			g.Assert(m.LoopCount > 0).Equal(true)
+			g.Assert(m.Frames[0].Red).Equal(uint32(255))
+			g.Assert(m.Frames[0].Transition).Equal(interchange.ControlFrameTransition_FADE)
+		})
+
+		g.It("expands colored presets using a hex color", func() {
+			m, e := Find("breathe-00ff00")
+			g.Assert(e).Equal(nil)
+			g.Assert(m.Frames[0].Green).Equal(uint32(255))
+			g.Assert(m.Frames[0].Duration > 0).Equal(true)
+		})
+
+		g.It("does not modify the frames of the library between expansions", func() {
+			first, _ := Find("blink-red")
+			second, _ := Find("blink-blue")
+			g.Assert(first.Frames[0].Red).Equal(uint32(255))
+			g.Assert(second.Frames[0].Red).Equal(uint32(0))
+		})
+
+		g.It("keeps every preset within the control message limits", func() {
+			for _, preset := range library {
+				m := preset.expand(interchange.ControlFrame{})
+				g.Assert(len(m.Frames) <= defs.ControlMessageMaxFrames).Equal(true)
+				g.Assert(m.LoopCount <= defs.ControlMessageMaxLoopCount).Equal(true)
+			}
+		})
+	})
+}
@@ -0,0 +1,180 @@
+package routes
+
+import "time"
+import "github.com/satori/go.uuid"
+import "github.com/dadleyy/beacon.api/beacon/net"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/device"
+import "github.com/dadleyy/beacon.api/beacon/logging"
+import "github.com/dadleyy/beacon.api/beacon/security"
+
+// NewAccessTokensAPI initializes a new access token api.
+func NewAccessTokensAPI(
+	auth device.TokenStore,
+	index device.Index,
+	revocations device.RevocationStore,
+	key device.AccessTokenKey,
+) *AccessTokensAPI {
+	logger := logging.New(defs.AccessTokensAPILogPrefix, logging.Green)
+	return &AccessTokensAPI{logger, auth, index, revocations, key}
+}
+
+type accessTokenRequest struct {
+	DeviceIDs  []string `json:"device_ids"`
+	Permission uint     `json:"permission"`
+	TTL        uint     `json:"ttl"`
+}
+
+// AccessTokensAPI defines the api for creating and revoking signed access tokens.
+type AccessTokensAPI struct {
+	logging.LeveledLogger
+	device.TokenStore
+	device.Index
+	device.RevocationStore
+	key device.AccessTokenKey
+}
+
+// CreateAccessToken signs a new access token for the devices requested. The token in the request header must be an
+// admin token of every device; access tokens themselves are not accepted so they cannot be used to extend themselves.
+func (tokens *AccessTokensAPI) CreateAccessToken(runtime *net.RequestRuntime) net.HandlerResult {
+	request := accessTokenRequest{}
+
+	if e := runtime.ReadBody(&request); e != nil {
+		tokens.Warnf("received invalid request: %s", e.Error())
+		return runtime.LogicError(defs.ErrInvalidAccessTokenRequest)
+	}
+
+	if len(request.DeviceIDs) == 0 || request.TTL > defs.SecurityAccessTokenMaxTTL {
+		return runtime.LogicError(defs.ErrInvalidAccessTokenRequest)
+	}
+
+	if request.Permission&defs.SecurityDeviceTokenPermissionAll == 0 {
+		tokens.Infof("no permission found - defaulting to viewer")
+		request.Permission = defs.SecurityDeviceTokenPermissionViewer
+	}
+
+	if request.TTL == 0 {
+		request.TTL = defs.SecurityAccessTokenDefaultTTL
+	}
+
+	token := runtime.HeaderValue(defs.APIUserTokenHeader)
+
+	if token == "" || security.IsAccessToken(token) {
+		tokens.Warnf("attempt to create access token w/o an admin token")
+		return runtime.LogicError(defs.ErrInvalidAccessTokenRequest)
+	}
+
+	devices, names := make([]string, 0, len(request.DeviceIDs)), make(map[string]string, len(request.DeviceIDs))
+
+	for _, id := range request.DeviceIDs {
+		registration, e := device.FindAuthorizedDevice(tokens.Index, tokens.TokenStore, id, token, adminPermission)
+
+		if e != nil && e.Error() == defs.ErrUnauthorizedToken {
+			tokens.Warnf("unauthorized attempt to create access token (device: %s)", id)
+			return runtime.LogicError(defs.ErrInvalidAccessTokenRequest)
+		}
+
+		if e != nil {
+			tokens.Warnf("unable to find device (device id: %s): %s", id, e.Error())
+			return runtime.LogicError(defs.ErrNotFound)
+		}
+
+		devices, names[registration.DeviceID] = append(devices, registration.DeviceID), registration.Name
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(time.Duration(request.TTL) * time.Second)
+
+	claims := security.AccessClaims{
+		TokenID:    uuid.NewV4().String(),
+		Devices:    devices,
+		Names:      names,
+		Permission: request.Permission,
+		IssuedAt:   now.Unix(),
+		ExpiresAt:  expiresAt.Unix(),
+	}
+
+	signed, e := tokens.key.SignAccessToken(claims)
+
+	if e != nil {
+		tokens.Errorf("unable to sign access token: %s", e.Error())
+		return runtime.ServerError()
+	}
+
+	details := device.AccessTokenDetails{
+		TokenID:    claims.TokenID,
+		Token:      signed,
+		Devices:    devices,
+		Permission: claims.Permission,
+		ExpiresAt:  time.Unix(claims.ExpiresAt, 0),
+	}
+
+	if e := tokens.SaveAccessToken(details); e != nil {
+		tokens.Errorf("unable to save access token[%s]: %s", claims.TokenID, e.Error())
+		return runtime.ServerError()
+	}
+
+	tokens.Infof("created access token[%s] for %d devices (perm: %b)", claims.TokenID, len(devices), claims.Permission)
+
+	return net.HandlerResult{Results: []device.AccessTokenDetails{details}}
+}
+
+// RevokeAccessToken revokes an access token, rejecting it until it expires. Access tokens may revoke themselves when
+// sent in the request header; any other is revoked by its id (the `token_id` query param) w/ an admin token of every
+// device it was issued for.
+func (tokens *AccessTokensAPI) RevokeAccessToken(runtime *net.RequestRuntime) net.HandlerResult {
+	token := runtime.HeaderValue(defs.APIUserTokenHeader)
+
+	if tokenID := runtime.GetQueryParam("token_id"); tokenID != "" {
+		return tokens.revokeIssued(runtime, tokenID, token)
+	}
+
+	if security.IsAccessToken(token) != true {
+		return runtime.LogicError(defs.ErrInvalidAccessToken)
+	}
+
+	claims, e := tokens.key.ParseAccessToken(token, time.Now())
+
+	if e != nil {
+		tokens.Warnf("unable to revoke access token: %s", e.Error())
+		return runtime.LogicError(e.Error())
+	}
+
+	return tokens.revoke(runtime, claims.TokenID, time.Unix(claims.ExpiresAt, 0))
+}
+
+// revokeIssued revokes the issued access token w/ the given id once the token provided is approved as an admin of
+// every device the access token was issued for. Access tokens are not accepted as the admin token.
+func (tokens *AccessTokensAPI) revokeIssued(runtime *net.RequestRuntime, tokenID, token string) net.HandlerResult {
+	if token == "" || security.IsAccessToken(token) {
+		tokens.Warnf("attempt to revoke access token[%s] w/o an admin token", tokenID)
+		return runtime.LogicError(defs.ErrInvalidAccessTokenRequest)
+	}
+
+	issued, e := tokens.FindAccessToken(tokenID)
+
+	if e != nil {
+		tokens.Warnf("unable to find access token[%s]: %s", tokenID, e.Error())
+		return runtime.LogicError(defs.ErrNotFound)
+	}
+
+	for _, id := range issued.Devices {
+		if tokens.AuthorizeToken(id, token, defs.SecurityDeviceTokenPermissionAdmin) != true {
+			tokens.Warnf("unauthorized attempt to revoke access token[%s] (device: %s)", tokenID, id)
+			return runtime.LogicError(defs.ErrNotFound)
+		}
+	}
+
+	return tokens.revoke(runtime, tokenID, issued.ExpiresAt)
+}
+
+// revoke adds the access token id to the revocation list until its expiry.
+func (tokens *AccessTokensAPI) revoke(runtime *net.RequestRuntime, id string, expiresAt time.Time) net.HandlerResult {
+	if e := tokens.RevocationStore.RevokeAccessToken(id, expiresAt); e != nil {
+		tokens.Errorf("unable to revoke access token[%s]: %s", id, e.Error())
+		return runtime.ServerError()
+	}
+
+	tokens.Infof("revoked access token[%s]", id)
+	return net.HandlerResult{}
+}
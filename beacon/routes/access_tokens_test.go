@@ -0,0 +1,266 @@
+package routes
+
+import "fmt"
+import "time"
+import "bytes"
+import "testing"
+import "net/http/httptest"
+import "github.com/franela/goblin"
+import "github.com/dadleyy/beacon.api/beacon/net"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/device"
+import "github.com/dadleyy/beacon.api/beacon/security"
+
+type accessTokensAPIScaffolding struct {
+	api     *AccessTokensAPI
+	store   *testRouteStore
+	key     *testAccessTokenKey
+	runtime *net.RequestRuntime
+	body    *bytes.Buffer
+}
+
+func (t *accessTokensAPIScaffolding) Reset() {
+	t.store = newTestRouteStore()
+	t.key = &testAccessTokenKey{}
+
+	t.body = bytes.NewBuffer([]byte{})
+
+	t.runtime = &net.RequestRuntime{
+		Request: httptest.NewRequest("POST", "/access-tokens", t.body),
+	}
+
+	t.api = &AccessTokensAPI{
+		LeveledLogger:   newTestRouteLogger(),
+		TokenStore:      t.store,
+		Index:           t.store,
+		RevocationStore: t.store,
+		key:             t.key,
+	}
+}
+
+func Test_AccessTokensAPI(suite *testing.T) {
+	g := goblin.Goblin(suite)
+
+	scaffold := &accessTokensAPIScaffolding{}
+
+	g.Describe("CreateAccessToken", func() {
+		g.BeforeEach(scaffold.Reset)
+
+		g.It("fails without a valid request body", func() {
+			r := scaffold.api.CreateAccessToken(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidAccessTokenRequest)
+		})
+
+		g.It("fails without any devices in the request", func() {
+			scaffold.body.WriteString(`{"device_ids": []}`)
+			r := scaffold.api.CreateAccessToken(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidAccessTokenRequest)
+		})
+
+		g.It("fails w/ a ttl longer than the maximum", func() {
+			scaffold.body.WriteString(fmt.Sprintf(`{"device_ids": ["a"], "ttl": %d}`, defs.SecurityAccessTokenMaxTTL+1))
+			r := scaffold.api.CreateAccessToken(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidAccessTokenRequest)
+		})
+
+		g.Describe("with a valid request body", func() {
+			g.BeforeEach(func() {
+				scaffold.body.WriteString(`{"device_ids": ["first-id", "second-id"], "permission": 2}`)
+			})
+
+			g.It("fails without a token in the header", func() {
+				r := scaffold.api.CreateAccessToken(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidAccessTokenRequest)
+			})
+
+			g.It("fails if the token in the header is itself an access token", func() {
+				scaffold.runtime.Header.Set(defs.APIUserTokenHeader, "header.claims.signature")
+				r := scaffold.api.CreateAccessToken(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidAccessTokenRequest)
+			})
+
+			g.Describe("having found a token in the header", func() {
+				g.BeforeEach(func() {
+					scaffold.runtime.Header.Set(defs.APIUserTokenHeader, "admin-token")
+				})
+
+				g.It("fails if unable to find a device", func() {
+					r := scaffold.api.CreateAccessToken(scaffold.runtime)
+					g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+				})
+
+				g.Describe("having found the devices", func() {
+					g.BeforeEach(func() {
+						scaffold.store.register("first-name", "first-id")
+						scaffold.store.register("second-name", "second-id")
+					})
+
+					g.It("fails if the token is not an admin token of the devices", func() {
+						r := scaffold.api.CreateAccessToken(scaffold.runtime)
+						g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidAccessTokenRequest)
+					})
+
+					g.It("fails if the token is an admin token of only some of the devices", func() {
+						account := scaffold.store.account(map[string]defs.DeviceTokenPermissions{
+							"first-name": defs.SecurityDeviceTokenPermissionAdmin,
+						})
+						scaffold.runtime.Header.Set(defs.APIUserTokenHeader, account.Token)
+						r := scaffold.api.CreateAccessToken(scaffold.runtime)
+						g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidAccessTokenRequest)
+					})
+
+					g.Describe("having authorized the token", func() {
+						g.BeforeEach(func() {
+							account := scaffold.store.account(map[string]defs.DeviceTokenPermissions{
+								"first-name":  defs.SecurityDeviceTokenPermissionAdmin,
+								"second-name": defs.SecurityDeviceTokenPermissionAdmin,
+							})
+							scaffold.runtime.Header.Set(defs.APIUserTokenHeader, account.Token)
+						})
+
+						g.It("fails if unable to save the access token", func() {
+							scaffold.store.errors["SaveAccessToken"] = fmt.Errorf("bad-set")
+							r := scaffold.api.CreateAccessToken(scaffold.runtime)
+							g.Assert(r.Errors[0].Error()).Equal(defs.ErrServerError)
+						})
+
+						g.It("saves the issued access token", func() {
+							scaffold.api.CreateAccessToken(scaffold.runtime)
+							saved, e := scaffold.store.FindAccessToken(scaffold.key.signed[0].TokenID)
+							g.Assert(e).Equal(nil)
+							g.Assert(saved.Devices).Equal([]string{"first-id", "second-id"})
+						})
+
+						g.It("fails if unable to sign the access token", func() {
+							scaffold.key.signErrors = append(scaffold.key.signErrors, fmt.Errorf("bad-sign"))
+							r := scaffold.api.CreateAccessToken(scaffold.runtime)
+							g.Assert(r.Errors[0].Error()).Equal(defs.ErrServerError)
+						})
+
+						g.It("responds w/ the signed access token", func() {
+							r := scaffold.api.CreateAccessToken(scaffold.runtime)
+							g.Assert(len(r.Errors)).Equal(0)
+							results, ok := r.Results.([]device.AccessTokenDetails)
+							g.Assert(ok).Equal(true)
+							g.Assert(results[0].Token).Equal("header.claims.signature")
+							g.Assert(results[0].Devices).Equal([]string{"first-id", "second-id"})
+							g.Assert(results[0].Permission).Equal(uint(2))
+						})
+
+						g.It("signs claims that expire after the default ttl", func() {
+							scaffold.api.CreateAccessToken(scaffold.runtime)
+							claims := scaffold.key.signed[0]
+							g.Assert(claims.ExpiresAt - claims.IssuedAt).Equal(int64(defs.SecurityAccessTokenDefaultTTL))
+						})
+
+						g.It("signs claims w/ the names of the devices", func() {
+							scaffold.api.CreateAccessToken(scaffold.runtime)
+							g.Assert(scaffold.key.signed[0].Names).Equal(map[string]string{
+								"first-id":  "first-name",
+								"second-id": "second-name",
+							})
+						})
+					})
+				})
+			})
+		})
+	})
+
+	g.Describe("RevokeAccessToken", func() {
+		g.BeforeEach(scaffold.Reset)
+
+		g.It("fails without an access token in the header", func() {
+			scaffold.runtime.Header.Set(defs.APIUserTokenHeader, "opaque-token")
+			r := scaffold.api.RevokeAccessToken(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidAccessToken)
+		})
+
+		g.Describe("having found an access token in the header", func() {
+			g.BeforeEach(func() {
+				scaffold.runtime.Header.Set(defs.APIUserTokenHeader, "header.claims.signature")
+				scaffold.key.claims = security.AccessClaims{TokenID: "token-id", ExpiresAt: time.Now().Add(time.Hour).Unix()}
+			})
+
+			g.It("fails if unable to parse the access token", func() {
+				scaffold.key.parseErrors = append(scaffold.key.parseErrors, fmt.Errorf(defs.ErrExpiredAccessToken))
+				r := scaffold.api.RevokeAccessToken(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrExpiredAccessToken)
+			})
+
+			g.It("fails if unable to store the revocation", func() {
+				scaffold.store.errors["RevokeAccessToken"] = fmt.Errorf("bad-set")
+				r := scaffold.api.RevokeAccessToken(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrServerError)
+			})
+
+			g.It("revokes the access token", func() {
+				r := scaffold.api.RevokeAccessToken(scaffold.runtime)
+				g.Assert(len(r.Errors)).Equal(0)
+				revoked, _ := scaffold.store.AccessTokenRevoked("token-id")
+				g.Assert(revoked).Equal(true)
+			})
+		})
+
+		g.Describe("having found a token id in the query", func() {
+			query := func(id string) {
+				scaffold.runtime.Request = httptest.NewRequest("DELETE", "/access-tokens?token_id="+id, nil)
+				scaffold.runtime.Header.Set(defs.APIUserTokenHeader, "admin-token")
+			}
+
+			g.BeforeEach(func() {
+				query("token-id")
+				scaffold.store.register("first-name", "first-id")
+				scaffold.store.register("second-name", "second-id")
+				scaffold.store.SaveAccessToken(device.AccessTokenDetails{
+					TokenID:   "token-id",
+					Devices:   []string{"first-id", "second-id"},
+					ExpiresAt: time.Now().Add(time.Hour),
+				})
+			})
+
+			g.It("fails if the token in the header is itself an access token", func() {
+				scaffold.runtime.Header.Set(defs.APIUserTokenHeader, "header.claims.signature")
+				r := scaffold.api.RevokeAccessToken(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidAccessTokenRequest)
+				revoked, _ := scaffold.store.AccessTokenRevoked("token-id")
+				g.Assert(revoked).Equal(false)
+			})
+
+			g.It("fails if unable to find the issued access token", func() {
+				query("other-token-id")
+				r := scaffold.api.RevokeAccessToken(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+			})
+
+			g.It("fails if the token is not an admin token of the devices", func() {
+				r := scaffold.api.RevokeAccessToken(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+				revoked, _ := scaffold.store.AccessTokenRevoked("token-id")
+				g.Assert(revoked).Equal(false)
+			})
+
+			g.It("fails if the token is an admin token of only some of the devices", func() {
+				account := scaffold.store.account(map[string]defs.DeviceTokenPermissions{
+					"first-name": defs.SecurityDeviceTokenPermissionAdmin,
+				})
+				scaffold.runtime.Header.Set(defs.APIUserTokenHeader, account.Token)
+				r := scaffold.api.RevokeAccessToken(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+				revoked, _ := scaffold.store.AccessTokenRevoked("token-id")
+				g.Assert(revoked).Equal(false)
+			})
+
+			g.It("revokes the issued access token w/ an admin token of every device", func() {
+				account := scaffold.store.account(map[string]defs.DeviceTokenPermissions{
+					"first-name":  defs.SecurityDeviceTokenPermissionAdmin,
+					"second-name": defs.SecurityDeviceTokenPermissionAdmin,
+				})
+				scaffold.runtime.Header.Set(defs.APIUserTokenHeader, account.Token)
+				r := scaffold.api.RevokeAccessToken(scaffold.runtime)
+				g.Assert(len(r.Errors)).Equal(0)
+				revoked, _ := scaffold.store.AccessTokenRevoked("token-id")
+				g.Assert(revoked).Equal(true)
+			})
+		})
+	})
+}
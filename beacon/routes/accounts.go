@@ -0,0 +1,169 @@
+package routes
+
+import "sort"
+import "github.com/dadleyy/beacon.api/beacon/net"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/device"
+import "github.com/dadleyy/beacon.api/beacon/logging"
+
+// NewAccountsAPI returns a new api for creating user accounts and managing the devices they are authorized for.
+func NewAccountsAPI(accounts device.AccountStore, auth device.TokenStore, index device.Index) *AccountsAPI {
+	logger := logging.New(defs.AccountsAPILogPrefix, logging.Green)
+	return &AccountsAPI{logger, accounts, auth, index}
+}
+
+type accountPermissionRequest struct {
+	AccountID  string `json:"account_id"`
+	DeviceID   string `json:"device_id"`
+	Permission uint   `json:"permission"`
+}
+
+// AccountsAPI is the route group responsible for user accounts - a single token that holds a permission bitmask for
+// every device it has been granted access to.
+type AccountsAPI struct {
+	logging.LeveledLogger
+	device.AccountStore
+	device.TokenStore
+	device.Index
+}
+
+// CreateAccount allocates a new user account. The account token is only returned in this response; the account id is
+// shared w/ device admins so they can grant the account permissions to their devices.
+func (accounts *AccountsAPI) CreateAccount(runtime *net.RequestRuntime) net.HandlerResult {
+	request := struct {
+		Name string `json:"name"`
+	}{}
+
+	if e := runtime.ReadBody(&request); e != nil {
+		accounts.Warnf("received invalid request: %s", e.Error())
+		return runtime.LogicError(defs.ErrBadRequestFormat)
+	}
+
+	account, e := accounts.AccountStore.CreateAccount(request.Name)
+
+	if e != nil && e.Error() == defs.ErrInvalidAccountName {
+		return runtime.LogicError(e.Error())
+	}
+
+	if e != nil {
+		accounts.Errorf("unable to create account[%s]: %s", request.Name, e.Error())
+		return runtime.ServerError()
+	}
+
+	accounts.Infof("created account[%s]", account.AccountID)
+
+	return net.HandlerResult{Results: []device.AccountDetails{account}}
+}
+
+// ListDevices returns the devices the account identified by the token in the request header is authorized for, along
+// w/ the permission it was granted to each.
+func (accounts *AccountsAPI) ListDevices(runtime *net.RequestRuntime) net.HandlerResult {
+	token := runtime.HeaderValue(defs.APIUserTokenHeader)
+
+	if token == "" {
+		return runtime.LogicError(defs.ErrNotFound)
+	}
+
+	account, e := accounts.FindAccount(token)
+
+	if e != nil {
+		accounts.Warnf("unable to find account: %s", e.Error())
+		return runtime.LogicError(defs.ErrNotFound)
+	}
+
+	results := make([]device.AccountDevice, 0, len(account.Permissions))
+
+	for name, permission := range account.Permissions {
+		registration, e := accounts.FindDevice(name)
+
+		if e != nil {
+			accounts.Debugf("skipping device[%s] of account[%s]: %s", name, account.AccountID, e.Error())
+			continue
+		}
+
+		results = append(results, device.AccountDevice{RegistrationDetails: registration, Permission: permission})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+
+	return net.HandlerResult{Results: results}
+}
+
+// GrantDevice sets the permission of an account for the name of a device, so the grant carries over to devices that
+// later register w/ the same name. The request must be authorized w/ an admin token of the device.
+func (accounts *AccountsAPI) GrantDevice(runtime *net.RequestRuntime) net.HandlerResult {
+	request := accountPermissionRequest{}
+
+	if e := runtime.ReadBody(&request); e != nil {
+		accounts.Warnf("received invalid request: %s", e.Error())
+		return runtime.LogicError(defs.ErrBadRequestFormat)
+	}
+
+	if request.Permission&defs.SecurityDeviceTokenPermissionAll == 0 {
+		accounts.Infof("no permission found - defaulting to viewer")
+		request.Permission = defs.SecurityDeviceTokenPermissionViewer
+	}
+
+	registration, ok := accounts.authorize(runtime, request)
+
+	if ok != true {
+		return runtime.LogicError(defs.ErrInvalidAccountRequest)
+	}
+
+	permission := defs.DeviceTokenPermissions(request.Permission & defs.SecurityDeviceTokenPermissionAll)
+
+	if e := accounts.GrantAccountPermission(request.AccountID, registration.Name, permission); e != nil {
+		accounts.Warnf("unable to grant account[%s] device[%s]: %s", request.AccountID, registration.Name, e.Error())
+		return runtime.LogicError(defs.ErrNotFound)
+	}
+
+	accounts.Infof("granted account[%s] device[%s] (permission: %b)", request.AccountID, registration.Name, permission)
+	return net.HandlerResult{}
+}
+
+// RevokeDevice removes the permission of the account in the query string for the device in the query string. The
+// request must be authorized w/ an admin token of the device.
+func (accounts *AccountsAPI) RevokeDevice(runtime *net.RequestRuntime) net.HandlerResult {
+	request := accountPermissionRequest{
+		AccountID: runtime.GetQueryParam("account_id"),
+		DeviceID:  runtime.GetQueryParam("device_id"),
+	}
+
+	registration, ok := accounts.authorize(runtime, request)
+
+	if ok != true {
+		return runtime.LogicError(defs.ErrInvalidAccountRequest)
+	}
+
+	if e := accounts.RevokeAccountPermission(request.AccountID, registration.Name); e != nil {
+		accounts.Warnf("unable to revoke account[%s] device[%s]: %s", request.AccountID, registration.Name, e.Error())
+		return runtime.LogicError(defs.ErrNotFound)
+	}
+
+	accounts.Infof("revoked account[%s] device[%s]", request.AccountID, registration.Name)
+	return net.HandlerResult{}
+}
+
+// authorize returns the device of the request if the token in the request header is an admin token of it.
+func (accounts *AccountsAPI) authorize(
+	runtime *net.RequestRuntime,
+	request accountPermissionRequest,
+) (device.RegistrationDetails, bool) {
+	token := runtime.HeaderValue(defs.APIUserTokenHeader)
+
+	if token == "" || request.AccountID == "" || request.DeviceID == "" {
+		return device.RegistrationDetails{}, false
+	}
+
+	query := request.DeviceID
+	registration, e := device.FindAuthorizedDevice(accounts.Index, accounts.TokenStore, query, token, adminPermission)
+
+	if e != nil {
+		accounts.Warnf("unable to authorize account[%s] device[%s]: %s", request.AccountID, request.DeviceID, e.Error())
+		return device.RegistrationDetails{}, false
+	}
+
+	return registration, true
+}
@@ -0,0 +1,192 @@
+package routes
+
+import "fmt"
+import "bytes"
+import "testing"
+import "net/http/httptest"
+import "github.com/franela/goblin"
+import "github.com/dadleyy/beacon.api/beacon/net"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/device"
+
+type accountsAPIScaffolding struct {
+	api     *AccountsAPI
+	store   *testRouteStore
+	runtime *net.RequestRuntime
+	body    *bytes.Buffer
+}
+
+func (t *accountsAPIScaffolding) Reset() {
+	t.store = newTestRouteStore()
+
+	t.body = bytes.NewBuffer([]byte{})
+
+	t.runtime = &net.RequestRuntime{
+		Request: httptest.NewRequest("POST", "/accounts", t.body),
+	}
+
+	t.api = &AccountsAPI{
+		LeveledLogger: newTestRouteLogger(),
+		AccountStore:  t.store,
+		TokenStore:    t.store,
+		Index:         t.store,
+	}
+}
+
+func Test_AccountsAPI(suite *testing.T) {
+	g := goblin.Goblin(suite)
+
+	scaffold := &accountsAPIScaffolding{}
+
+	g.Describe("CreateAccount", func() {
+		g.BeforeEach(scaffold.Reset)
+
+		g.It("fails without a valid request body", func() {
+			r := scaffold.api.CreateAccount(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrBadRequestFormat)
+		})
+
+		g.It("fails w/ the error of the store if the name is invalid", func() {
+			scaffold.body.WriteString(`{"name": "a"}`)
+			r := scaffold.api.CreateAccount(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidAccountName)
+		})
+
+		g.It("fails w/ a server error if unable to create the account", func() {
+			scaffold.body.WriteString(`{"name": "some-account"}`)
+			scaffold.store.errors["CreateAccount"] = fmt.Errorf("bad-create")
+			r := scaffold.api.CreateAccount(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrServerError)
+		})
+
+		g.It("responds w/ the account and its token", func() {
+			scaffold.body.WriteString(`{"name": "some-account"}`)
+			r := scaffold.api.CreateAccount(scaffold.runtime)
+			results, ok := r.Results.([]device.AccountDetails)
+			g.Assert(ok).Equal(true)
+			account, e := scaffold.store.FindAccount(results[0].Token)
+			g.Assert(e).Equal(nil)
+			g.Assert(account.AccountID).Equal(results[0].AccountID)
+		})
+	})
+
+	g.Describe("ListDevices", func() {
+		g.BeforeEach(scaffold.Reset)
+
+		g.It("fails without a token in the header", func() {
+			r := scaffold.api.ListDevices(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+		})
+
+		g.It("fails if unable to find the account", func() {
+			scaffold.runtime.Header.Set(defs.APIUserTokenHeader, "account-token")
+			r := scaffold.api.ListDevices(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+		})
+
+		g.It("responds w/ the devices of the account along w/ their permissions", func() {
+			scaffold.store.register("light", "device-id")
+			account := scaffold.store.account(map[string]defs.DeviceTokenPermissions{"light": 3})
+			scaffold.runtime.Header.Set(defs.APIUserTokenHeader, account.Token)
+			r := scaffold.api.ListDevices(scaffold.runtime)
+			results, ok := r.Results.([]device.AccountDevice)
+			g.Assert(ok).Equal(true)
+			g.Assert(len(results)).Equal(1)
+			g.Assert(results[0].DeviceID).Equal("device-id")
+			g.Assert(results[0].Permission).Equal(defs.DeviceTokenPermissions(3))
+		})
+
+		g.It("skips devices that can no longer be found", func() {
+			account := scaffold.store.account(map[string]defs.DeviceTokenPermissions{"light": 3})
+			scaffold.runtime.Header.Set(defs.APIUserTokenHeader, account.Token)
+			r := scaffold.api.ListDevices(scaffold.runtime)
+			results, _ := r.Results.([]device.AccountDevice)
+			g.Assert(len(results)).Equal(0)
+		})
+	})
+
+	g.Describe("GrantDevice", func() {
+		var account device.AccountDetails
+
+		g.BeforeEach(func() {
+			scaffold.Reset()
+			account = scaffold.store.account(nil)
+		})
+
+		g.It("fails without a valid request body", func() {
+			r := scaffold.api.GrantDevice(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrBadRequestFormat)
+		})
+
+		g.Describe("with a valid request body", func() {
+			var owner string
+
+			g.BeforeEach(func() {
+				body := `{"account_id": "%s", "device_id": "device-id", "permission": 2}`
+				scaffold.body.WriteString(fmt.Sprintf(body, account.AccountID))
+				owner = scaffold.store.register("device-name", "device-id")
+			})
+
+			g.It("fails if the token is not an admin token of the device", func() {
+				viewer := scaffold.store.token("device-id", defs.SecurityDeviceTokenPermissionViewer)
+				scaffold.runtime.Header.Set(defs.APIUserTokenHeader, viewer)
+				r := scaffold.api.GrantDevice(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidAccountRequest)
+			})
+
+			g.It("fails if unable to grant the permission", func() {
+				scaffold.runtime.Header.Set(defs.APIUserTokenHeader, owner)
+				scaffold.store.errors["GrantAccountPermission"] = fmt.Errorf("not-found")
+				r := scaffold.api.GrantDevice(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+			})
+
+			g.It("grants the permission to the account", func() {
+				scaffold.runtime.Header.Set(defs.APIUserTokenHeader, owner)
+				r := scaffold.api.GrantDevice(scaffold.runtime)
+				g.Assert(len(r.Errors)).Equal(0)
+				found, _ := scaffold.store.FindAccount(account.Token)
+				g.Assert(found.Permissions["device-name"]).Equal(defs.DeviceTokenPermissions(2))
+			})
+		})
+	})
+
+	g.Describe("RevokeDevice", func() {
+		var account device.AccountDetails
+
+		g.BeforeEach(func() {
+			scaffold.Reset()
+			account = scaffold.store.account(nil)
+		})
+
+		g.It("fails without an account and device in the query string", func() {
+			scaffold.runtime.Header.Set(defs.APIUserTokenHeader, "admin-token")
+			r := scaffold.api.RevokeDevice(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidAccountRequest)
+		})
+
+		g.Describe("with an account and device in the query string", func() {
+			g.BeforeEach(func() {
+				query := fmt.Sprintf("/account-devices?account_id=%s&device_id=device-id", account.AccountID)
+				scaffold.runtime = &net.RequestRuntime{
+					Request: httptest.NewRequest("DELETE", query, scaffold.body),
+				}
+				owner := scaffold.store.register("device-name", "device-id")
+				scaffold.runtime.Header.Set(defs.APIUserTokenHeader, owner)
+			})
+
+			g.It("fails if the account was not granted the device", func() {
+				r := scaffold.api.RevokeDevice(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+			})
+
+			g.It("revokes the permission of the account", func() {
+				scaffold.store.GrantAccountPermission(account.AccountID, "device-name", 2)
+				r := scaffold.api.RevokeDevice(scaffold.runtime)
+				g.Assert(len(r.Errors)).Equal(0)
+				found, _ := scaffold.store.FindAccount(account.Token)
+				g.Assert(len(found.Permissions)).Equal(0)
+			})
+		})
+	})
+}
@@ -0,0 +1,143 @@
+package routes
+
+import "fmt"
+import "bytes"
+import "strings"
+import "github.com/golang/protobuf/proto"
+
+import "github.com/dadleyy/beacon.api/beacon/net"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/device"
+import "github.com/dadleyy/beacon.api/beacon/logging"
+import "github.com/dadleyy/beacon.api/beacon/interchange"
+
+// commandSender is implemented by the route groups that send control messages, logging the errors of their commands.
+type commandSender interface {
+	logging.LeveledLogger
+	device.CommandStore
+}
+
+type controlFrameRequest struct {
+	Red        uint32 `json:"red"`
+	Green      uint32 `json:"green"`
+	Blue       uint32 `json:"blue"`
+	Duration   uint32 `json:"duration"`
+	Transition string `json:"transition"`
+}
+
+// parseControlMessage validates the list of frames received from the client and converts them into a control message.
+func parseControlMessage(requests []controlFrameRequest, loopCount uint32) (*interchange.ControlMessage, error) {
+	if len(requests) > defs.ControlMessageMaxFrames || loopCount > defs.ControlMessageMaxLoopCount {
+		return nil, fmt.Errorf(defs.ErrInvalidControlFrames)
+	}
+
+	frames := make([]*interchange.ControlFrame, 0, len(requests))
+
+	for _, r := range requests {
+		if r.Red > defs.ControlFrameMaxColorValue || r.Green > defs.ControlFrameMaxColorValue {
+			return nil, fmt.Errorf(defs.ErrInvalidControlFrames)
+		}
+
+		if r.Blue > defs.ControlFrameMaxColorValue || r.Duration > defs.ControlFrameMaxDuration {
+			return nil, fmt.Errorf(defs.ErrInvalidControlFrames)
+		}
+
+		transition := interchange.ControlFrameTransition_INSTANT
+
+		if r.Transition != "" {
+			value, ok := interchange.ControlFrameTransition_value[strings.ToUpper(r.Transition)]
+
+			if ok != true {
+				return nil, fmt.Errorf(defs.ErrInvalidControlFrameTransition)
+			}
+
+			transition = interchange.ControlFrameTransition(value)
+		}
+
+		frames = append(frames, &interchange.ControlFrame{
+			Red:        r.Red,
+			Green:      r.Green,
+			Blue:       r.Blue,
+			Duration:   r.Duration,
+			Transition: transition,
+		})
+	}
+
+	return &interchange.ControlMessage{Frames: frames, LoopCount: loopCount}, nil
+}
+
+// sendControlMessage creates a command for the device that can be used to track the delivery of the control message,
+// publishing the message w/ the command's id. The command is marked as failed if the message could not be published.
+// Published commands are recorded in the command log of the device along w/ the route they were sent from; messages
+// sent to a group (non-empty group id) are logged as sent w/ the token of that group.
+func sendControlMessage(
+	runtime *net.RequestRuntime,
+	commands commandSender,
+	details device.RegistrationDetails,
+	token, groupID string,
+	control *interchange.ControlMessage,
+) (device.CommandDetails, error) {
+	command, e := commands.CreateCommand(details.DeviceID, token)
+
+	if e != nil {
+		return device.CommandDetails{}, e
+	}
+
+	if e := publishControlMessage(runtime, details.DeviceID, command.CommandID, control); e != nil {
+		if e := commands.UpdateCommandStatus(command.CommandID, defs.CommandStatusFailed); e != nil {
+			commands.Errorf("unable to mark command[%s] as failed: %s", command.CommandID, e.Error())
+		}
+
+		return device.CommandDetails{}, e
+	}
+
+	entry := device.CommandLogEntry{
+		CommandID:  command.CommandID,
+		DeviceID:   details.DeviceID,
+		DeviceName: details.Name,
+		Route:      fmt.Sprintf("%s %s", runtime.Method, runtime.URL.Path),
+		Message:    control,
+		CreatedAt:  command.CreatedAt,
+	}
+
+	if groupID != "" {
+		entry.Credential, entry.CredentialID = defs.SecurityCredentialGroupToken, groupID
+	}
+
+	if e := commands.LogCommand(token, entry); e != nil {
+		commands.Errorf("unable to log command[%s]: %s", command.CommandID, e.Error())
+	}
+
+	return command, nil
+}
+
+// publishControlMessage wraps the control message in a device message addressed to the device id and publishes it onto
+// the device control channel.
+func publishControlMessage(
+	runtime *net.RequestRuntime,
+	deviceID, commandID string,
+	control *interchange.ControlMessage,
+) error {
+	commandData, e := proto.Marshal(control)
+
+	if e != nil {
+		return e
+	}
+
+	message := interchange.DeviceMessage{
+		Type: interchange.DeviceMessageType_CONTROL,
+		Authentication: &interchange.DeviceMessageAuthentication{
+			DeviceID: deviceID,
+		},
+		Payload:   commandData,
+		CommandID: commandID,
+	}
+
+	data, e := proto.Marshal(&message)
+
+	if e != nil {
+		return e
+	}
+
+	return runtime.PublishReader(defs.DeviceControlChannelName, bytes.NewBuffer(data))
+}
@@ -0,0 +1,148 @@
+package routes
+
+import "strings"
+import "encoding/json"
+
+import "github.com/dadleyy/beacon.api/beacon/bg"
+import "github.com/dadleyy/beacon.api/beacon/net"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/device"
+import "github.com/dadleyy/beacon.api/beacon/logging"
+
+// NewDeviceEventsAPI returns a new initialized device events api.
+func NewDeviceEventsAPI(index device.Index, auth device.TokenStore, events bg.EventSubscriber) *DeviceEventsAPI {
+	logger := logging.New(defs.DeviceEventsAPILogPrefix, logging.Cyan)
+
+	return &DeviceEventsAPI{
+		LeveledLogger: logger,
+		Index:         index,
+		TokenStore:    auth,
+		events:        events,
+	}
+}
+
+// DeviceEventsAPI is the route group that streams the lifecycle events of a device to its viewers.
+type DeviceEventsAPI struct {
+	logging.LeveledLogger
+	device.Index
+	device.TokenStore
+	events bg.EventSubscriber
+}
+
+// StreamEvents subscribes to the events of the device and writes them to the client as they are published, over a
+// websocket when the request asks for an upgrade and as server-sent events otherwise.
+func (api *DeviceEventsAPI) StreamEvents(runtime *net.RequestRuntime) net.HandlerResult {
+	// Browsers are unable to set headers on websocket & event source requests; allow the token in the query as well.
+	token := runtime.HeaderValue(defs.APIUserTokenHeader)
+
+	if token == "" {
+		token = runtime.GetQueryParam("token")
+	}
+
+	query := runtime.GetQueryParam("device_id")
+	details, e := device.FindAuthorizedDevice(api.Index, api.TokenStore, query, token, viewerPermission)
+
+	if e != nil {
+		api.Warnf("unable to authorize event stream of device[%s]: %s", query, e.Error())
+		return runtime.LogicError(defs.ErrNotFound)
+	}
+
+	if strings.EqualFold(runtime.Header.Get("Upgrade"), "websocket") {
+		return api.streamWebsocket(runtime, details.Name)
+	}
+
+	stream, e := runtime.EventStream()
+
+	if e != nil {
+		api.Warnf("unable to open event stream: %s", e.Error())
+		return runtime.LogicError(e.Error())
+	}
+
+	subscription := api.events.Subscribe(details.Name)
+	defer api.events.Unsubscribe(subscription)
+
+	api.Infof("streaming events of device[%s]", details.Name)
+
+	api.stream(subscription, runtime.Request.Context().Done(), func(event bg.DeviceEvent, data []byte) error {
+		return stream.WriteEvent(event.Type, data)
+	})
+
+	return net.HandlerResult{NoRender: true}
+}
+
+func (api *DeviceEventsAPI) streamWebsocket(runtime *net.RequestRuntime, name string) net.HandlerResult {
+	connection, e := runtime.Websocket()
+
+	if e != nil {
+		api.Warnf("unable to upgrade websocket: %s", e.Error())
+		return runtime.LogicError(e.Error())
+	}
+
+	defer connection.Close()
+
+	subscription := api.events.Subscribe(name)
+	defer api.events.Unsubscribe(subscription)
+
+	api.Infof("streaming events of device[%s] over websocket", name)
+
+	// Nothing is expected from the client; read until the connection is closed so we know when to stop streaming.
+	closed := make(chan struct{})
+
+	go func() {
+		defer close(closed)
+
+		for {
+			if _, _, e := connection.NextReader(); e != nil {
+				return
+			}
+		}
+	}()
+
+	api.stream(subscription, closed, func(_ bg.DeviceEvent, data []byte) error {
+		writer, e := connection.NextWriter(defs.TextWriter)
+
+		if e != nil {
+			return e
+		}
+
+		if _, e := writer.Write(data); e != nil {
+			writer.Close()
+			return e
+		}
+
+		return writer.Close()
+	})
+
+	return net.HandlerResult{NoRender: true}
+}
+
+// stream sends each event received on the subscription until the subscription is closed, the done channel is closed or
+// the send function fails.
+func (api *DeviceEventsAPI) stream(
+	subscription *bg.EventSubscription,
+	done <-chan struct{},
+	send func(bg.DeviceEvent, []byte) error,
+) {
+	for {
+		select {
+		case event, ok := <-subscription.Events:
+			if ok != true {
+				return
+			}
+
+			data, e := json.Marshal(event)
+
+			if e != nil {
+				api.Errorf("unable to encode %s event: %s", event.Type, e.Error())
+				continue
+			}
+
+			if e := send(event, data); e != nil {
+				api.Warnf("unable to send %s event: %s", event.Type, e.Error())
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
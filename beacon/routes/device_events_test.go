@@ -0,0 +1,128 @@
+package routes
+
+import "bytes"
+import "testing"
+import "net/http/httptest"
+
+import "github.com/franela/goblin"
+import "github.com/dadleyy/beacon.api/beacon/bg"
+import "github.com/dadleyy/beacon.api/beacon/net"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+
+type deviceEventsAPIScaffolding struct {
+	api      *DeviceEventsAPI
+	store    *testRouteStore
+	events   *testEventSubscriber
+	upgrader *testWebsocketUpgrader
+	runtime  *net.RequestRuntime
+}
+
+func prepareDeviceEventsAPIScaffolding() deviceEventsAPIScaffolding {
+	store := newTestRouteStore()
+	events := testEventSubscriber{events: make(chan bg.DeviceEvent, 1)}
+	upgrader := testWebsocketUpgrader{}
+
+	api := DeviceEventsAPI{
+		LeveledLogger: newTestRouteLogger(),
+		Index:         store,
+		TokenStore:    store,
+		events:        &events,
+	}
+
+	request := httptest.NewRequest("GET", "/device-events?device_id=some-device", bytes.NewBuffer([]byte{}))
+
+	runtime := net.RequestRuntime{
+		Request:           request,
+		WebsocketUpgrader: &upgrader,
+	}
+
+	return deviceEventsAPIScaffolding{
+		api:      &api,
+		store:    store,
+		events:   &events,
+		upgrader: &upgrader,
+		runtime:  &runtime,
+	}
+}
+
+func Test_DeviceEventsAPI(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("StreamEvents", func() {
+		var scaffold deviceEventsAPIScaffolding
+
+		g.BeforeEach(func() {
+			scaffold = prepareDeviceEventsAPIScaffolding()
+		})
+
+		g.It("returns a not-found error if unable to find the device", func() {
+			r := scaffold.api.StreamEvents(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+		})
+
+		g.Describe("having found the device", func() {
+			g.BeforeEach(func() {
+				scaffold.store.register("desk-lamp", "some-device")
+			})
+
+			g.It("returns a not-found error without a token", func() {
+				r := scaffold.api.StreamEvents(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+				g.Assert(len(scaffold.events.subscribed)).Equal(0)
+			})
+
+			g.It("returns a not-found error if the token is not authorized to view the device", func() {
+				scaffold.runtime.Header.Set(defs.APIUserTokenHeader, "some-token")
+				r := scaffold.api.StreamEvents(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+			})
+
+			g.It("authorizes a viewer token sent in the query", func() {
+				token := scaffold.store.token("some-device", defs.SecurityDeviceTokenPermissionViewer)
+				query := "/device-events?device_id=some-device&token=" + token
+				scaffold.runtime.Request = httptest.NewRequest("GET", query, nil)
+				r := scaffold.api.StreamEvents(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrStreamingUnsupported)
+			})
+
+			g.Describe("having authorized the token", func() {
+				g.BeforeEach(func() {
+					token := scaffold.store.token("some-device", defs.SecurityDeviceTokenPermissionViewer)
+					scaffold.runtime.Header.Set(defs.APIUserTokenHeader, token)
+				})
+
+				g.It("returns an error if the response is unable to stream events", func() {
+					r := scaffold.api.StreamEvents(scaffold.runtime)
+					g.Assert(r.Errors[0].Error()).Equal(defs.ErrStreamingUnsupported)
+					g.Assert(len(scaffold.events.subscribed)).Equal(0)
+				})
+
+				g.Describe("when asked to upgrade to a websocket", func() {
+					var connection *testWebsocketConnection
+
+					g.BeforeEach(func() {
+						scaffold.runtime.Header.Set("Upgrade", "websocket")
+						connection = &testWebsocketConnection{open: make(chan struct{})}
+						scaffold.upgrader.connections = []*testWebsocketConnection{connection}
+					})
+
+					g.AfterEach(func() {
+						close(connection.open)
+					})
+
+					g.It("writes the events of the device to the connection until the subscription closes", func() {
+						scaffold.events.events <- bg.DeviceEvent{Type: defs.DeviceEventWelcomed, DeviceName: "desk-lamp"}
+						close(scaffold.events.events)
+						r := scaffold.api.StreamEvents(scaffold.runtime)
+						g.Assert(r.NoRender).Equal(true)
+						g.Assert(scaffold.events.subscribed).Equal([]string{"desk-lamp"})
+						g.Assert(scaffold.events.unsubscribed).Equal(1)
+						g.Assert(len(connection.written)).Equal(1)
+						g.Assert(bytes.Contains([]byte(connection.written[0]), []byte(`"type":"welcomed"`))).Equal(true)
+						g.Assert(connection.closeCount).Equal(1)
+					})
+				})
+			})
+		})
+	})
+}
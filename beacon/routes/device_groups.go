@@ -0,0 +1,154 @@
+package routes
+
+import "fmt"
+import "github.com/dadleyy/beacon.api/beacon/net"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/device"
+import "github.com/dadleyy/beacon.api/beacon/logging"
+
+// NewDeviceGroupsAPI returns a new api for creating device groups and managing their members.
+func NewDeviceGroupsAPI(groups device.GroupStore, auth device.TokenStore, index device.Index) *DeviceGroupsAPI {
+	logger := logging.New(defs.DeviceGroupsAPILogPrefix, logging.Green)
+	return &DeviceGroupsAPI{logger, groups, auth, index}
+}
+
+type groupMemberRequest struct {
+	DeviceID    string `json:"device_id"`
+	DeviceToken string `json:"device_token"`
+}
+
+// DeviceGroupsAPI is the route group responsible for device groups - named collections of devices that can be sent a
+// single control message together.
+type DeviceGroupsAPI struct {
+	logging.LeveledLogger
+	device.GroupStore
+	device.TokenStore
+	device.Index
+}
+
+// CreateGroup allocates a new device group. The group token is only returned in this response and is required to add
+// members to the group and to send it messages.
+func (groups *DeviceGroupsAPI) CreateGroup(runtime *net.RequestRuntime) net.HandlerResult {
+	request := struct {
+		Name string `json:"name"`
+	}{}
+
+	if e := runtime.ReadBody(&request); e != nil {
+		groups.Warnf("received invalid request: %s", e.Error())
+		return runtime.LogicError(defs.ErrBadRequestFormat)
+	}
+
+	group, e := groups.GroupStore.CreateGroup(request.Name)
+
+	if e != nil && (e.Error() == defs.ErrInvalidGroupName || e.Error() == defs.ErrDuplicateGroupName) {
+		groups.Warnf("unable to create group[%s]: %s", request.Name, e.Error())
+		return runtime.LogicError(e.Error())
+	}
+
+	if e != nil {
+		groups.Errorf("unable to create group[%s]: %s", request.Name, e.Error())
+		return runtime.ServerError()
+	}
+
+	groups.Infof("created device group[%s]", group.GroupID)
+
+	return net.HandlerResult{Results: []device.GroupDetails{group}}
+}
+
+// ListGroups returns the ids & names of the device groups. Members are left out since the request is not authorized w/
+// any group token.
+func (groups *DeviceGroupsAPI) ListGroups(runtime *net.RequestRuntime) net.HandlerResult {
+	results, e := groups.GroupStore.ListGroups()
+
+	if e != nil {
+		groups.Errorf("unable to list device groups: %s", e.Error())
+		return runtime.ServerError()
+	}
+
+	for i := range results {
+		results[i].Devices = nil
+	}
+
+	return net.HandlerResult{Results: results}
+}
+
+// AddMember adds a device to the group. The request must be authorized w/ the group token and include a token for the
+// device that has permission to control it.
+func (groups *DeviceGroupsAPI) AddMember(runtime *net.RequestRuntime) net.HandlerResult {
+	request := groupMemberRequest{}
+
+	if e := runtime.ReadBody(&request); e != nil {
+		groups.Warnf("received invalid request: %s", e.Error())
+		return runtime.LogicError(defs.ErrBadRequestFormat)
+	}
+
+	group, e := groups.authorize(runtime)
+
+	if e != nil {
+		return runtime.LogicError(e.Error())
+	}
+
+	query, token := request.DeviceID, request.DeviceToken
+	registration, e := device.FindAuthorizedDevice(groups.Index, groups.TokenStore, query, token, controllerPermission)
+
+	if e != nil {
+		groups.Warnf("unable to authorize device[%s] for group[%s]: %s", request.DeviceID, group.GroupID, e.Error())
+		return runtime.LogicError(defs.ErrNotFound)
+	}
+
+	if e := groups.AddGroupDevice(group.GroupID, registration.Name); e != nil {
+		groups.Errorf("unable to add device[%s] to group[%s]: %s", registration.Name, group.GroupID, e.Error())
+		return runtime.ServerError()
+	}
+
+	groups.Infof("added device[%s] to group[%s]", registration.Name, group.GroupID)
+
+	group.Devices = append(group.Devices, registration.Name)
+
+	return net.HandlerResult{Results: []device.GroupDetails{group}}
+}
+
+// RemoveMember removes the device name provided in the query string from the group.
+func (groups *DeviceGroupsAPI) RemoveMember(runtime *net.RequestRuntime) net.HandlerResult {
+	name := runtime.GetQueryParam("device_id")
+
+	if name == "" {
+		return runtime.LogicError(defs.ErrInvalidDeviceID)
+	}
+
+	group, e := groups.authorize(runtime)
+
+	if e != nil {
+		return runtime.LogicError(e.Error())
+	}
+
+	if e := groups.RemoveGroupDevice(group.GroupID, name); e != nil {
+		groups.Warnf("unable to remove device[%s] from group[%s]: %s", name, group.GroupID, e.Error())
+		return runtime.LogicError(defs.ErrNotFound)
+	}
+
+	groups.Infof("removed device[%s] from group[%s]", name, group.GroupID)
+
+	return net.HandlerResult{}
+}
+
+// authorize finds the group from the url params and verifies the group token in the request headers.
+func (groups *DeviceGroupsAPI) authorize(runtime *net.RequestRuntime) (device.GroupDetails, error) {
+	id := runtime.Get("group")
+
+	group, e := groups.FindGroup(id)
+
+	if e != nil {
+		groups.Warnf("unable to find group (group id: %s): %s", id, e.Error())
+		return device.GroupDetails{}, fmt.Errorf(defs.ErrNotFound)
+	}
+
+	token := runtime.HeaderValue(defs.APIUserTokenHeader)
+
+	if token == "" || groups.AuthorizeGroup(group.GroupID, token) != true {
+		groups.Warnf("unauthorized attempt to manage group (group: %s)", group.GroupID)
+		return device.GroupDetails{}, fmt.Errorf(defs.ErrNotFound)
+	}
+
+	return group, nil
+}
@@ -0,0 +1,206 @@
+package routes
+
+import "fmt"
+import "bytes"
+import "testing"
+import "net/url"
+import "net/http/httptest"
+import "github.com/franela/goblin"
+
+import "github.com/dadleyy/beacon.api/beacon/net"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/device"
+
+type deviceGroupsAPIScaffolding struct {
+	api     *DeviceGroupsAPI
+	store   *testRouteStore
+	group   device.GroupDetails
+	runtime *net.RequestRuntime
+	body    *bytes.Buffer
+}
+
+func (s *deviceGroupsAPIScaffolding) Reset() {
+	s.store = newTestRouteStore()
+	s.group = device.GroupDetails{GroupID: "group-id"}
+	s.body = bytes.NewBuffer([]byte{})
+
+	s.api = &DeviceGroupsAPI{
+		LeveledLogger: newTestRouteLogger(),
+		GroupStore:    s.store,
+		TokenStore:    s.store,
+		Index:         s.store,
+	}
+
+	s.withRequest("/device-groups/group-id/devices")
+}
+
+func (s *deviceGroupsAPIScaffolding) withRequest(url string) {
+	s.runtime = &net.RequestRuntime{
+		Request: httptest.NewRequest("GET", url, s.body),
+		Values:  map[string][]string{"group": {s.group.GroupID}},
+	}
+}
+
+func (s *deviceGroupsAPIScaffolding) authorize() {
+	s.group, _ = s.store.CreateGroup("office")
+	s.runtime.Values = map[string][]string{"group": {s.group.GroupID}}
+	s.runtime.Header.Set(defs.APIUserTokenHeader, s.group.Token)
+}
+
+func Test_DeviceGroupsAPI(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	scaffold := &deviceGroupsAPIScaffolding{}
+
+	g.Describe("CreateGroup", func() {
+		g.BeforeEach(scaffold.Reset)
+
+		g.It("fails without a valid json body", func() {
+			r := scaffold.api.CreateGroup(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrBadRequestFormat)
+		})
+
+		g.It("returns the validation error from the store", func() {
+			scaffold.store.CreateGroup("office")
+			scaffold.body.WriteString("{\"name\": \"office\"}")
+			r := scaffold.api.CreateGroup(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrDuplicateGroupName)
+		})
+
+		g.It("returns a server error if the store fails otherwise", func() {
+			scaffold.body.WriteString("{\"name\": \"office\"}")
+			scaffold.store.errors["CreateGroup"] = fmt.Errorf("bad-create")
+			r := scaffold.api.CreateGroup(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrServerError)
+		})
+
+		g.It("returns the group along w/ its token", func() {
+			scaffold.body.WriteString("{\"name\": \"office\"}")
+			r := scaffold.api.CreateGroup(scaffold.runtime)
+			g.Assert(len(r.Errors)).Equal(0)
+			list, ok := r.Results.([]device.GroupDetails)
+			g.Assert(ok).Equal(true)
+			g.Assert(scaffold.store.AuthorizeGroup(list[0].GroupID, list[0].Token)).Equal(true)
+		})
+	})
+
+	g.Describe("ListGroups", func() {
+		g.BeforeEach(scaffold.Reset)
+
+		g.It("returns a server error if unable to list the groups", func() {
+			scaffold.store.errors["ListGroups"] = fmt.Errorf("bad-list")
+			r := scaffold.api.ListGroups(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrServerError)
+		})
+
+		g.It("returns the groups from the store w/o their members", func() {
+			group, _ := scaffold.store.CreateGroup("office")
+			scaffold.store.AddGroupDevice(group.GroupID, "device-name")
+			r := scaffold.api.ListGroups(scaffold.runtime)
+			list, ok := r.Results.([]device.GroupDetails)
+			g.Assert(ok).Equal(true)
+			g.Assert(len(list)).Equal(1)
+			g.Assert(len(list[0].Devices)).Equal(0)
+		})
+	})
+
+	g.Describe("AddMember", func() {
+		g.BeforeEach(scaffold.Reset)
+
+		member := func(token string) {
+			scaffold.body.WriteString(fmt.Sprintf(`{"device_id": "desk-lamp", "device_token": "%s"}`, token))
+		}
+
+		g.It("fails without a valid json body", func() {
+			r := scaffold.api.AddMember(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrBadRequestFormat)
+		})
+
+		g.It("fails if the group does not exist", func() {
+			member("device-token")
+			r := scaffold.api.AddMember(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+		})
+
+		g.It("fails if the group token is not authorized", func() {
+			member("device-token")
+			scaffold.authorize()
+			scaffold.runtime.Header.Set(defs.APIUserTokenHeader, "other-group-token")
+			r := scaffold.api.AddMember(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+		})
+
+		g.It("fails if unable to find the device", func() {
+			member("device-token")
+			scaffold.authorize()
+			r := scaffold.api.AddMember(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+		})
+
+		g.Describe("having found the device", func() {
+			var owner string
+
+			g.BeforeEach(func() {
+				scaffold.authorize()
+				owner = scaffold.store.register("desk-lamp", "device-id")
+			})
+
+			g.It("fails if the device token is not authorized to control the device", func() {
+				member(scaffold.store.token("device-id", defs.SecurityDeviceTokenPermissionViewer))
+				r := scaffold.api.AddMember(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+			})
+
+			g.It("returns a server error if unable to add the device", func() {
+				member(owner)
+				scaffold.store.errors["AddGroupDevice"] = fmt.Errorf("bad-add")
+				r := scaffold.api.AddMember(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrServerError)
+			})
+
+			g.It("adds the device name to the group", func() {
+				member(scaffold.store.token("device-id", defs.SecurityDeviceTokenPermissionController))
+				r := scaffold.api.AddMember(scaffold.runtime)
+				g.Assert(len(r.Errors)).Equal(0)
+				group, _ := scaffold.store.FindGroup(scaffold.group.GroupID)
+				g.Assert(group.Devices).Equal([]string{"desk-lamp"})
+			})
+		})
+	})
+
+	g.Describe("RemoveMember", func() {
+		g.BeforeEach(scaffold.Reset)
+
+		g.It("fails without a device id", func() {
+			r := scaffold.api.RemoveMember(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidDeviceID)
+		})
+
+		g.Describe("with a device id", func() {
+			g.BeforeEach(func() {
+				query := url.Values{"device_id": {"desk-lamp"}}
+				scaffold.withRequest("/device-groups/group-id/devices?" + query.Encode())
+			})
+
+			g.It("fails if the group token is not authorized", func() {
+				r := scaffold.api.RemoveMember(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+			})
+
+			g.It("fails if the device is not a member of the group", func() {
+				scaffold.authorize()
+				r := scaffold.api.RemoveMember(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+			})
+
+			g.It("succeeds after removing the device", func() {
+				scaffold.authorize()
+				scaffold.store.AddGroupDevice(scaffold.group.GroupID, "desk-lamp")
+				r := scaffold.api.RemoveMember(scaffold.runtime)
+				g.Assert(len(r.Errors)).Equal(0)
+				group, _ := scaffold.store.FindGroup(scaffold.group.GroupID)
+				g.Assert(len(group.Devices)).Equal(0)
+			})
+		})
+	})
+}
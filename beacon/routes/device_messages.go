@@ -1,7 +1,6 @@
 package routes
 
-import "bytes"
-import "github.com/golang/protobuf/proto"
+import "strconv"
 
 import "github.com/dadleyy/beacon.api/beacon/net"
 import "github.com/dadleyy/beacon.api/beacon/defs"
@@ -10,80 +9,192 @@ import "github.com/dadleyy/beacon.api/beacon/logging"
 import "github.com/dadleyy/beacon.api/beacon/interchange"
 
 // NewDeviceMessagesAPI returns a new api for creating device messages.
-func NewDeviceMessagesAPI(index device.Index, auth device.TokenStore) *DeviceMessages {
+func NewDeviceMessagesAPI(
+	index device.Index,
+	auth device.TokenStore,
+	groups device.GroupStore,
+	commands device.CommandStore,
+) *DeviceMessages {
 	logger := logging.New(defs.DeviceMessagesAPILogPrefix, logging.Green)
 
 	return &DeviceMessages{
 		LeveledLogger: logger,
 		TokenStore:    auth,
 		Index:         index,
+		GroupStore:    groups,
+		CommandStore:  commands,
 	}
 }
 
+type deviceMessageRequest struct {
+	DeviceID  string                `json:"device_id"`
+	GroupID   string                `json:"group_id"`
+	Red       uint32                `json:"red"`
+	Green     uint32                `json:"green"`
+	Blue      uint32                `json:"blue"`
+	Frames    []controlFrameRequest `json:"frames"`
+	LoopCount uint32                `json:"loop_count"`
+}
+
+// control returns the control message for the request. Requests without a list of frames are treated as a single,
+// solid color.
+func (request *deviceMessageRequest) control() (*interchange.ControlMessage, error) {
+	frames := request.Frames
+
+	if len(frames) == 0 {
+		frames = []controlFrameRequest{{Red: request.Red, Green: request.Green, Blue: request.Blue}}
+	}
+
+	return parseControlMessage(frames, request.LoopCount)
+}
+
 // DeviceMessages is the route group that handles creating device messages
 type DeviceMessages struct {
 	logging.LeveledLogger
 	device.TokenStore
 	device.Index
+	device.GroupStore
+	device.CommandStore
 }
 
 // CreateMessage publishes a new DeviceMessage to the control stream
 func (messages *DeviceMessages) CreateMessage(runtime *net.RequestRuntime) net.HandlerResult {
-	message := struct {
-		DeviceID string `json:"device_id"`
-		Red      uint32 `json:"red"`
-		Green    uint32 `json:"green"`
-		Blue     uint32 `json:"blue"`
-	}{}
+	message := deviceMessageRequest{}
 
 	if e := runtime.ReadBody(&message); e != nil {
 		return runtime.LogicError(defs.ErrBadRequestFormat)
 	}
 
-	details, e := messages.FindDevice(message.DeviceID)
+	control, e := message.control()
 
 	if e != nil {
-		messages.Warnf("unable to locate device: %v", message.DeviceID)
-		return runtime.LogicError(defs.ErrNotFound)
+		messages.Warnf("invalid frames received for device[%s]: %s", message.DeviceID, e.Error())
+		return runtime.LogicError(e.Error())
 	}
 
-	token := runtime.HeaderValue(defs.APIUserTokenHeader)
+	query, token := message.DeviceID, runtime.HeaderValue(defs.APIUserTokenHeader)
+	details, e := device.FindAuthorizedDevice(messages.Index, messages.TokenStore, query, token, controllerPermission)
 
-	if token == "" || messages.AuthorizeToken(details.DeviceID, token, controllerPermission) != true {
-		messages.Warnf("unauthorized attempt to control device (token: %s, device: %s)", token, details.DeviceID)
+	if e != nil {
+		messages.Warnf("unable to authorize control of device[%s]: %s", message.DeviceID, e.Error())
 		return runtime.LogicError(defs.ErrNotFound)
 	}
 
 	messages.Debugf("creating device message for[%s]: %v", message.DeviceID, message)
 
-	commandData, e := proto.Marshal(&interchange.ControlMessage{
-		Frames: []*interchange.ControlFrame{
-			&interchange.ControlFrame{
-				Red:   message.Red,
-				Green: message.Green,
-				Blue:  message.Blue,
-			},
-		},
-	})
+	command, e := sendControlMessage(runtime, messages, details, token, "", control)
 
 	if e != nil {
 		return net.HandlerResult{Errors: []error{e}}
 	}
 
-	deviceMessage := interchange.DeviceMessage{
-		Type: interchange.DeviceMessageType_CONTROL,
-		Authentication: &interchange.DeviceMessageAuthentication{
-			DeviceID: details.DeviceID,
-		},
-		Payload: commandData,
+	return net.HandlerResult{Results: []device.CommandDetails{command}}
+}
+
+// ListMessages returns a page of the command log of a device, newest first. Reading the log requires an admin token.
+func (messages *DeviceMessages) ListMessages(runtime *net.RequestRuntime) net.HandlerResult {
+	query, token := runtime.GetQueryParam("device_id"), runtime.HeaderValue(defs.APIUserTokenHeader)
+	details, e := device.FindAuthorizedDevice(messages.Index, messages.TokenStore, query, token, adminPermission)
+
+	if e != nil {
+		messages.Warnf("unable to authorize command log of device[%s]: %s", query, e.Error())
+		return runtime.LogicError(defs.ErrNotFound)
+	}
+
+	page, e := strconv.Atoi(runtime.GetQueryParam("page"))
+
+	if e != nil || page < 1 {
+		page = 1
+	}
+
+	count, e := strconv.Atoi(runtime.GetQueryParam("count"))
+
+	if e != nil || count < 1 || count > defs.MaxCommandLogPageSize {
+		count = defs.DefaultCommandLogPageSize
 	}
 
-	data, e := proto.Marshal(&deviceMessage)
+	entries, e := messages.ListCommandLog(details.Name, (page-1)*count, count)
 
 	if e != nil {
-		return net.HandlerResult{Errors: []error{e}}
+		messages.Errorf("unable to load command log of device[%s]: %s", details.Name, e.Error())
+		return runtime.ServerError()
+	}
+
+	return net.HandlerResult{
+		Results:  entries,
+		Metadata: map[string]interface{}{"page": page, "count": count},
 	}
+}
+
+// FindMessage returns the delivery status of a message previously created w/ the same token.
+func (messages *DeviceMessages) FindMessage(runtime *net.RequestRuntime) net.HandlerResult {
+	id, token := runtime.Get("id"), runtime.HeaderValue(defs.APIUserTokenHeader)
+
+	if token == "" || messages.AuthorizeCommand(id, token) != true {
+		messages.Warnf("unauthorized attempt to read command (command: %s)", id)
+		return runtime.LogicError(defs.ErrNotFound)
+	}
+
+	command, e := messages.FindCommand(id)
+
+	if e != nil {
+		messages.Warnf("unable to load command[%s]: %s", id, e.Error())
+		return runtime.LogicError(defs.ErrNotFound)
+	}
+
+	return net.HandlerResult{Results: []device.CommandDetails{command}}
+}
+
+// CreateGroupMessage publishes the same control message to every connected member of a device group. Members that are
+// not currently connected are skipped and a command is returned for each device that was sent the message.
+func (messages *DeviceMessages) CreateGroupMessage(runtime *net.RequestRuntime) net.HandlerResult {
+	message := deviceMessageRequest{}
+
+	if e := runtime.ReadBody(&message); e != nil {
+		return runtime.LogicError(defs.ErrBadRequestFormat)
+	}
+
+	control, e := message.control()
+
+	if e != nil {
+		messages.Warnf("invalid frames received for group[%s]: %s", message.GroupID, e.Error())
+		return runtime.LogicError(e.Error())
+	}
+
+	group, e := messages.FindGroup(message.GroupID)
+
+	if e != nil {
+		messages.Warnf("unable to locate group: %v", message.GroupID)
+		return runtime.LogicError(defs.ErrNotFound)
+	}
+
+	token := runtime.HeaderValue(defs.APIUserTokenHeader)
+
+	if token == "" || messages.AuthorizeGroup(group.GroupID, token) != true {
+		messages.Warnf("unauthorized attempt to control group (group: %s)", group.GroupID)
+		return runtime.LogicError(defs.ErrNotFound)
+	}
+
+	delivered := make([]device.CommandDetails, 0, len(group.Devices))
+
+	for _, name := range group.Devices {
+		details, e := messages.FindDevice(name)
+
+		if e != nil {
+			messages.Debugf("skipping disconnected group[%s] member: %s", group.GroupID, name)
+			continue
+		}
+
+		command, e := sendControlMessage(runtime, messages, details, token, group.GroupID, control)
+
+		if e != nil {
+			return net.HandlerResult{Errors: []error{e}}
+		}
+
+		delivered = append(delivered, command)
+	}
+
+	messages.Infof("sent group[%s] message to %d of %d devices", group.GroupID, len(delivered), len(group.Devices))
 
-	runtime.PublishReader(defs.DeviceControlChannelName, bytes.NewBuffer(data))
-	return net.HandlerResult{}
+	return net.HandlerResult{Results: delivered}
 }
@@ -4,13 +4,18 @@ import "log"
 import "fmt"
 import "bytes"
 import "testing"
+import "strings"
+import "net/url"
+import "io/ioutil"
 import "net/http/httptest"
 
 import "github.com/franela/goblin"
+import "github.com/golang/protobuf/proto"
 import "github.com/dadleyy/beacon.api/beacon/net"
 import "github.com/dadleyy/beacon.api/beacon/defs"
 import "github.com/dadleyy/beacon.api/beacon/device"
 import "github.com/dadleyy/beacon.api/beacon/logging"
+import "github.com/dadleyy/beacon.api/beacon/interchange"
 
 func newDeviceMessagesAPILogger() *logging.Logger {
 	out := bytes.NewBuffer([]byte{})
@@ -21,53 +26,37 @@ func newDeviceMessagesAPILogger() *logging.Logger {
 
 type testDeviceMessagesAPIScaffolding struct {
 	api       *DeviceMessages
-	internals *testDeviceMessagesAPIInternals
+	store     *testRouteStore
+	publisher *testChannelPublisher
 	runtime   *net.RequestRuntime
 	body      *bytes.Buffer
 }
 
-type testDeviceMessagesAPIInternals struct {
-	authorized    bool
-	createdTokens []device.TokenDetails
-	foundTokens   []device.TokenDetails
-	foundDevices  []device.RegistrationDetails
-	removalErrors []error
-}
+func (s *testDeviceMessagesAPIScaffolding) publishedDeviceMessage() (interchange.DeviceMessage, error) {
+	message := interchange.DeviceMessage{}
 
-func (t *testDeviceMessagesAPIInternals) RemoveDevice(string) error {
-	if len(t.removalErrors) >= 1 {
-		return t.removalErrors[0]
+	if len(s.publisher.published) != 1 {
+		return message, fmt.Errorf("expected a single published message")
 	}
 
-	return nil
-}
+	data, e := ioutil.ReadAll(s.publisher.published[0])
 
-func (t *testDeviceMessagesAPIInternals) FindDevice(string) (device.RegistrationDetails, error) {
-	if len(t.foundDevices) >= 1 {
-		return t.foundDevices[0], nil
+	if e != nil {
+		return message, e
 	}
 
-	return device.RegistrationDetails{}, fmt.Errorf("not-found")
+	return message, proto.Unmarshal(data, &message)
 }
 
-func (t *testDeviceMessagesAPIInternals) CreateToken(string, string, uint) (device.TokenDetails, error) {
-	if len(t.createdTokens) >= 1 {
-		return t.createdTokens[0], nil
-	}
-
-	return device.TokenDetails{}, fmt.Errorf("not-found")
-}
+func (s *testDeviceMessagesAPIScaffolding) publishedControlMessage() (interchange.ControlMessage, error) {
+	control := interchange.ControlMessage{}
+	message, e := s.publishedDeviceMessage()
 
-func (t *testDeviceMessagesAPIInternals) ListTokens(string) ([]device.TokenDetails, error) {
-	if len(t.foundTokens) >= 1 {
-		return t.foundTokens, nil
+	if e != nil {
+		return control, e
 	}
 
-	return nil, fmt.Errorf("not-found")
-}
-
-func (t *testDeviceMessagesAPIInternals) AuthorizeToken(string, string, uint) bool {
-	return t.authorized
+	return control, proto.Unmarshal(message.GetPayload(), &control)
 }
 
 func Test_DeviceMessagesAPI(t *testing.T) {
@@ -77,17 +66,13 @@ func Test_DeviceMessagesAPI(t *testing.T) {
 		var scaffold testDeviceMessagesAPIScaffolding
 
 		g.BeforeEach(func() {
-			internals := &testDeviceMessagesAPIInternals{
-				createdTokens: make([]device.TokenDetails, 0),
-				foundTokens:   make([]device.TokenDetails, 0),
-				foundDevices:  make([]device.RegistrationDetails, 0),
-				removalErrors: make([]error, 0),
-			}
+			store := newTestRouteStore()
 
 			api := &DeviceMessages{
 				LeveledLogger: newDeviceMessagesAPILogger(),
-				TokenStore:    internals,
-				Index:         internals,
+				TokenStore:    store,
+				Index:         store,
+				CommandStore:  store,
 			}
 
 			body := bytes.NewBuffer([]byte{})
@@ -98,7 +83,8 @@ func Test_DeviceMessagesAPI(t *testing.T) {
 
 			scaffold = testDeviceMessagesAPIScaffolding{
 				api:       api,
-				internals: internals,
+				store:     store,
+				publisher: &publisher,
 				body:      body,
 				runtime: &net.RequestRuntime{
 					Request:          request,
@@ -123,10 +109,8 @@ func Test_DeviceMessagesAPI(t *testing.T) {
 			})
 
 			g.Describe("when a device was found successfully", func() {
-				device := device.RegistrationDetails{}
-
 				g.BeforeEach(func() {
-					scaffold.internals.foundDevices = append(scaffold.internals.foundDevices, device)
+					scaffold.store.register("desk-lamp", "123")
 				})
 
 				g.It("should fail when no authorization header was present", func() {
@@ -140,14 +124,334 @@ func Test_DeviceMessagesAPI(t *testing.T) {
 					g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
 				})
 
-				g.It("succeeds if authorized w/ valid body", func() {
-					scaffold.internals.authorized = true
-					scaffold.runtime.Header.Set(defs.APIUserTokenHeader, "some-token")
+				g.It("fails w/ a token that is only allowed to view the device", func() {
+					token := scaffold.store.token("123", defs.SecurityDeviceTokenPermissionViewer)
+					scaffold.runtime.Header.Set(defs.APIUserTokenHeader, token)
 					r := scaffold.api.CreateMessage(scaffold.runtime)
+					g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+					g.Assert(len(scaffold.publisher.published)).Equal(0)
+				})
+
+				g.Describe("having authorized the token", func() {
+					g.BeforeEach(func() {
+						token := scaffold.store.token("123", defs.SecurityDeviceTokenPermissionController)
+						scaffold.runtime.Header.Set(defs.APIUserTokenHeader, token)
+					})
+
+					g.It("succeeds if authorized w/ valid body", func() {
+						r := scaffold.api.CreateMessage(scaffold.runtime)
+						g.Assert(len(r.Errors)).Equal(0)
+						control, e := scaffold.publishedControlMessage()
+						g.Assert(e).Equal(nil)
+						g.Assert(len(control.Frames)).Equal(1)
+					})
+
+					g.It("returns the command that was published w/ the message", func() {
+						r := scaffold.api.CreateMessage(scaffold.runtime)
+						message, e := scaffold.publishedDeviceMessage()
+						g.Assert(e).Equal(nil)
+						command, e := scaffold.store.FindCommand(message.CommandID)
+						g.Assert(e).Equal(nil)
+						g.Assert(r.Results).Equal([]device.CommandDetails{command})
+					})
+
+					g.It("logs the command that was published w/ the route it was sent from", func() {
+						r := scaffold.api.CreateMessage(scaffold.runtime)
+						commands := r.Results.([]device.CommandDetails)
+						logged, _ := scaffold.store.ListCommandLog("desk-lamp", 0, defs.DefaultCommandLogPageSize)
+						g.Assert(len(logged)).Equal(1)
+						g.Assert(logged[0].Route).Equal("GET /device-messages")
+						g.Assert(logged[0].CommandID).Equal(commands[0].CommandID)
+					})
+
+					g.It("returns the command even if unable to log it", func() {
+						scaffold.store.errors["LogCommand"] = fmt.Errorf("bad-log")
+						r := scaffold.api.CreateMessage(scaffold.runtime)
+						g.Assert(len(r.Errors)).Equal(0)
+						g.Assert(len(r.Results.([]device.CommandDetails))).Equal(1)
+					})
+
+					g.It("fails w/o publishing if unable to create the command", func() {
+						scaffold.store.errors["CreateCommand"] = fmt.Errorf("bad-create")
+						r := scaffold.api.CreateMessage(scaffold.runtime)
+						g.Assert(r.Errors[0].Error()).Equal("bad-create")
+						g.Assert(len(scaffold.publisher.published)).Equal(0)
+					})
+				})
+			})
+		})
+
+		g.Describe("with a list of frames in the json body", func() {
+			g.BeforeEach(func() {
+				owner := scaffold.store.register("desk-lamp", "123")
+				scaffold.runtime.Header.Set(defs.APIUserTokenHeader, owner)
+			})
+
+			g.It("fails when given more frames than are allowed", func() {
+				frames := strings.Repeat("{\"red\": 255},", defs.ControlMessageMaxFrames)
+				scaffold.body.Write([]byte("{\"device_id\": \"123\", \"frames\": [" + frames + "{}]}"))
+				r := scaffold.api.CreateMessage(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidControlFrames)
+			})
+
+			g.It("fails when a frame has an invalid color value", func() {
+				scaffold.body.Write([]byte("{\"device_id\": \"123\", \"frames\": [{\"red\": 256}]}"))
+				r := scaffold.api.CreateMessage(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidControlFrames)
+			})
+
+			g.It("fails when a frame has an unknown transition", func() {
+				scaffold.body.Write([]byte("{\"device_id\": \"123\", \"frames\": [{\"transition\": \"wipe\"}]}"))
+				r := scaffold.api.CreateMessage(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidControlFrameTransition)
+			})
+
+			g.It("fails when the loop count is too large", func() {
+				scaffold.body.Write([]byte("{\"device_id\": \"123\", \"frames\": [{}], \"loop_count\": 1000}"))
+				r := scaffold.api.CreateMessage(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidControlFrames)
+			})
+
+			g.It("publishes every frame w/ its timing and transition", func() {
+				frames := "{\"red\": 255, \"duration\": 500, \"transition\": \"fade\"}, {\"duration\": 500}"
+				scaffold.body.Write([]byte("{\"device_id\": \"123\", \"loop_count\": 3, \"frames\": [" + frames + "]}"))
+				r := scaffold.api.CreateMessage(scaffold.runtime)
+				g.Assert(len(r.Errors)).Equal(0)
+				control, e := scaffold.publishedControlMessage()
+				g.Assert(e).Equal(nil)
+				g.Assert(control.LoopCount).Equal(uint32(3))
+				g.Assert(len(control.Frames)).Equal(2)
+				g.Assert(control.Frames[0].Red).Equal(uint32(255))
+				g.Assert(control.Frames[0].Duration).Equal(uint32(500))
+				g.Assert(control.Frames[0].Transition).Equal(interchange.ControlFrameTransition_FADE)
+				g.Assert(control.Frames[1].Transition).Equal(interchange.ControlFrameTransition_INSTANT)
+			})
+		})
+
+	})
+
+	g.Describe("CreateGroupMessage", func() {
+		var api *DeviceMessages
+		var store *testRouteStore
+		var publisher *testChannelPublisher
+		var runtime *net.RequestRuntime
+		var body *bytes.Buffer
+
+		g.BeforeEach(func() {
+			store = newTestRouteStore()
+			publisher = &testChannelPublisher{}
+			body = bytes.NewBuffer([]byte{})
+
+			api = &DeviceMessages{
+				LeveledLogger: newDeviceMessagesAPILogger(),
+				TokenStore:    store,
+				Index:         store,
+				GroupStore:    store,
+				CommandStore:  store,
+			}
+
+			runtime = &net.RequestRuntime{
+				Request:          httptest.NewRequest("POST", "/group-messages", body),
+				ChannelPublisher: publisher,
+			}
+		})
+
+		g.It("fails if it is unable to read the body of the request reasonably", func() {
+			r := api.CreateGroupMessage(runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrBadRequestFormat)
+		})
+
+		g.It("fails when given invalid frames", func() {
+			body.Write([]byte("{\"group_id\": \"office\", \"frames\": [{\"red\": 256}]}"))
+			r := api.CreateGroupMessage(runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidControlFrames)
+		})
+
+		g.Describe("with a valid json body", func() {
+			g.BeforeEach(func() {
+				body.Write([]byte("{\"group_id\": \"office\", \"red\": 255}"))
+			})
+
+			g.It("fails when unable to find the group", func() {
+				r := api.CreateGroupMessage(runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+			})
+
+			g.Describe("when the group was found successfully", func() {
+				var group device.GroupDetails
+
+				g.BeforeEach(func() {
+					group, _ = store.CreateGroup("office")
+					store.AddGroupDevice(group.GroupID, "desk")
+					store.AddGroupDevice(group.GroupID, "hall")
+				})
+
+				g.It("fails when no authorization header was present", func() {
+					r := api.CreateGroupMessage(runtime)
+					g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+				})
+
+				g.It("fails when the group token is not authorized", func() {
+					runtime.Header.Set(defs.APIUserTokenHeader, "some-token")
+					r := api.CreateGroupMessage(runtime)
+					g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+					g.Assert(len(publisher.published)).Equal(0)
+				})
+
+				g.It("publishes a message to every connected member", func() {
+					store.register("desk", "desk-id")
+					store.register("hall", "hall-id")
+					runtime.Header.Set(defs.APIUserTokenHeader, group.Token)
+					r := api.CreateGroupMessage(runtime)
+					g.Assert(len(r.Errors)).Equal(0)
+					commands := r.Results.([]device.CommandDetails)
+					g.Assert(len(commands)).Equal(2)
+					g.Assert(commands[0].DeviceID).Equal("desk-id")
+					g.Assert(commands[1].DeviceID).Equal("hall-id")
+					g.Assert(len(publisher.published)).Equal(2)
+				})
+
+				g.It("logs the commands as sent w/ the token of the group", func() {
+					store.register("desk", "desk-id")
+					runtime.Header.Set(defs.APIUserTokenHeader, group.Token)
+					api.CreateGroupMessage(runtime)
+					logged, _ := store.ListCommandLog("desk", 0, defs.DefaultCommandLogPageSize)
+					g.Assert(len(logged)).Equal(1)
+					g.Assert(logged[0].Credential).Equal(defs.SecurityCredentialGroupToken)
+					g.Assert(logged[0].CredentialID).Equal(group.GroupID)
+				})
+
+				g.It("skips members that are not connected", func() {
+					store.register("hall", "hall-id")
+					runtime.Header.Set(defs.APIUserTokenHeader, group.Token)
+					r := api.CreateGroupMessage(runtime)
 					g.Assert(len(r.Errors)).Equal(0)
+					commands := r.Results.([]device.CommandDetails)
+					g.Assert(len(commands)).Equal(1)
+					g.Assert(commands[0].DeviceID).Equal("hall-id")
+					g.Assert(len(publisher.published)).Equal(1)
 				})
 			})
 		})
+	})
+	g.Describe("FindMessage", func() {
+		var api *DeviceMessages
+		var store *testRouteStore
+		var command device.CommandDetails
+		var runtime *net.RequestRuntime
+
+		g.BeforeEach(func() {
+			store = newTestRouteStore()
+			command, _ = store.CreateCommand("device-id", "some-token")
+
+			api = &DeviceMessages{
+				LeveledLogger: newDeviceMessagesAPILogger(),
+				CommandStore:  store,
+			}
+
+			values := make(url.Values)
+			values.Set("id", command.CommandID)
+
+			runtime = &net.RequestRuntime{
+				Request: httptest.NewRequest("GET", "/device-messages/"+command.CommandID, nil),
+				Values:  values,
+			}
+		})
+
+		g.It("fails without a token header", func() {
+			r := api.FindMessage(runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+		})
+
+		g.It("fails if the token did not create the command", func() {
+			runtime.Header.Set(defs.APIUserTokenHeader, "other-token")
+			r := api.FindMessage(runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+		})
+
+		g.It("returns the command if authorized", func() {
+			runtime.Header.Set(defs.APIUserTokenHeader, "some-token")
+			r := api.FindMessage(runtime)
+			g.Assert(len(r.Errors)).Equal(0)
+			g.Assert(r.Results).Equal([]device.CommandDetails{command})
+		})
+	})
+
+	g.Describe("ListMessages", func() {
+		var api *DeviceMessages
+		var store *testRouteStore
+		var runtime *net.RequestRuntime
 
+		g.BeforeEach(func() {
+			store = newTestRouteStore()
+
+			api = &DeviceMessages{
+				LeveledLogger: newDeviceMessagesAPILogger(),
+				TokenStore:    store,
+				Index:         store,
+				CommandStore:  store,
+			}
+
+			runtime = &net.RequestRuntime{
+				Request: httptest.NewRequest("GET", "/device-messages?device_id=device-id&page=3&count=10", nil),
+			}
+		})
+
+		g.It("fails when unable to find the device", func() {
+			r := api.ListMessages(runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+		})
+
+		g.Describe("when a device was found successfully", func() {
+			var owner string
+
+			g.BeforeEach(func() {
+				owner = store.register("desk-lamp", "device-id")
+
+				for i := 0; i < 25; i++ {
+					store.LogCommand("", device.CommandLogEntry{CommandID: fmt.Sprintf("command-%d", i), DeviceName: "desk-lamp"})
+				}
+			})
+
+			g.It("fails without a token header", func() {
+				r := api.ListMessages(runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+			})
+
+			g.It("fails if the token is not an admin token of the device", func() {
+				token := store.token("device-id", defs.SecurityDeviceTokenPermissionController)
+				runtime.Header.Set(defs.APIUserTokenHeader, token)
+				r := api.ListMessages(runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+			})
+
+			g.Describe("having authorized successfully", func() {
+				g.BeforeEach(func() {
+					runtime.Header.Set(defs.APIUserTokenHeader, owner)
+				})
+
+				g.It("returns the requested page of the command log", func() {
+					r := api.ListMessages(runtime)
+					g.Assert(len(r.Errors)).Equal(0)
+					entries := r.Results.([]device.CommandLogEntry)
+					g.Assert(len(entries)).Equal(5)
+					g.Assert(entries[0].CommandID).Equal("command-4")
+					g.Assert(r.Metadata["page"]).Equal(3)
+				})
+
+				g.It("uses the default page size when given an invalid count", func() {
+					runtime.Request = httptest.NewRequest("GET", "/device-messages?device_id=device-id&count=5000", nil)
+					runtime.Header.Set(defs.APIUserTokenHeader, owner)
+					r := api.ListMessages(runtime)
+					g.Assert(r.Metadata["count"]).Equal(defs.DefaultCommandLogPageSize)
+				})
+
+				g.It("returns a server error if unable to load the log", func() {
+					store.errors["ListCommandLog"] = fmt.Errorf("bad-list")
+					r := api.ListMessages(runtime)
+					g.Assert(r.Errors[0].Error()).Equal(defs.ErrServerError)
+				})
+			})
+		})
 	})
 }
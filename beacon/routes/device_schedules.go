@@ -0,0 +1,172 @@
+package routes
+
+import "fmt"
+import "time"
+import "github.com/dadleyy/beacon.api/beacon/net"
+import "github.com/dadleyy/beacon.api/beacon/cron"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/device"
+import "github.com/dadleyy/beacon.api/beacon/logging"
+
+// NewDeviceSchedulesAPI returns a new api for creating and managing scheduled device messages.
+func NewDeviceSchedulesAPI(store device.ScheduleStore, auth device.TokenStore, index device.Index) *DeviceSchedulesAPI {
+	logger := logging.New(defs.DeviceSchedulesAPILogPrefix, logging.Green)
+	return &DeviceSchedulesAPI{logger, store, auth, index}
+}
+
+type scheduleRequest struct {
+	deviceMessageRequest
+	RunAt *time.Time `json:"run_at"`
+	Cron  string     `json:"cron"`
+}
+
+// DeviceSchedulesAPI is the route group responsible for control messages that are sent to devices at a later time,
+// either once ("run_at") or on a recurring cron expression evaluated in the server's time zone ("cron").
+type DeviceSchedulesAPI struct {
+	logging.LeveledLogger
+	device.ScheduleStore
+	device.TokenStore
+	device.Index
+}
+
+// ListSchedules returns the schedules for the device id provided in the query string.
+func (api *DeviceSchedulesAPI) ListSchedules(runtime *net.RequestRuntime) net.HandlerResult {
+	id := runtime.GetQueryParam("device_id")
+
+	registration, e := api.authorize(runtime, id, defs.SecurityDeviceTokenPermissionViewer)
+
+	if e != nil {
+		return runtime.LogicError(e.Error())
+	}
+
+	results, e := api.ScheduleStore.ListSchedules(registration.Name)
+
+	if e != nil {
+		api.Errorf("unable to list schedules for device[%s]: %s", registration.Name, e.Error())
+		return runtime.ServerError()
+	}
+
+	return net.HandlerResult{Results: results}
+}
+
+// CreateSchedule validates the requested time or cron expression and stores the control message to be sent later.
+func (api *DeviceSchedulesAPI) CreateSchedule(runtime *net.RequestRuntime) net.HandlerResult {
+	request := scheduleRequest{}
+
+	if e := runtime.ReadBody(&request); e != nil {
+		api.Warnf("received invalid request: %s", e.Error())
+		return runtime.LogicError(defs.ErrBadRequestFormat)
+	}
+
+	nextRun, e := api.nextRun(request, time.Now())
+
+	if e != nil {
+		api.Warnf("invalid schedule requested for device[%s]: %s", request.DeviceID, e.Error())
+		return runtime.LogicError(defs.ErrInvalidSchedule)
+	}
+
+	control, e := request.control()
+
+	if e != nil {
+		api.Warnf("invalid frames received for device[%s]: %s", request.DeviceID, e.Error())
+		return runtime.LogicError(e.Error())
+	}
+
+	registration, e := api.authorize(runtime, request.DeviceID, controllerPermission)
+
+	if e != nil {
+		return runtime.LogicError(e.Error())
+	}
+
+	existing, e := api.ScheduleStore.ListSchedules(registration.Name)
+
+	if e != nil {
+		api.Errorf("unable to list schedules for device[%s]: %s", registration.Name, e.Error())
+		return runtime.ServerError()
+	}
+
+	if len(existing) >= defs.SecurityMaxDeviceSchedules {
+		api.Warnf("device[%s] has reached the maximum amount of schedules", registration.Name)
+		return runtime.LogicError(defs.ErrTooManySchedules)
+	}
+
+	schedule, e := api.ScheduleStore.CreateSchedule(device.ScheduleDetails{
+		DeviceName: registration.Name,
+		Cron:       request.Cron,
+		NextRun:    nextRun,
+		Message:    control,
+	})
+
+	if e != nil {
+		api.Errorf("unable to create schedule for device[%s]: %s", registration.Name, e.Error())
+		return runtime.ServerError()
+	}
+
+	api.Infof("created schedule[%s] for device[%s] (next run %s)", schedule.ScheduleID, registration.Name, nextRun)
+
+	return net.HandlerResult{Results: []device.ScheduleDetails{schedule}}
+}
+
+// DeleteSchedule removes the schedule id provided in the query string from the device's schedules.
+func (api *DeviceSchedulesAPI) DeleteSchedule(runtime *net.RequestRuntime) net.HandlerResult {
+	id, scheduleID := runtime.GetQueryParam("device_id"), runtime.GetQueryParam("schedule_id")
+
+	registration, e := api.authorize(runtime, id, controllerPermission)
+
+	if e != nil {
+		return runtime.LogicError(e.Error())
+	}
+
+	if e := api.RemoveSchedule(registration.Name, scheduleID); e != nil {
+		api.Warnf("unable to remove schedule[%s] for device[%s]: %s", scheduleID, registration.Name, e.Error())
+		return runtime.LogicError(defs.ErrNotFound)
+	}
+
+	api.Infof("removed schedule[%s] for device[%s]", scheduleID, registration.Name)
+	return net.HandlerResult{}
+}
+
+// nextRun returns the first time the requested schedule should run. Exactly one of a future "run_at" time or a cron
+// expression must be provided.
+func (api *DeviceSchedulesAPI) nextRun(request scheduleRequest, now time.Time) (time.Time, error) {
+	if (request.RunAt == nil) == (request.Cron == "") {
+		return time.Time{}, fmt.Errorf(defs.ErrInvalidSchedule)
+	}
+
+	if request.RunAt != nil && request.RunAt.After(now) != true {
+		return time.Time{}, fmt.Errorf(defs.ErrInvalidSchedule)
+	}
+
+	if request.RunAt != nil {
+		return *request.RunAt, nil
+	}
+
+	expression, e := cron.Parse(request.Cron)
+
+	if e != nil {
+		return time.Time{}, e
+	}
+
+	if next := expression.Next(now.In(time.Local)); next.IsZero() != true {
+		return next, nil
+	}
+
+	return time.Time{}, fmt.Errorf(defs.ErrInvalidSchedule)
+}
+
+// authorize finds the device once the token in the request headers is approved for the permission level provided.
+func (api *DeviceSchedulesAPI) authorize(runtime *net.RequestRuntime, id string, level uint) (device.RegistrationDetails, error) {
+	if id == "" {
+		return device.RegistrationDetails{}, fmt.Errorf(defs.ErrInvalidDeviceID)
+	}
+
+	token := runtime.HeaderValue(defs.APIUserTokenHeader)
+	registration, e := device.FindAuthorizedDevice(api.Index, api.TokenStore, id, token, level)
+
+	if e != nil {
+		api.Warnf("unable to authorize access to schedules (device: %s): %s", id, e.Error())
+		return device.RegistrationDetails{}, fmt.Errorf(defs.ErrNotFound)
+	}
+
+	return registration, nil
+}
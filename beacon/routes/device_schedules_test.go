@@ -0,0 +1,234 @@
+package routes
+
+import "fmt"
+import "time"
+import "bytes"
+import "testing"
+import "net/http/httptest"
+import "github.com/franela/goblin"
+
+import "github.com/dadleyy/beacon.api/beacon/net"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/device"
+import "github.com/dadleyy/beacon.api/beacon/interchange"
+
+type deviceSchedulesAPIScaffolding struct {
+	api     *DeviceSchedulesAPI
+	store   *testRouteStore
+	runtime *net.RequestRuntime
+	body    *bytes.Buffer
+}
+
+func (s *deviceSchedulesAPIScaffolding) Reset() {
+	s.store = newTestRouteStore()
+	s.body = bytes.NewBuffer([]byte{})
+
+	s.api = &DeviceSchedulesAPI{
+		LeveledLogger: newTestRouteLogger(),
+		ScheduleStore: s.store,
+		TokenStore:    s.store,
+		Index:         s.store,
+	}
+
+	s.withRequest("/device-schedules")
+}
+
+func (s *deviceSchedulesAPIScaffolding) withRequest(url string) {
+	s.runtime = &net.RequestRuntime{
+		Request: httptest.NewRequest("GET", url, s.body),
+	}
+}
+
+func (s *deviceSchedulesAPIScaffolding) authorize() {
+	owner := s.store.register("desk-lamp", "device-id")
+	s.runtime.Header.Set(defs.APIUserTokenHeader, owner)
+}
+
+func (s *deviceSchedulesAPIScaffolding) schedule() device.ScheduleDetails {
+	schedule := device.ScheduleDetails{
+		DeviceName: "desk-lamp",
+		Cron:       "0 17 * * *",
+		NextRun:    time.Now().Add(time.Hour),
+		Message:    &interchange.ControlMessage{},
+	}
+
+	created, _ := s.store.CreateSchedule(schedule)
+	return created
+}
+
+func Test_DeviceSchedulesAPI(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	scaffold := &deviceSchedulesAPIScaffolding{}
+
+	g.Describe("ListSchedules", func() {
+		g.BeforeEach(scaffold.Reset)
+
+		g.It("fails without a device id", func() {
+			r := scaffold.api.ListSchedules(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidDeviceID)
+		})
+
+		g.Describe("with a device id", func() {
+			g.BeforeEach(func() {
+				scaffold.withRequest("/device-schedules?device_id=desk-lamp")
+			})
+
+			g.It("fails if the token is not authorized", func() {
+				scaffold.store.register("desk-lamp", "device-id")
+				scaffold.runtime.Header.Set(defs.APIUserTokenHeader, "some-token")
+				r := scaffold.api.ListSchedules(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+			})
+
+			g.It("fails if unable to list the schedules", func() {
+				scaffold.authorize()
+				scaffold.store.errors["ListSchedules"] = fmt.Errorf("bad-list")
+				r := scaffold.api.ListSchedules(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrServerError)
+			})
+
+			g.It("returns the schedules for the device w/ viewer permission", func() {
+				scaffold.store.register("desk-lamp", "device-id")
+				viewer := scaffold.store.token("device-id", defs.SecurityDeviceTokenPermissionViewer)
+				scaffold.runtime.Header.Set(defs.APIUserTokenHeader, viewer)
+				created := scaffold.schedule()
+				r := scaffold.api.ListSchedules(scaffold.runtime)
+				list, ok := r.Results.([]device.ScheduleDetails)
+				g.Assert(ok).Equal(true)
+				g.Assert(len(list)).Equal(1)
+				g.Assert(list[0].ScheduleID).Equal(created.ScheduleID)
+			})
+		})
+	})
+
+	g.Describe("CreateSchedule", func() {
+		g.BeforeEach(scaffold.Reset)
+
+		future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+		g.It("fails without a valid json body", func() {
+			r := scaffold.api.CreateSchedule(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrBadRequestFormat)
+		})
+
+		g.It("fails without a run time or cron expression", func() {
+			scaffold.body.WriteString("{\"device_id\": \"desk-lamp\", \"red\": 255}")
+			r := scaffold.api.CreateSchedule(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidSchedule)
+		})
+
+		g.It("fails w/ both a run time and cron expression", func() {
+			scaffold.body.WriteString("{\"device_id\": \"desk-lamp\", \"cron\": \"0 17 * * *\", \"run_at\": \"" + future + "\"}")
+			r := scaffold.api.CreateSchedule(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidSchedule)
+		})
+
+		g.It("fails w/ a run time in the past", func() {
+			scaffold.body.WriteString("{\"device_id\": \"desk-lamp\", \"run_at\": \"2001-01-01T00:00:00Z\"}")
+			r := scaffold.api.CreateSchedule(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidSchedule)
+		})
+
+		g.It("fails w/ an invalid cron expression", func() {
+			scaffold.body.WriteString("{\"device_id\": \"desk-lamp\", \"cron\": \"every day\"}")
+			r := scaffold.api.CreateSchedule(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidSchedule)
+		})
+
+		g.It("fails w/ invalid frames", func() {
+			scaffold.body.WriteString("{\"device_id\": \"desk-lamp\", \"cron\": \"0 17 * * *\", \"frames\": [{\"red\": 256}]}")
+			r := scaffold.api.CreateSchedule(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidControlFrames)
+		})
+
+		g.Describe("with a valid cron schedule", func() {
+			g.BeforeEach(func() {
+				scaffold.body.WriteString("{\"device_id\": \"desk-lamp\", \"cron\": \"0 17 * * 1-5\", \"red\": 255}")
+			})
+
+			g.It("fails if the token is not authorized", func() {
+				scaffold.store.register("desk-lamp", "device-id")
+				r := scaffold.api.CreateSchedule(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+			})
+
+			g.It("fails if the device has too many schedules", func() {
+				scaffold.authorize()
+
+				for i := 0; i < defs.SecurityMaxDeviceSchedules; i++ {
+					scaffold.schedule()
+				}
+
+				r := scaffold.api.CreateSchedule(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrTooManySchedules)
+			})
+
+			g.It("fails if unable to create the schedule", func() {
+				scaffold.authorize()
+				scaffold.store.errors["CreateSchedule"] = fmt.Errorf("bad-create")
+				r := scaffold.api.CreateSchedule(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrServerError)
+			})
+
+			g.It("creates the schedule for the device name w/ its next run", func() {
+				scaffold.authorize()
+				r := scaffold.api.CreateSchedule(scaffold.runtime)
+				g.Assert(len(r.Errors)).Equal(0)
+				list, _ := scaffold.store.ListSchedules("desk-lamp")
+				created := list[0]
+				g.Assert(created.DeviceName).Equal("desk-lamp")
+				g.Assert(created.NextRun.After(time.Now())).Equal(true)
+				g.Assert(created.NextRun.Hour()).Equal(17)
+				g.Assert(created.Message.Frames[0].Red).Equal(uint32(255))
+			})
+		})
+
+		g.It("creates one-time schedules at the requested time", func() {
+			scaffold.body.WriteString("{\"device_id\": \"desk-lamp\", \"run_at\": \"" + future + "\"}")
+			scaffold.authorize()
+			r := scaffold.api.CreateSchedule(scaffold.runtime)
+			g.Assert(len(r.Errors)).Equal(0)
+			list, _ := scaffold.store.ListSchedules("desk-lamp")
+			g.Assert(list[0].Cron).Equal("")
+			g.Assert(list[0].NextRun.UTC().Format(time.RFC3339)).Equal(future)
+		})
+	})
+
+	g.Describe("DeleteSchedule", func() {
+		g.BeforeEach(scaffold.Reset)
+
+		g.It("fails without a device id", func() {
+			r := scaffold.api.DeleteSchedule(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidDeviceID)
+		})
+
+		g.Describe("with a device id and schedule id", func() {
+			g.BeforeEach(func() {
+				scaffold.withRequest("/device-schedules?device_id=desk-lamp&schedule_id=abc")
+			})
+
+			g.It("fails if the token is not authorized", func() {
+				scaffold.store.register("desk-lamp", "device-id")
+				r := scaffold.api.DeleteSchedule(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+			})
+
+			g.It("fails if the schedule does not exist", func() {
+				scaffold.authorize()
+				r := scaffold.api.DeleteSchedule(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+			})
+
+			g.It("succeeds after removing the schedule", func() {
+				created := scaffold.schedule()
+				scaffold.withRequest("/device-schedules?device_id=desk-lamp&schedule_id=" + created.ScheduleID)
+				scaffold.authorize()
+				r := scaffold.api.DeleteSchedule(scaffold.runtime)
+				g.Assert(len(r.Errors)).Equal(0)
+				list, _ := scaffold.store.ListSchedules("desk-lamp")
+				g.Assert(len(list)).Equal(0)
+			})
+		})
+	})
+}
@@ -12,6 +12,7 @@ import "github.com/dadleyy/beacon.api/beacon/net"
 import "github.com/dadleyy/beacon.api/beacon/defs"
 import "github.com/dadleyy/beacon.api/beacon/logging"
 import "github.com/dadleyy/beacon.api/beacon/device"
+import "github.com/dadleyy/beacon.api/beacon/interchange"
 
 func newDevicesAPILogger() *logging.Logger {
 	out := bytes.NewBuffer([]byte{})
@@ -22,20 +23,20 @@ func newDevicesAPILogger() *logging.Logger {
 
 type testDevicesAPIScaffolding struct {
 	api        *Devices
-	registry   *testDeviceRegistry
-	tokenStore *testDeviceTokenStore
+	store      *testRouteStore
 	runtime    *net.RequestRuntime
 	body       *bytes.Buffer
 	pathValues url.Values
 }
 
 func prepareDeviceAPIScaffold() testDevicesAPIScaffolding {
-	registry := testDeviceRegistry{}
-	tokenStore := testDeviceTokenStore{}
+	store := newTestRouteStore()
 	api := Devices{
 		LeveledLogger: newDevicesAPILogger(),
-		Registry:      &registry,
-		TokenStore:    &tokenStore,
+		Registry:      store,
+		TokenStore:    store,
+		PresetStore:   store,
+		CommandStore:  store,
 	}
 
 	body := bytes.NewBuffer([]byte{})
@@ -48,8 +49,7 @@ func prepareDeviceAPIScaffold() testDevicesAPIScaffolding {
 
 	return testDevicesAPIScaffolding{
 		api:        &api,
-		registry:   &registry,
-		tokenStore: &tokenStore,
+		store:      store,
 		body:       body,
 		pathValues: pathValues,
 		runtime: &net.RequestRuntime{
@@ -71,15 +71,13 @@ func Test_DevicesAPI(t *testing.T) {
 		})
 
 		g.It("errors if unable to get a list of registrations from the registry", func() {
-			registry := scaffold.registry
-			registry.listRegistrationErrors = append(registry.listRegistrationErrors, fmt.Errorf("bad-list"))
+			scaffold.store.errors["ListRegistrations"] = fmt.Errorf("bad-list")
 			r := scaffold.api.ListDevices(scaffold.runtime)
 			g.Assert(r.Errors[0].Error()).Equal(defs.ErrServerError)
 		})
 
 		g.It("returns the list of registered devices if present", func() {
-			registry := scaffold.registry
-			registry.activeRegistrations = append(registry.activeRegistrations, device.RegistrationDetails{})
+			scaffold.store.register("desk-lamp", "device-id")
 			r := scaffold.api.ListDevices(scaffold.runtime)
 			g.Assert(len(r.Errors)).Equal(0)
 			l, e := r.Results.([]device.RegistrationDetails)
@@ -101,9 +99,11 @@ func Test_DevicesAPI(t *testing.T) {
 		})
 
 		g.Describe("having found a device", func() {
+			var owner string
+
 			g.BeforeEach(func() {
-				testDevice := device.RegistrationDetails{}
-				scaffold.registry.activeRegistrations = append(scaffold.registry.activeRegistrations, testDevice)
+				owner = scaffold.store.register("desk-lamp", "device-id")
+				scaffold.pathValues.Set("uuid", "device-id")
 			})
 
 			g.It("fails without a valid token header", func() {
@@ -112,8 +112,8 @@ func Test_DevicesAPI(t *testing.T) {
 			})
 
 			g.It("with a valid token but not authorized", func() {
-				scaffold.runtime.Header.Set(defs.APIUserTokenHeader, "some-token")
-				scaffold.tokenStore.authorized = false
+				viewer := scaffold.store.token("device-id", defs.SecurityDeviceTokenPermissionViewer)
+				scaffold.runtime.Header.Set(defs.APIUserTokenHeader, viewer)
 				r := scaffold.api.UpdateShorthand(scaffold.runtime)
 				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
 			})
@@ -121,8 +121,7 @@ func Test_DevicesAPI(t *testing.T) {
 			g.Describe("having authorized successfully", func() {
 
 				g.BeforeEach(func() {
-					scaffold.runtime.Header.Set(defs.APIUserTokenHeader, "some-token")
-					scaffold.tokenStore.authorized = true
+					scaffold.runtime.Header.Set(defs.APIUserTokenHeader, owner)
 				})
 
 				g.It("errors when the color short hand is not present", func() {
@@ -165,6 +164,52 @@ func Test_DevicesAPI(t *testing.T) {
 					g.It("succeeds when given a valid 6 character hex code", func() {
 						scaffold.pathValues.Set("color", "ffffff")
 					})
+
+					g.It("succeeds when given a preset", func() {
+						scaffold.pathValues.Set("color", "police")
+					})
+
+					g.It("succeeds when given a colored preset", func() {
+						scaffold.pathValues.Set("color", "pulse-ff00ff")
+					})
+				})
+
+				g.It("sends the preset saved for the device when one matches", func() {
+					control := interchange.ControlMessage{
+						Frames: []*interchange.ControlFrame{{Red: 12}, {Blue: 34}},
+					}
+					scaffold.store.SavePreset("desk-lamp", "deploy", control)
+					scaffold.pathValues.Set("color", "deploy")
+					r := scaffold.api.UpdateShorthand(scaffold.runtime)
+					g.Assert(len(r.Errors)).Equal(0)
+				})
+
+				g.It("returns the command created for the message", func() {
+					scaffold.pathValues.Set("color", "red")
+					r := scaffold.api.UpdateShorthand(scaffold.runtime)
+					commands := r.Results.([]device.CommandDetails)
+					command, e := scaffold.store.FindCommand(commands[0].CommandID)
+					g.Assert(e).Equal(nil)
+					g.Assert(commands).Equal([]device.CommandDetails{command})
+				})
+
+				g.It("errors when unable to create a command for the message", func() {
+					scaffold.pathValues.Set("color", "red")
+					scaffold.store.errors["CreateCommand"] = fmt.Errorf("bad-create")
+					r := scaffold.api.UpdateShorthand(scaffold.runtime)
+					g.Assert(r.Errors[0].Error()).Equal("bad-create")
+				})
+
+				g.It("errors when given a name that matches no presets", func() {
+					scaffold.pathValues.Set("color", "deploy")
+					r := scaffold.api.UpdateShorthand(scaffold.runtime)
+					g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidColorShorthand)
+				})
+
+				g.It("errors when given a colored preset w/ an invalid color", func() {
+					scaffold.pathValues.Set("color", "pulse-nope")
+					r := scaffold.api.UpdateShorthand(scaffold.runtime)
+					g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidColorShorthand)
 				})
 			})
 		})
@@ -1,5 +1,7 @@
 package routes
 
+import "time"
+import "strings"
 import "strconv"
 import "io/ioutil"
 import "github.com/golang/protobuf/proto"
@@ -11,13 +13,14 @@ import "github.com/dadleyy/beacon.api/beacon/logging"
 import "github.com/dadleyy/beacon.api/beacon/interchange"
 
 // NewFeedbackAPI returns a new initialized feed back api
-func NewFeedbackAPI(store device.FeedbackStore, index device.Index) *Feedback {
+func NewFeedbackAPI(store device.FeedbackStore, index device.Index, commands device.CommandStore) *Feedback {
 	logger := logging.New(defs.FeedbackAPILogPrefix, logging.Green)
 
 	return &Feedback{
 		LeveledLogger: logger,
 		FeedbackStore: store,
 		Index:         index,
+		CommandStore:  commands,
 	}
 }
 
@@ -26,15 +29,29 @@ type Feedback struct {
 	logging.LeveledLogger
 	device.FeedbackStore
 	device.Index
+	device.CommandStore
+}
+
+type feedbackEntry struct {
+	Type       string    `json:"type"`
+	CommandID  string    `json:"command_id,omitempty"`
+	ReceivedAt time.Time `json:"received_at"`
 }
 
 type reportEntry struct {
+	feedbackEntry
 	Red   uint32 `json:"red"`
 	Green uint32 `json:"green"`
 	Blue  uint32 `json:"blue"`
 }
 
-// ListFeedback validates a payload from the client and adds an entry to the device feedback log.
+type errorEntry struct {
+	feedbackEntry
+	ShortDescription string `json:"short_description"`
+	LongDescription  string `json:"long_description"`
+}
+
+// ListFeedback returns the latest entries from the device feedback log, optionally filtered by the "type" query param.
 func (feedback *Feedback) ListFeedback(runtime *net.RequestRuntime) net.HandlerResult {
 	count, e := strconv.Atoi(runtime.GetQueryParam("count"))
 
@@ -43,6 +60,18 @@ func (feedback *Feedback) ListFeedback(runtime *net.RequestRuntime) net.HandlerR
 		feedback.Debugf("defaulting feedback count to 1")
 	}
 
+	filter, filtered := interchange.FeedbackMessageType(0), runtime.GetQueryParam("type") != ""
+
+	if filtered {
+		value, ok := interchange.FeedbackMessageType_value[strings.ToUpper(runtime.GetQueryParam("type"))]
+
+		if ok != true {
+			return runtime.LogicError(defs.ErrInvalidFeedbackType)
+		}
+
+		filter = interchange.FeedbackMessageType(value)
+	}
+
 	deviceID := runtime.GetQueryParam("device_id")
 
 	if _, e := feedback.FindDevice(deviceID); e != nil {
@@ -50,7 +79,14 @@ func (feedback *Feedback) ListFeedback(runtime *net.RequestRuntime) net.HandlerR
 		return runtime.LogicError(defs.ErrNotFound)
 	}
 
-	entries, e := feedback.FeedbackStore.ListFeedback(deviceID, count-1)
+	// When filtering by type the whole log is loaded so that the count applies to the matching entries.
+	limit := count - 1
+
+	if filtered {
+		limit = defs.MaxFeedbackEntries
+	}
+
+	entries, e := feedback.FeedbackStore.ListFeedback(deviceID, limit)
 
 	if e != nil {
 		feedback.Warnf("unable to load device feedback: %s", e.Error())
@@ -62,6 +98,14 @@ func (feedback *Feedback) ListFeedback(runtime *net.RequestRuntime) net.HandlerR
 	results := make([]interface{}, 0, len(entries))
 
 	for _, top := range entries {
+		if len(results) >= count {
+			break
+		}
+
+		if filtered && top.Type != filter {
+			continue
+		}
+
 		payload := top.GetPayload()
 
 		if payload == nil || len(payload) == 0 {
@@ -69,9 +113,22 @@ func (feedback *Feedback) ListFeedback(runtime *net.RequestRuntime) net.HandlerR
 			continue
 		}
 
+		entry := feedbackEntry{
+			Type:       strings.ToLower(top.Type.String()),
+			CommandID:  top.CommandID,
+			ReceivedAt: time.Unix(top.ReceivedAt, 0),
+		}
+
 		switch top.Type {
 		case interchange.FeedbackMessageType_ERROR:
-			results = append(results, nil)
+			message := interchange.ErrorMessage{}
+
+			if e := proto.Unmarshal(payload, &message); e != nil {
+				feedback.Errorf("unable to unmarshal error feedback payload: %s", e.Error())
+				return runtime.LogicError(defs.ErrBadInterchangeData)
+			}
+
+			results = append(results, errorEntry{entry, message.ShortDescription, message.LongDescription})
 		case interchange.FeedbackMessageType_REPORT:
 			report := interchange.ReportMessage{}
 
@@ -80,7 +137,7 @@ func (feedback *Feedback) ListFeedback(runtime *net.RequestRuntime) net.HandlerR
 				return runtime.LogicError(defs.ErrBadInterchangeData)
 			}
 
-			results = append(results, reportEntry{report.Red, report.Green, report.Blue})
+			results = append(results, reportEntry{entry, report.Red, report.Green, report.Blue})
 		}
 	}
 
@@ -124,6 +181,12 @@ func (feedback *Feedback) CreateFeedback(runtime *net.RequestRuntime) net.Handle
 		return runtime.ServerError()
 	}
 
+	if message.CommandID != "" {
+		if e := device.AcknowledgeCommand(feedback.CommandStore, auth.GetDeviceID(), message); e != nil {
+			feedback.Warnf("unable to acknowledge command[%s] of device[%s]: %s", message.CommandID, auth.DeviceID, e.Error())
+		}
+	}
+
 	feedback.Infof("successfully posted feedback from device[%s]", auth.DeviceID)
 	return net.HandlerResult{}
 }
@@ -1,6 +1,7 @@
 package routes
 
 import "fmt"
+import "time"
 import "bytes"
 import "testing"
 import "net/http/httptest"
@@ -13,32 +14,39 @@ import "github.com/dadleyy/beacon.api/beacon/device"
 import "github.com/dadleyy/beacon.api/beacon/interchange"
 
 type testFeedbackAPIScaffolding struct {
-	index   *testDeviceIndex
-	store   *testFeedbackStore
+	store   *testRouteStore
 	api     *Feedback
 	runtime *net.RequestRuntime
 	body    *bytes.Buffer
 }
 
+// log stores the feedback of device "123" so that it is listed in the order provided, newest first.
+func (s *testFeedbackAPIScaffolding) log(entries ...interchange.FeedbackMessage) {
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		entry.Authentication = &interchange.DeviceMessageAuthentication{DeviceID: "123"}
+		s.store.LogFeedback(entry)
+	}
+}
+
 func prepareFeedbackAPIScaffold() testFeedbackAPIScaffolding {
-	store := testFeedbackStore{}
-	index := testDeviceIndex{}
+	store := newTestRouteStore()
 
 	api := Feedback{
 		LeveledLogger: newTestRouteLogger(),
-		FeedbackStore: &store,
-		Index:         &index,
+		FeedbackStore: store,
+		Index:         store,
+		CommandStore:  store,
 	}
 
 	body := bytes.NewBuffer([]byte{})
 
 	runtime := net.RequestRuntime{
-		Request: httptest.NewRequest("GET", "/feedback", body),
+		Request: httptest.NewRequest("GET", "/feedback?device_id=123", body),
 	}
 
 	return testFeedbackAPIScaffolding{
-		index:   &index,
-		store:   &store,
+		store:   store,
 		api:     &api,
 		runtime: &runtime,
 		body:    body,
@@ -56,45 +64,103 @@ func Test_FeedbackAPI(t *testing.T) {
 		})
 
 		g.It("returns an error if unable to find the device", func() {
-			scaffold.index.findErrors = append(scaffold.index.findErrors, fmt.Errorf("bad-find"))
 			r := scaffold.api.ListFeedback(scaffold.runtime)
 			g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
 		})
 
 		g.Describe("having found the device", func() {
 			g.BeforeEach(func() {
-				scaffold.index.foundDevices = append(scaffold.index.foundDevices, device.RegistrationDetails{})
+				scaffold.store.register("desk-lamp", "123")
 			})
 
 			g.It("fails if unable to list the feedback from the store", func() {
-				scaffold.store.listErrors = append(scaffold.store.listErrors, fmt.Errorf("bad-list"))
+				scaffold.store.errors["ListFeedback"] = fmt.Errorf("bad-list")
 				r := scaffold.api.ListFeedback(scaffold.runtime)
 				g.Assert(r.Errors[0].Error()).Equal(defs.ErrServerError)
 			})
 
 			g.It("returns nil for feedback items without a payload", func() {
-				scaffold.store.listResults = append(scaffold.store.listResults, interchange.FeedbackMessage{})
+				scaffold.log(interchange.FeedbackMessage{})
 				r := scaffold.api.ListFeedback(scaffold.runtime)
 				list, _ := r.Results.([]interface{})
 				first, _ := list[0].(error)
 				g.Assert(first).Equal(nil)
 			})
 
-			g.It("returns nil for error reporting feedback items", func() {
-				scaffold.store.listResults = append(scaffold.store.listResults, interchange.FeedbackMessage{
+			g.It("returns an error when unable to unmarshal the payload of an error entry", func() {
+				scaffold.log(interchange.FeedbackMessage{
 					Type:    interchange.FeedbackMessageType_ERROR,
-					Payload: []byte("empty"),
+					Payload: []byte("this-is-ugly"),
+				})
+				r := scaffold.api.ListFeedback(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrBadInterchangeData)
+			})
+
+			g.It("returns the descriptions of error entries", func() {
+				payload, _ := proto.Marshal(&interchange.ErrorMessage{
+					ShortDescription: "bad-frame",
+					LongDescription:  "frame 2 has an unsupported transition",
+				})
+
+				scaffold.log(interchange.FeedbackMessage{
+					Type:      interchange.FeedbackMessageType_ERROR,
+					Payload:   payload,
+					CommandID: "command-id",
 				})
+
 				r := scaffold.api.ListFeedback(scaffold.runtime)
 				list, _ := r.Results.([]interface{})
-				first, _ := list[0].(error)
-				g.Assert(first).Equal(nil)
+				first, ok := list[0].(errorEntry)
+
+				g.Assert(ok).Equal(true)
+				g.Assert(first.Type).Equal("error")
+				g.Assert(first.CommandID).Equal("command-id")
+				g.Assert(time.Since(first.ReceivedAt) < time.Minute).Equal(true)
+				g.Assert(first.ShortDescription).Equal("bad-frame")
+				g.Assert(first.LongDescription).Equal("frame 2 has an unsupported transition")
+			})
+
+			g.Describe("filtering by type", func() {
+				g.BeforeEach(func() {
+					report, _ := proto.Marshal(&interchange.ReportMessage{Red: 100})
+					failure, _ := proto.Marshal(&interchange.ErrorMessage{ShortDescription: "bad-frame"})
+
+					scaffold.log(
+						interchange.FeedbackMessage{Type: interchange.FeedbackMessageType_REPORT, Payload: report},
+						interchange.FeedbackMessage{Type: interchange.FeedbackMessageType_ERROR, Payload: failure},
+						interchange.FeedbackMessage{Type: interchange.FeedbackMessageType_ERROR, Payload: failure},
+					)
+				})
+
+				g.It("returns an error for unknown types", func() {
+					scaffold.runtime.URL.RawQuery = "device_id=123&type=warning"
+					r := scaffold.api.ListFeedback(scaffold.runtime)
+					g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidFeedbackType)
+				})
+
+				g.It("loads the whole feedback log and returns the count of matching entries", func() {
+					scaffold.runtime.URL.RawQuery = "device_id=123&type=error&count=2"
+					r := scaffold.api.ListFeedback(scaffold.runtime)
+					list, _ := r.Results.([]interface{})
+					g.Assert(len(list)).Equal(2)
+					_, ok := list[1].(errorEntry)
+					g.Assert(ok).Equal(true)
+				})
+
+				g.It("returns only report entries", func() {
+					scaffold.runtime.URL.RawQuery = "device_id=123&type=report&count=5"
+					r := scaffold.api.ListFeedback(scaffold.runtime)
+					list, _ := r.Results.([]interface{})
+					g.Assert(len(list)).Equal(1)
+					first, _ := list[0].(reportEntry)
+					g.Assert(first.Type).Equal("report")
+				})
 			})
 
 			g.It("returns an an error when unable to unmarshall the payload of a report entry", func() {
 				payload := []byte("this-is-ugly")
 
-				scaffold.store.listResults = append(scaffold.store.listResults, interchange.FeedbackMessage{
+				scaffold.log(interchange.FeedbackMessage{
 					Type:    interchange.FeedbackMessageType_REPORT,
 					Payload: payload,
 				})
@@ -110,7 +176,7 @@ func Test_FeedbackAPI(t *testing.T) {
 					Blue:  300,
 				})
 
-				scaffold.store.listResults = append(scaffold.store.listResults, interchange.FeedbackMessage{
+				scaffold.log(interchange.FeedbackMessage{
 					Type:    interchange.FeedbackMessageType_REPORT,
 					Payload: payload,
 				})
@@ -161,22 +227,77 @@ func Test_FeedbackAPI(t *testing.T) {
 			})
 
 			g.It("returns an error if unable to find device", func() {
-				scaffold.index.findErrors = append(scaffold.index.findErrors, fmt.Errorf("not-found"))
 				r := scaffold.api.CreateFeedback(scaffold.runtime)
 				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
 			})
 
 			g.It("returns an error if unable to log the feedback", func() {
-				scaffold.index.foundDevices = append(scaffold.index.foundDevices, device.RegistrationDetails{})
-				scaffold.store.logErrors = append(scaffold.store.logErrors, fmt.Errorf("bad-store"))
+				scaffold.store.register("desk-lamp", "123")
+				scaffold.store.errors["LogFeedback"] = fmt.Errorf("bad-store")
 				r := scaffold.api.CreateFeedback(scaffold.runtime)
 				g.Assert(r.Errors[0].Error()).Equal(defs.ErrServerError)
 			})
 
 			g.It("returns without an error if successfully logged the feedback", func() {
-				scaffold.index.foundDevices = append(scaffold.index.foundDevices, device.RegistrationDetails{})
+				scaffold.store.register("desk-lamp", "123")
+				r := scaffold.api.CreateFeedback(scaffold.runtime)
+				g.Assert(len(r.Errors)).Equal(0)
+				logged, _ := scaffold.store.ListFeedback("123", 0)
+				g.Assert(len(logged)).Equal(1)
+			})
+		})
+
+		g.Describe("when the feedback message references a command", func() {
+			var command device.CommandDetails
+
+			write := func(message interchange.FeedbackMessage) {
+				data, _ := proto.Marshal(&message)
+				scaffold.body.Write(data)
+			}
+
+			status := func() string {
+				found, _ := scaffold.store.FindCommand(command.CommandID)
+				return found.Status
+			}
+
+			g.BeforeEach(func() {
+				scaffold.runtime.Header.Set(defs.APIContentTypeHeader, defs.APIFeedbackContentTypeHeader)
+				scaffold.store.register("desk-lamp", "123")
+				command, _ = scaffold.store.CreateCommand("123", "some-token")
+			})
+
+			g.It("acknowledges the command", func() {
+				write(interchange.FeedbackMessage{
+					Type:           interchange.FeedbackMessageType_REPORT,
+					CommandID:      command.CommandID,
+					Authentication: &interchange.DeviceMessageAuthentication{DeviceID: "123"},
+				})
+				r := scaffold.api.CreateFeedback(scaffold.runtime)
+				g.Assert(len(r.Errors)).Equal(0)
+				g.Assert(status()).Equal(defs.CommandStatusAcknowledged)
+			})
+
+			g.It("marks the command as failed for error feedback", func() {
+				write(interchange.FeedbackMessage{
+					Type:           interchange.FeedbackMessageType_ERROR,
+					CommandID:      command.CommandID,
+					Authentication: &interchange.DeviceMessageAuthentication{DeviceID: "123"},
+				})
+				r := scaffold.api.CreateFeedback(scaffold.runtime)
+				g.Assert(len(r.Errors)).Equal(0)
+				g.Assert(status()).Equal(defs.CommandStatusFailed)
+			})
+
+			g.It("does not update commands that were sent to other devices", func() {
+				scaffold.store.register("hall-lamp", "456")
+				write(interchange.FeedbackMessage{
+					Type:           interchange.FeedbackMessageType_REPORT,
+					CommandID:      command.CommandID,
+					Authentication: &interchange.DeviceMessageAuthentication{DeviceID: "456"},
+				})
 				r := scaffold.api.CreateFeedback(scaffold.runtime)
 				g.Assert(len(r.Errors)).Equal(0)
+				g.Assert(status()).Equal(defs.CommandStatusQueued)
 			})
 		})
 	})
@@ -0,0 +1,190 @@
+package routes
+
+import "fmt"
+import "github.com/dadleyy/beacon.api/beacon/net"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/device"
+import "github.com/dadleyy/beacon.api/beacon/logging"
+import "github.com/dadleyy/beacon.api/beacon/presets"
+
+// NewPresetsAPI returns a new api for listing and managing animation presets.
+func NewPresetsAPI(store device.PresetStore, auth device.TokenStore, index device.Index) *PresetsAPI {
+	logger := logging.New(defs.PresetsAPILogPrefix, logging.Green)
+	return &PresetsAPI{logger, store, auth, index}
+}
+
+type presetRequest struct {
+	DeviceID  string                `json:"device_id"`
+	Name      string                `json:"name"`
+	Frames    []controlFrameRequest `json:"frames"`
+	LoopCount uint32                `json:"loop_count"`
+}
+
+// PresetsAPI is the route group that exposes the animation presets that can be sent to devices.
+type PresetsAPI struct {
+	logging.LeveledLogger
+	device.PresetStore
+	device.TokenStore
+	device.Index
+}
+
+// ListPresets returns the list of server-side presets, or the presets saved for a device if a device id is provided.
+func (api *PresetsAPI) ListPresets(runtime *net.RequestRuntime) net.HandlerResult {
+	id := runtime.GetQueryParam("device_id")
+
+	if id == "" {
+		return net.HandlerResult{Results: presets.List()}
+	}
+
+	registration, e := api.authorize(runtime, id, defs.SecurityDeviceTokenPermissionViewer)
+
+	if e != nil {
+		return runtime.LogicError(e.Error())
+	}
+
+	saved, e := api.PresetStore.ListPresets(registration.Name)
+
+	if e != nil {
+		api.Errorf("unable to list presets for device[%s]: %s", registration.DeviceID, e.Error())
+		return runtime.ServerError()
+	}
+
+	return net.HandlerResult{Results: saved}
+}
+
+// CreatePreset saves a new named control message for the device that can be used by the device shorthand route.
+func (api *PresetsAPI) CreatePreset(runtime *net.RequestRuntime) net.HandlerResult {
+	request := presetRequest{}
+
+	if e := runtime.ReadBody(&request); e != nil {
+		api.Warnf("received invalid request: %s", e.Error())
+		return runtime.LogicError(defs.ErrBadRequestFormat)
+	}
+
+	if api.validName(request.Name) != true {
+		api.Warnf("invalid preset name: %s", request.Name)
+		return runtime.LogicError(defs.ErrInvalidPresetName)
+	}
+
+	registration, e := api.authorize(runtime, request.DeviceID, defs.SecurityDeviceTokenPermissionAdmin)
+
+	if e != nil {
+		return runtime.LogicError(e.Error())
+	}
+
+	if _, e := api.FindPreset(registration.Name, request.Name); e == nil {
+		api.Warnf("duplicate preset name for device[%s]: %s", registration.DeviceID, request.Name)
+		return runtime.LogicError(defs.ErrDuplicatePresetName)
+	}
+
+	saved, e := api.PresetStore.ListPresets(registration.Name)
+
+	if e != nil {
+		api.Errorf("unable to list presets for device[%s]: %s", registration.DeviceID, e.Error())
+		return runtime.ServerError()
+	}
+
+	if len(saved) >= defs.SecurityMaxDevicePresets {
+		api.Warnf("device[%s] has reached the maximum amount of presets", registration.DeviceID)
+		return runtime.LogicError(defs.ErrTooManyPresets)
+	}
+
+	return api.save(runtime, registration.Name, request)
+}
+
+// UpdatePreset replaces the control message saved under an existing preset name for the device.
+func (api *PresetsAPI) UpdatePreset(runtime *net.RequestRuntime) net.HandlerResult {
+	request := presetRequest{}
+
+	if e := runtime.ReadBody(&request); e != nil {
+		api.Warnf("received invalid request: %s", e.Error())
+		return runtime.LogicError(defs.ErrBadRequestFormat)
+	}
+
+	registration, e := api.authorize(runtime, request.DeviceID, defs.SecurityDeviceTokenPermissionAdmin)
+
+	if e != nil {
+		return runtime.LogicError(e.Error())
+	}
+
+	if _, e := api.FindPreset(registration.Name, request.Name); e != nil {
+		api.Warnf("unable to find preset[%s] for device[%s]: %s", request.Name, registration.DeviceID, e.Error())
+		return runtime.LogicError(defs.ErrNotFound)
+	}
+
+	return api.save(runtime, registration.Name, request)
+}
+
+// DeletePreset removes a preset saved for the device.
+func (api *PresetsAPI) DeletePreset(runtime *net.RequestRuntime) net.HandlerResult {
+	id, name := runtime.GetQueryParam("device_id"), runtime.GetQueryParam("name")
+
+	registration, e := api.authorize(runtime, id, defs.SecurityDeviceTokenPermissionAdmin)
+
+	if e != nil {
+		return runtime.LogicError(e.Error())
+	}
+
+	if e := api.RemovePreset(registration.Name, name); e != nil {
+		api.Warnf("unable to remove preset[%s] for device[%s]: %s", name, registration.DeviceID, e.Error())
+		return runtime.LogicError(defs.ErrNotFound)
+	}
+
+	api.Infof("removed preset[%s] for device[%s]", name, registration.DeviceID)
+	return net.HandlerResult{}
+}
+
+func (api *PresetsAPI) save(runtime *net.RequestRuntime, deviceName string, request presetRequest) net.HandlerResult {
+	control, e := parseControlMessage(request.Frames, request.LoopCount)
+
+	if e != nil {
+		api.Warnf("invalid frames received for preset[%s]: %s", request.Name, e.Error())
+		return runtime.LogicError(e.Error())
+	}
+
+	if len(control.Frames) == 0 {
+		return runtime.LogicError(defs.ErrInvalidControlFrames)
+	}
+
+	if e := api.SavePreset(deviceName, request.Name, *control); e != nil {
+		api.Errorf("unable to save preset[%s] for device[%s]: %s", request.Name, deviceName, e.Error())
+		return runtime.ServerError()
+	}
+
+	api.Infof("saved preset[%s] for device[%s]", request.Name, deviceName)
+
+	preset := device.PresetDetails{DeviceName: deviceName, Name: request.Name, Message: control}
+	return net.HandlerResult{Results: []device.PresetDetails{preset}}
+}
+
+// authorize finds the device once the token in the request headers is approved for the permission level provided.
+func (api *PresetsAPI) authorize(runtime *net.RequestRuntime, id string, level uint) (device.RegistrationDetails, error) {
+	if id == "" {
+		return device.RegistrationDetails{}, fmt.Errorf(defs.ErrInvalidDeviceID)
+	}
+
+	token := runtime.HeaderValue(defs.APIUserTokenHeader)
+	registration, e := device.FindAuthorizedDevice(api.Index, api.TokenStore, id, token, level)
+
+	if e != nil {
+		api.Warnf("unable to authorize access to presets (device: %s): %s", id, e.Error())
+		return device.RegistrationDetails{}, fmt.Errorf(defs.ErrNotFound)
+	}
+
+	return registration, nil
+}
+
+// validName returns true if the name is allowed to be used for a user-defined preset. Names that would resolve to one
+// of the shorthand colors or server-side presets are not allowed.
+func (api *PresetsAPI) validName(name string) bool {
+	if len(name) > defs.SecurityDevicePresetNameMaxLength || defs.PresetNameRegex.MatchString(name) != true {
+		return false
+	}
+
+	if _, e := presets.ParseColor(name); e == nil {
+		return false
+	}
+
+	_, e := presets.Find(name)
+	return e != nil
+}
@@ -0,0 +1,251 @@
+package routes
+
+import "fmt"
+import "bytes"
+import "testing"
+import "net/http/httptest"
+import "github.com/franela/goblin"
+
+import "github.com/dadleyy/beacon.api/beacon/net"
+import "github.com/dadleyy/beacon.api/beacon/defs"
+import "github.com/dadleyy/beacon.api/beacon/device"
+import "github.com/dadleyy/beacon.api/beacon/presets"
+import "github.com/dadleyy/beacon.api/beacon/interchange"
+
+type presetsAPIScaffolding struct {
+	api     *PresetsAPI
+	store   *testRouteStore
+	runtime *net.RequestRuntime
+	body    *bytes.Buffer
+}
+
+func (s *presetsAPIScaffolding) Reset() {
+	s.store = newTestRouteStore()
+	s.body = bytes.NewBuffer([]byte{})
+
+	s.api = &PresetsAPI{
+		LeveledLogger: newTestRouteLogger(),
+		PresetStore:   s.store,
+		TokenStore:    s.store,
+		Index:         s.store,
+	}
+
+	s.withRequest("/presets")
+}
+
+func (s *presetsAPIScaffolding) withRequest(url string) {
+	s.runtime = &net.RequestRuntime{
+		Request: httptest.NewRequest("GET", url, s.body),
+	}
+}
+
+func (s *presetsAPIScaffolding) authorize() {
+	owner := s.store.register("some-name", "some-device")
+	s.runtime.Header.Set(defs.APIUserTokenHeader, owner)
+}
+
+func (s *presetsAPIScaffolding) preset(name string, frames ...*interchange.ControlFrame) {
+	s.store.SavePreset("some-name", name, interchange.ControlMessage{Frames: frames})
+}
+
+func Test_PresetsAPI(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	scaffold := &presetsAPIScaffolding{}
+
+	g.Describe("ListPresets", func() {
+		g.BeforeEach(scaffold.Reset)
+
+		g.It("returns the list of presets from the library w/o a device id", func() {
+			r := scaffold.api.ListPresets(scaffold.runtime)
+			g.Assert(len(r.Errors)).Equal(0)
+			list, ok := r.Results.([]presets.Preset)
+			g.Assert(ok).Equal(true)
+			g.Assert(len(list)).Equal(len(presets.List()))
+		})
+
+		g.Describe("with a device id", func() {
+			g.BeforeEach(func() {
+				scaffold.withRequest("/presets?device_id=some-device")
+			})
+
+			g.It("fails if unable to find the device", func() {
+				r := scaffold.api.ListPresets(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+			})
+
+			g.It("fails if the token is not authorized", func() {
+				scaffold.store.register("some-name", "some-device")
+				scaffold.runtime.Header.Set(defs.APIUserTokenHeader, "some-token")
+				r := scaffold.api.ListPresets(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+			})
+
+			g.It("fails if unable to list the presets from the store", func() {
+				scaffold.authorize()
+				scaffold.store.errors["ListPresets"] = fmt.Errorf("bad-list")
+				r := scaffold.api.ListPresets(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrServerError)
+			})
+
+			g.It("returns the presets saved for the device to a viewer token", func() {
+				scaffold.store.register("some-name", "some-device")
+				viewer := scaffold.store.token("some-device", defs.SecurityDeviceTokenPermissionViewer)
+				scaffold.runtime.Header.Set(defs.APIUserTokenHeader, viewer)
+				scaffold.preset("deploy")
+				r := scaffold.api.ListPresets(scaffold.runtime)
+				list, ok := r.Results.([]device.PresetDetails)
+				g.Assert(ok).Equal(true)
+				g.Assert(len(list)).Equal(1)
+			})
+		})
+	})
+
+	g.Describe("CreatePreset", func() {
+		g.BeforeEach(scaffold.Reset)
+
+		g.It("fails without a valid json body", func() {
+			r := scaffold.api.CreatePreset(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrBadRequestFormat)
+		})
+
+		g.It("fails with a name that matches a shorthand color", func() {
+			scaffold.body.WriteString("{\"device_id\": \"some-device\", \"name\": \"red\"}")
+			r := scaffold.api.CreatePreset(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidPresetName)
+		})
+
+		g.It("fails with a name that matches a server-side preset", func() {
+			scaffold.body.WriteString("{\"device_id\": \"some-device\", \"name\": \"pulse-red\"}")
+			r := scaffold.api.CreatePreset(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidPresetName)
+		})
+
+		g.It("fails with a name that could not be used in the shorthand route", func() {
+			scaffold.body.WriteString("{\"device_id\": \"some-device\", \"name\": \"Our Deploy\"}")
+			r := scaffold.api.CreatePreset(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidPresetName)
+		})
+
+		g.Describe("with a valid name", func() {
+			g.BeforeEach(func() {
+				scaffold.body.WriteString("{\"device_id\": \"some-device\", \"name\": \"deploy\", \"frames\": [{\"red\": 10}]}")
+			})
+
+			g.It("fails if the token is not authorized", func() {
+				scaffold.store.register("some-name", "some-device")
+				r := scaffold.api.CreatePreset(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+			})
+
+			g.It("fails if the preset already exists", func() {
+				scaffold.authorize()
+				scaffold.preset("deploy")
+				r := scaffold.api.CreatePreset(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrDuplicatePresetName)
+			})
+
+			g.It("fails if the device has too many presets", func() {
+				scaffold.authorize()
+
+				for i := 0; i < defs.SecurityMaxDevicePresets; i++ {
+					scaffold.preset(fmt.Sprintf("preset-%d", i))
+				}
+
+				r := scaffold.api.CreatePreset(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrTooManyPresets)
+			})
+
+			g.It("fails if unable to save the preset", func() {
+				scaffold.authorize()
+				scaffold.store.errors["SavePreset"] = fmt.Errorf("bad-save")
+				r := scaffold.api.CreatePreset(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrServerError)
+			})
+
+			g.It("saves the preset for the device", func() {
+				scaffold.authorize()
+				r := scaffold.api.CreatePreset(scaffold.runtime)
+				g.Assert(len(r.Errors)).Equal(0)
+				saved, e := scaffold.store.FindPreset("some-name", "deploy")
+				g.Assert(e).Equal(nil)
+				g.Assert(saved.Message.Frames[0].Red).Equal(uint32(10))
+			})
+		})
+
+		g.It("fails without any frames", func() {
+			scaffold.body.WriteString("{\"device_id\": \"some-device\", \"name\": \"deploy\"}")
+			scaffold.authorize()
+			r := scaffold.api.CreatePreset(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidControlFrames)
+		})
+	})
+
+	g.Describe("UpdatePreset", func() {
+		g.BeforeEach(scaffold.Reset)
+
+		g.BeforeEach(func() {
+			scaffold.body.WriteString("{\"device_id\": \"some-device\", \"name\": \"deploy\", \"frames\": [{\"blue\": 10}]}")
+		})
+
+		g.It("fails if the token is not authorized", func() {
+			scaffold.store.register("some-name", "some-device")
+			r := scaffold.api.UpdatePreset(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+		})
+
+		g.It("fails if the preset does not exist", func() {
+			scaffold.authorize()
+			r := scaffold.api.UpdatePreset(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+		})
+
+		g.It("replaces the existing preset", func() {
+			scaffold.authorize()
+			scaffold.preset("deploy", &interchange.ControlFrame{Red: 10})
+			r := scaffold.api.UpdatePreset(scaffold.runtime)
+			g.Assert(len(r.Errors)).Equal(0)
+			saved, _ := scaffold.store.FindPreset("some-name", "deploy")
+			g.Assert(saved.Message.Frames[0].Blue).Equal(uint32(10))
+		})
+	})
+
+	g.Describe("DeletePreset", func() {
+		g.BeforeEach(scaffold.Reset)
+
+		g.It("fails without a device id", func() {
+			r := scaffold.api.DeletePreset(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidDeviceID)
+		})
+
+		g.Describe("with a device id and name", func() {
+			g.BeforeEach(func() {
+				scaffold.withRequest("/presets?device_id=some-device&name=deploy")
+			})
+
+			g.It("fails if the token is not an admin token of the device", func() {
+				scaffold.store.register("some-name", "some-device")
+				token := scaffold.store.token("some-device", defs.SecurityDeviceTokenPermissionController)
+				scaffold.runtime.Header.Set(defs.APIUserTokenHeader, token)
+				scaffold.preset("deploy")
+				r := scaffold.api.DeletePreset(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+			})
+
+			g.It("fails if the preset does not exist", func() {
+				scaffold.authorize()
+				r := scaffold.api.DeletePreset(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+			})
+
+			g.It("succeeds after removing the preset", func() {
+				scaffold.authorize()
+				scaffold.preset("deploy")
+				r := scaffold.api.DeletePreset(scaffold.runtime)
+				g.Assert(len(r.Errors)).Equal(0)
+				_, e := scaffold.store.FindPreset("some-name", "deploy")
+				g.Assert(e == nil).Equal(false)
+			})
+		})
+	})
+}
@@ -70,16 +70,21 @@ func (registrations *RegistrationAPI) Preregister(runtime *net.RequestRuntime) n
 		return runtime.LogicError("bad-key-format")
 	}
 
-	details := device.RegistrationRequest(request)
+	details := device.RegistrationRequest{SharedSecret: request.SharedSecret, Name: request.Name}
 
-	if e := registrations.AllocateRegistration(details); e != nil {
+	owner, e := registrations.AllocateRegistration(details)
+
+	if e != nil {
 		registrations.Errorf("unable to allocate registration: %s", e.Error())
 		return runtime.ServerError()
 	}
 
 	registrations.Infof("successfully pre-registered device: %s", details.Name)
 
-	return net.HandlerResult{}
+	// The owner token is only ever returned here; it is needed to create the first admin token of the device.
+	details.OwnerToken = owner
+
+	return net.HandlerResult{Results: []device.RegistrationRequest{details}}
 }
 
 // Register is the route handler responsible for upgrating + registering connections
@@ -14,7 +14,7 @@ import "github.com/dadleyy/beacon.api/beacon/device"
 
 type registrationAPIScaffolding struct {
 	api      *RegistrationAPI
-	registry *testDeviceRegistry
+	store    *testRouteStore
 	runtime  *net.RequestRuntime
 	body     *bytes.Buffer
 	upgrader *testWebsocketUpgrader
@@ -22,12 +22,12 @@ type registrationAPIScaffolding struct {
 }
 
 func prepareRegistrationAPIScaffolding() registrationAPIScaffolding {
-	registry := testDeviceRegistry{}
+	store := newTestRouteStore()
 	stream := make(device.RegistrationStream, 0)
 
 	api := RegistrationAPI{
 		LeveledLogger: newTestRouteLogger(),
-		Registry:      &registry,
+		Registry:      store,
 		stream:        stream,
 	}
 
@@ -42,7 +42,7 @@ func prepareRegistrationAPIScaffolding() registrationAPIScaffolding {
 
 	return registrationAPIScaffolding{
 		api:      &api,
-		registry: &registry,
+		store:    store,
 		upgrader: &upgrader,
 		runtime:  &runtime,
 		stream:   stream,
@@ -106,7 +106,7 @@ func Test_RegistrationAPI(t *testing.T) {
 			})
 
 			g.It("fails if able to find a device by the same name", func() {
-				scaffold.registry.activeRegistrations = append(scaffold.registry.activeRegistrations, device.RegistrationDetails{})
+				scaffold.store.register("some-device", "device-id")
 				r := scaffold.api.Preregister(scaffold.runtime)
 				g.Assert(r.Errors[0].Error()).Equal(defs.ErrDuplicateRegistrationName)
 			})
@@ -150,7 +150,7 @@ func Test_RegistrationAPI(t *testing.T) {
 			})
 
 			g.It("errors when unable to allocate a registration with the registry", func() {
-				scaffold.registry.allocationErrors = append(scaffold.registry.allocationErrors, fmt.Errorf("error"))
+				scaffold.store.errors["AllocateRegistration"] = fmt.Errorf("error")
 				r := scaffold.api.Preregister(scaffold.runtime)
 				g.Assert(r.Errors[0].Error()).Equal(defs.ErrServerError)
 			})
@@ -159,6 +159,14 @@ func Test_RegistrationAPI(t *testing.T) {
 				r := scaffold.api.Preregister(scaffold.runtime)
 				g.Assert(len(r.Errors)).Equal(0)
 			})
+
+			g.It("responds w/ the owner token issued by the registry", func() {
+				r := scaffold.api.Preregister(scaffold.runtime)
+				results, ok := r.Results.([]device.RegistrationRequest)
+				g.Assert(ok).Equal(true)
+				g.Assert(results[0].OwnerToken).Equal("generated-token-1")
+				g.Assert(results[0].Name).Equal("some-device")
+			})
 		})
 	})
 
@@ -196,8 +204,7 @@ func Test_RegistrationAPI(t *testing.T) {
 					scaffold.runtime.Header.Set(defs.APIDeviceRegistrationHeader, string(secretValue))
 				})
 
-				g.It("fails + closes the connection if unable to fill registration", func() {
-					scaffold.registry.fillErrors = append(scaffold.registry.fillErrors, fmt.Errorf("invalid"))
+				g.It("fails + closes the connection if no registration was allocated for the key", func() {
 					g.Assert(connection.closeCount).Equal(0)
 					r := scaffold.api.Register(scaffold.runtime)
 					g.Assert(connection.closeCount).Equal(1)
@@ -205,6 +212,8 @@ func Test_RegistrationAPI(t *testing.T) {
 				})
 
 				g.It("sends the connection to the registration stream if successfully filled", func() {
+					request := device.RegistrationRequest{Name: "some-device", SharedSecret: string(secretValue)}
+					scaffold.store.AllocateRegistration(request)
 					wg := sync.WaitGroup{}
 
 					go func() {
@@ -216,6 +225,8 @@ func Test_RegistrationAPI(t *testing.T) {
 					r := scaffold.api.Register(scaffold.runtime)
 					wg.Wait()
 					g.Assert(r.NoRender).Equal(true)
+					_, e := scaffold.store.FindDevice("some-device")
+					g.Assert(e).Equal(nil)
 				})
 
 			})
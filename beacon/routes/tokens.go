@@ -1,6 +1,7 @@
 package routes
 
 import "fmt"
+import "time"
 import "github.com/dadleyy/beacon.api/beacon/net"
 import "github.com/dadleyy/beacon.api/beacon/defs"
 import "github.com/dadleyy/beacon.api/beacon/device"
@@ -13,9 +14,30 @@ func NewTokensAPI(store device.TokenStore, index device.Index) *TokensAPI {
 }
 
 type tokenRequest struct {
-	DeviceID   string `json:"device_id"`
-	Name       string `json:"name"`
-	Permission uint   `json:"permission"`
+	DeviceID   string     `json:"device_id"`
+	Name       string     `json:"name"`
+	Permission uint       `json:"permission"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	TTL        uint       `json:"ttl"`
+}
+
+// expiry returns the time the requested token should stop being accepted, given either as an absolute "expires_at" or
+// as a "ttl" in seconds from now. Tokens requested w/ neither do not expire.
+func (request tokenRequest) expiry(now time.Time) (*time.Time, error) {
+	if request.ExpiresAt != nil && request.TTL != 0 {
+		return nil, fmt.Errorf(defs.ErrInvalidTokenExpiry)
+	}
+
+	if request.TTL != 0 {
+		expiresAt := now.Add(time.Duration(request.TTL) * time.Second)
+		return &expiresAt, nil
+	}
+
+	if request.ExpiresAt != nil && request.ExpiresAt.After(now) != true {
+		return nil, fmt.Errorf(defs.ErrInvalidTokenExpiry)
+	}
+
+	return request.ExpiresAt, nil
 }
 
 // TokensAPI defines the api for creating/deleting device auth tokens.
@@ -43,28 +65,35 @@ func (tokens *TokensAPI) CreateToken(requestRuntime *net.RequestRuntime) net.Han
 		return requestRuntime.LogicError(defs.ErrInvalidDeviceTokenName)
 	}
 
-	registration, e := tokens.FindDevice(request.DeviceID)
+	expiresAt, e := request.expiry(time.Now())
 
 	if e != nil {
-		tokens.Warnf("unable to find device (device id: %s): %s", request.DeviceID, e.Error())
-		return requestRuntime.LogicError(defs.ErrNotFound)
+		return requestRuntime.LogicError(e.Error())
 	}
 
 	token := requestRuntime.HeaderValue(defs.APIUserTokenHeader)
 
 	if token == "" {
-		tokens.Warnf("attempt to create token w/o auth for device %s", registration.DeviceID)
+		tokens.Warnf("attempt to create token w/o auth for device %s", request.DeviceID)
 		return requestRuntime.LogicError(defs.ErrInvalidTokenRequest)
 	}
 
 	// Attempt to authorize the provided token against the admin permission.
-	if tokens.AuthorizeToken(registration.DeviceID, token, defs.SecurityDeviceTokenPermissionAdmin) != true {
-		tokens.Warnf("unauthorized attempt to create token (token: %s, device: %s)", token, registration.DeviceID)
+	query := request.DeviceID
+	registration, e := device.FindAuthorizedDevice(tokens.Index, tokens.TokenStore, query, token, adminPermission)
+
+	if e != nil && e.Error() == defs.ErrUnauthorizedToken {
+		tokens.Warnf("unauthorized attempt to create token (device: %s)", request.DeviceID)
 		return requestRuntime.LogicError(defs.ErrInvalidTokenRequest)
 	}
 
+	if e != nil {
+		tokens.Warnf("unable to find device (device id: %s): %s", request.DeviceID, e.Error())
+		return requestRuntime.LogicError(defs.ErrNotFound)
+	}
+
 	tokens.Debugf("creating device token for device %s (permission: %b)", registration.DeviceID, request.Permission)
-	return tokens.create(registration.DeviceID, request.Name, request.Permission)
+	return tokens.create(registration.DeviceID, request.Name, request.Permission, expiresAt)
 }
 
 // ListTokens returns a set tokens based on the device id provided.
@@ -82,15 +111,11 @@ func (tokens *TokensAPI) ListTokens(requestRuntime *net.RequestRuntime) net.Hand
 		return requestRuntime.LogicError(defs.ErrNotFound)
 	}
 
-	registration, e := tokens.FindDevice(id)
+	// Attempt to authorize the provided token against the admin permission.
+	registration, e := device.FindAuthorizedDevice(tokens.Index, tokens.TokenStore, id, token, adminPermission)
 
 	if e != nil {
-		return requestRuntime.LogicError(defs.ErrNotFound)
-	}
-
-	// Attempt to authorize the provided token against the admin permission.
-	if tokens.AuthorizeToken(registration.DeviceID, token, defs.SecurityDeviceTokenPermissionAdmin) != true {
-		tokens.Warnf("unauthorized attempt to create token (token: %s, device: %s)", token, registration.DeviceID)
+		tokens.Warnf("unable to authorize token listing (device: %s): %s", id, e.Error())
 		return requestRuntime.LogicError(defs.ErrNotFound)
 	}
 
@@ -104,15 +129,43 @@ func (tokens *TokensAPI) ListTokens(requestRuntime *net.RequestRuntime) net.Hand
 	return net.HandlerResult{Results: deviceTokens}
 }
 
-func (tokens *TokensAPI) create(deviceID, name string, permission uint) net.HandlerResult {
-	token, e := tokens.TokenStore.CreateToken(deviceID, name, permission)
+// DeleteToken removes the token identified in the path from the device in the query string. The token is rejected by
+// any further authorization attempts.
+func (tokens *TokensAPI) DeleteToken(requestRuntime *net.RequestRuntime) net.HandlerResult {
+	id, tokenID := requestRuntime.GetQueryParam("device_id"), requestRuntime.Get("id")
+
+	token := requestRuntime.HeaderValue(defs.APIUserTokenHeader)
+
+	if token == "" {
+		tokens.Warnf("attempt to delete token w/o auth for device")
+		return requestRuntime.LogicError(defs.ErrNotFound)
+	}
+
+	registration, e := device.FindAuthorizedDevice(tokens.Index, tokens.TokenStore, id, token, adminPermission)
+
+	if e != nil {
+		tokens.Warnf("unable to authorize token removal (device: %s): %s", id, e.Error())
+		return requestRuntime.LogicError(defs.ErrNotFound)
+	}
+
+	if e := tokens.RemoveToken(registration.DeviceID, tokenID); e != nil {
+		tokens.Warnf("unable to remove token[%s] of device[%s]: %s", tokenID, registration.DeviceID, e.Error())
+		return requestRuntime.LogicError(defs.ErrNotFound)
+	}
+
+	tokens.Infof("removed token[%s] of device[%s]", tokenID, registration.DeviceID)
+	return net.HandlerResult{}
+}
+
+func (tokens *TokensAPI) create(deviceID, name string, permission uint, expiresAt *time.Time) net.HandlerResult {
+	token, e := tokens.TokenStore.CreateToken(deviceID, name, permission, expiresAt)
 
 	if e != nil {
-		tokens.Warnf("unable to create token: %s (got %v)", e.Error(), token)
+		tokens.Warnf("unable to create token: %s", e.Error())
 		return net.HandlerResult{Errors: []error{fmt.Errorf("server-error")}}
 	}
 
-	tokens.Debugf("created token: %v", token)
+	tokens.Debugf("created token[%s] for device[%s]", token.TokenID, token.DeviceID)
 
 	return net.HandlerResult{Results: []device.TokenDetails{token}}
 }
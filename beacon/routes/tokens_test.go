@@ -1,9 +1,12 @@
 package routes
 
 import "fmt"
+import "time"
 import "bytes"
+import "strings"
 import "testing"
 import "crypto/rand"
+import "net/url"
 import "encoding/hex"
 import "net/http/httptest"
 import "github.com/franela/goblin"
@@ -13,8 +16,7 @@ import "github.com/dadleyy/beacon.api/beacon/device"
 
 type tokensAPIScaffolding struct {
 	api     *TokensAPI
-	store   *testDeviceTokenStore
-	index   *testDeviceIndex
+	store   *testRouteStore
 	runtime *net.RequestRuntime
 	body    *bytes.Buffer
 }
@@ -22,8 +24,7 @@ type tokensAPIScaffolding struct {
 func (t *tokensAPIScaffolding) Reset() {
 	logger := newTestRouteLogger()
 
-	t.store = &testDeviceTokenStore{}
-	t.index = &testDeviceIndex{}
+	t.store = newTestRouteStore()
 
 	t.body = bytes.NewBuffer([]byte{})
 
@@ -34,7 +35,7 @@ func (t *tokensAPIScaffolding) Reset() {
 	t.api = &TokensAPI{
 		LeveledLogger: logger,
 		TokenStore:    t.store,
-		Index:         t.index,
+		Index:         t.store,
 	}
 }
 
@@ -61,7 +62,7 @@ func Test_TokensAPI(suite *testing.T) {
 			})
 
 			g.It("fails without having set the token authorization header", func() {
-				scaffold.index.foundDevices = append(scaffold.index.foundDevices, device.RegistrationDetails{})
+				scaffold.store.register("desk-lamp", "some-device")
 				r := scaffold.api.ListTokens(scaffold.runtime)
 				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
 			})
@@ -72,13 +73,12 @@ func Test_TokensAPI(suite *testing.T) {
 				})
 
 				g.It("fails without finding a device associated with the id in the query string", func() {
-					scaffold.index.findErrors = append(scaffold.index.findErrors, fmt.Errorf("bad-find"))
 					r := scaffold.api.ListTokens(scaffold.runtime)
 					g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
 				})
 
 				g.It("fails if unauthorized attempt", func() {
-					scaffold.index.foundDevices = append(scaffold.index.foundDevices, device.RegistrationDetails{})
+					scaffold.store.register("desk-lamp", "some-device")
 					r := scaffold.api.ListTokens(scaffold.runtime)
 					g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
 				})
@@ -86,20 +86,23 @@ func Test_TokensAPI(suite *testing.T) {
 				g.Describe("with valid auth and found devices", func() {
 
 					g.BeforeEach(func() {
-						scaffold.index.foundDevices = append(scaffold.index.foundDevices, device.RegistrationDetails{})
-						scaffold.store.authorized = true
+						owner := scaffold.store.register("desk-lamp", "some-device")
+						scaffold.runtime.Header.Set(defs.APIUserTokenHeader, owner)
 					})
 
 					g.It("fails if unable to list tokens", func() {
-						scaffold.store.listedErrors = append(scaffold.store.listedErrors, fmt.Errorf("bad-list"))
+						scaffold.store.errors["ListTokens"] = fmt.Errorf("bad-list")
 						r := scaffold.api.ListTokens(scaffold.runtime)
 						g.Assert(r.Errors[0].Error()).Equal(defs.ErrServerError)
 					})
 
 					g.It("returns the found tokens", func() {
-						scaffold.store.listedTokens = append(scaffold.store.listedTokens, device.TokenDetails{})
+						scaffold.store.token("some-device", defs.SecurityDeviceTokenPermissionViewer)
 						r := scaffold.api.ListTokens(scaffold.runtime)
 						g.Assert(len(r.Errors)).Equal(0)
+						list, ok := r.Results.([]device.TokenDetails)
+						g.Assert(ok).Equal(true)
+						g.Assert(len(list)).Equal(1)
 					})
 
 				})
@@ -135,13 +138,13 @@ func Test_TokensAPI(suite *testing.T) {
 			})
 
 			g.It("fails if it is unable to find the device associated with the request", func() {
-				scaffold.index.findErrors = append(scaffold.index.findErrors, fmt.Errorf("bad-find"))
+				scaffold.runtime.Header.Set(defs.APIUserTokenHeader, "some-token")
 				r := scaffold.api.CreateToken(scaffold.runtime)
 				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
 			})
 
 			g.It("fails if no token was provided in the header", func() {
-				scaffold.index.foundDevices = append(scaffold.index.foundDevices, device.RegistrationDetails{})
+				scaffold.store.register("desk-lamp", "some-device")
 				r := scaffold.api.CreateToken(scaffold.runtime)
 				g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidTokenRequest)
 			})
@@ -149,6 +152,7 @@ func Test_TokensAPI(suite *testing.T) {
 			g.Describe("with a valid name and device id", func() {
 
 				deviceID := "some-device"
+				var owner string
 
 				g.BeforeEach(func() {
 					nameBuffer := make([]byte, defs.SecurityUserDeviceNameMinLength+1)
@@ -156,37 +160,161 @@ func Test_TokensAPI(suite *testing.T) {
 					json := fmt.Sprintf(`{"name": "%s", "device_id": "%s"}`, hex.EncodeToString(nameBuffer), deviceID)
 					scaffold.body.Reset()
 					scaffold.body.Write([]byte(json))
+					owner = scaffold.store.register("desk-lamp", deviceID)
 					scaffold.runtime.Header.Set(defs.APIUserTokenHeader, "some-token")
-					scaffold.index.foundDevices = append(scaffold.index.foundDevices, device.RegistrationDetails{
-						DeviceID: deviceID,
-					})
 				})
 
 				g.It("fails if it is unable to authorize the token found in the header", func() {
 					r := scaffold.api.CreateToken(scaffold.runtime)
 					g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidTokenRequest)
-					v, ok := scaffold.store.authorizationAttempts[deviceID]
-					g.Assert(ok).Equal(true)
-					permission, ok := v["some-token"]
-					g.Assert(ok).Equal(true)
-					g.Assert(permission).Equal(uint(defs.SecurityDeviceTokenPermissionAdmin))
+				})
+
+				g.It("fails if the token found in the header is not an admin token", func() {
+					controller := scaffold.store.token(deviceID, defs.SecurityDeviceTokenPermissionController)
+					scaffold.runtime.Header.Set(defs.APIUserTokenHeader, controller)
+					r := scaffold.api.CreateToken(scaffold.runtime)
+					g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidTokenRequest)
 				})
 
 				g.It("errors if it is unable to create the token", func() {
-					scaffold.store.authorized = true
+					scaffold.runtime.Header.Set(defs.APIUserTokenHeader, owner)
+					scaffold.store.errors["CreateToken"] = fmt.Errorf("bad-create")
 					r := scaffold.api.CreateToken(scaffold.runtime)
 					g.Assert(r.Errors[0].Error()).Equal(defs.ErrServerError)
 				})
 
-				g.It("succeeds if it is unable to create the token", func() {
-					scaffold.store.authorized = true
-					scaffold.store.createdTokens = append(scaffold.store.createdTokens, device.TokenDetails{})
+				g.It("succeeds if it is able to create the token", func() {
+					scaffold.runtime.Header.Set(defs.APIUserTokenHeader, owner)
 					r := scaffold.api.CreateToken(scaffold.runtime)
 					g.Assert(len(r.Errors)).Equal(0)
+					created, _ := scaffold.store.ListTokens(deviceID)
+					g.Assert(len(created)).Equal(1)
+					g.Assert(created[0].ExpiresAt == nil).Equal(true)
+				})
+
+				g.Describe("with an expiry in the request", func() {
+					name := strings.Repeat("a", defs.SecurityUserDeviceNameMinLength+1)
+
+					created := func() []device.TokenDetails {
+						list, _ := scaffold.store.ListTokens(deviceID)
+						return list
+					}
+
+					g.BeforeEach(func() {
+						scaffold.runtime.Header.Set(defs.APIUserTokenHeader, owner)
+					})
+
+					g.It("creates the token w/ an expiry of the ttl from now", func() {
+						scaffold.body.Reset()
+						fmt.Fprintf(scaffold.body, `{"name": "%s", "device_id": "%s", "ttl": 60}`, name, deviceID)
+						r := scaffold.api.CreateToken(scaffold.runtime)
+						g.Assert(len(r.Errors)).Equal(0)
+						remaining := created()[0].ExpiresAt.Sub(time.Now())
+						g.Assert(remaining > 59*time.Second && remaining <= time.Minute).Equal(true)
+					})
+
+					g.It("creates the token w/ the expires_at provided", func() {
+						expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+						scaffold.body.Reset()
+						template := `{"name": "%s", "device_id": "%s", "expires_at": "%s"}`
+						fmt.Fprintf(scaffold.body, template, name, deviceID, expiresAt.Format(time.RFC3339))
+						r := scaffold.api.CreateToken(scaffold.runtime)
+						g.Assert(len(r.Errors)).Equal(0)
+						g.Assert(created()[0].ExpiresAt.Equal(expiresAt)).Equal(true)
+					})
+
+					g.It("fails if the expires_at has already passed", func() {
+						expiresAt := time.Now().Add(-time.Hour)
+						scaffold.body.Reset()
+						template := `{"name": "%s", "device_id": "%s", "expires_at": "%s"}`
+						fmt.Fprintf(scaffold.body, template, name, deviceID, expiresAt.Format(time.RFC3339))
+						r := scaffold.api.CreateToken(scaffold.runtime)
+						g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidTokenExpiry)
+						g.Assert(len(created())).Equal(0)
+					})
+
+					g.It("fails if given both an expires_at and a ttl", func() {
+						expiresAt := time.Now().Add(time.Hour)
+						scaffold.body.Reset()
+						template := `{"name": "%s", "device_id": "%s", "expires_at": "%s", "ttl": 60}`
+						fmt.Fprintf(scaffold.body, template, name, deviceID, expiresAt.Format(time.RFC3339))
+						r := scaffold.api.CreateToken(scaffold.runtime)
+						g.Assert(r.Errors[0].Error()).Equal(defs.ErrInvalidTokenExpiry)
+					})
 				})
 			})
 
 		})
 
 	})
+
+	g.Describe("DeleteToken", func() {
+		var created device.TokenDetails
+
+		g.BeforeEach(func() {
+			scaffold.Reset()
+
+			scaffold.store.register("desk-lamp", "some-device")
+			created, _ = scaffold.store.CreateToken("some-device", "kitchen", defs.SecurityDeviceTokenPermissionViewer, nil)
+
+			values := make(url.Values)
+			values.Set("id", created.TokenID)
+
+			scaffold.runtime = &net.RequestRuntime{
+				Request: httptest.NewRequest("DELETE", "/device-tokens/"+created.TokenID+"?device_id=some-device", nil),
+				Values:  values,
+			}
+		})
+
+		remaining := func() []device.TokenDetails {
+			list, _ := scaffold.store.ListTokens("some-device")
+			return list
+		}
+
+		g.It("fails without having set the token authorization header", func() {
+			r := scaffold.api.DeleteToken(scaffold.runtime)
+			g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+		})
+
+		g.Describe("having found a token in the header", func() {
+			g.BeforeEach(func() {
+				scaffold.runtime.Header.Set(defs.APIUserTokenHeader, "some-token")
+			})
+
+			g.It("fails without finding the device", func() {
+				scaffold.store.RemoveDevice("some-device")
+				r := scaffold.api.DeleteToken(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+			})
+
+			g.It("fails w/o removing the token if the token in the header is not an admin", func() {
+				controller := scaffold.store.token("some-device", defs.SecurityDeviceTokenPermissionController)
+				scaffold.runtime.Header.Set(defs.APIUserTokenHeader, controller)
+				r := scaffold.api.DeleteToken(scaffold.runtime)
+				g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+				g.Assert(len(remaining())).Equal(2)
+			})
+
+			g.Describe("with valid auth and found devices", func() {
+				g.BeforeEach(func() {
+					admin := scaffold.store.token("some-device", defs.SecurityDeviceTokenPermissionAdmin)
+					scaffold.runtime.Header.Set(defs.APIUserTokenHeader, admin)
+				})
+
+				g.It("fails if unable to remove the token", func() {
+					scaffold.store.errors["RemoveToken"] = fmt.Errorf("bad-remove")
+					r := scaffold.api.DeleteToken(scaffold.runtime)
+					g.Assert(r.Errors[0].Error()).Equal(defs.ErrNotFound)
+				})
+
+				g.It("removes the token identified in the path", func() {
+					r := scaffold.api.DeleteToken(scaffold.runtime)
+					g.Assert(len(r.Errors)).Equal(0)
+					g.Assert(len(remaining())).Equal(1)
+					_, e := scaffold.store.FindToken(created.Token)
+					g.Assert(e == nil).Equal(false)
+				})
+			})
+		})
+	})
 }
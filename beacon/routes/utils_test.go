@@ -3,11 +3,15 @@ package routes
 import "io"
 import "fmt"
 import "log"
+import "time"
 import "bytes"
+import "strings"
 import "net/http"
+import "github.com/dadleyy/beacon.api/beacon/bg"
 import "github.com/dadleyy/beacon.api/beacon/defs"
 import "github.com/dadleyy/beacon.api/beacon/device"
 import "github.com/dadleyy/beacon.api/beacon/logging"
+import "github.com/dadleyy/beacon.api/beacon/security"
 import "github.com/dadleyy/beacon.api/beacon/interchange"
 
 func newTestRouteLogger() *logging.Logger {
@@ -17,149 +21,243 @@ func newTestRouteLogger() *logging.Logger {
 	return &logging.Logger{Logger: logger}
 }
 
-type testChannelPublisher struct {
+type testTokenGenerator struct {
+	generated int
 }
 
-func (t *testChannelPublisher) PublishReader(string, io.Reader) error {
-	return nil
+func (t *testTokenGenerator) GenerateToken() (string, error) {
+	t.generated++
+	return fmt.Sprintf("generated-token-%d", t.generated), nil
 }
 
-type feedbackStoreListParams struct {
-	deviceID      string
-	feedbackCount int
+// testRouteStore backs the route tests w/ a memory registry. An error set for a method name is returned by that method
+// in place of calling through to the registry.
+type testRouteStore struct {
+	*device.MemoryRegistry
+	errors map[string]error
 }
 
-type testFeedbackStore struct {
-	testErrorStore
-	listResults []interchange.FeedbackMessage
-	listErrors  []error
-	logErrors   []error
-	listCalls   []feedbackStoreListParams
+func newTestRouteStore() *testRouteStore {
+	registry := device.NewMemoryRegistry(&testTokenGenerator{})
+	registry.Logger = newTestRouteLogger()
+	return &testRouteStore{MemoryRegistry: registry, errors: make(map[string]error)}
+}
+
+// register fills a registration for the device name under the id, returning the owner token of the device.
+func (t *testRouteStore) register(name, id string) string {
+	secret := fmt.Sprintf("%s-%s", name, strings.Repeat("s", defs.SecurityMinimumDeviceSharedSecretSize))
+	owner, _ := t.MemoryRegistry.AllocateRegistration(device.RegistrationRequest{Name: name, SharedSecret: secret})
+	t.MemoryRegistry.FillRegistration(secret, id)
+	return owner
+}
+
+// token creates a token w/ the permission for the device, returning the raw token.
+func (t *testRouteStore) token(deviceID string, permission uint) string {
+	details, _ := t.MemoryRegistry.CreateToken(deviceID, "test-token", permission, nil)
+	return details.Token
 }
 
-func (t *testFeedbackStore) LogFeedback(interchange.FeedbackMessage) error {
-	return t.latestError(t.logErrors)
+// account creates an account w/ the permissions granted by device name, returning the account.
+func (t *testRouteStore) account(permissions map[string]defs.DeviceTokenPermissions) device.AccountDetails {
+	account, _ := t.MemoryRegistry.CreateAccount("test-account")
+
+	for name, permission := range permissions {
+		t.MemoryRegistry.GrantAccountPermission(account.AccountID, name, permission)
+	}
+
+	return account
 }
 
-func (t *testFeedbackStore) ListFeedback(d string, c int) ([]interchange.FeedbackMessage, error) {
-	t.listCalls = append(t.listCalls, feedbackStoreListParams{d, c})
+func (t *testRouteStore) AllocateRegistration(request device.RegistrationRequest) (string, error) {
+	if e := t.errors["AllocateRegistration"]; e != nil {
+		return "", e
+	}
+
+	return t.MemoryRegistry.AllocateRegistration(request)
+}
 
-	if e := t.latestError(t.listErrors); e != nil {
+func (t *testRouteStore) ListRegistrations() ([]device.RegistrationDetails, error) {
+	if e := t.errors["ListRegistrations"]; e != nil {
 		return nil, e
 	}
 
-	return t.listResults, nil
+	return t.MemoryRegistry.ListRegistrations()
 }
 
-type testDeviceRegistry struct {
-	testErrorStore
-	allocationErrors       []error
-	findErrors             []error
-	fillErrors             []error
-	listRegistrationErrors []error
-	removalErrors          []error
-	activeRegistrations    []device.RegistrationDetails
+func (t *testRouteStore) CreateToken(
+	deviceID, name string,
+	permission uint,
+	expiry *time.Time,
+) (device.TokenDetails, error) {
+	if e := t.errors["CreateToken"]; e != nil {
+		return device.TokenDetails{}, e
+	}
+
+	return t.MemoryRegistry.CreateToken(deviceID, name, permission, expiry)
 }
 
-func (t *testDeviceRegistry) AllocateRegistration(device.RegistrationRequest) error {
-	return t.latestError(t.allocationErrors)
+func (t *testRouteStore) ListTokens(query string) ([]device.TokenDetails, error) {
+	if e := t.errors["ListTokens"]; e != nil {
+		return nil, e
+	}
+
+	return t.MemoryRegistry.ListTokens(query)
 }
 
-func (t *testDeviceRegistry) FindDevice(string) (device.RegistrationDetails, error) {
-	if e := t.latestError(t.findErrors); e != nil {
-		return device.RegistrationDetails{}, e
+func (t *testRouteStore) RemoveToken(deviceID, tokenID string) error {
+	if e := t.errors["RemoveToken"]; e != nil {
+		return e
 	}
 
-	if len(t.activeRegistrations) >= 1 {
-		return t.activeRegistrations[0], nil
+	return t.MemoryRegistry.RemoveToken(deviceID, tokenID)
+}
+
+func (t *testRouteStore) LogFeedback(message interchange.FeedbackMessage) error {
+	if e := t.errors["LogFeedback"]; e != nil {
+		return e
 	}
 
-	return device.RegistrationDetails{}, fmt.Errorf("not-found")
+	return t.MemoryRegistry.LogFeedback(message)
 }
 
-func (t *testDeviceRegistry) FillRegistration(string, string) error {
-	return t.latestError(t.fillErrors)
+func (t *testRouteStore) ListFeedback(deviceID string, count int) ([]interchange.FeedbackMessage, error) {
+	if e := t.errors["ListFeedback"]; e != nil {
+		return nil, e
+	}
+
+	return t.MemoryRegistry.ListFeedback(deviceID, count)
 }
 
-func (t *testDeviceRegistry) RemoveDevice(string) error {
-	return t.latestError(t.removalErrors)
+func (t *testRouteStore) CreateAccount(name string) (device.AccountDetails, error) {
+	if e := t.errors["CreateAccount"]; e != nil {
+		return device.AccountDetails{}, e
+	}
+
+	return t.MemoryRegistry.CreateAccount(name)
+}
+
+func (t *testRouteStore) GrantAccountPermission(accountID, name string, permission defs.DeviceTokenPermissions) error {
+	if e := t.errors["GrantAccountPermission"]; e != nil {
+		return e
+	}
+
+	return t.MemoryRegistry.GrantAccountPermission(accountID, name, permission)
 }
 
-func (t *testDeviceRegistry) ListRegistrations() ([]device.RegistrationDetails, error) {
-	if e := t.latestError(t.listRegistrationErrors); e != nil {
+func (t *testRouteStore) SavePreset(deviceName, name string, message interchange.ControlMessage) error {
+	if e := t.errors["SavePreset"]; e != nil {
+		return e
+	}
+
+	return t.MemoryRegistry.SavePreset(deviceName, name, message)
+}
+
+func (t *testRouteStore) ListPresets(deviceName string) ([]device.PresetDetails, error) {
+	if e := t.errors["ListPresets"]; e != nil {
 		return nil, e
 	}
 
-	return t.activeRegistrations, nil
+	return t.MemoryRegistry.ListPresets(deviceName)
 }
 
-type testErrorStore struct {
+func (t *testRouteStore) CreateGroup(name string) (device.GroupDetails, error) {
+	if e := t.errors["CreateGroup"]; e != nil {
+		return device.GroupDetails{}, e
+	}
+
+	return t.MemoryRegistry.CreateGroup(name)
 }
 
-func (t *testErrorStore) latestError(errList []error) error {
-	if len(errList) >= 1 {
-		return errList[0]
+func (t *testRouteStore) ListGroups() ([]device.GroupDetails, error) {
+	if e := t.errors["ListGroups"]; e != nil {
+		return nil, e
 	}
 
-	return nil
+	return t.MemoryRegistry.ListGroups()
 }
 
-type testDeviceTokenStore struct {
-	authorized            bool
-	createdTokens         []device.TokenDetails
-	creationErrors        []error
-	listedTokens          []device.TokenDetails
-	listedErrors          []error
-	authorizationAttempts map[string]map[string]uint
+func (t *testRouteStore) AddGroupDevice(groupID, name string) error {
+	if e := t.errors["AddGroupDevice"]; e != nil {
+		return e
+	}
+
+	return t.MemoryRegistry.AddGroupDevice(groupID, name)
 }
 
-func (t *testDeviceTokenStore) AuthorizeToken(deviceID string, newToken string, level uint) bool {
-	if t.authorizationAttempts == nil {
-		t.authorizationAttempts = make(map[string]map[string]uint)
+func (t *testRouteStore) CreateSchedule(schedule device.ScheduleDetails) (device.ScheduleDetails, error) {
+	if e := t.errors["CreateSchedule"]; e != nil {
+		return device.ScheduleDetails{}, e
 	}
 
-	t.authorizationAttempts[deviceID] = map[string]uint{newToken: level}
+	return t.MemoryRegistry.CreateSchedule(schedule)
+}
 
-	return t.authorized
+func (t *testRouteStore) ListSchedules(name string) ([]device.ScheduleDetails, error) {
+	if e := t.errors["ListSchedules"]; e != nil {
+		return nil, e
+	}
+
+	return t.MemoryRegistry.ListSchedules(name)
 }
 
-func (t *testDeviceTokenStore) ListTokens(string) ([]device.TokenDetails, error) {
-	if len(t.listedErrors) >= 1 {
-		return nil, t.listedErrors[0]
+func (t *testRouteStore) CreateCommand(deviceID, token string) (device.CommandDetails, error) {
+	if e := t.errors["CreateCommand"]; e != nil {
+		return device.CommandDetails{}, e
 	}
 
-	return t.listedTokens, nil
+	return t.MemoryRegistry.CreateCommand(deviceID, token)
 }
 
-func (t *testDeviceTokenStore) CreateToken(string, string, uint) (device.TokenDetails, error) {
-	if len(t.createdTokens) >= 1 {
-		return t.createdTokens[0], nil
+func (t *testRouteStore) LogCommand(token string, entry device.CommandLogEntry) error {
+	if e := t.errors["LogCommand"]; e != nil {
+		return e
 	}
 
-	if len(t.creationErrors) >= 1 {
-		return device.TokenDetails{}, t.creationErrors[0]
+	return t.MemoryRegistry.LogCommand(token, entry)
+}
+
+func (t *testRouteStore) ListCommandLog(name string, offset, count int) ([]device.CommandLogEntry, error) {
+	if e := t.errors["ListCommandLog"]; e != nil {
+		return nil, e
 	}
 
-	return device.TokenDetails{}, fmt.Errorf("not-found")
+	return t.MemoryRegistry.ListCommandLog(name, offset, count)
 }
 
-type testDeviceIndex struct {
-	testErrorStore
-	foundDevices  []device.RegistrationDetails
-	findErrors    []error
-	removalErrors []error
+func (t *testRouteStore) SaveAccessToken(details device.AccessTokenDetails) error {
+	if e := t.errors["SaveAccessToken"]; e != nil {
+		return e
+	}
+
+	return t.MemoryRegistry.SaveAccessToken(details)
+}
+
+func (t *testRouteStore) RevokeAccessToken(id string, expiresAt time.Time) error {
+	if e := t.errors["RevokeAccessToken"]; e != nil {
+		return e
+	}
+
+	return t.MemoryRegistry.RevokeAccessToken(id, expiresAt)
 }
 
-func (t *testDeviceIndex) RemoveDevice(string) error {
-	return t.latestError(t.removalErrors)
+type testChannelPublisher struct {
+	published []io.Reader
 }
 
-func (t *testDeviceIndex) FindDevice(string) (device.RegistrationDetails, error) {
-	if e := t.latestError(t.findErrors); e != nil {
-		return device.RegistrationDetails{}, e
+func (t *testChannelPublisher) PublishReader(_ string, reader io.Reader) error {
+	t.published = append(t.published, reader)
+	return nil
+}
+
+type testErrorStore struct {
+}
+
+func (t *testErrorStore) latestError(errList []error) error {
+	if len(errList) >= 1 {
+		return errList[0]
 	}
 
-	return t.foundDevices[0], nil
+	return nil
 }
 
 type testWebsocketUpgrader struct {
@@ -182,9 +280,15 @@ func (t *testWebsocketUpgrader) UpgradeWebsocket(http.ResponseWriter, *http.Requ
 
 type testWebsocketConnection struct {
 	closeCount int
+	written    []string
+	open       chan struct{}
 }
 
 func (t *testWebsocketConnection) NextReader() (int, io.Reader, error) {
+	if t.open != nil {
+		<-t.open
+	}
+
 	return 0, nil, fmt.Errorf("not-implemented")
 }
 
@@ -194,5 +298,55 @@ func (t *testWebsocketConnection) Close() error {
 }
 
 func (t *testWebsocketConnection) NextWriter(int) (io.WriteCloser, error) {
-	return nil, fmt.Errorf("not-implemented")
+	return &testWebsocketWriter{connection: t}, nil
+}
+
+type testWebsocketWriter struct {
+	bytes.Buffer
+	connection *testWebsocketConnection
+}
+
+func (t *testWebsocketWriter) Close() error {
+	t.connection.written = append(t.connection.written, t.String())
+	return nil
+}
+
+type testEventSubscriber struct {
+	events       chan bg.DeviceEvent
+	subscribed   []string
+	unsubscribed int
+}
+
+func (t *testEventSubscriber) Subscribe(name string) *bg.EventSubscription {
+	t.subscribed = append(t.subscribed, name)
+	return &bg.EventSubscription{Events: t.events}
+}
+
+func (t *testEventSubscriber) Unsubscribe(*bg.EventSubscription) {
+	t.unsubscribed++
+}
+
+type testAccessTokenKey struct {
+	testErrorStore
+	signed      []security.AccessClaims
+	signErrors  []error
+	claims      security.AccessClaims
+	parseErrors []error
+}
+
+func (t *testAccessTokenKey) SignAccessToken(claims security.AccessClaims) (string, error) {
+	if e := t.latestError(t.signErrors); e != nil {
+		return "", e
+	}
+
+	t.signed = append(t.signed, claims)
+	return "header.claims.signature", nil
+}
+
+func (t *testAccessTokenKey) ParseAccessToken(string, time.Time) (security.AccessClaims, error) {
+	if e := t.latestError(t.parseErrors); e != nil {
+		return security.AccessClaims{}, e
+	}
+
+	return t.claims, nil
 }
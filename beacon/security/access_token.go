@@ -0,0 +1,141 @@
+package security
+
+import "fmt"
+import "time"
+import "bytes"
+import "crypto"
+import "strings"
+import "crypto/rsa"
+import "crypto/rand"
+import "crypto/sha256"
+import "encoding/json"
+import "encoding/base64"
+
+import "github.com/dadleyy/beacon.api/beacon/defs"
+
+// accessTokenHeader is the encoded jwt header of every access token; tokens signed w/ any other algorithm are rejected.
+var accessTokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+
+// AccessClaims are the claims carried by signed access tokens (jwt), granting the permission to every device listed.
+// The names of the devices are kept alongside their ids so requests authorized by the claims need no device lookup.
+type AccessClaims struct {
+	TokenID    string            `json:"jti"`
+	Devices    []string          `json:"devices"`
+	Names      map[string]string `json:"names"`
+	Permission uint              `json:"permission"`
+	IssuedAt   int64             `json:"iat"`
+	ExpiresAt  int64             `json:"exp"`
+}
+
+// Expired returns true if the claims expire at or before the time provided.
+func (claims AccessClaims) Expired(now time.Time) bool {
+	return claims.ExpiresAt <= now.Unix()
+}
+
+// Allows returns true if the claims grant the permission to the device id provided.
+func (claims AccessClaims) Allows(deviceID string, permission uint) bool {
+	if claims.Permission&permission != permission {
+		return false
+	}
+
+	for _, id := range claims.Devices {
+		if id == deviceID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsAccessToken returns true if the token has the format of a signed access token rather than an opaque device token.
+func IsAccessToken(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// SignAccessToken returns the jwt for the claims provided, signed w/ the rsa private key of the server.
+func (key *ServerKey) SignAccessToken(claims AccessClaims) (string, error) {
+	payload, e := json.Marshal(claims)
+
+	if e != nil {
+		return "", e
+	}
+
+	content := fmt.Sprintf("%s.%s", accessTokenHeader, base64.RawURLEncoding.EncodeToString(payload))
+	digest := sha256.Sum256([]byte(content))
+
+	signature, e := rsa.SignPKCS1v15(rand.Reader, key.PrivateKey, crypto.SHA256, digest[:])
+
+	if e != nil {
+		return "", e
+	}
+
+	return fmt.Sprintf("%s.%s", content, base64.RawURLEncoding.EncodeToString(signature)), nil
+}
+
+// ParseAccessToken verifies the signature of the jwt provided and returns its claims if they have not yet expired.
+func (key *ServerKey) ParseAccessToken(token string, now time.Time) (AccessClaims, error) {
+	segments := strings.Split(token, ".")
+
+	if len(segments) != 3 || segments[0] != accessTokenHeader {
+		return AccessClaims{}, fmt.Errorf(defs.ErrInvalidAccessToken)
+	}
+
+	signature, e := base64.RawURLEncoding.DecodeString(segments[2])
+
+	if e != nil {
+		return AccessClaims{}, fmt.Errorf(defs.ErrInvalidAccessToken)
+	}
+
+	digest := sha256.Sum256([]byte(fmt.Sprintf("%s.%s", segments[0], segments[1])))
+
+	if e := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); e != nil {
+		return AccessClaims{}, fmt.Errorf(defs.ErrInvalidAccessToken)
+	}
+
+	claims, e := decodeAccessClaims(segments[1])
+
+	if e != nil {
+		return AccessClaims{}, e
+	}
+
+	if claims.Expired(now) {
+		return AccessClaims{}, fmt.Errorf(defs.ErrExpiredAccessToken)
+	}
+
+	return claims, nil
+}
+
+// AccessTokenID returns the id (jti) of the access token w/o verifying its signature, or an empty string if the token
+// can not be decoded. It is only meant to identify tokens that have already been authorized.
+func AccessTokenID(token string) string {
+	segments := strings.Split(token, ".")
+
+	if len(segments) != 3 {
+		return ""
+	}
+
+	claims, e := decodeAccessClaims(segments[1])
+
+	if e != nil {
+		return ""
+	}
+
+	return claims.TokenID
+}
+
+// decodeAccessClaims decodes the base64 encoded payload segment of an access token.
+func decodeAccessClaims(segment string) (AccessClaims, error) {
+	payload, e := base64.RawURLEncoding.DecodeString(segment)
+
+	if e != nil {
+		return AccessClaims{}, fmt.Errorf(defs.ErrInvalidAccessToken)
+	}
+
+	claims := AccessClaims{}
+
+	if e := json.NewDecoder(bytes.NewBuffer(payload)).Decode(&claims); e != nil || claims.TokenID == "" {
+		return AccessClaims{}, fmt.Errorf(defs.ErrInvalidAccessToken)
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,98 @@
+package security
+
+import "time"
+import "strings"
+import "testing"
+import "crypto/rsa"
+import "crypto/rand"
+import "encoding/base64"
+
+import "github.com/dadleyy/beacon.api/beacon/defs"
+
+func testServerKey(suite *testing.T) *ServerKey {
+	privateKey, e := rsa.GenerateKey(rand.Reader, 2048)
+
+	if e != nil {
+		suite.Fatalf("unable to generate key: %s", e.Error())
+	}
+
+	return &ServerKey{PrivateKey: privateKey}
+}
+
+func Test_AccessToken(suite *testing.T) {
+	key, now := testServerKey(suite), time.Now()
+
+	claims := AccessClaims{
+		TokenID:    "token-id",
+		Devices:    []string{"first-device", "second-device"},
+		Names:      map[string]string{"first-device": "first-name", "second-device": "second-name"},
+		Permission: defs.SecurityDeviceTokenPermissionController,
+		IssuedAt:   now.Unix(),
+		ExpiresAt:  now.Add(time.Hour).Unix(),
+	}
+
+	token, e := key.SignAccessToken(claims)
+
+	if e != nil {
+		suite.Fatalf("unable to sign access token: %s", e.Error())
+	}
+
+	if IsAccessToken(token) != true || IsAccessToken("opaque-token") {
+		suite.Fatalf("expected only the signed token to be detected as an access token")
+	}
+
+	parsed, e := key.ParseAccessToken(token, now)
+
+	if e != nil {
+		suite.Fatalf("unable to parse signed access token: %s", e.Error())
+	}
+
+	if parsed.TokenID != claims.TokenID || len(parsed.Devices) != 2 || parsed.Permission != claims.Permission {
+		suite.Fatalf("expected parsed claims to match signed claims, got %v", parsed)
+	}
+
+	if parsed.Names["second-device"] != "second-name" {
+		suite.Fatalf("expected parsed claims to keep the device names, got %v", parsed.Names)
+	}
+
+	if parsed.Allows("first-device", defs.SecurityDeviceTokenPermissionController) != true {
+		suite.Fatalf("expected claims to allow controlling the first device")
+	}
+
+	if parsed.Allows("first-device", defs.SecurityDeviceTokenPermissionAdmin) {
+		suite.Fatalf("expected claims to not allow administering the first device")
+	}
+
+	if parsed.Allows("other-device", defs.SecurityDeviceTokenPermissionController) {
+		suite.Fatalf("expected claims to not allow controlling devices that were not listed")
+	}
+
+	if _, e := key.ParseAccessToken(token, now.Add(2*time.Hour)); e == nil || e.Error() != defs.ErrExpiredAccessToken {
+		suite.Fatalf("expected expired access token to be rejected, got %v", e)
+	}
+
+	if _, e := testServerKey(suite).ParseAccessToken(token, now); e == nil || e.Error() != defs.ErrInvalidAccessToken {
+		suite.Fatalf("expected access token signed by another key to be rejected, got %v", e)
+	}
+
+	segments := strings.Split(token, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"jti":"token-id","devices":["other-device"],"exp":0}`))
+
+	if _, e := key.ParseAccessToken(strings.Join([]string{segments[0], forged, segments[2]}, "."), now); e == nil {
+		suite.Fatalf("expected access token w/ modified claims to be rejected")
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+
+	if _, e := key.ParseAccessToken(strings.Join([]string{unsigned, segments[1], ""}, "."), now); e == nil {
+		suite.Fatalf("expected access token w/o a signature to be rejected")
+	}
+
+	if id := AccessTokenID(token); id != claims.TokenID {
+		suite.Fatalf("expected the id of the access token to be read from its claims, got %s", id)
+	}
+
+	if id := AccessTokenID("opaque-token"); id != "" {
+		suite.Fatalf("expected tokens that are not access tokens to not have an id, got %s", id)
+	}
+}
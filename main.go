@@ -6,19 +6,25 @@ import "fmt"
 import "log"
 import "flag"
 import "sync"
+import "time"
 import "context"
 import "syscall"
 import "net/url"
 import "net/http"
 import "os/signal"
+import "database/sql"
 
 import "crypto/rand"
 import "encoding/hex"
 
 import "github.com/joho/godotenv"
+import "github.com/satori/go.uuid"
 import "github.com/gorilla/websocket"
 import "github.com/garyburd/redigo/redis"
 
+import _ "github.com/lib/pq"
+import _ "github.com/mattn/go-sqlite3"
+
 import "github.com/dadleyy/beacon.api/beacon/bg"
 import "github.com/dadleyy/beacon.api/beacon/net"
 import "github.com/dadleyy/beacon.api/beacon/defs"
@@ -54,6 +60,55 @@ func (t TokenGenerator) GenerateToken() (string, error) {
 	return hex.EncodeToString(buffer), nil
 }
 
+// deviceStore is the persistence layer for devices, their tokens, feedback & everything else the api keeps about them,
+// selected by the -store flag.
+type deviceStore interface {
+	device.Registry
+	device.TokenStore
+	device.FeedbackStore
+	device.AccountStore
+	device.RevocationStore
+	device.StateStore
+	device.PresetStore
+	device.GroupStore
+	device.ScheduleStore
+	device.CommandStore
+	device.PendingMessageStore
+}
+
+// newRedisPool returns a connection pool for the redis server at the uri provided, authenticating w/ the password found
+// in its query string when there is one.
+func newRedisPool(uri string) (*redis.Pool, error) {
+	redisURL, e := url.Parse(uri)
+
+	if e != nil {
+		return nil, e
+	}
+
+	dial := func() (redis.Conn, error) {
+		c, err := redis.DialURL(uri)
+
+		if err != nil {
+			return nil, err
+		}
+
+		password := redisURL.Query().Get("password")
+
+		if password == "" {
+			return c, nil
+		}
+
+		if _, err := c.Do("AUTH", password); err != nil {
+			c.Close()
+			return nil, err
+		}
+
+		return c, nil
+	}
+
+	return &redis.Pool{Dial: dial}, nil
+}
+
 type wsUpgrader struct {
 	websocket.Upgrader
 }
@@ -69,6 +124,15 @@ func main() {
 		envFile    string
 		redisURI   string
 		privateKey string
+		nodeID     string
+		channels   string
+		tokenSalt  string
+		store      string
+		storeURI   string
+		legacyAuth bool
+		jwtAuth    bool
+		checkEvery time.Duration
+		repairKeys bool
 	}{}
 
 	logger := logging.New(defs.MainLogPrefix, logging.Green)
@@ -77,6 +141,20 @@ func main() {
 	flag.StringVar(&options.envFile, "envfile", ".env", "the environment variable file to load")
 	flag.StringVar(&options.redisURI, "redisuri", defs.DefaultRedisURI, "redis server uri")
 	flag.StringVar(&options.privateKey, "private-key", ".keys/private.pem", "pem encoded rsa private key")
+	flag.StringVar(&options.nodeID, "node-id", "", "id of this api node, generated when empty (except w/ streams)")
+	flag.StringVar(&options.channels, "channels", "", "channel backend (pubsub, streams or local), by store when empty")
+	flag.StringVar(&options.tokenSalt, "token-salt", "", "salt used when hashing device tokens, shared when empty")
+	flag.StringVar(&options.store, "store", defs.StoreBackendRedis, "store backend (redis, memory, sqlite or postgres)")
+	flag.StringVar(&options.storeURI, "store-uri", "beacon.db", "sqlite file or postgres connection string of sql stores")
+	flag.BoolVar(&options.legacyAuth, "legacy-secret-auth", false, "accept shared secrets of devices w/o owner tokens")
+	flag.BoolVar(&options.jwtAuth, "access-tokens", false, "accept signed access tokens (jwt) as user tokens")
+	flag.DurationVar(
+		&options.checkEvery,
+		"consistency-interval",
+		defs.DefaultConsistencyInterval,
+		"how often redis keys are checked for consistency, 0 disables the check",
+	)
+	flag.BoolVar(&options.repairKeys, "consistency-repair", false, "repair inconsistent redis keys")
 	flag.Parse()
 
 	if valid := len(options.port) >= 1; !valid {
@@ -102,19 +180,32 @@ func main() {
 		options.hostname = os.Getenv("HOSTNAME")
 	}
 
+	if os.Getenv("NODE_ID") != "" {
+		options.nodeID = os.Getenv("NODE_ID")
+	}
+
+	if os.Getenv("TOKEN_SALT") != "" {
+		options.tokenSalt = os.Getenv("TOKEN_SALT")
+	}
+
+	if os.Getenv("STORE_URI") != "" {
+		options.storeURI = os.Getenv("STORE_URI")
+	}
+
+	// Stream entries are only delivered again to a node that restarts w/ the same id, so generated ids are not allowed w/
+	// the streams channel backend.
+	stableNode := options.nodeID != ""
+
+	if options.nodeID == "" {
+		options.nodeID = uuid.NewV4().String()
+	}
+
 	logger.Debugf("permissions: (admin: %b) (controller %b) (viewer: %b)",
 		defs.SecurityDeviceTokenPermissionAdmin,
 		defs.SecurityDeviceTokenPermissionController,
 		defs.SecurityDeviceTokenPermissionViewer,
 	)
 
-	redisURL, e := url.Parse(options.redisURI)
-
-	if e != nil {
-		logger.Errorf("unable to establish connection to redis server: %s", e.Error())
-		return
-	}
-
 	serverKey, e := security.ReadServerKeyFromFile(options.privateKey)
 
 	if e != nil {
@@ -142,36 +233,109 @@ func main() {
 
 	registrationStream := make(device.RegistrationStream, 10)
 
-	redisPool := redis.Pool{
-		Dial: func() (redis.Conn, error) {
-			c, err := redis.DialURL(options.redisURI)
+	if options.legacyAuth {
+		logger.Warnf("accepting shared secrets of devices registered w/o an owner token")
+	}
 
-			if err != nil {
-				return nil, err
-			}
+	// Redis is only set up for the redis store; the other stores keep everything in memory or in their sql database.
+	var redisPool *redis.Pool
+	var registry *device.RedisRegistry
 
-			password := redisURL.Query().Get("password")
+	// Create our device store - responsible for providing a persistence layer for devices & everything kept about them.
+	var store deviceStore
 
-			if password == "" {
-				return c, nil
-			}
+	switch options.store {
+	case defs.StoreBackendRedis:
+		if redisPool, e = newRedisPool(options.redisURI); e != nil {
+			logger.Errorf("unable to establish connection to redis server: %s", e.Error())
+			return
+		}
+
+		defer redisPool.Close()
+
+		registry = &device.RedisRegistry{
+			Pool:             redisPool,
+			Logger:           logging.New(defs.RegistryLogPrefix, logging.Green),
+			TokenGenerator:   TokenGenerator{},
+			TokenSalt:        options.tokenSalt,
+			LegacySecretAuth: options.legacyAuth,
+		}
+
+		if registry.TokenSalt == "" {
+			logger.Warnf("no token salt configured, using the salt shared in redis")
 
-			if _, err := c.Do("AUTH", password); err != nil {
-				c.Close()
-				return nil, err
+			if registry.TokenSalt, e = registry.SharedTokenSalt(); e != nil {
+				logger.Errorf("unable to load shared token salt: %s", e.Error())
+				return
 			}
+		}
 
-			return c, nil
-		},
-	}
+		if e := registry.Migrate(); e != nil {
+			logger.Errorf("unable to migrate redis data: %s", e.Error())
+			return
+		}
+
+		store = registry
+	case defs.StoreBackendMemory:
+		logger.Warnf("keeping everything in memory, it will be lost when the server stops")
 
-	defer redisPool.Close()
+		if options.tokenSalt == "" {
+			logger.Warnf("no token salt configured, generating one for this run")
+
+			if options.tokenSalt, e = (TokenGenerator{}).GenerateToken(); e != nil {
+				logger.Errorf("unable to generate token salt: %s", e.Error())
+				return
+			}
+		}
+
+		memoryRegistry := device.NewMemoryRegistry(TokenGenerator{})
+		memoryRegistry.TokenSalt = options.tokenSalt
+		memoryRegistry.LegacySecretAuth = options.legacyAuth
+		store = memoryRegistry
+	case defs.StoreBackendSQLite, defs.StoreBackendPostgres:
+		driver := defs.SQLDriverSQLite
+
+		if options.store == defs.StoreBackendPostgres {
+			driver = defs.SQLDriverPostgres
+		}
+
+		db, e := sql.Open(driver, options.storeURI)
+
+		if e != nil {
+			logger.Errorf("unable to open %s store: %s", options.store, e.Error())
+			return
+		}
+
+		defer db.Close()
+
+		sqlRegistry := &device.SQLRegistry{
+			Logger:           logging.New(defs.SQLRegistryLogPrefix, logging.Green),
+			DB:               db,
+			TokenGenerator:   TokenGenerator{},
+			Driver:           driver,
+			TokenSalt:        options.tokenSalt,
+			LegacySecretAuth: options.legacyAuth,
+		}
+
+		if e := sqlRegistry.Migrate(); e != nil {
+			logger.Errorf("unable to migrate %s store: %s", options.store, e.Error())
+			return
+		}
+
+		if sqlRegistry.TokenSalt == "" {
+			logger.Warnf("no token salt configured, using the salt shared in the %s store", options.store)
+
+			if sqlRegistry.TokenSalt, e = sqlRegistry.SharedTokenSalt(); e != nil {
+				logger.Errorf("unable to load shared token salt: %s", e.Error())
+				return
+			}
+		}
 
-	// Create our device store - responsible for providing a persistence layer for connected device information.
-	registry := device.RedisRegistry{
-		Pool:           &redisPool,
-		Logger:         logging.New(defs.RegistryLogPrefix, logging.Green),
-		TokenGenerator: TokenGenerator{},
+		store = sqlRegistry
+	default:
+		logger.Errorf("invalid store backend: %s", options.store)
+		flag.PrintDefaults()
+		return
 	}
 
 	// Bundle our two message channels w/ the registration stream.
@@ -181,19 +345,100 @@ func main() {
 		Registrations: registrationStream,
 	}
 
+	// Create the publisher that relays control messages to the api node holding the connection of each device, either
+	// over redis pub/sub or through durable redis streams. W/o redis there are no other nodes to relay messages to.
+	var relay bg.ChannelRelay
+
+	if options.channels == "" && registry == nil {
+		options.channels = defs.ChannelBackendLocal
+	}
+
+	if options.channels == "" {
+		options.channels = defs.ChannelBackendPubSub
+	}
+
+	switch options.channels {
+	case defs.ChannelBackendPubSub, defs.ChannelBackendStreams:
+		if registry == nil {
+			logger.Errorf("the %s channel backend is only available w/ the redis store", options.channels)
+			return
+		}
+	}
+
+	if options.channels == defs.ChannelBackendStreams && stableNode != true {
+		logger.Errorf("the %s channel backend requires a stable node id (-node-id or NODE_ID)", options.channels)
+		flag.PrintDefaults()
+		return
+	}
+
+	switch options.channels {
+	case defs.ChannelBackendLocal:
+		relay = bg.NewLocalChannelPublisher(publisher)
+	case defs.ChannelBackendPubSub:
+		relay = bg.NewRedisChannelPublisher(redisPool, options.nodeID, registry, publisher)
+	case defs.ChannelBackendStreams:
+		streams := bg.NewRedisStreamPublisher(redisPool, options.nodeID, registry, publisher)
+		deviceChannels.Feedback = streams.Outbox(defs.DeviceFeedbackChannelName)
+		relay = streams
+	default:
+		logger.Errorf("invalid channel backend: %s", options.channels)
+		flag.PrintDefaults()
+		return
+	}
+
+	// Create the broker that relays device lifecycle events from the processors to any streaming clients.
+	events := bg.NewDeviceEventBroker()
+
 	// Create the main device controller that handles registrations & sending messages to the connected devices.
-	control := bg.NewDeviceControlProcessor(&deviceChannels, &registry, serverKey)
+	control := bg.NewDeviceControlProcessor(
+		&deviceChannels,
+		store,
+		store,
+		store,
+		store,
+		events,
+		relay,
+		serverKey,
+	)
 
 	// Create the secondary processor that will receive messages from devices.
-	feedback := bg.NewDeviceFeedbackProcessor(publisher[defs.DeviceFeedbackChannelName])
+	feedback := bg.NewDeviceFeedbackProcessor(
+		publisher[defs.DeviceFeedbackChannelName],
+		store,
+		store,
+		store,
+		events,
+	)
 
-	processors := []bg.Processor{control, feedback}
+	// Create the processor that publishes scheduled messages onto the control channel once they are due.
+	schedule := bg.NewDeviceScheduleProcessor(store, store, store, store, relay)
 
-	deviceRoutes := routes.NewDevicesAPI(&registry, &registry)
-	registrationRoutes := routes.NewRegistrationAPI(registrationStream, &registry)
-	messageRoutes := routes.NewDeviceMessagesAPI(&registry, &registry)
-	feedbackRoutes := routes.NewFeedbackAPI(&registry, &registry)
-	tokenRoutes := routes.NewTokensAPI(&registry, &registry)
+	processors := []bg.Processor{control, feedback, schedule, events, relay}
+
+	// Periodically look for redis keys left inconsistent by writes that were interrupted part way.
+	if options.checkEvery > 0 && options.store == defs.StoreBackendRedis {
+		processors = append(processors, bg.NewConsistencyCheckProcessor(registry, options.checkEvery, options.repairKeys))
+	}
+
+	// Routes authorize user tokens through the registry unless signed access tokens are also accepted, in which case those
+	// are authorized from their claims w/ only a lookup of the revocation list.
+	var auth device.TokenStore = store
+
+	if options.jwtAuth {
+		auth = &device.AccessTokenStore{TokenStore: store, RevocationStore: store, Key: serverKey}
+	}
+
+	deviceRoutes := routes.NewDevicesAPI(store, auth, store, store)
+	registrationRoutes := routes.NewRegistrationAPI(registrationStream, store)
+	messageRoutes := routes.NewDeviceMessagesAPI(store, auth, store, store)
+	groupRoutes := routes.NewDeviceGroupsAPI(store, auth, store)
+	feedbackRoutes := routes.NewFeedbackAPI(store, store, store)
+	tokenRoutes := routes.NewTokensAPI(auth, store)
+	accountRoutes := routes.NewAccountsAPI(store, auth, store)
+	accessTokenRoutes := routes.NewAccessTokensAPI(store, store, store, serverKey)
+	presetRoutes := routes.NewPresetsAPI(store, auth, store)
+	scheduleRoutes := routes.NewDeviceSchedulesAPI(store, auth, store)
+	eventRoutes := routes.NewDeviceEventsAPI(store, auth, events)
 
 	routes := net.RouteConfigMapMatcher{
 		// [/system]
@@ -231,12 +476,110 @@ func main() {
 			Method:  "GET",
 			Pattern: defs.DeviceTokensRoute,
 		}: tokenRoutes.ListTokens,
+		net.RouteConfig{
+			Method:  "DELETE",
+			Pattern: defs.DeviceTokenRoute,
+		}: tokenRoutes.DeleteToken,
+
+		// [/accounts]
+		net.RouteConfig{
+			Method:  "POST",
+			Pattern: defs.AccountsRoute,
+		}: accountRoutes.CreateAccount,
+
+		// [/account-devices]
+		net.RouteConfig{
+			Method:  "GET",
+			Pattern: defs.AccountDevicesRoute,
+		}: accountRoutes.ListDevices,
+		net.RouteConfig{
+			Method:  "POST",
+			Pattern: defs.AccountDevicesRoute,
+		}: accountRoutes.GrantDevice,
+		net.RouteConfig{
+			Method:  "DELETE",
+			Pattern: defs.AccountDevicesRoute,
+		}: accountRoutes.RevokeDevice,
 
 		// [/device-messages]
 		net.RouteConfig{
 			Method:  "POST",
 			Pattern: defs.DeviceMessagesRoute,
 		}: messageRoutes.CreateMessage,
+		net.RouteConfig{
+			Method:  "GET",
+			Pattern: defs.DeviceMessagesRoute,
+		}: messageRoutes.ListMessages,
+
+		// [/device-messages/:id]
+		net.RouteConfig{
+			Method:  "GET",
+			Pattern: defs.DeviceMessageRoute,
+		}: messageRoutes.FindMessage,
+
+		// [/device-events]
+		net.RouteConfig{
+			Method:  "GET",
+			Pattern: defs.DeviceEventsRoute,
+		}: eventRoutes.StreamEvents,
+
+		// [/device-schedules]
+		net.RouteConfig{
+			Method:  "GET",
+			Pattern: defs.DeviceSchedulesRoute,
+		}: scheduleRoutes.ListSchedules,
+		net.RouteConfig{
+			Method:  "POST",
+			Pattern: defs.DeviceSchedulesRoute,
+		}: scheduleRoutes.CreateSchedule,
+		net.RouteConfig{
+			Method:  "DELETE",
+			Pattern: defs.DeviceSchedulesRoute,
+		}: scheduleRoutes.DeleteSchedule,
+
+		// [/group-messages]
+		net.RouteConfig{
+			Method:  "POST",
+			Pattern: defs.GroupMessagesRoute,
+		}: messageRoutes.CreateGroupMessage,
+
+		// [/device-groups]
+		net.RouteConfig{
+			Method:  "GET",
+			Pattern: defs.DeviceGroupsRoute,
+		}: groupRoutes.ListGroups,
+		net.RouteConfig{
+			Method:  "POST",
+			Pattern: defs.DeviceGroupsRoute,
+		}: groupRoutes.CreateGroup,
+
+		// [/device-groups/:id/devices]
+		net.RouteConfig{
+			Method:  "POST",
+			Pattern: defs.DeviceGroupMembersRoute,
+		}: groupRoutes.AddMember,
+		net.RouteConfig{
+			Method:  "DELETE",
+			Pattern: defs.DeviceGroupMembersRoute,
+		}: groupRoutes.RemoveMember,
+
+		// [/presets]
+		net.RouteConfig{
+			Method:  "GET",
+			Pattern: defs.PresetsRoute,
+		}: presetRoutes.ListPresets,
+		net.RouteConfig{
+			Method:  "POST",
+			Pattern: defs.PresetsRoute,
+		}: presetRoutes.CreatePreset,
+		net.RouteConfig{
+			Method:  "PATCH",
+			Pattern: defs.PresetsRoute,
+		}: presetRoutes.UpdatePreset,
+		net.RouteConfig{
+			Method:  "DELETE",
+			Pattern: defs.PresetsRoute,
+		}: presetRoutes.DeletePreset,
 
 		// [/devices/:id/:color]
 		net.RouteConfig{
@@ -251,11 +594,17 @@ func main() {
 		}: deviceRoutes.ListDevices,
 	}
 
+	if options.jwtAuth {
+		// [/access-tokens]
+		routes[net.RouteConfig{Method: "POST", Pattern: defs.AccessTokensRoute}] = accessTokenRoutes.CreateAccessToken
+		routes[net.RouteConfig{Method: "DELETE", Pattern: defs.AccessTokensRoute}] = accessTokenRoutes.RevokeAccessToken
+	}
+
 	runtime := net.ServerRuntime{
 		Logger:             logging.New(defs.ServerRuntimeLogPrefix, logging.Magenta),
 		WebsocketUpgrader:  &websocket,
 		Multiplexer:        &routes,
-		ChannelPublisher:   &publisher,
+		ChannelPublisher:   relay,
 		ApplicationVersion: version.Semver,
 	}
 
@@ -274,7 +623,7 @@ func main() {
 
 	go systemWatch(signalChan, killers, &server)
 
-	logger.Infof("server (version %s) starting, binding on: %s\n", version.Semver, serverAddress)
+	logger.Infof("server (version %s, node %s) starting, binding on: %s\n", version.Semver, options.nodeID, serverAddress)
 
 	if e := server.ListenAndServe(); e != nil {
 		logger.Debugf("server shutdown: %s", e.Error())